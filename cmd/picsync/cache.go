@@ -2,8 +2,16 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/transform"
+	"github.com/andrewjjenkins/picsync/pkg/util"
 	"github.com/spf13/cobra"
 )
 
@@ -19,11 +27,139 @@ var (
 		Run:   runCacheStatus,
 	}
 
+	cacheMigrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the cache to the schema this picsync uses",
+		Long: "Upgrade the cache to the schema this picsync uses.  Other commands " +
+			"do this automatically when they open the cache; this shows what changes.",
+		Args: cobra.NoArgs,
+		Run:  runCacheMigrate,
+	}
+
+	cacheGcCmd = &cobra.Command{
+		Use:   "gc [<picsync.yaml>]",
+		Short: "Remove old or unneeded entries from the cache",
+		Long: "Remove old or unneeded entries from the cache.  Entries are removed " +
+			"if any of the flags say so; picsync.yaml is only read for " +
+			"--not-referenced-by-config.",
+		Args: cobra.MaximumNArgs(1),
+		Run:  runCacheGc,
+	}
+
+	cacheListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List cache entries",
+		Args:  cobra.NoArgs,
+		Run:   runCacheList,
+	}
+
+	cacheShowCmd = &cobra.Command{
+		Use:   "show <table> <id>",
+		Short: "Show every column of a cache entry",
+		Args:  cobra.ExactArgs(2),
+		Run:   runCacheShow,
+	}
+
+	cacheExportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export the whole cache as JSON Lines",
+		Args:  cobra.NoArgs,
+		Run:   runCacheExport,
+	}
+
+	cacheImportCmd = &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import a cache exported by \"picsync cache export\"",
+		Long: "Import a cache exported by \"picsync cache export\" (\"-\" for stdin).  " +
+			"Imported entries replace cached entries with the same key.",
+		Args: cobra.ExactArgs(1),
+		Run:  runCacheImport,
+	}
+
 	cacheFilename = ""
+	migrateDryRun bool
+
+	listSources   []string
+	listOlderThan string
+	listHash      string
+	exportOut     string
+	importReplace bool
+
+	gcOlderThan             string
+	gcMaxEntries            int
+	gcNotReferencedByConfig bool
+	gcDryRun                bool
 )
 
 func init() {
+	cacheMigrateCmd.PersistentFlags().BoolVar(
+		&migrateDryRun,
+		"dry-run",
+		false,
+		"Only list the migrations that would be applied",
+	)
+	cacheGcCmd.PersistentFlags().StringVar(
+		&gcOlderThan,
+		"older-than",
+		"",
+		"Remove entries not used for this long (like 2160h)",
+	)
+	cacheGcCmd.PersistentFlags().IntVar(
+		&gcMaxEntries,
+		"max-entries",
+		0,
+		"Remove the least recently used entries of each table beyond this many",
+	)
+	cacheGcCmd.PersistentFlags().BoolVar(
+		&gcNotReferencedByConfig,
+		"not-referenced-by-config",
+		false,
+		"Remove album titles, playlists, local files and transforms the config doesn't use",
+	)
+	cacheGcCmd.PersistentFlags().BoolVar(
+		&gcDryRun,
+		"dry-run",
+		false,
+		"Only list the entries that would be removed",
+	)
+	cacheListCmd.PersistentFlags().StringSliceVar(
+		&listSources,
+		"source",
+		nil,
+		"Only list entries from these cache tables (like googlephotos,local)",
+	)
+	cacheListCmd.PersistentFlags().StringVar(
+		&listOlderThan,
+		"older-than",
+		"",
+		"Only list entries not used for this long (like 2160h)",
+	)
+	cacheListCmd.PersistentFlags().StringVar(
+		&listHash,
+		"hash",
+		"",
+		"Only list entries with a SHA256 or MD5 starting with this",
+	)
+	cacheExportCmd.PersistentFlags().StringVarP(
+		&exportOut,
+		"outfile",
+		"o",
+		"-",
+		"Write export to file (\"-\" for stdout)",
+	)
+	cacheImportCmd.PersistentFlags().BoolVar(
+		&importReplace,
+		"replace",
+		false,
+		"Remove everything that was cached first",
+	)
 	cacheCmd.AddCommand(cacheStatusCmd)
+	cacheCmd.AddCommand(cacheMigrateCmd)
+	cacheCmd.AddCommand(cacheGcCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheShowCmd)
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheImportCmd)
 
 	rootCmd.AddCommand(cacheCmd)
 
@@ -46,9 +182,305 @@ func runCacheStatus(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 	fmt.Printf("Cache status:\n"+
+		"Schema Version: %d\n"+
 		"Google Photos Valid Entries: %d\n"+
-		"Nixplay Valid Entries: %d\n",
+		"Nixplay Valid Entries: %d\n"+
+		"Local Valid Entries: %d\n"+
+		"Album Title Entries: %d\n"+
+		"Video Entries: %d\n"+
+		"Transformed Image Entries: %d\n"+
+		"Playlist Entries: %d\n"+
+		"Nixplay Album Entries: %d\n"+
+		"Blob Entries: %d\n",
+		status.SchemaVersion,
 		status.GooglePhotosValidRows,
 		status.NixplayValidRows,
+		status.LocalValidRows,
+		status.AlbumRefValidRows,
+		status.VideoValidRows,
+		status.TransformValidRows,
+		status.PlaylistValidRows,
+		status.NixplayAlbumValidRows,
+		status.BlobValidRows,
 	)
 }
+
+func runCacheMigrate(cmd *cobra.Command, args []string) {
+	version, err := cache.GetSchemaVersion(cacheFilename)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Cache %s is schema version %d, this picsync uses %d\n",
+		cacheFilename, version, cache.SchemaVersion)
+
+	migrations, err := cache.Migrate(cacheFilename, migrateDryRun)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if len(migrations) == 0 {
+		fmt.Printf("Cache is up to date\n")
+		return
+	}
+	if !migrateDryRun {
+		fmt.Printf("Applied %d migrations\n", len(migrations))
+		return
+	}
+	fmt.Printf("Would apply %d migrations:\n", len(migrations))
+	for _, m := range migrations {
+		fmt.Printf("  %d: %s\n", m.Version, m.Description)
+	}
+}
+
+func runCacheGc(cmd *cobra.Command, args []string) {
+	configFile := "picsync.yaml"
+	if len(args) == 1 {
+		configFile = args[0]
+	}
+	var config *util.Config
+	if gcNotReferencedByConfig {
+		var err error
+		config, err = util.LoadConfig(configFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	opts, err := gcOptions(config, util.ConfigCacheGC{
+		OlderThan:             gcOlderThan,
+		MaxEntries:            gcMaxEntries,
+		NotReferencedByConfig: gcNotReferencedByConfig,
+	})
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if opts.OlderThan == 0 && opts.MaxEntries == 0 && opts.NotReferencedBy == nil {
+		fmt.Printf("Pass --older-than, --max-entries or --not-referenced-by-config\n")
+		os.Exit(1)
+	}
+	opts.DryRun = gcDryRun
+
+	c, err := cache.New(promReg, cacheFilename)
+	if err != nil {
+		panic(err)
+	}
+	result, err := c.GC(opts)
+	if err != nil {
+		panic(err)
+	}
+	if gcDryRun {
+		fmt.Printf("Would remove %d cache entries\n", result.Total())
+		return
+	}
+	fmt.Printf("Removed %d cache entries\n", result.Total())
+}
+
+func runCacheList(cmd *cobra.Command, args []string) {
+	opts := cache.ListOptions{
+		Tables: listSources,
+		Hash:   strings.ToLower(listHash),
+	}
+	if listOlderThan != "" {
+		olderThan, err := time.ParseDuration(listOlderThan)
+		if err != nil || olderThan <= 0 {
+			fmt.Printf("bad --older-than %q\n", listOlderThan)
+			os.Exit(1)
+		}
+		opts.OlderThan = olderThan
+	}
+
+	c, err := cache.New(promReg, cacheFilename)
+	if err != nil {
+		panic(err)
+	}
+	entries, err := c.ListEntries(opts)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	for _, e := range entries {
+		fmt.Printf("%s %v %s", e.Table, e.Row["Id"], e.Key)
+		for _, column := range e.Columns {
+			if cache.IsHashColumn(column) {
+				fmt.Printf(" %s=%v", column, e.Row[column])
+			}
+		}
+		fmt.Printf(" (last used %s)\n", entryTime(e.Row["LastUsed"]))
+	}
+	fmt.Printf("%d cache entries\n", len(entries))
+}
+
+func runCacheShow(cmd *cobra.Command, args []string) {
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		fmt.Printf("bad id %q\n", args[1])
+		os.Exit(1)
+	}
+	c, err := cache.New(promReg, cacheFilename)
+	if err != nil {
+		panic(err)
+	}
+	e, err := c.GetEntry(args[0], id)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if e == nil {
+		fmt.Printf("No %s cache entry %d\n", args[0], id)
+		os.Exit(1)
+	}
+	for _, column := range e.Columns {
+		value := fmt.Sprint(e.Row[column])
+		if entryTimeColumns[column] {
+			value = fmt.Sprintf("%s (%s)", value, entryTime(e.Row[column]))
+		}
+		fmt.Printf("%s: %s\n", column, value)
+	}
+}
+
+// entryTimeColumns are the columns that are times, as Unix nanoseconds.
+var entryTimeColumns = map[string]bool{
+	"ModTime":     true,
+	"LastUpdated": true,
+	"LastUsed":    true,
+}
+
+func entryTime(v interface{}) string {
+	unixNano, ok := v.(int64)
+	if !ok || unixNano == 0 {
+		return "never"
+	}
+	return time.Unix(0, unixNano).Format(time.RFC3339)
+}
+
+func runCacheExport(cmd *cobra.Command, args []string) {
+	c, err := cache.New(promReg, cacheFilename)
+	if err != nil {
+		panic(err)
+	}
+	if exportOut == "-" {
+		if _, err := c.Export(os.Stdout); err != nil {
+			panic(err)
+		}
+		return
+	}
+	f, err := os.Create(exportOut)
+	if err != nil {
+		panic(err)
+	}
+	n, err := c.Export(f)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(exportOut)
+		panic(err)
+	}
+	fmt.Printf("Exported %d cache entries to %s\n", n, exportOut)
+}
+
+func runCacheImport(cmd *cobra.Command, args []string) {
+	in := os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	c, err := cache.New(promReg, cacheFilename)
+	if err != nil {
+		panic(err)
+	}
+	result, err := c.Import(in, importReplace)
+	if err != nil {
+		fmt.Printf("Cannot import %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d cache entries (%d replaced existing entries)\n",
+		result.Imported, result.Replaced)
+}
+
+// gcOptions returns the cache.GCOptions for gc.  config is only needed if
+// gc.NotReferencedByConfig is set.
+func gcOptions(config *util.Config, gc util.ConfigCacheGC) (cache.GCOptions, error) {
+	var opts cache.GCOptions
+	if gc.OlderThan != "" {
+		olderThan, err := time.ParseDuration(gc.OlderThan)
+		if err != nil || olderThan <= 0 {
+			return cache.GCOptions{}, fmt.Errorf("bad cache gc olderThan %q", gc.OlderThan)
+		}
+		opts.OlderThan = olderThan
+	}
+	if gc.MaxEntries < 0 {
+		return cache.GCOptions{}, fmt.Errorf("bad cache gc maxEntries %d", gc.MaxEntries)
+	}
+	opts.MaxEntries = gc.MaxEntries
+	if gc.NotReferencedByConfig {
+		refs, err := configReferences(config)
+		if err != nil {
+			return cache.GCOptions{}, err
+		}
+		opts.NotReferencedBy = refs
+	}
+	return opts, nil
+}
+
+// configReferences returns the cache entries that config uses.
+func configReferences(config *util.Config) (*cache.References, error) {
+	refs := &cache.References{}
+	addAlbumRef := func(album *util.ConfigAlbum, s string) error {
+		ref, err := googlephotos.ParseAlbumRef(s)
+		if err != nil {
+			return fmt.Errorf("album %s: %v", album.Name, err)
+		}
+		refs.AlbumRefs = append(refs.AlbumRefs, ref.String())
+		return nil
+	}
+	for _, album := range config.Albums {
+		for _, sourceAlbum := range album.Sources.Googlephotos {
+			if err := addAlbumRef(album, sourceAlbum); err != nil {
+				return nil, err
+			}
+		}
+		for _, sourceSearch := range album.Sources.GooglephotosSearch {
+			if sourceSearch.Album == "" {
+				continue
+			}
+			if err := addAlbumRef(album, sourceSearch.Album); err != nil {
+				return nil, err
+			}
+		}
+		for _, sourceDir := range album.Sources.Local {
+			// Local files are cached by their absolute path.
+			dir, err := filepath.Abs(sourceDir.Path)
+			if err != nil {
+				return nil, err
+			}
+			refs.LocalDirs = append(refs.LocalDirs, dir)
+		}
+		if album.Transform != nil {
+			settings := transform.Settings{
+				MaxWidth:  album.Transform.MaxWidth,
+				MaxHeight: album.Transform.MaxHeight,
+				Quality:   album.Transform.Quality,
+				StripGPS:  album.Transform.StripGPS,
+			}
+			refs.TransformSettings = append(refs.TransformSettings, settings.Key())
+		}
+		seed := album.Seed
+		if seed == "" {
+			seed = album.Name
+		}
+		playlists, err := albumPlaylists(album, seed)
+		if err != nil {
+			return nil, fmt.Errorf("album %s: %v", album.Name, err)
+		}
+		for _, playlist := range playlists {
+			refs.Playlists = append(refs.Playlists, playlist.Name)
+		}
+	}
+	return refs, nil
+}
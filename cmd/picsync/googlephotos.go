@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/andrewjjenkins/picsync/pkg/cache"
@@ -30,7 +32,9 @@ var (
 		Run:   runGooglephotosList,
 	}
 
-	listShared = false
+	listShared      = false
+	listLimit       = 0
+	listTitleFilter = ""
 )
 
 func init() {
@@ -57,6 +61,18 @@ func init() {
 		false,
 		"List albums shared with you",
 	)
+	googlephotosList.PersistentFlags().IntVar(
+		&listLimit,
+		"limit",
+		0,
+		"List at most this many albums (0 for all)",
+	)
+	googlephotosList.PersistentFlags().StringVar(
+		&listTitleFilter,
+		"title-filter",
+		"",
+		"Only list albums whose title contains this (ignoring case)",
+	)
 
 	googlephotosCmd.AddCommand(googlephotosList)
 
@@ -102,7 +118,7 @@ func runGooglephotosLogin(cmd *cobra.Command, args []string) {
 	writeLoginOut(toWrite)
 }
 
-func newGooglePhotosClient(c cache.Cache) (googlephotos.Client, error) {
+func newGooglePhotosClient(c cache.Cache, opts googlephotos.Options) (googlephotos.Client, error) {
 	var err error
 
 	consumerKey := viper.GetString("googlephotos.api.key")
@@ -127,12 +143,14 @@ func newGooglePhotosClient(c cache.Cache) (googlephotos.Client, error) {
 			return nil, err
 		}
 	}
-	client := googlephotos.NewClient(consumerKey, consumerSecret, context.Background(), &access, c, promReg)
+	opts.BaseURL = viper.GetString("googlephotos.api.base_url")
+	opts.TokenURL = viper.GetString("googlephotos.api.token_url")
+	client := googlephotos.NewClient(consumerKey, consumerSecret, context.Background(), &access, c, promReg, opts)
 	return client, nil
 }
 
-func getGooglephotoClientOrExit(c cache.Cache) googlephotos.Client {
-	client, err := newGooglePhotosClient(c)
+func getGooglephotoClientOrExit(c cache.Cache, opts googlephotos.Options) googlephotos.Client {
+	client, err := newGooglePhotosClient(c, opts)
 	if err != nil {
 		fmt.Printf("Google Photos login error: %v", err)
 		os.Exit(1)
@@ -145,20 +163,33 @@ func runGooglephotosList(cmd *cobra.Command, args []string) {
 	if err != nil {
 		panic(err)
 	}
-	c := getGooglephotoClientOrExit(myCache)
+	c := getGooglephotoClientOrExit(myCache, googlephotos.Options{})
 
 	if len(args) == 0 {
-		var albums []*googlephotos.Album
-		var err error
+		// Stop fetching pages if interrupted.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		var albums googlephotos.AlbumIterator
 		if listShared {
-			albums, err = c.ListSharedAlbums()
+			albums = c.IterateSharedAlbums(ctx, googlephotos.ListAlbumsOptions{})
 		} else {
-			albums, err = c.ListAlbums()
-		}
-		if err != nil {
-			panic(err)
+			albums = c.IterateAlbums(ctx, googlephotos.ListAlbumsOptions{})
 		}
-		for _, a := range albums {
+		filter := strings.ToLower(listTitleFilter)
+		listed := 0
+		for listLimit <= 0 || listed < listLimit {
+			a, err := albums.Next()
+			if err != nil {
+				panic(err)
+			}
+			if a == nil {
+				break
+			}
+			if !strings.Contains(strings.ToLower(a.Title), filter) {
+				continue
+			}
+			listed++
 			fmt.Printf("Album \"%s\":\n", a.Title)
 			fmt.Printf("  ID: %s\n", a.Id)
 			fmt.Printf("  Items: %d\n", a.MediaItemsCount)
@@ -214,7 +245,7 @@ func runGooglephotosListUpdateCache(client googlephotos.Client, albumId string)
 
 	var nextPageToken string
 	for ok := true; ok; ok = (nextPageToken != "") {
-		res, err := client.UpdateCacheForAlbumId(albumId, nextPageToken, updateCallback)
+		res, err := client.UpdateCacheForAlbumId(albumId, nextPageToken, googlephotos.VideoPolicy{}, updateCallback)
 		if err != nil {
 			panic(err)
 		}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos/googlephotostest"
+	"github.com/andrewjjenkins/picsync/pkg/nixplay/nixplaytest"
+)
+
+// picsyncArgsEnv, if set, makes the test binary run picsync with these
+// (newline-separated) arguments instead of the tests.
+const picsyncArgsEnv = "PICSYNC_TEST_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(picsyncArgsEnv); ok {
+		os.Args = append([]string{"picsync"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// picsync runs picsync in dir (which has its credentials), like it's run
+// from a shell, and returns what it printed.
+func picsync(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), picsyncArgsEnv+"="+strings.Join(args, "\n"))
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// mustPicsync runs picsync, and fails the test if it fails.
+func mustPicsync(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := picsync(t, dir, args...)
+	if err != nil {
+		t.Fatalf("picsync %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+// fakes are a fake Google Photos and Nixplay, and a directory with
+// credentials for them.
+type fakes struct {
+	dir string
+	gp  *googlephotostest.Server
+	np  *nixplaytest.Server
+}
+
+func newFakes(t *testing.T) *fakes {
+	f := &fakes{
+		dir: t.TempDir(),
+		gp:  googlephotostest.NewServer(),
+		np:  nixplaytest.NewServer("user@example.com", "password"),
+	}
+	t.Cleanup(f.gp.Close)
+	t.Cleanup(f.np.Close)
+
+	token := f.gp.Token()
+	f.write(t, ".picsync-credentials.yaml", fmt.Sprintf(`
+nixplay:
+  username: %q
+  password: %q
+  base_url: %q
+googlephotos:
+  api:
+    key: "test"
+    secret: "test"
+    base_url: %q
+    token_url: %q
+  access:
+    token_type: %q
+    access_token: %q
+    refresh_token: %q
+    expiry: %q
+`, f.np.Username, f.np.Password, f.np.URL, f.gp.URL, f.gp.TokenURL(),
+		token.TokenType, token.AccessToken, token.RefreshToken, token.Expiry.Format(time.RFC3339)))
+	return f
+}
+
+func (f *fakes) write(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, name), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
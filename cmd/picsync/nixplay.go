@@ -2,9 +2,9 @@ package main
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/nixplay"
 	"github.com/spf13/cobra"
 )
 
@@ -22,7 +22,7 @@ var (
 
 	nixplayDeleteCmd = &cobra.Command{
 		Use:   "delete",
-		Short: "Delete albums or photos",
+		Short: "Delete albums, photos or playlists",
 	}
 
 	nixplayDeleteAlbumCmd = &cobra.Command{
@@ -42,7 +42,47 @@ var (
 		},
 	}
 
+	nixplayDeletePlaylistCmd = &cobra.Command{
+		Use:   "playlist <playlistName>",
+		Short: "Delete all playlists named <playlistName> (not the photos in them)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			playlistName := args[0]
+			if playlistName == "" {
+				return fmt.Errorf("must specify playlist to delete")
+			}
+			runNixplayDeletePlaylist(playlistName)
+			return nil
+		},
+	}
+
+	nixplayFramesCmd = &cobra.Command{
+		Use:   "frames",
+		Short: "List frames or assign playlists to them",
+	}
+
+	nixplayFramesListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List frames and the playlists they show",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runNixplayFramesList()
+		},
+	}
+
+	nixplayFramesAssignCmd = &cobra.Command{
+		Use:   "assign <frame> <playlistName>",
+		Short: "Assign a playlist to a frame (by name or serial number)",
+		Long: `Assign a playlist to a frame (by name or serial number).  The playlist is
+added to the ones the frame already shows, unless --replace is given.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			runNixplayFramesAssign(args[0], args[1])
+		},
+	}
+
 	allowDeleteMultiple bool
+	replacePlaylists    bool
 )
 
 func init() {
@@ -50,7 +90,7 @@ func init() {
 		&updateCache,
 		"update-cache",
 		false,
-		"Also update the cache when listing (temporarily downloads each image)",
+		"Also record the album's photos in the cache, as sync does",
 	)
 	nixplayDeleteAlbumCmd.PersistentFlags().BoolVar(
 		&allowDeleteMultiple,
@@ -59,8 +99,25 @@ func init() {
 		"If there are multiple albums with the same name, delete them all instead of quitting",
 	)
 
+	nixplayDeletePlaylistCmd.PersistentFlags().BoolVar(
+		&allowDeleteMultiple,
+		"delete-multiple",
+		false,
+		"If there are multiple playlists with the same name, delete them all instead of quitting",
+	)
+	nixplayFramesAssignCmd.PersistentFlags().BoolVar(
+		&replacePlaylists,
+		"replace",
+		false,
+		"Make this the only playlist the frame shows, instead of adding it",
+	)
+
 	nixplayCmd.AddCommand(nixplayListCmd)
+	nixplayFramesCmd.AddCommand(nixplayFramesListCmd)
+	nixplayFramesCmd.AddCommand(nixplayFramesAssignCmd)
+	nixplayCmd.AddCommand(nixplayFramesCmd)
 	nixplayDeleteCmd.AddCommand(nixplayDeleteAlbumCmd)
+	nixplayDeleteCmd.AddCommand(nixplayDeletePlaylistCmd)
 	nixplayCmd.AddCommand(nixplayDeleteCmd)
 	rootCmd.AddCommand(nixplayCmd)
 }
@@ -78,7 +135,7 @@ func runNixplayList(cmd *cobra.Command, args []string) {
 }
 
 func runNixplayListAlbums() {
-	npClient := getNixplayClientOrExit()
+	npClient := getNixplayClientOrExit(nixplay.Options{})
 
 	npAlbums, err := npClient.GetAlbums()
 	if err != nil {
@@ -93,15 +150,24 @@ func runNixplayListAlbums() {
 }
 
 func runNixplayListAlbum(albumName string) {
-	npClient := getNixplayClientOrExit()
+	npClient := getNixplayClientOrExit(nixplay.Options{})
 
 	npAlbums, err := npClient.GetAlbumsByName(albumName)
 	if err != nil {
 		panic(err)
 	}
+	var c cache.Cache
+	if updateCache {
+		c, err = cache.New(promReg, cacheFilename)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	for _, npAlbum := range npAlbums {
 		page := 1
 		limit := 100
+		var entries []*cache.NixplayData
 		fmt.Printf("Photos for album %s (%d)\n", npAlbum.Title, npAlbum.ID)
 		for {
 			npPhotos, err := npClient.GetPhotos(npAlbum.ID, page, limit)
@@ -109,36 +175,21 @@ func runNixplayListAlbum(albumName string) {
 				panic(err)
 			}
 
-			var c cache.Cache
-			if updateCache {
-				c, err = cache.New(promReg, cacheFilename)
-				if err != nil {
-					panic(err)
-				}
-			}
-
 			for i, p := range npPhotos {
 				fmt.Printf("Nixplay Photo %d:\n", i+((page-1)*limit))
 				fmt.Printf("  Filename: %s\n", p.Filename)
 				fmt.Printf("  Date: %s\n", p.SortDate)
 				fmt.Printf("  URL: %s\n", p.URL)
 				fmt.Printf("  MD5: %s\n", p.Md5)
-				if updateCache {
-					timeNow := time.Now()
-					err := c.UpsertNixplay(&cache.NixplayData{
-						NixplayId:   p.ID,
-						URL:         p.URL,
-						Filename:    p.Filename,
-						SortDate:    p.SortDate,
-						Md5:         p.Md5,
-						LastUpdated: timeNow,
-						LastUsed:    timeNow,
-					})
-					if err != nil {
-						panic(err)
-					}
-				}
-
+				entries = append(entries, &cache.NixplayData{
+					AlbumId:   npAlbum.ID,
+					NixplayId: p.ID,
+					URL:       p.URL,
+					Filename:  p.Filename,
+					SortDate:  p.SortDate,
+					Md5:       p.Md5,
+					Caption:   p.Caption,
+				})
 			}
 
 			if len(npPhotos) < limit {
@@ -146,11 +197,21 @@ func runNixplayListAlbum(albumName string) {
 			}
 			page++
 		}
+
+		if updateCache {
+			err := c.ReplaceNixplayAlbum(&cache.NixplayAlbumData{
+				AlbumId: npAlbum.ID,
+				Title:   npAlbum.Title,
+			}, entries)
+			if err != nil {
+				panic(err)
+			}
+		}
 	}
 }
 
 func runNixplayDeleteAlbum(albumName string) {
-	npClient := getNixplayClientOrExit()
+	npClient := getNixplayClientOrExit(nixplay.Options{})
 
 	deletedCount, err := npClient.DeleteAlbumsByName(albumName, allowDeleteMultiple)
 	if err != nil {
@@ -158,3 +219,74 @@ func runNixplayDeleteAlbum(albumName string) {
 	}
 	fmt.Printf("Deleted %d albums named %s\n", deletedCount, albumName)
 }
+
+func runNixplayDeletePlaylist(playlistName string) {
+	npClient := getNixplayClientOrExit(nixplay.Options{})
+
+	deletedCount, err := npClient.DeletePlaylistsByName(playlistName, allowDeleteMultiple)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Deleted %d playlists named %s\n", deletedCount, playlistName)
+}
+
+func runNixplayFramesList() {
+	npClient := getNixplayClientOrExit(nixplay.Options{})
+
+	frames, err := npClient.GetFrames()
+	if err != nil {
+		panic(err)
+	}
+	playlists, err := npClient.GetPlaylists()
+	if err != nil {
+		panic(err)
+	}
+	playlistNames := make(map[int]string, len(playlists))
+	for _, pl := range playlists {
+		playlistNames[pl.Id] = pl.Name
+	}
+	for _, f := range frames {
+		fmt.Printf("Nixplay frame %s:\n", f.Name)
+		fmt.Printf("  Serial Number: %s\n", f.SerialNumber)
+		fmt.Printf("  Model: %s\n", f.Model)
+		fmt.Printf("  ID: %d\n", f.Id)
+		fmt.Printf("  Playlists:\n")
+		for _, id := range f.Playlists {
+			name, ok := playlistNames[id]
+			if !ok {
+				name = "(unknown)"
+			}
+			fmt.Printf("    %s (%d)\n", name, id)
+		}
+	}
+}
+
+// runNixplayFramesAssign adds a playlist to the ones a frame shows, or with
+// --replace, makes it the only one.
+func runNixplayFramesAssign(frameName string, playlistName string) {
+	npClient := getNixplayClientOrExit(nixplay.Options{})
+
+	frame, err := npClient.GetFrameByName(frameName)
+	if err != nil {
+		panic(err)
+	}
+	playlist, err := npClient.GetPlaylistByName(playlistName)
+	if err != nil {
+		panic(err)
+	}
+	playlists := []int{playlist.Id}
+	if !replacePlaylists {
+		for _, id := range frame.Playlists {
+			if id == playlist.Id {
+				fmt.Printf("Frame %s already shows playlist %s\n", frame.Name, playlist.Name)
+				return
+			}
+		}
+		playlists = append(append([]int{}, frame.Playlists...), playlist.Id)
+	}
+	err = npClient.SetFramePlaylists(frame.Id, playlists)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Assigned playlist %s to frame %s\n", playlist.Name, frame.Name)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNixplayFrames(t *testing.T) {
+	f := newFakes(t)
+	other := f.np.AddPlaylist("Other")
+	family := f.np.AddPlaylist("ss_Family")
+	f.np.AddFrame("Kitchen", "SN1")
+	f.np.AddFrame("Hall", "SN2")
+	shows := func() string {
+		var shows []string
+		for _, frame := range f.np.Frames() {
+			shows = append(shows, fmt.Sprintf("%s%v", frame.Name, frame.Playlists))
+		}
+		return fmt.Sprint(shows)
+	}
+
+	// Assigning adds to what the frame shows.
+	out := mustPicsync(t, f.dir, "nixplay", "frames", "assign", "Kitchen", "Other")
+	if !strings.Contains(out, "Assigned playlist Other to frame Kitchen") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	mustPicsync(t, f.dir, "nixplay", "frames", "assign", "SN1", "ss_Family")
+	want := fmt.Sprintf("[Kitchen[%d %d] Hall[]]", other.Id, family.Id)
+	if got := shows(); got != want {
+		t.Errorf("frames show %s, want %s", got, want)
+	}
+
+	out = mustPicsync(t, f.dir, "nixplay", "frames", "assign", "Kitchen", "Other")
+	if !strings.Contains(out, "Frame Kitchen already shows playlist Other") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if got := shows(); got != want {
+		t.Errorf("frames show %s, want %s", got, want)
+	}
+
+	out = mustPicsync(t, f.dir, "nixplay", "frames", "list")
+	for _, line := range []string{
+		"Nixplay frame Kitchen:\n  Serial Number: SN1\n",
+		fmt.Sprintf("  Playlists:\n    Other (%d)\n    ss_Family (%d)\nNixplay frame Hall:", other.Id, family.Id),
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("list doesn't have %q:\n%s", line, out)
+		}
+	}
+
+	// --replace makes it the only one.
+	mustPicsync(t, f.dir, "nixplay", "frames", "assign", "--replace", "Kitchen", "ss_Family")
+	want = fmt.Sprintf("[Kitchen[%d] Hall[]]", family.Id)
+	if got := shows(); got != want {
+		t.Errorf("frames show %s, want %s", got, want)
+	}
+
+	// An unknown frame fails, and changes nothing.
+	out, err := picsync(t, f.dir, "nixplay", "frames", "assign", "Attic", "Other")
+	if err == nil || !strings.Contains(out, `did not find frame "Attic" in 2 frames`) {
+		t.Errorf("assigned to an unknown frame (%v):\n%s", err, out)
+	}
+	if got := shows(); got != want {
+		t.Errorf("frames show %s, want %s", got, want)
+	}
+}
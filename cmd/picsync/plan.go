@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/sync"
+	"github.com/andrewjjenkins/picsync/pkg/util"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	planCmd = &cobra.Command{
+		Use:   "plan [<picsync.yaml>]",
+		Short: "Write a plan of what sync would do, without doing it",
+		Run:   runPlan,
+	}
+
+	applyCmd = &cobra.Command{
+		Use:   "apply <plan>",
+		Short: "Do exactly what a plan from \"picsync plan\" says",
+		Args:  cobra.ExactArgs(1),
+		Run:   runApply,
+	}
+
+	planOut    string
+	planFormat string
+)
+
+// planFileVersion is bumped if the plan file format changes incompatibly.
+const planFileVersion = 2
+
+type planFile struct {
+	Version     int                    `json:"version" yaml:"version"`
+	Created     string                 `json:"created" yaml:"created"`
+	Concurrency util.ConfigConcurrency `json:"concurrency" yaml:"concurrency"`
+	Blobs       *util.ConfigCacheBlobs `json:"blobs,omitempty" yaml:"blobs,omitempty"`
+	Albums      []*albumPlan           `json:"albums" yaml:"albums"`
+}
+
+// albumPlan keeps the album's config with its plan, so that apply can
+// enumerate the same sources without the original picsync.yaml.
+type albumPlan struct {
+	Album *util.ConfigAlbum `json:"album" yaml:"album"`
+	Plan  *sync.Plan        `json:"plan" yaml:"plan"`
+}
+
+func init() {
+	planCmd.PersistentFlags().StringVarP(
+		&planOut,
+		"outfile",
+		"o",
+		"picsync-plan.json",
+		"Write plan to file (\"-\" for stdout)",
+	)
+	planCmd.PersistentFlags().StringVar(
+		&planFormat,
+		"format",
+		"",
+		"Plan format, json or yaml (default: from the outfile extension, else json)",
+	)
+
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(applyCmd)
+}
+
+func planFileFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return "json"
+}
+
+func runPlan(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		panic(fmt.Errorf("pass the path to one picsync.yaml config file"))
+	}
+	configFile := "picsync.yaml"
+	if len(args) == 1 {
+		configFile = args[0]
+	}
+	config, err := util.LoadConfig(configFile)
+	if err != nil {
+		panic(err)
+	}
+
+	format := planFormat
+	if format == "" {
+		format = planFileFormat(planOut)
+	}
+	if format != "json" && format != "yaml" {
+		fmt.Printf("Unknown plan format %s (must be json or yaml)\n", format)
+		os.Exit(1)
+	}
+
+	clients := newSyncClientsOrExit(config.Concurrency, config.Cache.Blobs)
+
+	plans := planFile{
+		Version:     planFileVersion,
+		Created:     time.Now().Format(time.RFC3339),
+		Concurrency: config.Concurrency,
+		Blobs:       config.Cache.Blobs,
+	}
+	for _, album := range config.Albums {
+		sources, dest, opts, err := albumSync(clients, album)
+		if err != nil {
+			fmt.Printf("Error planning album %s: %v\n", album.Name, err)
+			os.Exit(1)
+		}
+		plan, err := clients.syncer.Plan(sources, dest, opts)
+		if err != nil {
+			fmt.Printf("Error planning album %s: %v\n", album.Name, err)
+			os.Exit(1)
+		}
+		plans.Albums = append(plans.Albums, &albumPlan{
+			Album: album,
+			Plan:  plan,
+		})
+	}
+
+	var out []byte
+	if format == "yaml" {
+		out, err = yaml.Marshal(&plans)
+	} else {
+		out, err = json.MarshalIndent(&plans, "", "  ")
+		out = append(out, '\n')
+	}
+	if err != nil {
+		panic(err)
+	}
+	if planOut == "-" {
+		os.Stdout.Write(out)
+		return
+	}
+	if err = os.WriteFile(planOut, out, 0644); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Wrote plan for %d albums to %s\n", len(plans.Albums), planOut)
+}
+
+func loadPlanFile(filename string) (*planFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	in, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := planFile{}
+	if planFileFormat(filename) == "yaml" {
+		err = yaml.Unmarshal(in, &plans)
+	} else {
+		err = json.Unmarshal(in, &plans)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if plans.Version != planFileVersion {
+		return nil, fmt.Errorf("plan %s is version %d, this picsync only understands %d",
+			filename, plans.Version, planFileVersion)
+	}
+	return &plans, nil
+}
+
+func runApply(cmd *cobra.Command, args []string) {
+	plans, err := loadPlanFile(args[0])
+	if err != nil {
+		fmt.Printf("Cannot load plan: %v\n", err)
+		os.Exit(1)
+	}
+
+	clients := newSyncClientsOrExit(plans.Concurrency, plans.Blobs)
+
+	for _, ap := range plans.Albums {
+		if ap.Album == nil || ap.Plan == nil {
+			fmt.Printf("Plan %s is missing an album or its plan\n", args[0])
+			os.Exit(1)
+		}
+		sources, dest, opts, err := albumSync(clients, ap.Album)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		if opts.DryRun {
+			fmt.Printf("Album %s is dryRun, not applying\n", ap.Album.Name)
+			continue
+		}
+		err = clients.syncer.Apply(ap.Plan, sources, dest, opts)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+}
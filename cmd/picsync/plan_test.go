@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+)
+
+// planFakes returns fakes with a Google Photos album of n photos, and a
+// picsync.yaml that syncs it.
+func planFakes(t *testing.T, n int) (*fakes, string) {
+	t.Helper()
+	f := newFakes(t)
+	album := f.gp.AddAlbum("Family", false)
+	for i := 1; i <= n; i++ {
+		f.gp.AddMediaItem(album.Id, googlephotos.MediaItem{
+			Filename: fmt.Sprintf("photo%d.jpg", i),
+		}, []byte(fmt.Sprintf("photo %d", i)))
+	}
+	f.write(t, "picsync.yaml", `
+albums:
+- name: Family
+  sources:
+    googlephotos:
+    - title:Family
+`)
+	return f, album.Id
+}
+
+func TestPlanApply(t *testing.T) {
+	for _, planName := range []string{"plan.json", "plan.yaml"} {
+		t.Run(planName, func(t *testing.T) {
+			f, _ := planFakes(t, 2)
+			mustPicsync(t, f.dir, "plan", "picsync.yaml", "--cache", "cache.db", "-o", planName)
+
+			// The plan reads back as written.
+			plans, err := loadPlanFile(filepath.Join(f.dir, planName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plans.Version != planFileVersion || len(plans.Albums) != 1 {
+				t.Fatalf("read back version %d with %d albums", plans.Version, len(plans.Albums))
+			}
+			ap := plans.Albums[0]
+			if ap.Album.Name != "Family" || len(ap.Album.Sources.Googlephotos) != 1 {
+				t.Errorf("read back album %+v", ap.Album)
+			}
+			if len(ap.Plan.Uploads) != 2 || len(ap.Plan.Publish) != 1 || ap.Plan.Publish[0].Playlist != "ss_Family" {
+				t.Errorf("read back plan %+v", ap.Plan)
+			}
+			if len(f.np.Albums()) != 0 {
+				t.Fatalf("planning changed Nixplay")
+			}
+
+			mustPicsync(t, f.dir, "apply", planName, "--cache", "cache.db")
+			albums := f.np.Albums()
+			if len(albums) != 1 || len(f.np.Photos(albums[0].ID)) != 2 {
+				t.Errorf("after apply, albums %v", albums)
+			}
+		})
+	}
+}
+
+func TestApplyRefusesStalePlan(t *testing.T) {
+	f, albumId := planFakes(t, 2)
+	mustPicsync(t, f.dir, "plan", "picsync.yaml", "--cache", "cache.db", "-o", "plan.json")
+
+	// Something changed since the plan was made.
+	f.gp.AddMediaItem(albumId, googlephotos.MediaItem{Filename: "photo3.jpg"}, []byte("photo 3"))
+	out, err := picsync(t, f.dir, "apply", "plan.json", "--cache", "cache.db")
+	if err == nil {
+		t.Fatalf("applied a stale plan:\n%s", out)
+	}
+	if !strings.Contains(out, "plan for nixplay album Family is stale, run plan again: "+
+		"upload of photo3.jpg") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if albums := f.np.Albums(); len(albums) != 0 {
+		t.Errorf("stale plan changed Nixplay: %v", albums)
+	}
+}
+
+func TestLoadPlanFileRejectsOtherVersions(t *testing.T) {
+	f := &fakes{dir: t.TempDir()}
+	for _, version := range []int{planFileVersion - 1, planFileVersion + 1} {
+		for _, name := range []string{"plan.json", "plan.yaml"} {
+			content := fmt.Sprintf(`{"version": %d, "albums": []}`, version)
+			if name == "plan.yaml" {
+				content = fmt.Sprintf("version: %d\nalbums: []\n", version)
+			}
+			f.write(t, name, content)
+			_, err := loadPlanFile(filepath.Join(f.dir, name))
+			want := fmt.Sprintf("is version %d, this picsync only understands %d", version, planFileVersion)
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s version %d: got %v, want %q", name, version, err, want)
+			}
+		}
+	}
+
+	// Apply says so, and does nothing.
+	f.write(t, "plan.json", fmt.Sprintf(`{"version": %d, "albums": []}`, planFileVersion-1))
+	out, err := picsync(t, f.dir, "apply", "plan.json")
+	if err == nil || !strings.Contains(out, "Cannot load plan") {
+		t.Errorf("applied an old plan (%v):\n%s", err, out)
+	}
+}
@@ -2,14 +2,16 @@ package main
 
 import (
 	"fmt"
-	"net/http"
 	"os"
-	"strconv"
 	"time"
 
+	"github.com/andrewjjenkins/picsync/pkg/blob"
 	"github.com/andrewjjenkins/picsync/pkg/cache"
 	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/local"
 	"github.com/andrewjjenkins/picsync/pkg/nixplay"
+	"github.com/andrewjjenkins/picsync/pkg/sync"
+	"github.com/andrewjjenkins/picsync/pkg/transform"
 	"github.com/andrewjjenkins/picsync/pkg/util"
 	"github.com/robfig/cron"
 	"github.com/spf13/cobra"
@@ -30,7 +32,15 @@ func init() {
 type syncClients struct {
 	googlephotos googlephotos.Client
 	nixplay      nixplay.Client
+	local        local.Client
+	transform    transform.Client
 	cache        cache.Cache
+	syncer       sync.Syncer
+	concurrency  util.ConfigConcurrency
+
+	// nixplayOpts is how sync uses the cache of Nixplay albums; plans
+	// always list them.
+	nixplayOpts sync.NixplayOptions
 }
 
 func runSync(cmd *cobra.Command, args []string) {
@@ -52,7 +62,41 @@ func runSync(cmd *cobra.Command, args []string) {
 		pprofInitOrDie(config.Pprof.Listen)
 	}
 
-	clients := syncClients{}
+	var gc *cache.GCOptions
+	if config.Every != "" && config.Cache.GC != nil {
+		opts, err := gcOptions(config, *config.Cache.GC)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		gc = &opts
+	}
+
+	var nixplayOpts sync.NixplayOptions
+	if config.Cache.Nixplay.MaxAge != "" {
+		maxAge, err := time.ParseDuration(config.Cache.Nixplay.MaxAge)
+		if err != nil || maxAge < 0 {
+			fmt.Printf("bad cache nixplay maxAge %q\n", config.Cache.Nixplay.MaxAge)
+			os.Exit(1)
+		}
+		nixplayOpts.MaxAge = maxAge
+	}
+
+	clients := newSyncClientsOrExit(config.Concurrency, config.Cache.Blobs)
+	clients.nixplayOpts = nixplayOpts
+
+	if config.Every != "" {
+		runSyncGooglephotosEvery(clients, config.Albums, config.Every, gc)
+	} else {
+		runSyncGooglephotosOnce(clients, config.Albums)
+	}
+}
+
+func newSyncClientsOrExit(concurrency util.ConfigConcurrency, blobs *util.ConfigCacheBlobs) syncClients {
+	var err error
+	clients := syncClients{
+		concurrency: concurrency,
+	}
 
 	// Create the cache up here so we can pass it down, this avoids
 	// re-creating the cache (opening/closing Sqlite db) every run
@@ -62,15 +106,39 @@ func runSync(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
-	// Log in to services; exit early if there's an auth problem
-	clients.googlephotos = getGooglephotoClientOrExit(clients.cache)
-	clients.nixplay = getNixplayClientOrExit()
-
-	if config.Every != "" {
-		runSyncGooglephotosEvery(clients, config.Albums, config.Every)
-	} else {
-		runSyncGooglephotosOnce(clients, config.Albums)
+	var store blob.Store
+	if blobs != nil {
+		dir := blobs.Dir
+		if dir == "" {
+			dir = cacheFilename + ".blobs"
+		}
+		store, err = blob.NewStore(clients.cache, promReg, blob.Options{
+			Dir:      dir,
+			MaxBytes: blobs.MaxSizeMB << 20,
+		})
+		if err != nil {
+			fmt.Printf("Cannot open blob store %s: %v\n", dir, err)
+			os.Exit(1)
+		}
 	}
+
+	// Log in to services; exit early if there's an auth problem
+	clients.googlephotos = getGooglephotoClientOrExit(clients.cache, googlephotos.Options{
+		MaxConcurrentDownloads: concurrency.Googlephotos,
+		Blobs:                  store,
+	})
+	clients.nixplay = getNixplayClientOrExit(nixplay.Options{
+		MaxConcurrentUploads: concurrency.Nixplay,
+	})
+	clients.local = local.NewClient(clients.cache, promReg, local.Options{
+		MaxConcurrentReads: concurrency.Local,
+	})
+	clients.transform = transform.NewClient(clients.cache, promReg, transform.Options{
+		MaxConcurrent: concurrency.Transform,
+		Blobs:         store,
+	})
+	clients.syncer = sync.New(promReg)
+	return clients
 }
 
 func runSyncGooglephotosOnce(clients syncClients, albums []*util.ConfigAlbum) {
@@ -84,7 +152,9 @@ func runSyncGooglephotosOnce(clients syncClients, albums []*util.ConfigAlbum) {
 	os.Exit(0)
 }
 
-func runSyncGooglephotosEvery(clients syncClients, albums []*util.ConfigAlbum, every string) {
+// runSyncGooglephotosEvery syncs albums every so often, and garbage collects
+// the cache after each sync if gc is set.
+func runSyncGooglephotosEvery(clients syncClients, albums []*util.ConfigAlbum, every string, gc *cache.GCOptions) {
 	everyCronSpec := fmt.Sprintf("@every %s", every)
 	job := func() {
 		for _, album := range albums {
@@ -93,8 +163,17 @@ func runSyncGooglephotosEvery(clients syncClients, albums []*util.ConfigAlbum, e
 				fmt.Printf("Error syncing album %s: %v\n", album.Name, err)
 			}
 		}
-		fmt.Printf("%s: Sync of %d albums complete\n\n",
+		fmt.Printf("%s: Sync of %d albums complete\n",
 			time.Now().String(), len(albums))
+		if gc != nil {
+			result, err := clients.cache.GC(*gc)
+			if err != nil {
+				fmt.Printf("Error collecting cache: %v\n", err)
+			} else {
+				fmt.Printf("Removed %d cache entries\n", result.Total())
+			}
+		}
+		fmt.Printf("\n")
 	}
 
 	c := cron.New()
@@ -112,243 +191,184 @@ func runSyncGooglephotosEvery(clients syncClients, albums []*util.ConfigAlbum, e
 }
 
 func doSyncGooglephotos(clients syncClients, album *util.ConfigAlbum) error {
-	sourceAlbums := album.Sources.Googlephotos
-
-	if len(sourceAlbums) == 0 {
-		fmt.Printf("No source album. Cowardly refusing to delete all destination photos.\n")
-		return nil
+	sources, dest, opts, err := albumSync(clients, album)
+	if err != nil {
+		return err
 	}
+	return clients.syncer.Sync(sources, dest, opts)
+}
 
-	var sourceCacheImages []*googlephotos.CachedMediaItem
-	for i, sourceAlbumId := range sourceAlbums {
-		var sourceCacheUpdateCount int
-		sourceCacheUpdateCb := func(cached *googlephotos.CachedMediaItem) {
-			sourceCacheUpdateCount++
-			fmt.Fprintf(os.Stdout, "\033[2K\rRefreshing source image %d...", sourceCacheUpdateCount)
-		}
-
-		var nextPageToken string
-		for ok := true; ok; ok = (nextPageToken != "") {
-			res, err := clients.googlephotos.UpdateCacheForAlbumId(
-				sourceAlbumId, nextPageToken, sourceCacheUpdateCb)
-			if err != nil {
-				return err
+// albumSync returns the sources, destination and options for a configured
+// album.
+func albumSync(clients syncClients, album *util.ConfigAlbum) ([]sync.Source, sync.Destination, sync.Options, error) {
+	var videos googlephotos.VideoPolicy
+	if album.Videos != nil {
+		videos.Policy = album.Videos.Policy
+		videos.MaxSize = album.Videos.MaxSizeMB << 20
+		videos.Oversized = album.Videos.Oversized
+		if album.Videos.MaxDuration != "" {
+			maxDuration, err := time.ParseDuration(album.Videos.MaxDuration)
+			if err != nil || maxDuration <= 0 {
+				return nil, nil, sync.Options{}, fmt.Errorf("album %s: bad videos maxDuration %q", album.Name, album.Videos.MaxDuration)
 			}
-			nextPageToken = res.NextPageToken
-			sourceCacheImages = append(sourceCacheImages, res.CachedMediaItems...)
+			videos.MaxDuration = maxDuration
 		}
-		fmt.Fprintf(os.Stdout, "\033[2K\rRefreshed %d source images for album %d/%d\n",
-			sourceCacheUpdateCount, i+1, len(sourceAlbums))
-	}
-
-	// Get the nixplay album specified by the user.
-	// It is possible for there to be multiple albums with the same name
-	// (they will have different IDs).  We don't support that however.
-	npAlbums, err := clients.nixplay.GetAlbumsByName(album.Name)
-	if err != nil {
-		return err
-	}
-	var npAlbum *nixplay.Album
-	if len(npAlbums) == 0 {
-		fmt.Printf("Could not get nixplay album %s, creating.\n", album.Name)
-		npAlbum, err = clients.nixplay.CreateAlbum(album.Name)
-		if err != nil {
-			return err
+		if err := videos.Check(); err != nil {
+			return nil, nil, sync.Options{}, fmt.Errorf("album %s: %v", album.Name, err)
 		}
-	} else if len(npAlbums) > 1 {
-		// See "picsync nixplay delete album --delete-multiple"
-		return fmt.Errorf(
-			"multiple nixplay albums named %s, you must delete all but one",
-			album.Name,
-		)
-	} else {
-		npAlbum = npAlbums[0]
 	}
 
-	// Get the nixplay image metadata for the requested album
-	var npPhotos []*nixplay.Photo
-	page := 1
-	limit := 100
-	for {
-		fmt.Fprintf(os.Stdout, "\033[2k\rRefreshing destination image %d...", len(npPhotos))
-		photos, err := clients.nixplay.GetPhotos(npAlbum.ID, page, limit)
+	var sources []sync.Source
+	for _, sourceAlbum := range album.Sources.Googlephotos {
+		ref, err := googlephotos.ParseAlbumRef(sourceAlbum)
 		if err != nil {
-			return err
+			return nil, nil, sync.Options{}, fmt.Errorf("album %s: %v", album.Name, err)
 		}
-		page++
-		npPhotos = append(npPhotos, photos...)
-		if len(photos) < limit {
-			break
+		sources = append(sources,
+			sync.NewGooglephotosAlbumSource(clients.googlephotos, ref, videos))
+	}
+	for _, sourceSearch := range album.Sources.GooglephotosSearch {
+		search := googlephotos.Search{
+			From:       sourceSearch.From,
+			To:         sourceSearch.To,
+			Last:       sourceSearch.Last,
+			OnThisDay:  sourceSearch.OnThisDay,
+			DaysAround: sourceSearch.DaysAround,
+			Include:    sourceSearch.Categories,
+			Exclude:    sourceSearch.ExcludeCategories,
+			MediaType:  sourceSearch.MediaType,
+			Favorites:  sourceSearch.Favorites,
 		}
-	}
-	fmt.Fprintf(os.Stdout, "\033[2K\rRefreshed %d destination images for album %s\n",
-		len(npPhotos), npAlbum.Title)
-
-	work, err := calcSyncGooglephotosWork(sourceCacheImages, npPhotos)
-	if err != nil {
-		return err
-	}
-	fmt.Printf("Sync work:\n")
-	fmt.Printf("  To upload: %d\n", len(work.ToUpload))
-	fmt.Printf("  To delete: %d\n", len(work.ToDelete))
-
-	if album.DryRun != nil && *album.DryRun {
-		return nil
-	}
-
-	for i, up := range work.ToUpload {
-		fmt.Fprintf(os.Stdout, "\033[2K\rUploading image %d/%d...", i+1, len(work.ToUpload))
-		err := uploadGooglephotoToNixplay(up, npAlbum.ID, clients.nixplay)
-		if err != nil {
-			fmt.Printf("\nError uploading photo %s (skipping): %v\n", up.MediaItem.Filename, err)
+		if _, err := search.Filters(time.Now()); err != nil {
+			return nil, nil, sync.Options{}, fmt.Errorf("album %s: bad Google Photos search (%s): %v", album.Name, &search, err)
+		}
+		var searchAlbum *googlephotos.AlbumRef
+		if sourceSearch.Album != "" {
+			ref, err := googlephotos.ParseAlbumRef(sourceSearch.Album)
+			if err != nil {
+				return nil, nil, sync.Options{}, fmt.Errorf("album %s: %v", album.Name, err)
+			}
+			if err := search.CheckLocal(); err != nil {
+				return nil, nil, sync.Options{}, fmt.Errorf("album %s: bad Google Photos search (%s): %v", album.Name, &search, err)
+			}
+			searchAlbum = &ref
+		}
+		sources = append(sources,
+			sync.NewGooglephotosSearchSource(clients.googlephotos, search, searchAlbum, videos))
+	}
+	for _, sourceDir := range album.Sources.Local {
+		sources = append(sources,
+			sync.NewLocalSource(clients.local, local.Dir{
+				Path:      sourceDir.Path,
+				Glob:      sourceDir.Glob,
+				Recursive: sourceDir.Recursive,
+			}))
+	}
+	if album.Transform != nil {
+		settings := transform.Settings{
+			MaxWidth:  album.Transform.MaxWidth,
+			MaxHeight: album.Transform.MaxHeight,
+			Quality:   album.Transform.Quality,
+			StripGPS:  album.Transform.StripGPS,
+		}
+		if err := settings.Check(); err != nil {
+			return nil, nil, sync.Options{}, fmt.Errorf("album %s: bad transform: %v", album.Name, err)
+		}
+		if settings.Enabled() {
+			for i, source := range sources {
+				sources[i] = sync.NewTransformSource(source, clients.transform, settings)
+			}
 		}
 	}
-	if len(work.ToUpload) > 0 {
-		fmt.Printf("DONE.  Uploading complete.\n")
-	}
+	dest := sync.NewNixplayDestination(clients.nixplay, clients.cache, album.Name, clients.nixplayOpts)
 
-	if len(work.ToUpload) > 0 {
-		fmt.Printf("Sleeping for 5 seconds to let nixplay digest uploaded photos...\n")
-		time.Sleep(5 * time.Second)
+	opts := sync.Options{
+		MaxConcurrentUploads: clients.concurrency.Nixplay,
 	}
-
-	for i, del := range work.ToDelete {
-		fmt.Fprintf(os.Stdout, "\033[2K\rDeleting image %d/%d...", i+1, len(work.ToDelete))
-		err := deleteGooglephotoFromNixplay(del, clients.nixplay)
-		if err != nil {
-			fmt.Printf("\nError deleting photo %s (skipping): %v\n", del.Filename, err)
-		}
+	if album.DryRun != nil {
+		opts.DryRun = *album.DryRun
 	}
-	if len(work.ToDelete) > 0 {
-		fmt.Printf("DONE.  Deleting complete.\n")
+	if album.ForcePublish != nil {
+		opts.ForcePublish = *album.ForcePublish
+	}
+	if album.Delete != nil {
+		opts.Additive = !*album.Delete
 	}
 
-	// FIXME: This should be commonized
-	// Now, get the photos again and put them in a playlist
-	npPhotos = nil
-	page = 1
-	limit = 100
-	for {
-		fmt.Fprintf(os.Stdout, "\033[2k\rRefreshing playlist image %d...", len(npPhotos))
-		photos, err := clients.nixplay.GetPhotos(npAlbum.ID, page, limit)
-		if err != nil {
-			return err
-		}
-		page++
-		npPhotos = append(npPhotos, photos...)
-		if len(photos) < limit {
-			break
-		}
+	opts.Sample = sync.SampleOptions{
+		MaxPhotos: album.MaxPhotos,
+		Selection: album.Selection,
+		Seed:      album.Seed,
 	}
-	fmt.Fprintf(os.Stdout, "\033[2K\rRefreshed %d playlist images for album %s\n",
-		len(npPhotos), npAlbum.Title)
-	plName := fmt.Sprintf("ss_%s", album.Name)
-	pl, err := clients.nixplay.GetPlaylistByName(plName)
-	var playlistId int
-	neededCreate := false
-	if err == nil {
-		playlistId = pl.Id
-	} else {
-		fmt.Printf("Could not find playlist %s (%v), creating\n", plName, err)
-		fmt.Printf(
-			"If this works, you must then assign the playlist %s to frames - "+
-				"this program will not do that (but it will update the playlist once "+
-				"you've assigned it)\n",
-			plName,
-		)
-		neededCreate = true
-		playlistId, err = clients.nixplay.CreatePlaylist(plName)
-		if err != nil {
-			return err
-		}
+	if opts.Sample.Seed == "" {
+		opts.Sample.Seed = album.Name
 	}
-
-	forcePublish := false
-	if album.ForcePublish != nil {
-		forcePublish = *album.ForcePublish
+	if err := sync.CheckSelection(album.Selection); err != nil {
+		return nil, nil, sync.Options{}, fmt.Errorf("album %s: %v", album.Name, err)
 	}
-	if len(work.ToUpload) > 0 || len(work.ToDelete) > 0 || neededCreate || forcePublish {
-		err = clients.nixplay.PublishPlaylist(playlistId, npPhotos)
+	if album.Caption != "" {
+		captions, err := sync.NewCaptions(album.Caption)
 		if err != nil {
-			return err
+			return nil, nil, sync.Options{}, fmt.Errorf("album %s: bad caption: %v", album.Name, err)
 		}
-		fmt.Printf("Published %d photos to playlist %s\n", len(npPhotos), plName)
-	} else {
-		fmt.Printf(
-			"No changes required for slideshow %s (%d photos)\n",
-			plName,
-			len(npPhotos),
-		)
+		opts.Captions = captions
 	}
-	return nil
-}
-
-type syncGooglephotosWork struct {
-	ToUpload []*googlephotos.CachedMediaItem
-	ToDelete []*nixplay.Photo
-}
-type nixplayAlbumImagesByMd5 map[string]*nixplay.Photo
-
-func calcSyncGooglephotosWork(sourceImgs []*googlephotos.CachedMediaItem, destImgs []*nixplay.Photo) (*syncGooglephotosWork, error) {
-	work := syncGooglephotosWork{}
-
-	// Create a lookup-by-md5 for all the images already in the destination album
-	targetMd5s := make(nixplayAlbumImagesByMd5)
-	for _, img := range destImgs {
-		alreadyThere, ok := targetMd5s[img.Md5]
-		if ok {
-			fmt.Printf(
-				"Warning: duplicate images with MD5 %s (%s, %s)\n",
-				img.Md5, alreadyThere.Filename, img.Filename,
-			)
-			continue
-		}
-		targetMd5s[img.Md5] = img
+	playlists, err := albumPlaylists(album, opts.Sample.Seed)
+	if err != nil {
+		return nil, nil, sync.Options{}, fmt.Errorf("album %s: %v", album.Name, err)
 	}
-
-	// For each source image, find if it is already in the destination.
-	for _, img := range sourceImgs {
-		_, ok := targetMd5s[img.Md5]
-		if !ok {
-			work.ToUpload = append(work.ToUpload, img)
-			continue
+	opts.Playlists = playlists
+	if album.ReshuffleEvery != "" {
+		every, err := time.ParseDuration(album.ReshuffleEvery)
+		if err != nil || every <= 0 {
+			return nil, nil, sync.Options{}, fmt.Errorf("album %s: bad reshuffleEvery %q", album.Name, album.ReshuffleEvery)
 		}
-
-		// If it is present, delete it from targetMd5s so it won't count toward
-		// toDelete.  This only works if there are no duplicates in source.
-		delete(targetMd5s, img.Md5)
+		opts.Sample.ReshuffleEvery = every
 	}
-
-	// Everything left in np isn't referenced by an entry in sourceImgs.
-	for _, targetImg := range targetMd5s {
-		work.ToDelete = append(work.ToDelete, targetImg)
-	}
-
-	return &work, nil
+	return sources, dest, opts, nil
 }
 
-func uploadGooglephotoToNixplay(from *googlephotos.CachedMediaItem, toAlbum int, npClient nixplay.Client) error {
-	fullResUrl := from.MediaItem.BaseUrl + "=d"
-	imgResp, err := http.Get(fullResUrl)
-	if err != nil {
-		return err
+// albumPlaylists returns the playlists a configured album is published to.
+func albumPlaylists(album *util.ConfigAlbum, seed string) ([]sync.PlaylistOptions, error) {
+	if album.Playlist != nil && len(album.Playlists) > 0 {
+		return nil, fmt.Errorf("set playlist or playlists, not both")
 	}
-	defer imgResp.Body.Close()
-	if imgResp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed downloading Googlephoto to upload (%d)", imgResp.StatusCode)
+	configs := album.Playlists
+	if album.Playlist != nil {
+		configs = []util.ConfigPlaylist{*album.Playlist}
 	}
-
-	filename := from.MediaItem.Filename
-	filetype := imgResp.Header.Get("content-type")
-	filesizeStr := imgResp.Header.Get("content-length")
-	filesize, err := strconv.ParseUint(filesizeStr, 10, 64)
-	if err != nil {
-		return err
+	if len(configs) == 0 {
+		configs = []util.ConfigPlaylist{{}}
 	}
 
-	return npClient.UploadPhoto(toAlbum, filename, filetype, filesize, imgResp.Body)
-}
+	var playlists []sync.PlaylistOptions
+	for _, config := range configs {
+		name, err := sync.PlaylistName(config.Name, album.Name)
+		if err != nil {
+			return nil, err
+		}
 
-func deleteGooglephotoFromNixplay(del *nixplay.Photo, npClient nixplay.Client) error {
-	return npClient.DeletePhoto(del.ID)
+		order := config.Order
+		if order == "" {
+			order = album.Order
+		}
+		if err := sync.CheckOrder(order); err != nil {
+			return nil, fmt.Errorf("playlist %s: %v", name, err)
+		}
+		frames := config.Frames
+		if frames == nil {
+			frames = album.Frames
+		}
+		if config.MaxPhotos < 0 {
+			return nil, fmt.Errorf("playlist %s: bad maxPhotos %d", name, config.MaxPhotos)
+		}
+		playlists = append(playlists, sync.PlaylistOptions{
+			Name:      name,
+			Order:     sync.OrderOptions{By: order, Seed: seed},
+			Sources:   config.Sources,
+			MaxPhotos: config.MaxPhotos,
+			Frames:    frames,
+		})
+	}
+	return playlists, nil
 }
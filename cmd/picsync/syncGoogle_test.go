@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+)
+
+func TestSyncCommand(t *testing.T) {
+	f := newFakes(t)
+	album := f.gp.AddAlbum("Family 2022", false)
+	var want []string
+	for n := 1; n <= 3; n++ {
+		content := []byte(fmt.Sprintf("photo %d", n))
+		f.gp.AddMediaItem(album.Id, googlephotos.MediaItem{
+			Filename: fmt.Sprintf("photo%d.jpg", n),
+			MimeType: "image/jpeg",
+		}, content)
+		sum := md5.Sum(content)
+		want = append(want, hex.EncodeToString(sum[:]))
+	}
+	sort.Strings(want)
+	f.np.AddFrame("Kitchen", "SN1")
+	f.write(t, "picsync.yaml", `
+albums:
+- name: Family
+  sources:
+    googlephotos:
+    - title:Family*
+  frames: [Kitchen]
+`)
+
+	mustPicsync(t, f.dir, "sync", "picsync.yaml", "--cache", "cache.db")
+
+	albums := f.np.Albums()
+	if len(albums) != 1 || albums[0].Title != "Family" {
+		t.Fatalf("albums %v, want just Family", albums)
+	}
+	var got []string
+	for _, p := range f.np.Photos(albums[0].ID) {
+		got = append(got, p.Md5)
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("album has %v, want %v", got, want)
+	}
+	playlists := f.np.Playlists()
+	if len(playlists) != 1 || playlists[0].Name != "ss_Family" {
+		t.Fatalf("playlists %v, want just ss_Family", playlists)
+	}
+	if items := f.np.PlaylistItems(playlists[0].Id); len(items) != 3 {
+		t.Errorf("playlist has %d items, want 3", len(items))
+	}
+	if frames := f.np.Frames(); len(frames[0].Playlists) != 1 || frames[0].Playlists[0] != playlists[0].Id {
+		t.Errorf("frame shows %v, want [%d]", frames[0].Playlists, playlists[0].Id)
+	}
+
+	// The next run uses the cache, and has nothing to do.
+	downloads := f.gp.Downloads()
+	out := mustPicsync(t, f.dir, "sync", "picsync.yaml", "--cache", "cache.db")
+	if !strings.Contains(out, "No changes required for slideshow ss_Family (3 photos)") {
+		t.Errorf("second sync did something:\n%s", out)
+	}
+	if got := f.gp.Downloads() - downloads; got != 0 {
+		t.Errorf("second sync downloaded %d items", got)
+	}
+}
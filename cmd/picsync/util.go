@@ -7,7 +7,7 @@ import (
 	"github.com/andrewjjenkins/picsync/pkg/nixplay"
 )
 
-func getNixplayClientOrExit() (c nixplay.Client) {
+func getNixplayClientOrExit(opts nixplay.Options) (c nixplay.Client) {
 	username := viper.GetString("nixplay.username")
 	if username == "" {
 		fmt.Printf("Must provide a nixplay username")
@@ -18,7 +18,9 @@ func getNixplayClientOrExit() (c nixplay.Client) {
 		fmt.Printf("Must provide a nixplay password")
 		os.Exit(1)
 	}
-	c, err := nixplay.NewClient(username, password, promReg)
+	opts.CookieFile = viper.GetString("nixplay.cookie_file")
+	opts.BaseURL = viper.GetString("nixplay.base_url")
+	c, err := nixplay.NewClient(username, password, promReg, opts)
 	if err != nil {
 		fmt.Printf("Nixplay login error: %v", err)
 		os.Exit(1)
@@ -0,0 +1,59 @@
+package blob
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type promImpl struct {
+	promFactory promauto.Factory
+
+	hits         prometheus.Counter
+	misses       prometheus.Counter
+	stored       prometheus.Counter
+	storedBytes  prometheus.Counter
+	evicted      prometheus.Counter
+	evictedBytes prometheus.Counter
+	bytes        prometheus.Gauge
+}
+
+func (s *storeImpl) promRegister(reg prometheus.Registerer) error {
+	s.prom.promFactory = promauto.With(reg)
+
+	s.prom.hits = s.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "blob_store_hits",
+			Help: "Number of files that were read from the blob store instead of downloaded",
+		})
+	s.prom.misses = s.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "blob_store_misses",
+			Help: "Number of files that were not in the blob store",
+		})
+	s.prom.stored = s.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "blob_store_stored",
+			Help: "Number of files that were added to the blob store",
+		})
+	s.prom.storedBytes = s.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "blob_store_stored_bytes",
+			Help: "Total bytes of all files added to the blob store",
+		})
+	s.prom.evicted = s.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "blob_store_evicted",
+			Help: "Number of files removed from the blob store to make room",
+		})
+	s.prom.evictedBytes = s.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "blob_store_evicted_bytes",
+			Help: "Total bytes of all files removed from the blob store to make room",
+		})
+	s.prom.bytes = s.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "blob_store_bytes",
+			Help: "Total bytes of all files in the blob store",
+		})
+	return nil
+}
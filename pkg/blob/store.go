@@ -0,0 +1,263 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Blob is the content of a stored file.  The caller must close it.
+type Blob struct {
+	io.ReadCloser
+	Sha256      string
+	ContentType string
+	Size        int64
+}
+
+// Store keeps downloaded files on disk, named by their SHA256, so that they
+// don't have to be downloaded again.  Storing is best effort: if a file
+// can't be stored, a warning is printed and the caller carries on.
+type Store interface {
+	// Open returns the file with SHA256 sha256, or nil if it isn't stored.
+	Open(sha256 string) (*Blob, error)
+
+	// Tee returns a reader of r that stores what is read, once all of it is
+	// read and the reader is closed.  size is how big r is, or -1 if that
+	// isn't known.  If sha256 is set and the content doesn't match it, it
+	// isn't stored.
+	Tee(r io.ReadCloser, size int64, contentType string, sha256 string) io.ReadCloser
+}
+
+// Options are settings for a Store.
+type Options struct {
+	// Dir is where files are stored.  It is created if it doesn't exist.
+	Dir string
+
+	// MaxBytes, if set, bounds how big all the files are together.  The
+	// least recently used are removed to make room for new ones, and files
+	// bigger than this aren't stored at all.
+	MaxBytes int64
+}
+
+type storeImpl struct {
+	cache cache.Cache
+	dir   string
+	max   int64
+
+	// mu serializes changes, so eviction sees every stored file.
+	mu sync.Mutex
+
+	prom promImpl
+}
+
+// NewStore returns a Store of files in opts.Dir, recorded in c.
+func NewStore(c cache.Cache, reg prometheus.Registerer, opts Options) (Store, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("must specify blob store directory")
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	s := storeImpl{
+		cache: c,
+		dir:   opts.Dir,
+		max:   opts.MaxBytes,
+	}
+	s.promRegister(reg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.evict(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *storeImpl) path(sha256 string) string {
+	return filepath.Join(s.dir, sha256[:2], sha256)
+}
+
+func (s *storeImpl) Open(sha256 string) (*Blob, error) {
+	if len(sha256) < 2 {
+		return nil, fmt.Errorf("bad SHA256 %q", sha256)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.cache.GetBlob(sha256)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		s.prom.misses.Inc()
+		return nil, nil
+	}
+	f, err := os.Open(s.path(sha256))
+	if err == nil {
+		var info os.FileInfo
+		if info, err = f.Stat(); err == nil && info.Size() != b.Size {
+			err = fmt.Errorf("is %d bytes, expected %d", info.Size(), b.Size)
+		}
+		if err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		// It was removed (or damaged) behind our back; forget it.
+		fmt.Printf("Warning: stored blob %s is unusable (%v), forgetting it\n", sha256, err)
+		os.Remove(s.path(sha256))
+		s.prom.misses.Inc()
+		return nil, s.cache.DeleteBlob(sha256)
+	}
+	s.prom.hits.Inc()
+	return &Blob{
+		ReadCloser:  f,
+		Sha256:      sha256,
+		ContentType: b.ContentType,
+		Size:        b.Size,
+	}, nil
+}
+
+func (s *storeImpl) Tee(r io.ReadCloser, size int64, contentType string, want string) io.ReadCloser {
+	if s.max > 0 && size > s.max {
+		// It won't be stored, so don't bother copying it.
+		return r
+	}
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		fmt.Printf("Warning: can't store blob: %v\n", err)
+		return r
+	}
+	return &teeReader{
+		ReadCloser:  r,
+		store:       s,
+		tmp:         tmp,
+		hash:        sha256.New(),
+		contentType: contentType,
+		want:        want,
+	}
+}
+
+// teeReader copies what is read to tmp, and stores it on Close if it's
+// complete.
+type teeReader struct {
+	io.ReadCloser
+	store       *storeImpl
+	tmp         *os.File
+	hash        hash.Hash
+	size        int64
+	contentType string
+	want        string
+
+	// done is set once all of it has been read, and failed if it can't be
+	// stored.
+	done   bool
+	failed bool
+	closed bool
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 && !t.failed {
+		t.size += int64(n)
+		if t.store.max > 0 && t.size > t.store.max {
+			t.failed = true
+		} else if _, werr := t.tmp.Write(p[:n]); werr != nil {
+			fmt.Printf("Warning: can't store blob: %v\n", werr)
+			t.failed = true
+		} else {
+			t.hash.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		t.done = true
+	}
+	return n, err
+}
+
+func (t *teeReader) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	// Close first, so a download's slot is free while storing.
+	err := t.ReadCloser.Close()
+	cerr := t.tmp.Close()
+	if !t.done || t.failed || cerr != nil {
+		os.Remove(t.tmp.Name())
+		return err
+	}
+	got := hex.EncodeToString(t.hash.Sum(nil))
+	if t.want != "" && got != t.want {
+		fmt.Printf("Warning: not storing blob %s, its content is %s\n", t.want, got)
+		os.Remove(t.tmp.Name())
+		return err
+	}
+	if serr := t.store.commit(t.tmp.Name(), got, t.size, t.contentType); serr != nil {
+		fmt.Printf("Warning: can't store blob %s: %v\n", got, serr)
+		os.Remove(t.tmp.Name())
+	}
+	return err
+}
+
+// commit moves the file at tmp into place as sha256, and evicts others to
+// make room.
+func (s *storeImpl) commit(tmp string, sha256 string, size int64, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.path(sha256)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	err := s.cache.UpsertBlob(&cache.BlobData{
+		Sha256:      sha256,
+		Size:        size,
+		ContentType: contentType,
+	})
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	s.prom.stored.Inc()
+	s.prom.storedBytes.Add(float64(size))
+	return s.evict()
+}
+
+// evict removes the least recently used files until the rest fit in
+// MaxBytes.
+func (s *storeImpl) evict() error {
+	blobs, err := s.cache.ListBlobs()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, b := range blobs {
+		total += b.Size
+	}
+	for _, b := range blobs {
+		if s.max <= 0 || total <= s.max {
+			break
+		}
+		if err := os.Remove(s.path(b.Sha256)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := s.cache.DeleteBlob(b.Sha256); err != nil {
+			return err
+		}
+		total -= b.Size
+		s.prom.evicted.Inc()
+		s.prom.evictedBytes.Add(float64(b.Size))
+	}
+	s.prom.bytes.Set(float64(total))
+	return nil
+}
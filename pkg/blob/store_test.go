@@ -0,0 +1,288 @@
+package blob_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrewjjenkins/picsync/pkg/blob"
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos/googlephotostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type testStore struct {
+	blob.Store
+	dir   string
+	cache cache.Cache
+}
+
+func newTestStore(t *testing.T, maxBytes int64) *testStore {
+	t.Helper()
+	c, err := cache.New(prometheus.NewRegistry(), filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "blobs")
+	s, err := blob.NewStore(c, prometheus.NewRegistry(), blob.Options{Dir: dir, MaxBytes: maxBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testStore{Store: s, dir: dir, cache: c}
+}
+
+func sha256Of(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// path is where the store keeps the file with SHA256 sha.
+func (s *testStore) path(sha string) string {
+	return filepath.Join(s.dir, sha[:2], sha)
+}
+
+// put stores content by reading all of it through Tee.
+func (s *testStore) put(t *testing.T, content string, want string) {
+	t.Helper()
+	r := s.Tee(ioutil.NopCloser(strings.NewReader(content)), int64(len(content)), "image/jpeg", want)
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// get returns what is stored as the SHA256 of content, or "" if it isn't.
+func (s *testStore) get(t *testing.T, content string) string {
+	t.Helper()
+	b, err := s.Open(sha256Of(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		return ""
+	}
+	defer b.Close()
+	got, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(got)
+}
+
+// stored returns which of contents are stored, without using them.
+func (s *testStore) stored(t *testing.T, contents ...string) []string {
+	t.Helper()
+	blobs, err := s.cache.ListBlobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := make(map[string]bool)
+	for _, b := range blobs {
+		have[b.Sha256] = true
+	}
+	var stored []string
+	for _, content := range contents {
+		if have[sha256Of(content)] {
+			stored = append(stored, content)
+		}
+	}
+	return stored
+}
+
+// tmpFiles returns the files being stored.
+func (s *testStore) tmpFiles(t *testing.T) []string {
+	t.Helper()
+	tmp, err := filepath.Glob(filepath.Join(s.dir, "tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmp
+}
+
+func TestStore(t *testing.T) {
+	s := newTestStore(t, 0)
+	s.put(t, "photo 1", "")
+	if got := s.get(t, "photo 1"); got != "photo 1" {
+		t.Errorf("got %q, want photo 1", got)
+	}
+	if got := s.get(t, "photo 2"); got != "" {
+		t.Errorf("got %q for a blob that was never stored", got)
+	}
+
+	// A reader that isn't read to the end isn't stored.
+	r := s.Tee(ioutil.NopCloser(strings.NewReader("photo 2")), 7, "image/jpeg", "")
+	r.Read(make([]byte, 3))
+	r.Close()
+	if got := s.stored(t, "photo 2"); len(got) != 0 {
+		t.Errorf("stored %v after a partial read", got)
+	}
+	if tmp := s.tmpFiles(t); len(tmp) != 0 {
+		t.Errorf("left %v behind", tmp)
+	}
+}
+
+func TestStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	// Room for two of the seven byte photos.
+	s := newTestStore(t, 20)
+	s.put(t, "photo 1", "")
+	s.put(t, "photo 2", "")
+	// Reading 1 makes 2 the least recently used.
+	s.get(t, "photo 1")
+	s.put(t, "photo 3", "")
+	if got := s.stored(t, "photo 1", "photo 2", "photo 3"); fmt.Sprint(got) != "[photo 1 photo 3]" {
+		t.Errorf("stored %q, want photos 1 and 3", got)
+	}
+	if _, err := os.Stat(s.path(sha256Of("photo 2"))); !os.IsNotExist(err) {
+		t.Errorf("evicted file is still there (%v)", err)
+	}
+
+	// Several may have to go to make room.
+	s.put(t, "photo 4, bigger one", "")
+	if got := s.stored(t, "photo 1", "photo 3", "photo 4, bigger one"); fmt.Sprint(got) != "[photo 4, bigger one]" {
+		t.Errorf("stored %q, want just photo 4", got)
+	}
+}
+
+func TestStoreMaxBytes(t *testing.T) {
+	s := newTestStore(t, 10)
+	s.put(t, "small", "")
+
+	// Bigger than the whole store: it isn't stored, and nothing is evicted
+	// for it.
+	big := "bigger than ten bytes"
+	r := s.Tee(ioutil.NopCloser(strings.NewReader(big)), int64(len(big)), "image/jpeg", "")
+	if tmp := s.tmpFiles(t); len(tmp) != 0 {
+		t.Errorf("created %v for a blob that's too big", tmp)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil || string(got) != big {
+		t.Errorf("read %q (%v), want %q", got, err, big)
+	}
+	r.Close()
+
+	// Same, but its size isn't known until it's read.
+	r = s.Tee(ioutil.NopCloser(strings.NewReader(big)), -1, "image/jpeg", "")
+	if got, err := ioutil.ReadAll(r); err != nil || string(got) != big {
+		t.Errorf("read %q (%v), want %q", got, err, big)
+	}
+	r.Close()
+
+	if got := s.stored(t, "small", big); fmt.Sprint(got) != "[small]" {
+		t.Errorf("stored %q, want just small", got)
+	}
+	if tmp := s.tmpFiles(t); len(tmp) != 0 {
+		t.Errorf("left %v behind", tmp)
+	}
+}
+
+func TestStoreRejectsWrongSha256(t *testing.T) {
+	s := newTestStore(t, 0)
+	want := sha256Of("photo 1")
+	s.put(t, "not photo 1", want)
+	if got := s.stored(t, "photo 1", "not photo 1"); len(got) != 0 {
+		t.Errorf("stored %q", got)
+	}
+	if b, err := s.Open(want); err != nil || b != nil {
+		t.Errorf("opened %v (%v), want nothing", b, err)
+	}
+	if tmp := s.tmpFiles(t); len(tmp) != 0 {
+		t.Errorf("left %v behind", tmp)
+	}
+
+	// The right content is stored.
+	s.put(t, "photo 1", want)
+	if got := s.get(t, "photo 1"); got != "photo 1" {
+		t.Errorf("got %q, want photo 1", got)
+	}
+}
+
+func TestStoreForgetsUnusableFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(path string) error
+	}{
+		{
+			name:   "missing",
+			damage: os.Remove,
+		},
+		{
+			name:   "wrong size",
+			damage: func(path string) error { return os.WriteFile(path, []byte("truncated"), 0644) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, 0)
+			content := "a photo that will be damaged"
+			s.put(t, content, "")
+			if err := tt.damage(s.path(sha256Of(content))); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.get(t, content); got != "" {
+				t.Errorf("got %q, want nothing", got)
+			}
+			if got := s.stored(t, content); len(got) != 0 {
+				t.Errorf("still recorded as stored")
+			}
+			if _, err := os.Stat(s.path(sha256Of(content))); !os.IsNotExist(err) {
+				t.Errorf("damaged file is still there (%v)", err)
+			}
+
+			// It can be stored again.
+			s.put(t, content, "")
+			if got := s.get(t, content); got != content {
+				t.Errorf("got %q after storing again", got)
+			}
+		})
+	}
+}
+
+// Hashing a new item stores it, so uploading it doesn't download it again.
+func TestStoreSharesDownloads(t *testing.T) {
+	gp := googlephotostest.NewServer()
+	defer gp.Close()
+	album := gp.AddAlbum("Family", false)
+	content := []byte("photo 1")
+	gp.AddMediaItem(album.Id, googlephotos.MediaItem{Filename: "photo1.jpg", MimeType: "image/jpeg"}, content)
+
+	s := newTestStore(t, 0)
+	client := googlephotos.NewClient("id", "secret", context.Background(), gp.Token(), s.cache,
+		prometheus.NewRegistry(), googlephotos.Options{BaseURL: gp.URL, TokenURL: gp.TokenURL(), Blobs: s})
+	res, err := client.UpdateCacheForAlbumId(album.Id, "", googlephotos.VideoPolicy{}, func(*googlephotos.CachedMediaItem) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.CachedMediaItems) != 1 {
+		t.Fatalf("hashed %d items, want 1", len(res.CachedMediaItems))
+	}
+	if got := gp.Downloads(); got != 1 {
+		t.Errorf("hashing downloaded %d times, want 1", got)
+	}
+
+	item := res.CachedMediaItems[0]
+	b, err := client.Open(item.MediaItem, item.Sha256, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	got, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("opened %q, want %q", got, content)
+	}
+	if got := gp.Downloads(); got != 1 {
+		t.Errorf("hashing and uploading downloaded %d times, want 1", got)
+	}
+}
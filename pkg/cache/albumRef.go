@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AlbumRefData is what a reference to an album by title (like
+// "title:Xmas*") resolved to.
+type AlbumRefData struct {
+	Id          int64
+	Ref         string
+	AlbumIds    []string
+	LastUpdated time.Time
+	LastUsed    time.Time
+}
+
+// Album IDs don't contain spaces, so they are stored space-separated.
+const albumIdsSeparator = " "
+
+// Updates/inserts the resolution of an album reference.
+// r will be modified with new last used time and, if insert, the new row id.
+func (c *cacheImpl) UpsertAlbumRef(r *AlbumRefData) error {
+	if r.Ref == "" || len(r.AlbumIds) == 0 {
+		return errors.New("must provide Ref, AlbumIds")
+	}
+	if r.LastUpdated.IsZero() {
+		r.LastUpdated = time.Now()
+	}
+	r.LastUsed = time.Now()
+	albumIds := strings.Join(r.AlbumIds, albumIdsSeparator)
+
+	if r.Id == 0 {
+		rows, err := c.db.Query("SELECT Id FROM albumrefs WHERE Ref=?;", r.Ref)
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&r.Id)
+			rows.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			rows.Close()
+		}
+	}
+
+	if r.Id != 0 {
+		c.prom.cacheUpsertsUpdateAlbumRefs.Inc()
+		res, err := c.db.Exec("UPDATE albumrefs "+
+			"SET AlbumIds=?, LastUpdated=?, LastUsed=? WHERE Id=? AND Ref=?;",
+			albumIds, r.LastUpdated.UnixNano(), r.LastUsed.UnixNano(), r.Id, r.Ref)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return fmt.Errorf("expected 1 row updated, got %d", rows)
+		}
+		return nil
+	}
+
+	c.prom.cacheUpsertsInsertAlbumRefs.Inc()
+	res, err := c.db.Exec("INSERT INTO albumrefs "+
+		"(Ref, AlbumIds, LastUpdated, LastUsed) VALUES(?,?,?,?);",
+		r.Ref, albumIds, r.LastUpdated.UnixNano(), r.LastUsed.UnixNano())
+	if err != nil {
+		return err
+	}
+	rowId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	r.Id = rowId
+	c.prom.cacheEntriesAlbumRefs.Inc()
+	return nil
+}
+
+// GetAlbumRef returns what ref last resolved to, or nil if it isn't cached.
+func (c *cacheImpl) GetAlbumRef(ref string) (*AlbumRefData, error) {
+	rows, err := c.db.Query(
+		"SELECT Id, Ref, AlbumIds, LastUpdated, LastUsed "+
+			"FROM albumrefs WHERE Ref=? LIMIT 1;",
+		ref)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		c.prom.cacheGetMissesAlbumRefs.Inc()
+		return nil, nil
+	}
+	var toRet AlbumRefData
+	var albumIds string
+	var lastUpdated, lastUsed int64
+	err = rows.Scan(&toRet.Id, &toRet.Ref, &albumIds, &lastUpdated, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	toRet.AlbumIds = strings.Split(albumIds, albumIdsSeparator)
+	toRet.LastUpdated = time.Unix(0, lastUpdated)
+	rows.Close()
+	toRet.LastUsed, err = c.touch("albumrefs", toRet.Id)
+	if err != nil {
+		return nil, err
+	}
+	c.prom.cacheGetHitsAlbumRefs.Inc()
+	return &toRet, nil
+}
+
+// DeleteAlbumRef forgets what ref resolved to.
+func (c *cacheImpl) DeleteAlbumRef(ref string) error {
+	res, err := c.db.Exec("DELETE FROM albumrefs WHERE Ref=?;", ref)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	c.prom.cacheEntriesAlbumRefs.Sub(float64(rows))
+	return nil
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BlobData is a file in the blob store.  The file is named by its SHA256, so
+// the store only needs the cache for sizes and when each was last used.
+type BlobData struct {
+	Id          int64
+	Sha256      string
+	Size        int64
+	ContentType string
+	LastUpdated time.Time
+	LastUsed    time.Time
+}
+
+// Updates/inserts the blob with b.Sha256.
+// b will be modified with new last used time and, if insert, the new row id.
+func (c *cacheImpl) UpsertBlob(b *BlobData) error {
+	if b.Sha256 == "" {
+		return errors.New("must provide Sha256")
+	}
+	if b.LastUpdated.IsZero() {
+		b.LastUpdated = time.Now()
+	}
+	b.LastUsed = time.Now()
+
+	if b.Id == 0 {
+		rows, err := c.db.Query("SELECT Id FROM blobs WHERE Sha256=?;", b.Sha256)
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&b.Id)
+			rows.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			rows.Close()
+		}
+	}
+
+	if b.Id != 0 {
+		c.prom.cacheUpsertsUpdateBlobs.Inc()
+		res, err := c.db.Exec("UPDATE blobs "+
+			"SET Size=?, ContentType=?, LastUpdated=?, LastUsed=? "+
+			"WHERE Id=? AND Sha256=?;",
+			b.Size, b.ContentType, b.LastUpdated.UnixNano(), b.LastUsed.UnixNano(),
+			b.Id, b.Sha256)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return fmt.Errorf("expected 1 row updated, got %d", rows)
+		}
+		return nil
+	}
+
+	c.prom.cacheUpsertsInsertBlobs.Inc()
+	res, err := c.db.Exec("INSERT INTO blobs "+
+		"(Sha256, Size, ContentType, LastUpdated, LastUsed) VALUES(?,?,?,?,?);",
+		b.Sha256, b.Size, b.ContentType, b.LastUpdated.UnixNano(), b.LastUsed.UnixNano())
+	if err != nil {
+		return err
+	}
+	rowId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	b.Id = rowId
+	c.prom.cacheEntriesBlobs.Inc()
+	return nil
+}
+
+// GetBlob returns the blob with SHA256 sha256, or nil if it isn't stored.
+func (c *cacheImpl) GetBlob(sha256 string) (*BlobData, error) {
+	rows, err := c.db.Query(
+		"SELECT Id, Sha256, Size, ContentType, LastUpdated, LastUsed "+
+			"FROM blobs WHERE Sha256=? LIMIT 1;",
+		sha256)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		c.prom.cacheGetMissesBlobs.Inc()
+		return nil, nil
+	}
+	var toRet BlobData
+	var lastUpdated, lastUsed int64
+	err = rows.Scan(&toRet.Id, &toRet.Sha256, &toRet.Size, &toRet.ContentType,
+		&lastUpdated, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	toRet.LastUpdated = time.Unix(0, lastUpdated)
+	rows.Close()
+	toRet.LastUsed, err = c.touch("blobs", toRet.Id)
+	if err != nil {
+		return nil, err
+	}
+	c.prom.cacheGetHitsBlobs.Inc()
+	return &toRet, nil
+}
+
+// DeleteBlob forgets the blob with SHA256 sha256, which has been removed
+// from the store.
+func (c *cacheImpl) DeleteBlob(sha256 string) error {
+	res, err := c.db.Exec("DELETE FROM blobs WHERE Sha256=?;", sha256)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	c.prom.cacheEntriesBlobs.Sub(float64(rows))
+	return nil
+}
+
+// ListBlobs returns every blob, least recently used first.
+func (c *cacheImpl) ListBlobs() ([]*BlobData, error) {
+	rows, err := c.db.Query(
+		"SELECT Id, Sha256, Size, ContentType, LastUpdated, LastUsed " +
+			"FROM blobs ORDER BY LastUsed, Id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var blobs []*BlobData
+	for rows.Next() {
+		var b BlobData
+		var lastUpdated, lastUsed int64
+		err = rows.Scan(&b.Id, &b.Sha256, &b.Size, &b.ContentType, &lastUpdated, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		b.LastUpdated = time.Unix(0, lastUpdated)
+		b.LastUsed = time.Unix(0, lastUsed)
+		blobs = append(blobs, &b)
+	}
+	return blobs, rows.Err()
+}
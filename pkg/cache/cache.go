@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,21 +24,62 @@ type GooglephotoData struct {
 
 type NixplayData struct {
 	Id          int64
+	AlbumId     int
 	URL         string
 	Filename    string
 	SortDate    string
 	Md5         string
+	Caption     string
 	NixplayId   int
 	LastUpdated time.Time
 	LastUsed    time.Time
 }
 
+type LocalData struct {
+	Id          int64
+	Path        string
+	Size        int64
+	ModTime     time.Time
+	Sha256      string
+	Md5         string
+	LastUpdated time.Time
+	LastUsed    time.Time
+}
+
 type Cache interface {
 	UpsertGooglephoto(p *GooglephotoData) error
 	GetGooglephoto(baseUrl string) (*GooglephotoData, error)
+	DeleteGooglephoto(googlephotosId string) error
 	UpsertNixplay(n *NixplayData) error
+	DeleteNixplay(nixplayId int) error
+	ReplaceNixplayAlbum(a *NixplayAlbumData, photos []*NixplayData) error
+	GetNixplayAlbum(albumId int) (*NixplayAlbumData, []*NixplayData, error)
+	DeleteNixplayAlbum(albumId int) error
+	UpsertLocal(l *LocalData) error
+	GetLocal(path string) (*LocalData, error)
+	UpsertAlbumRef(r *AlbumRefData) error
+	GetAlbumRef(ref string) (*AlbumRefData, error)
+	DeleteAlbumRef(ref string) error
+	UpsertVideo(v *VideoData) error
+	GetVideo(googlephotosId string) (*VideoData, error)
+	UpsertTransform(t *TransformData) error
+	GetTransform(sourceSha256 string, settings string) (*TransformData, error)
+	UpsertPlaylist(p *PlaylistData) error
+	GetPlaylist(name string) (*PlaylistData, error)
+	DeletePlaylist(name string) error
+	UpsertBlob(b *BlobData) error
+	GetBlob(sha256 string) (*BlobData, error)
+	DeleteBlob(sha256 string) error
+	ListBlobs() ([]*BlobData, error)
 
 	Status() (StatusResponse, error)
+	GC(opts GCOptions) (GCResult, error)
+
+	EntryTables() []string
+	ListEntries(opts ListOptions) ([]*Entry, error)
+	GetEntry(table string, id int64) (*Entry, error)
+	Export(w io.Writer) (int, error)
+	Import(r io.Reader, replace bool) (ImportResult, error)
 }
 
 type cacheImpl struct {
@@ -107,7 +149,8 @@ func (c *cacheImpl) updateGooglephoto(p *GooglephotoData) error {
 	res, err := c.db.Exec("UPDATE googlephotos "+
 		"SET Sha256=?, Md5=?, BaseUrl=?, LastUsed=?, LastUpdated=? "+
 		"WHERE Id=? AND GooglephotosId=? ;",
-		p.Sha256, p.Md5, p.BaseUrl, p.LastUsed, p.LastUpdated, p.Id, p.GooglephotosId)
+		p.Sha256, p.Md5, p.BaseUrl, p.LastUsed.UnixNano(), p.LastUpdated.UnixNano(),
+		p.Id, p.GooglephotosId)
 	if err != nil {
 		return err
 	}
@@ -126,7 +169,7 @@ func (c *cacheImpl) insertGooglephoto(p *GooglephotoData) error {
 		"(Sha256, Md5, GooglephotosId, BaseUrl, Width, Height, LastUpdated, LastUsed)"+
 		"VALUES(?,?,?,?,?,?,?,?);",
 		p.Sha256, p.Md5, p.GooglephotosId, p.BaseUrl, p.Width, p.Height,
-		p.LastUpdated, p.LastUsed,
+		p.LastUpdated.UnixNano(), p.LastUsed.UnixNano(),
 	)
 	if err != nil {
 		return err
@@ -162,17 +205,42 @@ func (c *cacheImpl) GetGooglephoto(googlephotosId string) (*GooglephotoData, err
 		return nil, nil
 	}
 	var toRet GooglephotoData
-	rows.Scan(&toRet.Id, &toRet.BaseUrl, &toRet.Sha256, &toRet.Md5,
-		&toRet.GooglephotosId, &toRet.Width, &toRet.Height, &toRet.LastUpdated,
-		&toRet.LastUsed)
+	var lastUpdated, lastUsed int64
+	err = rows.Scan(&toRet.Id, &toRet.BaseUrl, &toRet.Sha256, &toRet.Md5,
+		&toRet.GooglephotosId, &toRet.Width, &toRet.Height, &lastUpdated,
+		&lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	toRet.LastUpdated = time.Unix(0, lastUpdated)
+	rows.Close()
+	toRet.LastUsed, err = c.touch("googlephotos", toRet.Id)
+	if err != nil {
+		return nil, err
+	}
 	c.prom.cacheGetHitsGooglephotos.Inc()
 	return &toRet, nil
 }
 
+// DeleteGooglephoto forgets the hashes of the item with googlephotosId.
+func (c *cacheImpl) DeleteGooglephoto(googlephotosId string) error {
+	res, err := c.db.Exec("DELETE FROM googlephotos WHERE GooglephotosId=?;", googlephotosId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	c.prom.cacheEntriesGooglephotos.Sub(float64(rows))
+	return nil
+}
+
+// Updates/inserts a cache entry for a Nixplay photo.
+// n will be modified with new last used time and, if insert, the new row id.
 func (c *cacheImpl) UpsertNixplay(n *NixplayData) error {
-	if n.Md5 == "" || n.NixplayId == 0 || n.Filename == "" ||
-		n.URL == "" || n.SortDate == "" {
-		return errors.New("must provide Md5, NixplayID, Filename, URL, SortDate")
+	if n.Md5 == "" || n.NixplayId == 0 || n.Filename == "" {
+		return errors.New("must provide Md5, NixplayID, Filename")
 	}
 	if n.LastUpdated.IsZero() {
 		n.LastUpdated = time.Now()
@@ -181,14 +249,9 @@ func (c *cacheImpl) UpsertNixplay(n *NixplayData) error {
 
 	if n.Id == 0 {
 		// Caller doesn't know an Id.  Maybe it's new, but let's try to find
-		// it by md5 first.
-		// This assumes that there will be no md5 collisions.  It's unlikely
-		// any one user's photo collection will have some, and I don't know
-		// enough about what is persistent/unique in Nixplay's API to choose
-		// something like Google's baseUrl.
-		// We could use Sha256 someday if we implemented something like the
-		// way we download all the images for googlephotos.
-		rows, err := c.db.Query("SELECT Id FROM nixplay WHERE Md5=?;", n.Md5)
+		// it by NixplayId first.  (The same photo can be in more than one
+		// album, with the same md5 but different NixplayIds.)
+		rows, err := c.db.Query("SELECT Id FROM nixplay WHERE NixplayId=?;", n.NixplayId)
 		if err != nil {
 			return err
 		}
@@ -213,10 +276,10 @@ func (c *cacheImpl) UpsertNixplay(n *NixplayData) error {
 
 func (c *cacheImpl) updateNixplay(n *NixplayData) error {
 	res, err := c.db.Exec("UPDATE nixplay "+
-		"SET NixplayId=?, Filename=?, URL=?, SortDate=?, LastUsed=?, LastUpdated=? "+
-		"WHERE Id=? AND Md5=? ;",
-		n.NixplayId, n.Filename, n.URL, n.SortDate, n.LastUsed,
-		n.LastUpdated, n.Id, n.Md5)
+		"SET AlbumId=?, Md5=?, Filename=?, URL=?, SortDate=?, Caption=?, LastUsed=?, LastUpdated=? "+
+		"WHERE Id=? AND NixplayId=? ;",
+		n.AlbumId, n.Md5, n.Filename, n.URL, n.SortDate, n.Caption, n.LastUsed.UnixNano(),
+		n.LastUpdated.UnixNano(), n.Id, n.NixplayId)
 	if err != nil {
 		return err
 	}
@@ -231,11 +294,7 @@ func (c *cacheImpl) updateNixplay(n *NixplayData) error {
 }
 
 func (c *cacheImpl) insertNixplay(n *NixplayData) error {
-	res, err := c.db.Exec("INSERT INTO nixplay "+
-		"(NixplayId, Filename, URL, SortDate, Md5, LastUpdated, LastUsed)"+
-		"VALUES(?,?,?,?,?,?,?);",
-		n.NixplayId, n.Filename, n.URL, n.SortDate, n.Md5,
-		n.LastUpdated, n.LastUsed)
+	res, err := insertNixplay(c.db, n)
 	if err != nil {
 		return err
 	}
@@ -255,19 +314,160 @@ func (c *cacheImpl) insertNixplay(n *NixplayData) error {
 	return nil
 }
 
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertNixplay inserts n with db, which is c.db or a transaction.
+func insertNixplay(db execer, n *NixplayData) (sql.Result, error) {
+	return db.Exec("INSERT INTO nixplay "+
+		"(AlbumId, NixplayId, Filename, URL, SortDate, Md5, Caption, LastUpdated, LastUsed)"+
+		"VALUES(?,?,?,?,?,?,?,?,?);",
+		n.AlbumId, n.NixplayId, n.Filename, n.URL, n.SortDate, n.Md5, n.Caption,
+		n.LastUpdated.UnixNano(), n.LastUsed.UnixNano())
+}
+
+// Updates/inserts a cache entry for a local file.
+// l will be modified with new last used time and, if insert, the new row id.
+func (c *cacheImpl) UpsertLocal(l *LocalData) error {
+	if l.Path == "" || l.Sha256 == "" || l.Md5 == "" {
+		return errors.New("must provide Path, Sha256, Md5")
+	}
+	if l.LastUpdated.IsZero() {
+		l.LastUpdated = time.Now()
+	}
+	l.LastUsed = time.Now()
+
+	if l.Id == 0 {
+		// Caller doesn't know an Id.  Maybe it's new, but let's try to find
+		// it by Path first.
+		rows, err := c.db.Query("SELECT Id FROM local WHERE Path=?;", l.Path)
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			// This is an update.  Store the row Id we just found.
+			err = rows.Scan(&l.Id)
+			rows.Close()
+			if err != nil {
+				return err
+			}
+			c.prom.cacheUpsertsUpdateLocal.Inc()
+			return c.updateLocal(l)
+		}
+		rows.Close()
+		// This is an insert
+		c.prom.cacheUpsertsInsertLocal.Inc()
+		return c.insertLocal(l)
+	}
+	c.prom.cacheUpsertsUpdateLocal.Inc()
+	return c.updateLocal(l)
+}
+
+func (c *cacheImpl) updateLocal(l *LocalData) error {
+	res, err := c.db.Exec("UPDATE local "+
+		"SET Size=?, ModTime=?, Sha256=?, Md5=?, LastUsed=?, LastUpdated=? "+
+		"WHERE Id=? AND Path=? ;",
+		l.Size, l.ModTime.UnixNano(), l.Sha256, l.Md5, l.LastUsed.UnixNano(),
+		l.LastUpdated.UnixNano(), l.Id, l.Path)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("expected 1 row updated, got %d", rows)
+	}
+	return nil
+}
+
+func (c *cacheImpl) insertLocal(l *LocalData) error {
+	res, err := c.db.Exec("INSERT INTO local "+
+		"(Path, Size, ModTime, Sha256, Md5, LastUpdated, LastUsed)"+
+		"VALUES(?,?,?,?,?,?,?);",
+		l.Path, l.Size, l.ModTime.UnixNano(), l.Sha256, l.Md5,
+		l.LastUpdated.UnixNano(), l.LastUsed.UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("expected 1 row updated, got %d", rows)
+	}
+	rowId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	l.Id = rowId
+	c.prom.cacheEntriesLocal.Inc()
+	return nil
+}
+
+func (c *cacheImpl) GetLocal(path string) (*LocalData, error) {
+	rows, err := c.db.Query(
+		"SELECT Id, Path, Size, ModTime, Sha256, Md5, LastUpdated, LastUsed "+
+			"FROM local WHERE Path=? LIMIT 1;",
+		path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found := rows.Next()
+	if !found {
+		c.prom.cacheGetMissesLocal.Inc()
+		return nil, nil
+	}
+	var toRet LocalData
+	var modTime, lastUpdated, lastUsed int64
+	err = rows.Scan(&toRet.Id, &toRet.Path, &toRet.Size, &modTime,
+		&toRet.Sha256, &toRet.Md5, &lastUpdated, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	toRet.ModTime = time.Unix(0, modTime)
+	toRet.LastUpdated = time.Unix(0, lastUpdated)
+	rows.Close()
+	toRet.LastUsed, err = c.touch("local", toRet.Id)
+	if err != nil {
+		return nil, err
+	}
+	c.prom.cacheGetHitsLocal.Inc()
+	return &toRet, nil
+}
+
 type StatusResponse struct {
+	SchemaVersion         int
 	GooglePhotosValidRows int64
 	NixplayValidRows      int64
+	LocalValidRows        int64
+	AlbumRefValidRows     int64
+	VideoValidRows        int64
+	TransformValidRows    int64
+	PlaylistValidRows     int64
+	NixplayAlbumValidRows int64
+	BlobValidRows         int64
 }
 
 func (c *cacheImpl) Status() (StatusResponse, error) {
 	resp := StatusResponse{}
 
+	version, err := schemaVersion(c.db)
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	resp.SchemaVersion = version
+
 	// Because Google never modifies content at a particular Id
 	// (instead creating a new Id), the mapping from Id to
-	// md5 never becomes invalid.
-	// FIXME: In the future, we could check consistency and also remove
-	// least-recently-used entries.
+	// md5 never becomes invalid.  Entries that aren't used any more are
+	// removed by GC.
 	rows, err := c.db.Query("SELECT COUNT(Id) FROM googlephotos")
 	if err != nil {
 		return StatusResponse{}, err
@@ -276,7 +476,6 @@ func (c *cacheImpl) Status() (StatusResponse, error) {
 	rows.Scan(&resp.GooglePhotosValidRows)
 	rows.Close()
 
-	// FIXME: Implement expiring of Nixplay cache entries.
 	rows, err = c.db.Query("SELECT COUNT(Id) FROM nixplay")
 	if err != nil {
 		return StatusResponse{}, err
@@ -286,5 +485,63 @@ func (c *cacheImpl) Status() (StatusResponse, error) {
 	rows.Scan(&resp.NixplayValidRows)
 	rows.Close()
 
+	// Local entries are checked against the file's size and modification
+	// time when used, so stale entries are harmless.
+	rows, err = c.db.Query("SELECT COUNT(Id) FROM local")
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	rows.Next()
+	rows.Scan(&resp.LocalValidRows)
+	rows.Close()
+
+	rows, err = c.db.Query("SELECT COUNT(Id) FROM albumrefs")
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	rows.Next()
+	rows.Scan(&resp.AlbumRefValidRows)
+	rows.Close()
+
+	rows, err = c.db.Query("SELECT COUNT(Id) FROM videos")
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	rows.Next()
+	rows.Scan(&resp.VideoValidRows)
+	rows.Close()
+
+	rows, err = c.db.Query("SELECT COUNT(Id) FROM transforms")
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	rows.Next()
+	rows.Scan(&resp.TransformValidRows)
+	rows.Close()
+
+	rows, err = c.db.Query("SELECT COUNT(Id) FROM playlists")
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	rows.Next()
+	rows.Scan(&resp.PlaylistValidRows)
+	rows.Close()
+
+	rows, err = c.db.Query("SELECT COUNT(Id) FROM nixplayalbums")
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	rows.Next()
+	rows.Scan(&resp.NixplayAlbumValidRows)
+	rows.Close()
+
+	rows, err = c.db.Query("SELECT COUNT(Id) FROM blobs")
+	if err != nil {
+		return StatusResponse{}, err
+	}
+	rows.Next()
+	rows.Scan(&resp.BlobValidRows)
+	rows.Close()
+
 	return resp, nil
 }
@@ -0,0 +1,358 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Entry is one cache entry, for inspecting, exporting and importing the
+// cache without knowing what's in each table.
+type Entry struct {
+	Table string
+
+	// Key is what identifies the entry, as GC logs it.
+	Key string
+
+	// Columns are the table's columns, in order, and Row is the entry's
+	// value for each.  Times are Unix nanoseconds.
+	Columns []string
+	Row     map[string]interface{}
+}
+
+// ListOptions says which entries ListEntries returns.  The zero value
+// returns everything.
+type ListOptions struct {
+	// Tables, if set, only lists entries in these tables.
+	Tables []string
+
+	// OlderThan only lists entries that haven't been used for this long.
+	OlderThan time.Duration
+
+	// Hash only lists entries with a SHA256 or MD5 that starts with this.
+	Hash string
+}
+
+// entryKeys are the columns that identify an entry in each table, so that an
+// imported entry replaces the one it's a copy of.
+var entryKeys = map[string][]string{
+	"googlephotos":  {"GooglephotosId"},
+	"nixplay":       {"NixplayId"},
+	"local":         {"Path"},
+	"albumrefs":     {"Ref"},
+	"videos":        {"GooglephotosId"},
+	"transforms":    {"SourceSha256", "Settings"},
+	"playlists":     {"Name"},
+	"nixplayalbums": {"AlbumId"},
+}
+
+// EntryTables are the tables that entries can be listed, exported and
+// imported from.  Blobs aren't included; they're files that are only on this
+// machine.
+func (c *cacheImpl) EntryTables() []string {
+	var tables []string
+	for _, table := range c.gcTables() {
+		tables = append(tables, table.name)
+	}
+	return tables
+}
+
+func (c *cacheImpl) entryTable(name string) (gcTable, error) {
+	for _, table := range c.gcTables() {
+		if table.name == name {
+			return table, nil
+		}
+	}
+	return gcTable{}, fmt.Errorf("unknown cache table %q (want one of %s)",
+		name, strings.Join(c.EntryTables(), ", "))
+}
+
+// columns returns the columns of table, in order.
+func (c *cacheImpl) columns(table string) ([]string, error) {
+	rows, err := c.db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 0;", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// IsHashColumn returns true for columns that hold a SHA256 or MD5.
+func IsHashColumn(column string) bool {
+	return strings.HasSuffix(column, "Sha256") || strings.HasSuffix(column, "Md5")
+}
+
+// ListEntries returns the entries opts says, by table and then by Id.  It
+// doesn't count as using them.
+func (c *cacheImpl) ListEntries(opts ListOptions) ([]*Entry, error) {
+	tables := opts.Tables
+	if len(tables) == 0 {
+		tables = c.EntryTables()
+	}
+	var entries []*Entry
+	for _, name := range tables {
+		table, err := c.entryTable(name)
+		if err != nil {
+			return nil, err
+		}
+		columns, err := c.columns(table.name)
+		if err != nil {
+			return nil, err
+		}
+
+		var where []string
+		var args []interface{}
+		if opts.OlderThan > 0 {
+			where = append(where, "LastUsed < ?")
+			args = append(args, time.Now().Add(-opts.OlderThan).UnixNano())
+		}
+		if opts.Hash != "" {
+			var hashes []string
+			for _, column := range columns {
+				if IsHashColumn(column) {
+					hashes = append(hashes, column+" LIKE ?")
+					args = append(args, opts.Hash+"%")
+				}
+			}
+			if len(hashes) == 0 {
+				continue
+			}
+			where = append(where, "("+strings.Join(hashes, " OR ")+")")
+		}
+		query := fmt.Sprintf("SELECT %s, %s FROM %s", table.key, strings.Join(columns, ", "), table.name)
+		if len(where) > 0 {
+			query += " WHERE " + strings.Join(where, " AND ")
+		}
+		tableEntries, err := c.queryEntries(table.name, columns, query+" ORDER BY Id;", args...)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, tableEntries...)
+	}
+	return entries, nil
+}
+
+// GetEntry returns the entry in table with id, or nil if there isn't one.
+// It doesn't count as using it.
+func (c *cacheImpl) GetEntry(tableName string, id int64) (*Entry, error) {
+	table, err := c.entryTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := c.columns(table.name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.queryEntries(table.name, columns, fmt.Sprintf(
+		"SELECT %s, %s FROM %s WHERE Id=?;", table.key, strings.Join(columns, ", "), table.name),
+		id)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// queryEntries runs query, which selects the key and then columns.
+func (c *cacheImpl) queryEntries(table string, columns []string, query string, args ...interface{}) ([]*Entry, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []*Entry
+	for rows.Next() {
+		var key interface{}
+		values := make([]interface{}, len(columns))
+		dest := []interface{}{&key}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		e := &Entry{
+			Table:   table,
+			Key:     fmt.Sprint(key),
+			Columns: columns,
+			Row:     make(map[string]interface{}, len(columns)),
+		}
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			e.Row[column] = values[i]
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// exportHeader is the first line of an export.
+type exportHeader struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Exported      string `json:"exported"`
+}
+
+// exportLine is each entry in an export.
+type exportLine struct {
+	Table string                 `json:"table"`
+	Row   map[string]interface{} `json:"row"`
+}
+
+// Export writes every entry to w as JSON Lines: a header with the schema
+// version, then one line per entry.  It returns how many entries it wrote.
+func (c *cacheImpl) Export(w io.Writer) (int, error) {
+	version, err := schemaVersion(c.db)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := c.ListEntries(ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(w)
+	err = enc.Encode(exportHeader{
+		SchemaVersion: version,
+		Exported:      time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return 0, err
+	}
+	for n, e := range entries {
+		if err := enc.Encode(exportLine{Table: e.Table, Row: e.Row}); err != nil {
+			return n, err
+		}
+	}
+	return len(entries), nil
+}
+
+// ImportResult is what Import did.
+type ImportResult struct {
+	// Imported is how many entries were imported, and Replaced how many of
+	// them replaced an entry that was already cached.
+	Imported int
+	Replaced int
+}
+
+// Import reads entries written by Export from r and adds them to the cache,
+// replacing entries with the same key.  If replace, everything that was
+// cached is removed first.  The import is all-or-nothing, and it must be
+// from the same schema version.
+func (c *cacheImpl) Import(r io.Reader, replace bool) (ImportResult, error) {
+	var result ImportResult
+	version, err := schemaVersion(c.db)
+	if err != nil {
+		return result, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return result, err
+		}
+		return result, fmt.Errorf("empty import")
+	}
+	var header exportHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.SchemaVersion == 0 {
+		return result, fmt.Errorf("line 1: not a picsync cache export")
+	}
+	if header.SchemaVersion != version {
+		return result, fmt.Errorf("export is schema version %d, but the cache is %d "+
+			"(export and import with the same picsync version)", header.SchemaVersion, version)
+	}
+
+	tables := make(map[string][]string)
+	for _, table := range c.EntryTables() {
+		if tables[table], err = c.columns(table); err != nil {
+			return result, err
+		}
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+	if replace {
+		for table := range tables {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s;", table)); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		var l exportLine
+		dec := json.NewDecoder(strings.NewReader(scanner.Text()))
+		// Times are Unix nanoseconds, which don't fit in a float64.
+		dec.UseNumber()
+		if err := dec.Decode(&l); err != nil {
+			return result, fmt.Errorf("line %d: %v", line, err)
+		}
+		columns, ok := tables[l.Table]
+		if !ok {
+			return result, fmt.Errorf("line %d: unknown cache table %q", line, l.Table)
+		}
+
+		var names, marks []string
+		var values []interface{}
+		for name, value := range l.Row {
+			if !contains(columns, name) {
+				return result, fmt.Errorf("line %d: unknown %s column %q", line, l.Table, name)
+			}
+			if name == "Id" {
+				// Ids are only meaningful in the cache they came from.
+				continue
+			}
+			if n, ok := value.(json.Number); ok {
+				if i, err := n.Int64(); err == nil {
+					value = i
+				} else if value, err = n.Float64(); err != nil {
+					return result, fmt.Errorf("line %d: bad %s %s: %v", line, l.Table, name, err)
+				}
+			}
+			names = append(names, name)
+			marks = append(marks, "?")
+			values = append(values, value)
+		}
+
+		var keys []string
+		var keyValues []interface{}
+		for _, key := range entryKeys[l.Table] {
+			// Without its key, an entry can't replace the one it's a copy
+			// of, and would be a duplicate.
+			if l.Row[key] == nil {
+				return result, fmt.Errorf("line %d: %s entry has no %s", line, l.Table, key)
+			}
+			keys = append(keys, key+"=?")
+			keyValues = append(keyValues, l.Row[key])
+		}
+		res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s;",
+			l.Table, strings.Join(keys, " AND ")), keyValues...)
+		if err != nil {
+			return result, fmt.Errorf("line %d: %v", line, err)
+		}
+		if removed, err := res.RowsAffected(); err != nil {
+			return result, err
+		} else if removed > 0 {
+			result.Replaced++
+		}
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES(%s);",
+			l.Table, strings.Join(names, ", "), strings.Join(marks, ",")), values...)
+		if err != nil {
+			return result, fmt.Errorf("line %d: %v", line, err)
+		}
+		result.Imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	return result, c.setEntries()
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fill adds an entry to each table, as picsync would.
+func fill(t *testing.T, c *cacheImpl) {
+	t.Helper()
+	modTime := time.Date(2022, 1, 2, 15, 4, 5, 123456789, time.UTC)
+	for _, err := range []error{
+		c.UpsertGooglephoto(&GooglephotoData{
+			GooglephotosId: "gp1", BaseUrl: "https://example.com/gp1", Sha256: "sha1", Md5: "md51",
+			Width: 4000, Height: 3000,
+		}),
+		c.UpsertLocal(&LocalData{Path: "/photos/a.jpg", Size: 1234, ModTime: modTime, Sha256: "sha2", Md5: "md52"}),
+		c.UpsertAlbumRef(&AlbumRefData{Ref: "title:Xmas*", AlbumIds: []string{"album1", "album2"}}),
+		c.UpsertVideo(&VideoData{GooglephotosId: "gp2", Size: -1, Duration: -1, PosterSha256: "sha3", PosterMd5: "md53"}),
+		c.UpsertTransform(&TransformData{SourceSha256: "sha2", Settings: "w1280", Sha256: "sha4", Md5: "md54", Size: 99}),
+		c.UpsertPlaylist(&PlaylistData{Name: "ss_Family", PlaylistId: 1001}),
+		c.ReplaceNixplayAlbum(&NixplayAlbumData{AlbumId: 7, Title: "Family"}, []*NixplayData{
+			{AlbumId: 7, NixplayId: 1002, Filename: "a.jpg", Md5: "md52", Caption: "Beach"},
+		}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// dump is every entry in c, without Ids (which aren't exported).
+func dump(t *testing.T, c *cacheImpl) string {
+	t.Helper()
+	entries, err := c.ListEntries(ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, e := range entries {
+		row := make(map[string]interface{}, len(e.Row))
+		for column, value := range e.Row {
+			if column != "Id" {
+				row[column] = value
+			}
+		}
+		lines = append(lines, fmt.Sprint(e.Table, " ", e.Key, " ", row))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestExportImport(t *testing.T) {
+	from := newTestCache(t)
+	fill(t, from)
+	var export bytes.Buffer
+	n, err := from.Export(&export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 8 {
+		t.Errorf("exported %d entries, want 8", n)
+	}
+
+	to := newTestCache(t)
+	result, err := to.Import(bytes.NewReader(export.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != n || result.Replaced != 0 {
+		t.Errorf("imported %+v, want %d entries and none replaced", result, n)
+	}
+	want := dump(t, from)
+	if got := dump(t, to); got != want {
+		t.Errorf("imported:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The entries are found by key, and times are exact.
+	local, err := to.GetLocal("/photos/a.jpg")
+	if err != nil || local == nil {
+		t.Fatalf("imported local entry not found (%v)", err)
+	}
+	if want := time.Date(2022, 1, 2, 15, 4, 5, 123456789, time.UTC); !local.ModTime.Equal(want) {
+		t.Errorf("imported ModTime %s, want %s", local.ModTime, want)
+	}
+
+	// Importing again replaces each entry, rather than adding another.
+	result, err = to.Import(bytes.NewReader(export.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != n || result.Replaced != n {
+		t.Errorf("imported again %+v, want %d entries, all replaced", result, n)
+	}
+	if got := dump(t, to); got != want {
+		t.Errorf("imported again:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Replacing removes what wasn't imported.
+	addEntry(t, to, "playlists", "Name", "ss_Old", time.Now())
+	if _, err := to.Import(bytes.NewReader(export.Bytes()), true); err != nil {
+		t.Fatal(err)
+	}
+	if got := dump(t, to); got != want {
+		t.Errorf("imported with replace:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	header := fmt.Sprintf(`{"schemaVersion":%d,"exported":"2022-01-02T15:04:05Z"}`, SchemaVersion)
+	good := `{"table":"playlists","row":{"Name":"ss_New","PlaylistId":1003,"LastUpdated":0,"LastUsed":0}}`
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{
+			name:    "not an export",
+			lines:   []string{`{"table":"playlists"}`},
+			wantErr: "not a picsync cache export",
+		},
+		{
+			name:    "other schema version",
+			lines:   []string{fmt.Sprintf(`{"schemaVersion":%d}`, SchemaVersion-1), good},
+			wantErr: "export is schema version",
+		},
+		{
+			name:    "unknown table",
+			lines:   []string{header, good, `{"table":"blobs","row":{"Sha256":"sha1"}}`},
+			wantErr: `line 3: unknown cache table "blobs"`,
+		},
+		{
+			name:    "unknown column",
+			lines:   []string{header, good, `{"table":"local","row":{"Path":"/a.jpg","Path; DROP":1}}`},
+			wantErr: `line 3: unknown local column "Path; DROP"`,
+		},
+		{
+			name:    "missing key",
+			lines:   []string{header, good, `{"table":"transforms","row":{"SourceSha256":"sha1","Sha256":"sha2"}}`},
+			wantErr: "line 3: transforms entry has no Settings",
+		},
+		{
+			name:    "null key",
+			lines:   []string{header, good, `{"table":"local","row":{"Path":null,"Sha256":"sha2"}}`},
+			wantErr: "line 3: local entry has no Path",
+		},
+	}
+	for _, tt := range tests {
+		c := newTestCache(t)
+		fill(t, c)
+		before := dump(t, c)
+		for _, replace := range []bool{false, true} {
+			_, err := c.Import(strings.NewReader(strings.Join(tt.lines, "\n")), replace)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.wantErr)
+			}
+			// Nothing is imported, or removed.
+			if got := dump(t, c); got != before {
+				t.Errorf("%s: replace %t: cache changed to:\n%s", tt.name, replace, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GCOptions says which cache entries GC removes.  An entry is removed if any
+// of them say so; the zero value removes nothing.
+type GCOptions struct {
+	// OlderThan removes entries that haven't been used for this long.
+	OlderThan time.Duration
+
+	// MaxEntries removes the least recently used entries of each table
+	// beyond this many.
+	MaxEntries int
+
+	// NotReferencedBy, if set, removes entries that it doesn't refer to.
+	NotReferencedBy *References
+
+	// DryRun only logs what would be removed.
+	DryRun bool
+}
+
+// References are the cache entries that a config refers to.  Tables that
+// aren't here (Google Photos, Nixplay photos and albums, and videos) are keyed by what's in the
+// services, not the config, and are only removed by age or count.
+type References struct {
+	// AlbumRefs are album references, like "title:Xmas*".
+	AlbumRefs []string
+
+	// Playlists are playlist names.
+	Playlists []string
+
+	// LocalDirs are directories; files anywhere under them are referenced.
+	LocalDirs []string
+
+	// TransformSettings are transform.Settings keys.
+	TransformSettings []string
+}
+
+// GCResult is how many entries GC removed from each table.
+type GCResult map[string]int
+
+// Total is how many entries GC removed from all tables.
+func (r GCResult) Total() int {
+	total := 0
+	for _, n := range r {
+		total += n
+	}
+	return total
+}
+
+// gcTable is a table that GC removes entries from.  key is what each entry is
+// logged as, and referenced (if set) says whether refs refer to an entry.
+type gcTable struct {
+	name       string
+	key        string
+	entries    prometheus.Gauge
+	removed    prometheus.Counter
+	referenced func(refs *References, key string) bool
+}
+
+func (c *cacheImpl) gcTables() []gcTable {
+	return []gcTable{
+		{"googlephotos", "GooglephotosId", c.prom.cacheEntriesGooglephotos, c.prom.cacheGcRemovedGooglephotos, nil},
+		{"nixplay", "NixplayId", c.prom.cacheEntriesNixplay, c.prom.cacheGcRemovedNixplay, nil},
+		{"local", "Path", c.prom.cacheEntriesLocal, c.prom.cacheGcRemovedLocal, localReferenced},
+		{"albumrefs", "Ref", c.prom.cacheEntriesAlbumRefs, c.prom.cacheGcRemovedAlbumRefs,
+			func(refs *References, ref string) bool { return contains(refs.AlbumRefs, ref) }},
+		{"videos", "GooglephotosId", c.prom.cacheEntriesVideos, c.prom.cacheGcRemovedVideos, nil},
+		{"transforms", "SourceSha256 || ' ' || Settings", c.prom.cacheEntriesTransforms, c.prom.cacheGcRemovedTransforms, transformReferenced},
+		{"playlists", "Name", c.prom.cacheEntriesPlaylists, c.prom.cacheGcRemovedPlaylists,
+			func(refs *References, name string) bool { return contains(refs.Playlists, name) }},
+		{"nixplayalbums", "Title", c.prom.cacheEntriesNixplayAlbums, c.prom.cacheGcRemovedNixplayAlbums, nil},
+	}
+}
+
+// GC removes cache entries as opts says, logging each one.
+func (c *cacheImpl) GC(opts GCOptions) (GCResult, error) {
+	result := GCResult{}
+	var cutoff int64
+	if opts.OlderThan > 0 {
+		cutoff = time.Now().Add(-opts.OlderThan).UnixNano()
+	}
+	for _, table := range c.gcTables() {
+		removed, err := c.gcTable(table, opts, cutoff)
+		if err != nil {
+			return result, fmt.Errorf("couldn't collect %s: %v", table.name, err)
+		}
+		if removed > 0 {
+			result[table.name] = removed
+		}
+	}
+	return result, nil
+}
+
+func (c *cacheImpl) gcTable(table gcTable, opts GCOptions, cutoff int64) (int, error) {
+	type entry struct {
+		id       int64
+		key      string
+		lastUsed int64
+		why      string
+	}
+	rows, err := c.db.Query(fmt.Sprintf(
+		"SELECT Id, %s, LastUsed FROM %s ORDER BY LastUsed DESC, Id DESC;",
+		table.key, table.name))
+	if err != nil {
+		return 0, err
+	}
+	var remove []entry
+	for n := 0; rows.Next(); n++ {
+		var e entry
+		if err := rows.Scan(&e.id, &e.key, &e.lastUsed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		switch {
+		case cutoff != 0 && e.lastUsed < cutoff:
+			e.why = fmt.Sprintf("not used for %s", opts.OlderThan)
+		case opts.MaxEntries > 0 && n >= opts.MaxEntries:
+			e.why = fmt.Sprintf("more than %d entries", opts.MaxEntries)
+		case opts.NotReferencedBy != nil && table.referenced != nil &&
+			!table.referenced(opts.NotReferencedBy, e.key):
+			e.why = "not referenced by config"
+		default:
+			continue
+		}
+		remove = append(remove, e)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(remove) == 0 {
+		return 0, nil
+	}
+	if !opts.DryRun {
+		tx, err := c.db.Begin()
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range remove {
+			_, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE Id=?;", table.name), e.id)
+			if err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+
+	verb := "Removed"
+	if opts.DryRun {
+		verb = "Would remove"
+	}
+	for _, e := range remove {
+		lastUsed := "never"
+		if e.lastUsed != 0 {
+			lastUsed = time.Unix(0, e.lastUsed).Format(time.RFC3339)
+		}
+		fmt.Printf("%s %s cache entry %s (%s, last used %s)\n",
+			verb, table.name, e.key, e.why, lastUsed)
+		if !opts.DryRun {
+			table.entries.Dec()
+			table.removed.Inc()
+		}
+	}
+	return len(remove), nil
+}
+
+// touch marks the entry with id in table as just used.
+func (c *cacheImpl) touch(table string, id int64) (time.Time, error) {
+	now := time.Now()
+	_, err := c.db.Exec(fmt.Sprintf("UPDATE %s SET LastUsed=? WHERE Id=?;", table),
+		now.UnixNano(), id)
+	return now, err
+}
+
+func localReferenced(refs *References, path string) bool {
+	for _, dir := range refs.LocalDirs {
+		dir = filepath.Clean(dir)
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) ||
+			dir == "." && !filepath.IsAbs(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// transformReferenced is keyed by source and settings; only the settings
+// come from the config.
+func transformReferenced(refs *References, key string) bool {
+	i := strings.Index(key, " ")
+	return i >= 0 && contains(refs.TransformSettings, key[i+1:])
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestCache(t *testing.T) *cacheImpl {
+	c, err := New(prometheus.NewRegistry(), filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.(*cacheImpl).db.Close() })
+	return c.(*cacheImpl)
+}
+
+// addEntry adds an entry to table with keyColumn set to key, last used at
+// lastUsed (never if zero).
+func addEntry(t *testing.T, c *cacheImpl, table, keyColumn, key string, lastUsed time.Time) {
+	t.Helper()
+	var unixNano int64
+	if !lastUsed.IsZero() {
+		unixNano = lastUsed.UnixNano()
+	}
+	exec(t, c.db, fmt.Sprintf("INSERT INTO %s (%s, LastUpdated, LastUsed) VALUES(?, ?, ?);", table, keyColumn),
+		key, unixNano, unixNano)
+}
+
+// keys returns what's in keyColumn of table, sorted.
+func keys(t *testing.T, c *cacheImpl, table, keyColumn string) []string {
+	t.Helper()
+	rows, err := c.db.Query(fmt.Sprintf("SELECT %s FROM %s;", keyColumn, table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func checkKeys(t *testing.T, c *cacheImpl, table, keyColumn string, want ...string) {
+	t.Helper()
+	if got := keys(t, c, table, keyColumn); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s has %v, want %v", table, got, want)
+	}
+}
+
+func TestGCOlderThan(t *testing.T) {
+	c := newTestCache(t)
+	now := time.Now()
+	addEntry(t, c, "local", "Path", "/photos/old.jpg", now.Add(-2*time.Hour))
+	addEntry(t, c, "local", "Path", "/photos/new.jpg", now.Add(-10*time.Minute))
+	addEntry(t, c, "local", "Path", "/photos/never.jpg", time.Time{})
+	addEntry(t, c, "googlephotos", "GooglephotosId", "old", now.Add(-2*time.Hour))
+
+	opts := GCOptions{OlderThan: time.Hour, DryRun: true}
+	result, err := c.GC(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["local"] != 2 || result["googlephotos"] != 1 || result.Total() != 3 {
+		t.Errorf("dry run would remove %v, want 2 local and 1 googlephotos", result)
+	}
+	checkKeys(t, c, "local", "Path", "/photos/never.jpg", "/photos/new.jpg", "/photos/old.jpg")
+
+	opts.DryRun = false
+	result, err = c.GC(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total() != 3 {
+		t.Errorf("removed %v, want 3 entries", result)
+	}
+	checkKeys(t, c, "local", "Path", "/photos/new.jpg")
+	checkKeys(t, c, "googlephotos", "GooglephotosId")
+}
+
+func TestGCMaxEntries(t *testing.T) {
+	c := newTestCache(t)
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		addEntry(t, c, "googlephotos", "GooglephotosId", fmt.Sprintf("gp%d", i), now.Add(time.Duration(i)*time.Minute))
+	}
+	addEntry(t, c, "playlists", "Name", "ss_Family", now)
+
+	// The most recently used are kept, in each table separately.
+	result, err := c.GC(GCOptions{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["googlephotos"] != 3 || result.Total() != 3 {
+		t.Errorf("removed %v, want 3 googlephotos", result)
+	}
+	checkKeys(t, c, "googlephotos", "GooglephotosId", "gp4", "gp5")
+	checkKeys(t, c, "playlists", "Name", "ss_Family")
+}
+
+func TestGCNotReferenced(t *testing.T) {
+	c := newTestCache(t)
+	now := time.Now()
+	for _, path := range []string{"/photos/a.jpg", "/photos/sub/b.jpg", "/photosmore/c.jpg", "/other/d.jpg", "e.jpg"} {
+		addEntry(t, c, "local", "Path", path, now)
+	}
+	addEntry(t, c, "albumrefs", "Ref", "title:Xmas*", now)
+	addEntry(t, c, "albumrefs", "Ref", "title:Old", now)
+	addEntry(t, c, "playlists", "Name", "ss_Family", now)
+	addEntry(t, c, "playlists", "Name", "ss_Old", now)
+	exec(t, c.db, "INSERT INTO transforms (SourceSha256, Settings, LastUpdated, LastUsed) VALUES "+
+		"('sha1', 'w1280', 0, ?), ('sha2', 'w1280', 0, ?), ('sha1', 'w640', 0, ?);",
+		now.UnixNano(), now.UnixNano(), now.UnixNano())
+	// Keyed by what's in Google Photos, so never unreferenced.
+	addEntry(t, c, "googlephotos", "GooglephotosId", "gp1", now)
+
+	refs := &References{
+		AlbumRefs:         []string{"title:Xmas*"},
+		Playlists:         []string{"ss_Family"},
+		LocalDirs:         []string{"/photos/", "."},
+		TransformSettings: []string{"w1280"},
+	}
+	result, err := c.GC(GCOptions{NotReferencedBy: refs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total() != 5 {
+		t.Errorf("removed %v, want 5 entries", result)
+	}
+	checkKeys(t, c, "local", "Path", "/photos/a.jpg", "/photos/sub/b.jpg", "e.jpg")
+	checkKeys(t, c, "albumrefs", "Ref", "title:Xmas*")
+	checkKeys(t, c, "playlists", "Name", "ss_Family")
+	checkKeys(t, c, "transforms", "SourceSha256 || ' ' || Settings", "sha1 w1280", "sha2 w1280")
+	checkKeys(t, c, "googlephotos", "GooglephotosId", "gp1")
+}
@@ -2,67 +2,127 @@ package cache
 
 import (
 	"database/sql"
+	"fmt"
+	"os"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
-const newDatabaseSchema = `
-drop table if exists googlephotos;
-create table googlephotos (
-	Id INTEGER PRIMARY KEY,
-	BaseUrl TEXT,
-	Sha256 TEXT,
-	Md5 TEXT,
-	Width INTEGER,
-	Height INTEGER,
-	GooglephotosId TEXT,
-	LastUpdated INTEGER,
-	LastUsed INTEGER
-);
-drop table if exists nixplay;
-create table nixplay (
-	Id INTEGER PRIMARY KEY,
-	Url TEXT,
-	Filename TEXT,
-	SortDate TEXT,
-	Md5 TEXT,
-	NixplayId INTEGER,
-	LastUpdated INTEGER,
-	LastUsed INTEGER
-);
-`
-
+// Open opens the cache in dbFilename, creating it if it doesn't exist and
+// migrating it to SchemaVersion if it's older.  A cache that is newer than
+// this picsync understands is an error, rather than risk damaging it.
 func Open(dbFilename string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dbFilename)
+	db, err := sql.Open("sqlite", dataSourceName(dbFilename))
 	if err != nil {
 		return nil, err
 	}
+	_, err = migrate(db, dbFilename, false)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// busyTimeout is how long a write waits for another to finish.  Downloads
+// and uploads run concurrently, and some of them write to the cache.
+const busyTimeout = 10 * time.Second
+
+func dataSourceName(dbFilename string) string {
+	return fmt.Sprintf("%s?_pragma=busy_timeout(%d)", dbFilename, busyTimeout.Milliseconds())
+}
 
-	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name='googlephotos';")
+// Migrate brings the cache in dbFilename up to SchemaVersion, and returns
+// the migrations it applied.  If dryRun, it returns the migrations it would
+// apply, and changes nothing (not even creating a missing cache).
+func Migrate(dbFilename string, dryRun bool) ([]Migration, error) {
+	if dryRun {
+		if _, err := os.Stat(dbFilename); os.IsNotExist(err) {
+			return pending(0), nil
+		}
+	}
+	db, err := sql.Open("sqlite", dataSourceName(dbFilename))
 	if err != nil {
 		return nil, err
 	}
-	var foundIt bool
-	for rows.Next() {
-		var name string
-		rows.Scan(&name)
-		if name == "googlephotos" {
-			foundIt = true
+	defer db.Close()
+	return migrate(db, dbFilename, dryRun)
+}
+
+// GetSchemaVersion returns the schema version of the cache in dbFilename,
+// without changing it.  A missing cache is version 0.
+func GetSchemaVersion(dbFilename string) (int, error) {
+	if _, err := os.Stat(dbFilename); os.IsNotExist(err) {
+		return 0, nil
+	}
+	db, err := sql.Open("sqlite", dataSourceName(dbFilename))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return schemaVersion(db)
+}
+
+func migrate(db *sql.DB, dbFilename string, dryRun bool) ([]Migration, error) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf(
+			"cache %s is schema version %d, but this picsync only understands up to %d "+
+				"(upgrade picsync, or use a different cache)",
+			dbFilename, version, SchemaVersion)
+	}
+	todo := pending(version)
+	if dryRun {
+		return todo, nil
+	}
+	for _, m := range todo {
+		if version > 0 {
+			fmt.Printf("Migrating cache %s to schema version %d: %s\n",
+				dbFilename, m.Version, m.Description)
 		}
+		if err := m.apply(db); err != nil {
+			return nil, fmt.Errorf("migrating cache %s to schema version %d: %v",
+				dbFilename, m.Version, err)
+		}
+	}
+	return todo, nil
+}
+
+// schemaVersion returns the version the cache's schema is at.  Caches from
+// before there were versions are version 1 if they have the original
+// tables, and new caches are version 0.
+func schemaVersion(db *sql.DB) (int, error) {
+	hasVersions, err := hasTable(db, "schema_version")
+	if err != nil {
+		return 0, err
 	}
-	if !foundIt {
-		// Need initialization
-		err := Init(db)
+	if !hasVersions {
+		hasOriginal, err := hasTable(db, "googlephotos")
 		if err != nil {
-			return nil, err
+			return 0, err
+		}
+		if hasOriginal {
+			return 1, nil
 		}
+		return 0, nil
 	}
-	return db, nil
+	var version sql.NullInt64
+	err = db.QueryRow("SELECT MAX(Version) FROM schema_version;").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
 }
 
-func Init(db *sql.DB) error {
-	if _, err := db.Exec(newDatabaseSchema); err != nil {
-		return err
+func hasTable(db *sql.DB, name string) (bool, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name=?;", name)
+	if err != nil {
+		return false, err
 	}
-	return nil
+	defer rows.Close()
+	return rows.Next(), nil
 }
@@ -0,0 +1,258 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Migration changes the cache's schema from Version-1 to Version.
+type Migration struct {
+	Version     int
+	Description string
+
+	up func(tx *sql.Tx) error
+}
+
+// migrations are applied in order, each in its own transaction.  Add new
+// ones to the end; never change one that has been released.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create googlephotos and nixplay tables",
+		up:          execSchema(originalSchema),
+	},
+	{
+		Version:     2,
+		Description: "create local, albumrefs, videos, transforms and playlists tables",
+		up:          execSchema(addedTablesSchema),
+	},
+	{
+		Version:     3,
+		Description: "store googlephotos, nixplay and local times as Unix nanoseconds",
+		up:          timesToUnixNano,
+	},
+	{
+		Version:     4,
+		Description: "record nixplay photos by album, and create nixplayalbums table",
+		up:          execSchema(nixplayAlbumsSchema),
+	},
+	{
+		Version:     5,
+		Description: "create blobs table",
+		up:          execSchema(blobsSchema),
+	},
+}
+
+// SchemaVersion is the cache schema version this picsync uses.
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+const schemaVersionSchema = `
+create table if not exists schema_version (
+	Version INTEGER PRIMARY KEY,
+	Description TEXT,
+	Applied INTEGER
+);
+`
+
+const originalSchema = `
+create table if not exists googlephotos (
+	Id INTEGER PRIMARY KEY,
+	BaseUrl TEXT,
+	Sha256 TEXT,
+	Md5 TEXT,
+	Width INTEGER,
+	Height INTEGER,
+	GooglephotosId TEXT,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+create table if not exists nixplay (
+	Id INTEGER PRIMARY KEY,
+	Url TEXT,
+	Filename TEXT,
+	SortDate TEXT,
+	Md5 TEXT,
+	NixplayId INTEGER,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+`
+
+// Tables added after the original schema, before there were versions.
+// Caches from then may have some of these already.
+const addedTablesSchema = `
+create table if not exists local (
+	Id INTEGER PRIMARY KEY,
+	Path TEXT,
+	Size INTEGER,
+	ModTime INTEGER,
+	Sha256 TEXT,
+	Md5 TEXT,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+create table if not exists albumrefs (
+	Id INTEGER PRIMARY KEY,
+	Ref TEXT,
+	AlbumIds TEXT,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+create table if not exists videos (
+	Id INTEGER PRIMARY KEY,
+	GooglephotosId TEXT,
+	Size INTEGER,
+	DurationMs INTEGER,
+	PosterSha256 TEXT,
+	PosterMd5 TEXT,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+create table if not exists transforms (
+	Id INTEGER PRIMARY KEY,
+	SourceSha256 TEXT,
+	Settings TEXT,
+	Sha256 TEXT,
+	Md5 TEXT,
+	Size INTEGER,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+create table if not exists playlists (
+	Id INTEGER PRIMARY KEY,
+	Name TEXT,
+	PlaylistId INTEGER,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+`
+
+// Nixplay photos are cached by album, as sync lists them.  Photos cached
+// before then (by "picsync nixplay list --update-cache") aren't in an album.
+const nixplayAlbumsSchema = `
+alter table nixplay add column AlbumId INTEGER NOT NULL DEFAULT 0;
+alter table nixplay add column Caption TEXT NOT NULL DEFAULT '';
+create table nixplayalbums (
+	Id INTEGER PRIMARY KEY,
+	AlbumId INTEGER,
+	Title TEXT,
+	Photos INTEGER,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+`
+
+// Blobs are files in the blob store, which are named by their SHA256.
+const blobsSchema = `
+create table blobs (
+	Id INTEGER PRIMARY KEY,
+	Sha256 TEXT,
+	Size INTEGER,
+	ContentType TEXT,
+	LastUpdated INTEGER,
+	LastUsed INTEGER
+);
+`
+
+// pending returns the migrations after version.
+func pending(version int) []Migration {
+	var todo []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			todo = append(todo, m)
+		}
+	}
+	return todo
+}
+
+// apply runs the migration and records it, or does neither.
+func (m *Migration) apply(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(schemaVersionSchema); err != nil {
+		return err
+	}
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_version (Version, Description, Applied) VALUES(?,?,?);",
+		m.Version, m.Description, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func execSchema(schema string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(schema)
+		return err
+	}
+}
+
+// Before version 3, the googlephotos, nixplay and local tables stored
+// LastUpdated and LastUsed as the text of a time.Time, which couldn't be
+// read back.
+var timeTables = []string{"googlephotos", "nixplay", "local"}
+
+func timesToUnixNano(tx *sql.Tx) error {
+	for _, table := range timeTables {
+		for _, column := range []string{"LastUpdated", "LastUsed"} {
+			if err := columnToUnixNano(tx, table, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func columnToUnixNano(tx *sql.Tx, table, column string) error {
+	rows, err := tx.Query(fmt.Sprintf(
+		"SELECT Id, %s FROM %s WHERE typeof(%s)='text';", column, table, column))
+	if err != nil {
+		return err
+	}
+	converted := make(map[int64]int64)
+	for rows.Next() {
+		var id int64
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			rows.Close()
+			return err
+		}
+		// Times that can't be read are left as 0 (unknown).
+		converted[id] = 0
+		if t, ok := parseTimeString(text); ok {
+			converted[id] = t.UnixNano()
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s=? WHERE Id=?;", table, column)
+	for id, unixNano := range converted {
+		if _, err := tx.Exec(update, unixNano, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTimeString parses the text of a time.Time (from its String method),
+// like "2022-01-02 15:04:05.123456789 -0800 PST m=+0.003607891".
+func parseTimeString(s string) (time.Time, bool) {
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openRaw opens the sqlite file without migrating it.
+func openRaw(t *testing.T, filename string) *sql.DB {
+	db, err := sql.Open("sqlite", dataSourceName(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func exec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+func schemaVersionOf(t *testing.T, filename string) int {
+	t.Helper()
+	version, err := GetSchemaVersion(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestGetSchemaVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing.db")
+	if got := schemaVersionOf(t, missing); got != 0 {
+		t.Errorf("missing cache is version %d, want 0", got)
+	}
+
+	empty := filepath.Join(dir, "empty.db")
+	exec(t, openRaw(t, empty), "create table unrelated (Id INTEGER);")
+	if got := schemaVersionOf(t, empty); got != 0 {
+		t.Errorf("cache without picsync tables is version %d, want 0", got)
+	}
+
+	// Caches from before versions may have some of the tables added since.
+	unversioned := filepath.Join(dir, "unversioned.db")
+	db := openRaw(t, unversioned)
+	exec(t, db, originalSchema)
+	exec(t, db, "create table local (Id INTEGER PRIMARY KEY, Path TEXT);")
+	if got := schemaVersionOf(t, unversioned); got != 1 {
+		t.Errorf("cache from before versions is version %d, want 1", got)
+	}
+
+	current := filepath.Join(dir, "current.db")
+	if _, err := Migrate(current, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := schemaVersionOf(t, current); got != SchemaVersion {
+		t.Errorf("new cache is version %d, want %d", got, SchemaVersion)
+	}
+}
+
+func TestMigrateFromUnversioned(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cache.db")
+	db := openRaw(t, filename)
+	exec(t, db, originalSchema)
+	exec(t, db, `create table local (
+		Id INTEGER PRIMARY KEY, Path TEXT, Size INTEGER, ModTime INTEGER,
+		Sha256 TEXT, Md5 TEXT, LastUpdated INTEGER, LastUsed INTEGER);`)
+
+	// Times were stored as the text of a time.Time.
+	updated := time.Date(2022, 1, 2, 15, 4, 5, 123456789, time.FixedZone("PST", -8*60*60))
+	exec(t, db, "INSERT INTO googlephotos (GooglephotosId, Sha256, Md5, LastUpdated, LastUsed) "+
+		"VALUES('gp1', 'sha', 'md5', ?, ?);", updated.String()+" m=+0.003607891", "not a time")
+	exec(t, db, "INSERT INTO nixplay (NixplayId, Md5, LastUpdated, LastUsed) VALUES(1, 'md5', ?, ?);",
+		updated.String(), updated.String())
+	exec(t, db, "INSERT INTO local (Path, Md5, LastUpdated, LastUsed) VALUES('a.jpg', 'md5', ?, ?);",
+		updated.String(), 42)
+
+	// A dry run says what would be done, and does nothing.
+	todo, err := Migrate(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todo) != SchemaVersion-1 || todo[0].Version != 2 {
+		t.Fatalf("dry run would apply %v, want versions 2 to %d", todo, SchemaVersion)
+	}
+	if got := schemaVersionOf(t, filename); got != 1 {
+		t.Errorf("dry run changed version to %d", got)
+	}
+
+	applied, err := Migrate(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(todo) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(todo))
+	}
+	if got := schemaVersionOf(t, filename); got != SchemaVersion {
+		t.Errorf("migrated to version %d, want %d", got, SchemaVersion)
+	}
+
+	times := []struct {
+		table, column string
+		want          int64
+	}{
+		{"googlephotos", "LastUpdated", updated.UnixNano()},
+		// Times that can't be read become unknown.
+		{"googlephotos", "LastUsed", 0},
+		{"nixplay", "LastUpdated", updated.UnixNano()},
+		{"nixplay", "LastUsed", updated.UnixNano()},
+		{"local", "LastUpdated", updated.UnixNano()},
+		// Times that are already numbers are left alone.
+		{"local", "LastUsed", 42},
+	}
+	for _, tt := range times {
+		var kind string
+		var got int64
+		err := db.QueryRow("SELECT typeof("+tt.column+"), "+tt.column+" FROM "+tt.table+";").Scan(&kind, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != "integer" || got != tt.want {
+			t.Errorf("%s.%s is %s %d, want integer %d", tt.table, tt.column, kind, got, tt.want)
+		}
+	}
+
+	// Later migrations worked too, and the cache is usable.
+	var albumId int
+	if err := db.QueryRow("SELECT AlbumId FROM nixplay;").Scan(&albumId); err != nil || albumId != 0 {
+		t.Errorf("nixplay AlbumId is %d (%v), want 0", albumId, err)
+	}
+	for _, table := range []string{"videos", "nixplayalbums", "blobs"} {
+		if ok, err := hasTable(db, table); err != nil || !ok {
+			t.Errorf("no %s table (%v)", table, err)
+		}
+	}
+	db.Close()
+	c, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestMigrateRefusesNewer(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cache.db")
+	if _, err := Migrate(filename, false); err != nil {
+		t.Fatal(err)
+	}
+	exec(t, openRaw(t, filename),
+		"INSERT INTO schema_version (Version, Description, Applied) VALUES(?, 'from the future', 0);",
+		SchemaVersion+1)
+
+	for _, dryRun := range []bool{true, false} {
+		_, err := Migrate(filename, dryRun)
+		if err == nil || !strings.Contains(err.Error(), "upgrade picsync") {
+			t.Errorf("dry run %t: migrating a newer cache got %v", dryRun, err)
+		}
+	}
+	if db, err := Open(filename); err == nil {
+		db.Close()
+		t.Errorf("opened a newer cache")
+	}
+}
+
+func TestMigrateRollsBack(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cache.db")
+	if _, err := Migrate(filename, false); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(m []Migration, v int) { migrations, SchemaVersion = m, v }(migrations, SchemaVersion)
+	migrations = append(append([]Migration{}, migrations...), Migration{
+		Version:     SchemaVersion + 1,
+		Description: "fail half way",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("create table halfway (Id INTEGER);"); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		},
+	})
+	SchemaVersion++
+
+	_, err := Migrate(filename, false)
+	if err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("failing migration got %v", err)
+	}
+	if got := schemaVersionOf(t, filename); got != SchemaVersion-1 {
+		t.Errorf("failed migration left version %d, want %d", got, SchemaVersion-1)
+	}
+	if ok, err := hasTable(openRaw(t, filename), "halfway"); err != nil || ok {
+		t.Errorf("failed migration's table is there (%v)", err)
+	}
+}
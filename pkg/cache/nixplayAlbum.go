@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// NixplayAlbumData is when a Nixplay album was last listed, and how many
+// photos it had then.  The photos are the nixplay entries with its AlbumId.
+type NixplayAlbumData struct {
+	Id          int64
+	AlbumId     int
+	Title       string
+	Photos      int
+	LastUpdated time.Time
+	LastUsed    time.Time
+}
+
+// ReplaceNixplayAlbum records a listing of album a.  photos are everything in
+// it, and replace what was cached for it.
+// a will be modified with new times and, if insert, the new row id.
+func (c *cacheImpl) ReplaceNixplayAlbum(a *NixplayAlbumData, photos []*NixplayData) error {
+	if a.AlbumId == 0 {
+		return errors.New("must provide AlbumId")
+	}
+	now := time.Now()
+	a.Photos = len(photos)
+	a.LastUpdated = now
+	a.LastUsed = now
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var removed int64
+	res, err := tx.Exec("DELETE FROM nixplay WHERE AlbumId=?;", a.AlbumId)
+	if err != nil {
+		return err
+	}
+	if removed, err = res.RowsAffected(); err != nil {
+		return err
+	}
+	for _, n := range photos {
+		if n.Md5 == "" || n.NixplayId == 0 || n.Filename == "" {
+			return errors.New("must provide Md5, NixplayID, Filename")
+		}
+		n.AlbumId = a.AlbumId
+		n.LastUpdated = now
+		n.LastUsed = now
+		// A photo that was moved from another album isn't there any more.
+		res, err := tx.Exec("DELETE FROM nixplay WHERE NixplayId=?;", n.NixplayId)
+		if err != nil {
+			return err
+		}
+		moved, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		removed += moved
+		res, err = insertNixplay(tx, n)
+		if err != nil {
+			return err
+		}
+		if n.Id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	inserted := false
+	if a.Id == 0 {
+		rows, err := tx.Query("SELECT Id FROM nixplayalbums WHERE AlbumId=?;", a.AlbumId)
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&a.Id)
+		}
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if a.Id != 0 {
+		_, err = tx.Exec("UPDATE nixplayalbums "+
+			"SET Title=?, Photos=?, LastUpdated=?, LastUsed=? WHERE Id=? AND AlbumId=?;",
+			a.Title, a.Photos, a.LastUpdated.UnixNano(), a.LastUsed.UnixNano(), a.Id, a.AlbumId)
+		if err != nil {
+			return err
+		}
+	} else {
+		res, err := tx.Exec("INSERT INTO nixplayalbums "+
+			"(AlbumId, Title, Photos, LastUpdated, LastUsed) VALUES(?,?,?,?,?);",
+			a.AlbumId, a.Title, a.Photos, a.LastUpdated.UnixNano(), a.LastUsed.UnixNano())
+		if err != nil {
+			return err
+		}
+		if a.Id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		inserted = true
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	c.prom.cacheUpsertsInsertNixplay.Add(float64(len(photos)))
+	c.prom.cacheEntriesNixplay.Add(float64(int64(len(photos)) - removed))
+	if inserted {
+		c.prom.cacheUpsertsInsertNixplayAlbums.Inc()
+		c.prom.cacheEntriesNixplayAlbums.Inc()
+	} else {
+		c.prom.cacheUpsertsUpdateNixplayAlbums.Inc()
+	}
+	return nil
+}
+
+// GetNixplayAlbum returns the last listing of the album with albumId, or nil
+// if it isn't cached, or some of its photos aren't any more.
+func (c *cacheImpl) GetNixplayAlbum(albumId int) (*NixplayAlbumData, []*NixplayData, error) {
+	rows, err := c.db.Query(
+		"SELECT Id, AlbumId, Title, Photos, LastUpdated, LastUsed "+
+			"FROM nixplayalbums WHERE AlbumId=? LIMIT 1;",
+		albumId)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		c.prom.cacheGetMissesNixplayAlbums.Inc()
+		return nil, nil, nil
+	}
+	var album NixplayAlbumData
+	var lastUpdated, lastUsed int64
+	err = rows.Scan(&album.Id, &album.AlbumId, &album.Title, &album.Photos,
+		&lastUpdated, &lastUsed)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows.Close()
+	album.LastUpdated = time.Unix(0, lastUpdated)
+	album.LastUsed = time.Unix(0, lastUsed)
+
+	rows, err = c.db.Query(
+		"SELECT Id, AlbumId, NixplayId, Filename, URL, SortDate, Md5, Caption, LastUpdated, LastUsed "+
+			"FROM nixplay WHERE AlbumId=? ORDER BY Id;",
+		albumId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var photos []*NixplayData
+	for rows.Next() {
+		var n NixplayData
+		err = rows.Scan(&n.Id, &n.AlbumId, &n.NixplayId, &n.Filename, &n.URL,
+			&n.SortDate, &n.Md5, &n.Caption, &lastUpdated, &lastUsed)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		n.LastUpdated = time.Unix(0, lastUpdated)
+		n.LastUsed = time.Unix(0, lastUsed)
+		photos = append(photos, &n)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(photos) != album.Photos {
+		c.prom.cacheGetMissesNixplayAlbums.Inc()
+		return nil, nil, nil
+	}
+
+	if album.LastUsed, err = c.touch("nixplayalbums", album.Id); err != nil {
+		return nil, nil, err
+	}
+	_, err = c.db.Exec("UPDATE nixplay SET LastUsed=? WHERE AlbumId=?;",
+		album.LastUsed.UnixNano(), albumId)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.prom.cacheGetHitsNixplayAlbums.Inc()
+	c.prom.cacheGetHitsNixplay.Add(float64(len(photos)))
+	return &album, photos, nil
+}
+
+// DeleteNixplayAlbum forgets the listing of the album with albumId, so that
+// it's listed again.
+func (c *cacheImpl) DeleteNixplayAlbum(albumId int) error {
+	res, err := c.db.Exec("DELETE FROM nixplayalbums WHERE AlbumId=?;", albumId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	c.prom.cacheEntriesNixplayAlbums.Sub(float64(rows))
+	return nil
+}
+
+// DeleteNixplay forgets the photo with nixplayId, which has been deleted.  The
+// listing of its album stays complete.
+func (c *cacheImpl) DeleteNixplay(nixplayId int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("UPDATE nixplayalbums SET Photos=Photos-1 WHERE AlbumId="+
+		"(SELECT AlbumId FROM nixplay WHERE NixplayId=?);", nixplayId)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM nixplay WHERE NixplayId=?;", nixplayId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.prom.cacheEntriesNixplay.Sub(float64(rows))
+	return nil
+}
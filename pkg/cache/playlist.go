@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PlaylistData is the Nixplay playlist that a configured playlist name was
+// published to, so that it is found by ID even if it's renamed in Nixplay.
+type PlaylistData struct {
+	Id          int64
+	Name        string
+	PlaylistId  int
+	LastUpdated time.Time
+	LastUsed    time.Time
+}
+
+// Updates/inserts the playlist published as p.Name.
+// p will be modified with new last used time and, if insert, the new row id.
+func (c *cacheImpl) UpsertPlaylist(p *PlaylistData) error {
+	if p.Name == "" || p.PlaylistId == 0 {
+		return errors.New("must provide Name, PlaylistId")
+	}
+	if p.LastUpdated.IsZero() {
+		p.LastUpdated = time.Now()
+	}
+	p.LastUsed = time.Now()
+
+	if p.Id == 0 {
+		rows, err := c.db.Query("SELECT Id FROM playlists WHERE Name=?;", p.Name)
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&p.Id)
+			rows.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			rows.Close()
+		}
+	}
+
+	if p.Id != 0 {
+		c.prom.cacheUpsertsUpdatePlaylists.Inc()
+		res, err := c.db.Exec("UPDATE playlists "+
+			"SET PlaylistId=?, LastUpdated=?, LastUsed=? WHERE Id=? AND Name=?;",
+			p.PlaylistId, p.LastUpdated.UnixNano(), p.LastUsed.UnixNano(), p.Id, p.Name)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return fmt.Errorf("expected 1 row updated, got %d", rows)
+		}
+		return nil
+	}
+
+	c.prom.cacheUpsertsInsertPlaylists.Inc()
+	res, err := c.db.Exec("INSERT INTO playlists "+
+		"(Name, PlaylistId, LastUpdated, LastUsed) VALUES(?,?,?,?);",
+		p.Name, p.PlaylistId, p.LastUpdated.UnixNano(), p.LastUsed.UnixNano())
+	if err != nil {
+		return err
+	}
+	rowId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	p.Id = rowId
+	c.prom.cacheEntriesPlaylists.Inc()
+	return nil
+}
+
+// GetPlaylist returns the playlist last published as name, or nil if it
+// isn't cached.
+func (c *cacheImpl) GetPlaylist(name string) (*PlaylistData, error) {
+	rows, err := c.db.Query(
+		"SELECT Id, Name, PlaylistId, LastUpdated, LastUsed "+
+			"FROM playlists WHERE Name=? LIMIT 1;",
+		name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		c.prom.cacheGetMissesPlaylists.Inc()
+		return nil, nil
+	}
+	var toRet PlaylistData
+	var lastUpdated, lastUsed int64
+	err = rows.Scan(&toRet.Id, &toRet.Name, &toRet.PlaylistId, &lastUpdated, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	toRet.LastUpdated = time.Unix(0, lastUpdated)
+	rows.Close()
+	toRet.LastUsed, err = c.touch("playlists", toRet.Id)
+	if err != nil {
+		return nil, err
+	}
+	c.prom.cacheGetHitsPlaylists.Inc()
+	return &toRet, nil
+}
+
+// DeletePlaylist forgets the playlist published as name.
+func (c *cacheImpl) DeletePlaylist(name string) error {
+	res, err := c.db.Exec("DELETE FROM playlists WHERE Name=?;", name)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	c.prom.cacheEntriesPlaylists.Sub(float64(rows))
+	return nil
+}
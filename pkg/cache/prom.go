@@ -16,12 +16,62 @@ type cachePromImpl struct {
 	cacheUpsertsUpdateGooglephotos prometheus.Counter
 	cacheUpsertsInsertGooglephotos prometheus.Counter
 	cacheEntriesGooglephotos       prometheus.Gauge
+	cacheGcRemovedGooglephotos     prometheus.Counter
 
 	cacheGetHitsNixplay       prometheus.Counter
 	cacheGetMissesNixplay     prometheus.Counter
 	cacheUpsertsUpdateNixplay prometheus.Counter
 	cacheUpsertsInsertNixplay prometheus.Counter
 	cacheEntriesNixplay       prometheus.Gauge
+	cacheGcRemovedNixplay     prometheus.Counter
+
+	cacheGetHitsLocal       prometheus.Counter
+	cacheGetMissesLocal     prometheus.Counter
+	cacheUpsertsUpdateLocal prometheus.Counter
+	cacheUpsertsInsertLocal prometheus.Counter
+	cacheEntriesLocal       prometheus.Gauge
+	cacheGcRemovedLocal     prometheus.Counter
+
+	cacheGetHitsAlbumRefs       prometheus.Counter
+	cacheGetMissesAlbumRefs     prometheus.Counter
+	cacheUpsertsUpdateAlbumRefs prometheus.Counter
+	cacheUpsertsInsertAlbumRefs prometheus.Counter
+	cacheEntriesAlbumRefs       prometheus.Gauge
+	cacheGcRemovedAlbumRefs     prometheus.Counter
+
+	cacheGetHitsVideos       prometheus.Counter
+	cacheGetMissesVideos     prometheus.Counter
+	cacheUpsertsUpdateVideos prometheus.Counter
+	cacheUpsertsInsertVideos prometheus.Counter
+	cacheEntriesVideos       prometheus.Gauge
+	cacheGcRemovedVideos     prometheus.Counter
+
+	cacheGetHitsTransforms       prometheus.Counter
+	cacheGetMissesTransforms     prometheus.Counter
+	cacheUpsertsUpdateTransforms prometheus.Counter
+	cacheUpsertsInsertTransforms prometheus.Counter
+	cacheEntriesTransforms       prometheus.Gauge
+	cacheGcRemovedTransforms     prometheus.Counter
+
+	cacheGetHitsPlaylists       prometheus.Counter
+	cacheGetMissesPlaylists     prometheus.Counter
+	cacheUpsertsUpdatePlaylists prometheus.Counter
+	cacheUpsertsInsertPlaylists prometheus.Counter
+	cacheEntriesPlaylists       prometheus.Gauge
+	cacheGcRemovedPlaylists     prometheus.Counter
+
+	cacheGetHitsNixplayAlbums       prometheus.Counter
+	cacheGetMissesNixplayAlbums     prometheus.Counter
+	cacheUpsertsUpdateNixplayAlbums prometheus.Counter
+	cacheUpsertsInsertNixplayAlbums prometheus.Counter
+	cacheEntriesNixplayAlbums       prometheus.Gauge
+	cacheGcRemovedNixplayAlbums     prometheus.Counter
+
+	cacheGetHitsBlobs       prometheus.Counter
+	cacheGetMissesBlobs     prometheus.Counter
+	cacheUpsertsUpdateBlobs prometheus.Counter
+	cacheUpsertsInsertBlobs prometheus.Counter
+	cacheEntriesBlobs       prometheus.Gauge
 
 	cacheFileSize prometheus.GaugeFunc
 }
@@ -55,6 +105,11 @@ func (c *cacheImpl) promRegister(reg prometheus.Registerer) error {
 			Name: "cache_entries_googlephotos",
 			Help: "Number of entries in the googlephotos cache",
 		})
+	c.prom.cacheGcRemovedGooglephotos = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_gc_removed_googlephotos",
+			Help: "Number of entries removed by garbage collection",
+		})
 	c.prom.cacheGetHitsNixplay = c.prom.promFactory.NewCounter(
 		prometheus.CounterOpts{
 			Name: "cache_get_hits_nixplay",
@@ -80,15 +135,225 @@ func (c *cacheImpl) promRegister(reg prometheus.Registerer) error {
 			Name: "cache_entries_nixplay",
 			Help: "Number of entries in the nixplay cache",
 		})
+	c.prom.cacheGcRemovedNixplay = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_gc_removed_nixplay",
+			Help: "Number of entries removed by garbage collection",
+		})
+	c.prom.cacheGetHitsLocal = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_hits_local",
+			Help: "Number of gets that were found in the cache",
+		})
+	c.prom.cacheGetMissesLocal = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_misses_local",
+			Help: "Number of gets that were not found in the cache",
+		})
+	c.prom.cacheUpsertsUpdateLocal = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_update_local",
+			Help: "Number of upserts that were updates (found in the cache)",
+		})
+	c.prom.cacheUpsertsInsertLocal = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_insert_local",
+			Help: "Number of upserts that were inserts (not found in the cache)",
+		})
+	c.prom.cacheEntriesLocal = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_entries_local",
+			Help: "Number of entries in the local files cache",
+		})
+	c.prom.cacheGcRemovedLocal = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_gc_removed_local",
+			Help: "Number of entries removed by garbage collection",
+		})
+	c.prom.cacheGetHitsAlbumRefs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_hits_albumrefs",
+			Help: "Number of gets that were found in the cache",
+		})
+	c.prom.cacheGetMissesAlbumRefs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_misses_albumrefs",
+			Help: "Number of gets that were not found in the cache",
+		})
+	c.prom.cacheUpsertsUpdateAlbumRefs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_update_albumrefs",
+			Help: "Number of upserts that were updates (found in the cache)",
+		})
+	c.prom.cacheUpsertsInsertAlbumRefs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_insert_albumrefs",
+			Help: "Number of upserts that were inserts (not found in the cache)",
+		})
+	c.prom.cacheEntriesAlbumRefs = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_entries_albumrefs",
+			Help: "Number of album titles resolved to IDs in the cache",
+		})
+	c.prom.cacheGcRemovedAlbumRefs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_gc_removed_albumrefs",
+			Help: "Number of entries removed by garbage collection",
+		})
+	c.prom.cacheGetHitsVideos = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_hits_videos",
+			Help: "Number of gets that were found in the cache",
+		})
+	c.prom.cacheGetMissesVideos = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_misses_videos",
+			Help: "Number of gets that were not found in the cache",
+		})
+	c.prom.cacheUpsertsUpdateVideos = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_update_videos",
+			Help: "Number of upserts that were updates (found in the cache)",
+		})
+	c.prom.cacheUpsertsInsertVideos = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_insert_videos",
+			Help: "Number of upserts that were inserts (not found in the cache)",
+		})
+	c.prom.cacheEntriesVideos = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_entries_videos",
+			Help: "Number of Google Photos videos in the cache",
+		})
+	c.prom.cacheGcRemovedVideos = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_gc_removed_videos",
+			Help: "Number of entries removed by garbage collection",
+		})
+	c.prom.cacheGetHitsTransforms = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_hits_transforms",
+			Help: "Number of gets that were found in the cache",
+		})
+	c.prom.cacheGetMissesTransforms = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_misses_transforms",
+			Help: "Number of gets that were not found in the cache",
+		})
+	c.prom.cacheUpsertsUpdateTransforms = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_update_transforms",
+			Help: "Number of upserts that were updates (found in the cache)",
+		})
+	c.prom.cacheUpsertsInsertTransforms = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_insert_transforms",
+			Help: "Number of upserts that were inserts (not found in the cache)",
+		})
+	c.prom.cacheEntriesTransforms = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_entries_transforms",
+			Help: "Number of transformed images in the cache",
+		})
+	c.prom.cacheGcRemovedTransforms = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_gc_removed_transforms",
+			Help: "Number of entries removed by garbage collection",
+		})
+	c.prom.cacheGetHitsPlaylists = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_hits_playlists",
+			Help: "Number of gets that were found in the cache",
+		})
+	c.prom.cacheGetMissesPlaylists = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_misses_playlists",
+			Help: "Number of gets that were not found in the cache",
+		})
+	c.prom.cacheUpsertsUpdatePlaylists = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_update_playlists",
+			Help: "Number of upserts that were updates (found in the cache)",
+		})
+	c.prom.cacheUpsertsInsertPlaylists = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_insert_playlists",
+			Help: "Number of upserts that were inserts (not found in the cache)",
+		})
+	c.prom.cacheEntriesPlaylists = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_entries_playlists",
+			Help: "Number of Nixplay playlists tracked by ID in the cache",
+		})
+	c.prom.cacheGcRemovedPlaylists = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_gc_removed_playlists",
+			Help: "Number of entries removed by garbage collection",
+		})
+
+	c.prom.cacheGetHitsNixplayAlbums = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_hits_nixplayalbums",
+			Help: "Number of gets that were found in the cache",
+		})
+	c.prom.cacheGetMissesNixplayAlbums = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_misses_nixplayalbums",
+			Help: "Number of gets that were not found in the cache",
+		})
+	c.prom.cacheUpsertsUpdateNixplayAlbums = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_update_nixplayalbums",
+			Help: "Number of upserts that were updates (found in the cache)",
+		})
+	c.prom.cacheUpsertsInsertNixplayAlbums = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_insert_nixplayalbums",
+			Help: "Number of upserts that were inserts (not found in the cache)",
+		})
+	c.prom.cacheEntriesNixplayAlbums = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_entries_nixplayalbums",
+			Help: "Number of Nixplay album listings in the cache",
+		})
+	c.prom.cacheGcRemovedNixplayAlbums = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_gc_removed_nixplayalbums",
+			Help: "Number of entries removed by garbage collection",
+		})
+
+	// Blobs aren't garbage collected; the blob store evicts them itself.
+	c.prom.cacheGetHitsBlobs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_hits_blobs",
+			Help: "Number of gets that were found in the cache",
+		})
+	c.prom.cacheGetMissesBlobs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_get_misses_blobs",
+			Help: "Number of gets that were not found in the cache",
+		})
+	c.prom.cacheUpsertsUpdateBlobs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_update_blobs",
+			Help: "Number of upserts that were updates (found in the cache)",
+		})
+	c.prom.cacheUpsertsInsertBlobs = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_upserts_insert_blobs",
+			Help: "Number of upserts that were inserts (not found in the cache)",
+		})
+	c.prom.cacheEntriesBlobs = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_entries_blobs",
+			Help: "Number of files in the blob store",
+		})
 
 	// Rather than re-counting the cache every time, we will init the gauge
 	// once and then keep it up-to-date by Inc/Dec.
-	status, err := c.Status()
-	if err != nil {
+	if err := c.setEntries(); err != nil {
 		return err
 	}
-	c.prom.cacheEntriesGooglephotos.Set(float64(status.GooglePhotosValidRows))
-	c.prom.cacheEntriesNixplay.Set(float64(status.NixplayValidRows))
 
 	c.prom.cacheFileSize = c.prom.promFactory.NewGaugeFunc(
 		prometheus.GaugeOpts{
@@ -99,6 +364,24 @@ func (c *cacheImpl) promRegister(reg prometheus.Registerer) error {
 	return nil
 }
 
+// setEntries sets the entries gauges by counting each table.
+func (c *cacheImpl) setEntries() error {
+	status, err := c.Status()
+	if err != nil {
+		return err
+	}
+	c.prom.cacheEntriesGooglephotos.Set(float64(status.GooglePhotosValidRows))
+	c.prom.cacheEntriesNixplay.Set(float64(status.NixplayValidRows))
+	c.prom.cacheEntriesLocal.Set(float64(status.LocalValidRows))
+	c.prom.cacheEntriesAlbumRefs.Set(float64(status.AlbumRefValidRows))
+	c.prom.cacheEntriesVideos.Set(float64(status.VideoValidRows))
+	c.prom.cacheEntriesTransforms.Set(float64(status.TransformValidRows))
+	c.prom.cacheEntriesPlaylists.Set(float64(status.PlaylistValidRows))
+	c.prom.cacheEntriesNixplayAlbums.Set(float64(status.NixplayAlbumValidRows))
+	c.prom.cacheEntriesBlobs.Set(float64(status.BlobValidRows))
+	return nil
+}
+
 func (c *cacheImpl) newDbFilesizeGetter() func() float64 {
 	return func() float64 {
 		fileinfo, err := os.Stat(c.dbFilename)
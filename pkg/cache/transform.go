@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TransformData is the result of transforming (e.g. resizing) an image.  It
+// is keyed by the SHA256 of the original and the transform's settings, so
+// the same original transformed the same way always has the same MD5.
+type TransformData struct {
+	Id           int64
+	SourceSha256 string
+	Settings     string
+	Sha256       string
+	Md5          string
+	Size         int64
+	LastUpdated  time.Time
+	LastUsed     time.Time
+}
+
+// Updates/inserts a transformed image.
+// t will be modified with new last used time and, if insert, the new row id.
+func (c *cacheImpl) UpsertTransform(t *TransformData) error {
+	if t.SourceSha256 == "" || t.Settings == "" || t.Sha256 == "" || t.Md5 == "" {
+		return errors.New("must provide SourceSha256, Settings, Sha256, Md5")
+	}
+	if t.LastUpdated.IsZero() {
+		t.LastUpdated = time.Now()
+	}
+	t.LastUsed = time.Now()
+
+	if t.Id == 0 {
+		rows, err := c.db.Query("SELECT Id FROM transforms WHERE SourceSha256=? AND Settings=?;",
+			t.SourceSha256, t.Settings)
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&t.Id)
+			rows.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			rows.Close()
+		}
+	}
+
+	if t.Id != 0 {
+		c.prom.cacheUpsertsUpdateTransforms.Inc()
+		res, err := c.db.Exec("UPDATE transforms "+
+			"SET Sha256=?, Md5=?, Size=?, LastUpdated=?, LastUsed=? "+
+			"WHERE Id=? AND SourceSha256=? AND Settings=?;",
+			t.Sha256, t.Md5, t.Size, t.LastUpdated.UnixNano(), t.LastUsed.UnixNano(),
+			t.Id, t.SourceSha256, t.Settings)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return fmt.Errorf("expected 1 row updated, got %d", rows)
+		}
+		return nil
+	}
+
+	c.prom.cacheUpsertsInsertTransforms.Inc()
+	res, err := c.db.Exec("INSERT INTO transforms "+
+		"(SourceSha256, Settings, Sha256, Md5, Size, LastUpdated, LastUsed) "+
+		"VALUES(?,?,?,?,?,?,?);",
+		t.SourceSha256, t.Settings, t.Sha256, t.Md5, t.Size,
+		t.LastUpdated.UnixNano(), t.LastUsed.UnixNano())
+	if err != nil {
+		return err
+	}
+	rowId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.Id = rowId
+	c.prom.cacheEntriesTransforms.Inc()
+	return nil
+}
+
+// GetTransform returns the image with SHA256 sourceSha256 transformed with
+// settings, or nil if it isn't cached.
+func (c *cacheImpl) GetTransform(sourceSha256 string, settings string) (*TransformData, error) {
+	rows, err := c.db.Query(
+		"SELECT Id, SourceSha256, Settings, Sha256, Md5, Size, LastUpdated, LastUsed "+
+			"FROM transforms WHERE SourceSha256=? AND Settings=? LIMIT 1;",
+		sourceSha256, settings)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		c.prom.cacheGetMissesTransforms.Inc()
+		return nil, nil
+	}
+	var toRet TransformData
+	var lastUpdated, lastUsed int64
+	err = rows.Scan(&toRet.Id, &toRet.SourceSha256, &toRet.Settings, &toRet.Sha256,
+		&toRet.Md5, &toRet.Size, &lastUpdated, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	toRet.LastUpdated = time.Unix(0, lastUpdated)
+	rows.Close()
+	toRet.LastUsed, err = c.touch("transforms", toRet.Id)
+	if err != nil {
+		return nil, err
+	}
+	c.prom.cacheGetHitsTransforms.Inc()
+	return &toRet, nil
+}
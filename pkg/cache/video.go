@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// VideoData is what we know about a Google Photos video, beyond the hashes
+// of the original (which are in GooglephotoData): how big and long it is,
+// and the hashes of its poster (a still frame).  Only what the video's policy
+// needs is found out, so Size and Duration are -1 until they're checked (and
+// 0 if the video doesn't say), and the poster's hashes are empty until it is
+// downloaded.
+type VideoData struct {
+	Id             int64
+	GooglephotosId string
+	Size           int64
+	Duration       time.Duration
+	PosterSha256   string
+	PosterMd5      string
+	LastUpdated    time.Time
+	LastUsed       time.Time
+}
+
+// Updates/inserts a cache entry for a Google Photos video.
+// v will be modified with new last used time and, if insert, the new row id.
+func (c *cacheImpl) UpsertVideo(v *VideoData) error {
+	if v.GooglephotosId == "" {
+		return errors.New("must provide GooglephotosId")
+	}
+	if v.LastUpdated.IsZero() {
+		v.LastUpdated = time.Now()
+	}
+	v.LastUsed = time.Now()
+	durationMs := v.Duration.Milliseconds()
+	if v.Duration < 0 {
+		durationMs = -1
+	}
+
+	if v.Id == 0 {
+		rows, err := c.db.Query("SELECT Id FROM videos WHERE GooglephotosId=?;", v.GooglephotosId)
+		if err != nil {
+			return err
+		}
+		if rows.Next() {
+			err = rows.Scan(&v.Id)
+			rows.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			rows.Close()
+		}
+	}
+
+	if v.Id != 0 {
+		c.prom.cacheUpsertsUpdateVideos.Inc()
+		res, err := c.db.Exec("UPDATE videos "+
+			"SET Size=?, DurationMs=?, PosterSha256=?, PosterMd5=?, LastUpdated=?, LastUsed=? "+
+			"WHERE Id=? AND GooglephotosId=?;",
+			v.Size, durationMs, v.PosterSha256, v.PosterMd5,
+			v.LastUpdated.UnixNano(), v.LastUsed.UnixNano(), v.Id, v.GooglephotosId)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return fmt.Errorf("expected 1 row updated, got %d", rows)
+		}
+		return nil
+	}
+
+	c.prom.cacheUpsertsInsertVideos.Inc()
+	res, err := c.db.Exec("INSERT INTO videos "+
+		"(GooglephotosId, Size, DurationMs, PosterSha256, PosterMd5, LastUpdated, LastUsed) "+
+		"VALUES(?,?,?,?,?,?,?);",
+		v.GooglephotosId, v.Size, durationMs, v.PosterSha256, v.PosterMd5,
+		v.LastUpdated.UnixNano(), v.LastUsed.UnixNano())
+	if err != nil {
+		return err
+	}
+	rowId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	v.Id = rowId
+	c.prom.cacheEntriesVideos.Inc()
+	return nil
+}
+
+// GetVideo returns what we know about a video, or nil if it isn't cached.
+func (c *cacheImpl) GetVideo(googlephotosId string) (*VideoData, error) {
+	rows, err := c.db.Query(
+		"SELECT Id, GooglephotosId, Size, DurationMs, PosterSha256, PosterMd5, LastUpdated, LastUsed "+
+			"FROM videos WHERE GooglephotosId=? LIMIT 1;",
+		googlephotosId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		c.prom.cacheGetMissesVideos.Inc()
+		return nil, nil
+	}
+	var toRet VideoData
+	var durationMs, lastUpdated, lastUsed int64
+	err = rows.Scan(&toRet.Id, &toRet.GooglephotosId, &toRet.Size, &durationMs,
+		&toRet.PosterSha256, &toRet.PosterMd5, &lastUpdated, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	toRet.Duration = time.Duration(durationMs) * time.Millisecond
+	if durationMs < 0 {
+		toRet.Duration = -1
+	}
+	toRet.LastUpdated = time.Unix(0, lastUpdated)
+	rows.Close()
+	toRet.LastUsed, err = c.touch("videos", toRet.Id)
+	if err != nil {
+		return nil, err
+	}
+	c.prom.cacheGetHitsVideos.Inc()
+	return &toRet, nil
+}
@@ -1,7 +1,9 @@
 package googlephotos
 
 import (
-	"fmt"
+	"context"
+	"net/url"
+	"strconv"
 )
 
 type Album struct {
@@ -18,59 +20,118 @@ type Album struct {
 
 type albumsResponse struct {
 	Albums        []*Album `json:"albums"`
+	SharedAlbums  []*Album `json:"sharedAlbums"`
 	NextPageToken string   `json:"nextPageToken"`
 }
 
-// FIXME: Handle pagination
-func (c clientImpl) ListAlbums() ([]*Album, error) {
+// ListAlbumsOptions are optional settings for listing albums.
+type ListAlbumsOptions struct {
+	// PageSize is how many albums to get per call (Google's default is 20,
+	// max 50).
+	PageSize int
+}
+
+// AlbumIterator returns albums one at a time, getting another page from
+// Google Photos when it needs to.
+type AlbumIterator interface {
+	// Next returns the next album, or nil (and no error) when there are no
+	// more.
+	Next() (*Album, error)
+}
+
+type albumIterator struct {
+	ctx    context.Context
+	c      *clientImpl
+	path   string
+	opts   ListAlbumsOptions
+	page   []*Album
+	token  string
+	gotAll bool
+}
+
+func (it *albumIterator) Next() (*Album, error) {
+	for len(it.page) == 0 {
+		if it.gotAll {
+			return nil, nil
+		}
+		if err := it.ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := it.getPage()
+		if err != nil {
+			return nil, err
+		}
+	}
+	a := it.page[0]
+	it.page = it.page[1:]
+	return a, nil
+}
+
+func (it *albumIterator) getPage() error {
+	query := url.Values{}
+	if it.opts.PageSize > 0 {
+		query.Set("pageSize", strconv.Itoa(it.opts.PageSize))
+	}
+	if it.token != "" {
+		query.Set("pageToken", it.token)
+	}
+	u := it.c.baseURL + it.path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
 	resp := albumsResponse{}
-	url := "https://photoslibrary.googleapis.com/v1/albums"
-	err := GetUnmarshalJSON(c.httpClient, url, &resp)
+	err := GetUnmarshalJSONContext(it.ctx, it.c.httpClient, u, &resp)
 	if err != nil {
-		c.prom.listAlbumsFailure.Inc()
-	} else {
-		c.prom.listAlbumsSuccess.Inc()
+		it.c.prom.listAlbumsFailure.Inc()
+		return err
 	}
-	return resp.Albums, err
+	it.c.prom.listAlbumsSuccess.Inc()
+	it.page = append(resp.Albums, resp.SharedAlbums...)
+	it.token = resp.NextPageToken
+	it.gotAll = (it.token == "")
+	return nil
 }
 
-type sharedAlbumsResponse struct {
-	SharedAlbums  []*Album `json:"sharedAlbums"`
-	NextPageToken string   `json:"nextPageToken"`
+// IterateAlbums returns an iterator over all the user's albums.
+func (c clientImpl) IterateAlbums(ctx context.Context, opts ListAlbumsOptions) AlbumIterator {
+	return &albumIterator{ctx: ctx, c: &c, path: "/v1/albums", opts: opts}
 }
 
-func (c clientImpl) ListSharedAlbums() ([]*Album, error) {
-	resp := sharedAlbumsResponse{}
-	url := "https://photoslibrary.googleapis.com/v1/sharedAlbums"
-	err := GetUnmarshalJSON(c.httpClient, url, &resp)
-	if err != nil {
-		c.prom.listAlbumsFailure.Inc()
-	} else {
-		c.prom.listAlbumsSuccess.Inc()
+// IterateSharedAlbums returns an iterator over all the albums shared with
+// the user.
+func (c clientImpl) IterateSharedAlbums(ctx context.Context, opts ListAlbumsOptions) AlbumIterator {
+	return &albumIterator{ctx: ctx, c: &c, path: "/v1/sharedAlbums", opts: opts}
+}
+
+func allAlbums(it AlbumIterator) ([]*Album, error) {
+	var albums []*Album
+	for {
+		a, err := it.Next()
+		if err != nil {
+			return albums, err
+		}
+		if a == nil {
+			return albums, nil
+		}
+		albums = append(albums, a)
 	}
-	return resp.SharedAlbums, err
 }
 
-type SearchMediaItemsResponse struct {
-	MediaItems    []*MediaItem `json:"mediaItems"`
-	NextPageToken string       `json:"nextPageToken"`
+// ListAlbums returns all of the user's albums.
+func (c clientImpl) ListAlbums() ([]*Album, error) {
+	return allAlbums(c.IterateAlbums(context.Background(), ListAlbumsOptions{}))
+}
+
+// ListSharedAlbums returns all the albums shared with the user.
+func (c clientImpl) ListSharedAlbums() ([]*Album, error) {
+	return allAlbums(c.IterateSharedAlbums(context.Background(), ListAlbumsOptions{}))
 }
 
+// ListMediaItemsForAlbumId gets a page of the items in an album.
 func (c clientImpl) ListMediaItemsForAlbumId(albumId string, nextPageToken string) (*SearchMediaItemsResponse, error) {
-	resp := SearchMediaItemsResponse{}
-	url := "https://photoslibrary.googleapis.com/v1/mediaItems:search"
-	var body string
-	if nextPageToken == "" {
-		body = fmt.Sprintf("{\"albumId\":\"%s\"}", albumId)
-	} else {
-		body = fmt.Sprintf("{\"albumId\":\"%s\",\"pageToken\":\"%s\"}", albumId, nextPageToken)
-	}
-	err := PostUnmarshalJSON(c.httpClient, url, body, &resp)
-	if err != nil {
-		c.prom.listMediaItemsFailure.Inc()
-	} else {
-		c.prom.listMediaItemsSuccess.Inc()
-		c.prom.listMediaItemsCount.Add(float64(len(resp.MediaItems)))
-	}
-	return &resp, err
+	return c.SearchMediaItems(context.Background(), &SearchMediaItemsRequest{
+		AlbumId:   albumId,
+		PageToken: nextPageToken,
+	})
 }
@@ -0,0 +1,172 @@
+package googlephotos
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+)
+
+const (
+	titlePrefix       = "title:"
+	sharedTitlePrefix = "shared-title:"
+)
+
+// AlbumRefMaxAge is how long a title resolved to album IDs is trusted
+// before the albums are listed again.
+const AlbumRefMaxAge = 24 * time.Hour
+
+// AlbumRef is how a config refers to an album: by its ID, or by its title.
+//
+//	AP5WpWre...              The album with this ID
+//	title:Xmas 2022          The one album titled exactly "Xmas 2022"
+//	title:Xmas*              All albums whose title matches the glob
+//	title:/^Xmas \d+$/       All albums whose title matches the regexp
+//	shared-title:...         Like title:, but for albums shared with you
+//
+// An exact title must match exactly one album.  A glob or regexp can match
+// several (they are all used), but must match at least one.
+type AlbumRef struct {
+	ID     string
+	Title  string
+	Shared bool
+
+	regexp *regexp.Regexp
+}
+
+// ParseAlbumRef parses a reference from a config.
+func ParseAlbumRef(s string) (AlbumRef, error) {
+	ref := AlbumRef{}
+	switch {
+	case strings.HasPrefix(s, sharedTitlePrefix):
+		ref.Title = strings.TrimPrefix(s, sharedTitlePrefix)
+		ref.Shared = true
+	case strings.HasPrefix(s, titlePrefix):
+		ref.Title = strings.TrimPrefix(s, titlePrefix)
+	default:
+		if s == "" {
+			return ref, fmt.Errorf("empty Google Photos album")
+		}
+		ref.ID = s
+		return ref, nil
+	}
+
+	if ref.Title == "" {
+		return ref, fmt.Errorf("no title in Google Photos album %q", s)
+	}
+	if ref.isRegexp() {
+		re, err := regexp.Compile(ref.Title[1 : len(ref.Title)-1])
+		if err != nil {
+			return ref, fmt.Errorf("bad regexp in Google Photos album %q: %v", s, err)
+		}
+		ref.regexp = re
+	} else if ref.isGlob() {
+		if _, err := path.Match(ref.Title, ""); err != nil {
+			return ref, fmt.Errorf("bad glob in Google Photos album %q: %v", s, err)
+		}
+	}
+	return ref, nil
+}
+
+func (r AlbumRef) String() string {
+	switch {
+	case r.ID != "":
+		return r.ID
+	case r.Shared:
+		return sharedTitlePrefix + r.Title
+	}
+	return titlePrefix + r.Title
+}
+
+// IsTitle is true if the reference is by title, and must be resolved.
+func (r AlbumRef) IsTitle() bool {
+	return r.ID == ""
+}
+
+func (r AlbumRef) isRegexp() bool {
+	return len(r.Title) >= 2 && strings.HasPrefix(r.Title, "/") && strings.HasSuffix(r.Title, "/")
+}
+
+func (r AlbumRef) isGlob() bool {
+	return strings.ContainsAny(r.Title, "*?[")
+}
+
+func (r AlbumRef) matches(title string) bool {
+	switch {
+	case r.regexp != nil:
+		return r.regexp.MatchString(title)
+	case r.isGlob():
+		match, _ := path.Match(r.Title, title)
+		return match
+	}
+	return r.Title == title
+}
+
+// ResolveAlbumRef returns the IDs of the albums that ref refers to.  Titles
+// are looked up in the cache first, unless refresh is true or the cached
+// answer is older than AlbumRefMaxAge.
+func (c clientImpl) ResolveAlbumRef(ctx context.Context, ref AlbumRef, refresh bool) ([]string, error) {
+	if !ref.IsTitle() {
+		return []string{ref.ID}, nil
+	}
+
+	if !refresh {
+		cached, err := c.cache.GetAlbumRef(ref.String())
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil && time.Since(cached.LastUpdated) < AlbumRefMaxAge {
+			if err := c.cache.UpsertAlbumRef(cached); err != nil {
+				return nil, err
+			}
+			return cached.AlbumIds, nil
+		}
+	}
+
+	var albums AlbumIterator
+	if ref.Shared {
+		albums = c.IterateSharedAlbums(ctx, ListAlbumsOptions{PageSize: 50})
+	} else {
+		albums = c.IterateAlbums(ctx, ListAlbumsOptions{PageSize: 50})
+	}
+	var matched []*Album
+	for {
+		a, err := albums.Next()
+		if err != nil {
+			return nil, err
+		}
+		if a == nil {
+			break
+		}
+		if ref.matches(a.Title) {
+			matched = append(matched, a)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no Google Photos album matches %s", ref)
+	}
+	if !ref.isRegexp() && !ref.isGlob() && len(matched) > 1 {
+		var which []string
+		for _, a := range matched {
+			which = append(which, a.Id)
+		}
+		return nil, fmt.Errorf("%s is ambiguous, %d albums have that title (IDs %s); use an ID instead",
+			ref, len(matched), strings.Join(which, ", "))
+	}
+
+	entry := &cache.AlbumRefData{
+		Ref: ref.String(),
+	}
+	for _, a := range matched {
+		entry.AlbumIds = append(entry.AlbumIds, a.Id)
+	}
+	if err := c.cache.UpsertAlbumRef(entry); err != nil {
+		return nil, err
+	}
+	return entry.AlbumIds, nil
+}
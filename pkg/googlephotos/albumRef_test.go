@@ -0,0 +1,144 @@
+package googlephotos_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos/googlephotostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestResolveAlbumRef(t *testing.T) {
+	gp := googlephotostest.NewServer()
+	defer gp.Close()
+	xmas21 := gp.AddAlbum("Xmas 2021", false)
+	xmas22 := gp.AddAlbum("Xmas 2022", false)
+	summer1 := gp.AddAlbum("Summer 2022", false)
+	summer2 := gp.AddAlbum("Summer 2022", false)
+	sharedXmas := gp.AddAlbum("Xmas 2022", true)
+
+	tests := []struct {
+		ref     string
+		want    []string
+		wantErr string
+	}{
+		{ref: "AP5WpWre", want: []string{"AP5WpWre"}},
+		{ref: "title:Xmas 2022", want: []string{xmas22.Id}},
+		{ref: "shared-title:Xmas 2022", want: []string{sharedXmas.Id}},
+		{ref: "title:Xmas*", want: []string{xmas21.Id, xmas22.Id}},
+		{ref: "title:Xmas 202?", want: []string{xmas21.Id, xmas22.Id}},
+		{ref: `title:/^\w+ 2022$/`, want: []string{xmas22.Id, summer1.Id, summer2.Id}},
+		{ref: "title:/^Xmas/", want: []string{xmas21.Id, xmas22.Id}},
+		{ref: "title:xmas 2022", wantErr: "no Google Photos album matches title:xmas 2022"},
+		{ref: "title:Easter*", wantErr: "no Google Photos album matches title:Easter*"},
+		{ref: "shared-title:Xmas 2021", wantErr: "no Google Photos album matches"},
+		{
+			ref: "title:Summer 2022",
+			wantErr: fmt.Sprintf("title:Summer 2022 is ambiguous, 2 albums have that title (IDs %s, %s)",
+				summer1.Id, summer2.Id),
+		},
+	}
+	for _, tt := range tests {
+		reg := prometheus.NewRegistry()
+		c, err := cache.New(reg, filepath.Join(t.TempDir(), "cache.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := googlephotos.NewClient("id", "secret", context.Background(), gp.Token(), c, reg,
+			googlephotos.Options{BaseURL: gp.URL, TokenURL: gp.TokenURL()})
+		ref, err := googlephotos.ParseAlbumRef(tt.ref)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.ref, err)
+		}
+		got, err := client.ResolveAlbumRef(context.Background(), ref, false)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v (%v), want %q", tt.ref, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.ref, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestParseAlbumRefRejects(t *testing.T) {
+	for ref, wantErr := range map[string]string{
+		"":              "empty Google Photos album",
+		"title:":        "no title",
+		"title:/(/":     "bad regexp",
+		"title:Xmas[20": "bad glob",
+	} {
+		if _, err := googlephotos.ParseAlbumRef(ref); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%q: got %v, want %q", ref, err, wantErr)
+		}
+	}
+}
+
+func TestResolveAlbumRefCaches(t *testing.T) {
+	gp := googlephotostest.NewServer()
+	defer gp.Close()
+	xmas22 := gp.AddAlbum("Xmas 2022", false)
+
+	reg := prometheus.NewRegistry()
+	c, err := cache.New(reg, filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := googlephotos.NewClient("id", "secret", context.Background(), gp.Token(), c, reg,
+		googlephotos.Options{BaseURL: gp.URL, TokenURL: gp.TokenURL()})
+	ref, err := googlephotos.ParseAlbumRef("title:Xmas*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listed float64
+	resolve := func(step string, refresh bool, wantIds []string, wantListed bool) {
+		t.Helper()
+		got, err := client.ResolveAlbumRef(context.Background(), ref, refresh)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(wantIds) {
+			t.Errorf("%s: got %v, want %v", step, got, wantIds)
+		}
+		now := counter(t, reg, "googlephotos_list_albums_success")
+		if gotListed := now > listed; gotListed != wantListed {
+			t.Errorf("%s: listed albums %t, want %t", step, gotListed, wantListed)
+		}
+		listed = now
+	}
+
+	resolve("first", false, []string{xmas22.Id}, true)
+	resolve("again", false, []string{xmas22.Id}, false)
+
+	// A new album isn't seen until the cached answer is refreshed...
+	xmas23 := gp.AddAlbum("Xmas 2023", false)
+	resolve("after adding", false, []string{xmas22.Id}, false)
+	resolve("refresh", true, []string{xmas22.Id, xmas23.Id}, true)
+	resolve("after refresh", false, []string{xmas22.Id, xmas23.Id}, false)
+
+	// ... or it's too old.
+	gp.RemoveAlbum(xmas22.Id)
+	err = c.UpsertAlbumRef(&cache.AlbumRefData{
+		Ref:         ref.String(),
+		AlbumIds:    []string{xmas22.Id, xmas23.Id},
+		LastUpdated: time.Now().Add(-googlephotos.AlbumRefMaxAge - time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve("too old", false, []string{xmas23.Id}, true)
+	resolve("after too old", false, []string{xmas23.Id}, false)
+}
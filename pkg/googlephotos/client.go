@@ -3,8 +3,12 @@ package googlephotos
 import (
 	"context"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/andrewjjenkins/picsync/pkg/blob"
 	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/oauth2"
 )
@@ -12,14 +16,68 @@ import (
 type Client interface {
 	ListAlbums() ([]*Album, error)
 	ListSharedAlbums() ([]*Album, error)
+	IterateAlbums(ctx context.Context, opts ListAlbumsOptions) AlbumIterator
+	IterateSharedAlbums(ctx context.Context, opts ListAlbumsOptions) AlbumIterator
+	ResolveAlbumRef(ctx context.Context, ref AlbumRef, refresh bool) ([]string, error)
 	ListMediaItemsForAlbumId(albumId string, nextPageToken string) (*SearchMediaItemsResponse, error)
-	UpdateCacheForAlbumId(albumId string, nextPageToken string, cb UpdateCacheCallback) (*UpdateCacheResult, error)
+	SearchMediaItems(ctx context.Context, req *SearchMediaItemsRequest) (*SearchMediaItemsResponse, error)
+	UpdateCacheForAlbumId(albumId string, nextPageToken string, videos VideoPolicy, cb UpdateCacheCallback) (*UpdateCacheResult, error)
+	UpdateCacheForSearch(ctx context.Context, req *SearchMediaItemsRequest, videos VideoPolicy, cb UpdateCacheCallback) (*UpdateCacheResult, error)
+	UpdateCache(page *SearchMediaItemsResponse, videos VideoPolicy, cb UpdateCacheCallback) (*UpdateCacheResult, error)
+	Download(item *MediaItem) (*http.Response, error)
+	DownloadPoster(item *MediaItem) (*http.Response, error)
+	Open(item *MediaItem, sha256 string, poster bool) (*blob.Blob, error)
+}
+
+// Options are optional settings for a Client.  The zero value is the
+// default for each.
+type Options struct {
+	// MaxConcurrentDownloads bounds how many media items are downloaded at
+	// once, for hashing or uploading (default 1).
+	MaxConcurrentDownloads int
+
+	// BaseURL is where the Photos Library API is (default DefaultBaseURL),
+	// and TokenURL is where OAuth2 tokens are refreshed (default Google's).
+	// These are for testing against a fake, like googlephotostest.
+	BaseURL  string
+	TokenURL string
+
+	// Blobs, if set, keeps what is downloaded, so that Open doesn't have to
+	// download it again.
+	Blobs blob.Store
+}
+
+// DefaultBaseURL is the real Photos Library API.
+const DefaultBaseURL = "https://photoslibrary.googleapis.com"
+
+// readPolicy is for calls that only read, even if they are POSTs.
+var readPolicy = util.RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    60 * time.Second,
+	Idempotent:  true,
+}
+
+// retryEndpoints sets how each Google Photos call is retried.  All of these
+// only read, so they are all safe to repeat.
+var retryEndpoints = []util.RetryEndpoint{
+	{Name: "albums", Method: "GET", PathPrefix: "/v1/albums", Policy: readPolicy},
+	{Name: "shared_albums", Method: "GET", PathPrefix: "/v1/sharedAlbums", Policy: readPolicy},
+	{Name: "mediaitems_search", Method: "POST", PathPrefix: "/v1/mediaItems:search", Policy: readPolicy},
+	{Name: "token", Method: "POST", PathPrefix: "/token", Policy: readPolicy},
+	{Name: "download", Method: "GET", PathPrefix: "/", Policy: readPolicy},
 }
 
 type clientImpl struct {
-	httpClient  *http.Client
-	tokenSource oauth2.TokenSource
-	cache       cache.Cache
+	httpClient     *http.Client
+	downloadClient *http.Client
+	tokenSource    oauth2.TokenSource
+	cache          cache.Cache
+	baseURL        string
+	blobs          blob.Store
+
+	downloads    *util.Limiter
+	maxDownloads int
 
 	prom promImpl
 }
@@ -31,18 +89,39 @@ func NewClient(
 	t *oauth2.Token,
 	c cache.Cache,
 	reg prometheus.Registerer,
+	opts Options,
 ) Client {
+	// Everything (including refreshing the token) goes through transport,
+	// which retries calls that fail.
+	transport := util.NewRetryTransport(nil, retryEndpoints, nil, nil)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+
 	config := newOauth2Config(consumerKey, consumerSecret, "")
+	if opts.TokenURL != "" {
+		config.Endpoint.TokenURL = opts.TokenURL
+	}
+	baseURL := strings.TrimSuffix(opts.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
 	tokenSource := config.TokenSource(ctx, t)
 	httpClient := oauth2.NewClient(ctx, tokenSource)
 
 	gpClient := clientImpl{
-		httpClient:  httpClient,
-		tokenSource: tokenSource,
-		cache:       c,
+		httpClient:     httpClient,
+		downloadClient: &http.Client{Transport: transport},
+		tokenSource:    tokenSource,
+		cache:          c,
+		baseURL:        baseURL,
+		blobs:          opts.Blobs,
+		maxDownloads:   opts.MaxConcurrentDownloads,
 	}
 
 	gpClient.promRegister(reg)
+	transport.Retries = gpClient.prom.httpRetries
+	transport.Failures = gpClient.prom.httpFailures
+	gpClient.downloads = util.NewLimiter(
+		opts.MaxConcurrentDownloads, gpClient.prom.mediaItemsDownloadsInFlight)
 
 	return &gpClient
 }
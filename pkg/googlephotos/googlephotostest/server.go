@@ -0,0 +1,453 @@
+// Package googlephotostest is a fake Google Photos Library API for testing,
+// built on net/http/httptest.  Point a googlephotos.Client at it with
+// Options.BaseURL and Options.TokenURL (see TokenURL), and use Token as the
+// client's token.
+//
+// It implements listing albums and shared albums, mediaItems:search (by
+// album, or the whole library with date, content category, media type and
+// favorites filters) and downloading media items (and still frames of
+// videos).  It keeps everything in memory.
+//
+// Unlike Nixplay, the Library API is documented, in its REST reference
+// (https://developers.google.com/photos/library/reference/rest) and its
+// guides; each handler says which part it follows.
+package googlephotostest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"golang.org/x/oauth2"
+)
+
+// AccessToken is the only access token the fake accepts.
+const AccessToken = "googlephotostest-access-token"
+
+// Server is a fake Google Photos.  URL (from the embedded httptest.Server)
+// is the base URL to give the client.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	nextID       int
+	albums       []*googlephotos.Album
+	sharedAlbums []*googlephotos.Album
+	albumItems   map[string][]*googlephotos.MediaItem
+	library      []*googlephotos.MediaItem
+	favorites    map[string]bool
+	categories   map[string][]string
+	content      map[string][]byte
+	downloads    int
+}
+
+// NewServer starts a fake Google Photos with no albums.  Call Close when
+// done.
+func NewServer() *Server {
+	s := &Server{
+		albumItems: make(map[string][]*googlephotos.MediaItem),
+		favorites:  make(map[string]bool),
+		categories: make(map[string][]string),
+		content:    make(map[string][]byte),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/v1/albums", s.authed(s.handleAlbums))
+	mux.HandleFunc("/v1/sharedAlbums", s.authed(s.handleSharedAlbums))
+	mux.HandleFunc("/v1/mediaItems:search", s.authed(s.handleSearch))
+	mux.HandleFunc("/media/", s.handleMedia)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// TokenURL is where the fake refreshes tokens.
+func (s *Server) TokenURL() string {
+	return s.URL + "/token"
+}
+
+// Token returns a token the fake accepts.
+func (s *Server) Token() *oauth2.Token {
+	return &oauth2.Token{
+		TokenType:    "Bearer",
+		AccessToken:  AccessToken,
+		RefreshToken: "googlephotostest-refresh-token",
+		Expiry:       time.Now().Add(time.Hour),
+	}
+}
+
+// AddAlbum adds an empty album (a shared album if shared is true).
+func (s *Server) AddAlbum(title string, shared bool) *googlephotos.Album {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	album := &googlephotos.Album{
+		Id:    fmt.Sprintf("album-%d", s.nextID),
+		Title: title,
+	}
+	if shared {
+		s.sharedAlbums = append(s.sharedAlbums, album)
+	} else {
+		s.albums = append(s.albums, album)
+	}
+	return album
+}
+
+// AddMediaItem adds an item with content to the library and to an album
+// (unless albumId is "").  item's Id and BaseUrl are filled in.
+func (s *Server) AddMediaItem(albumId string, item googlephotos.MediaItem, content []byte) *googlephotos.MediaItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	item.Id = fmt.Sprintf("item-%d", s.nextID)
+	item.BaseUrl = fmt.Sprintf("%s/media/%s", s.URL, item.Id)
+	if item.MimeType == "" {
+		item.MimeType = "image/jpeg"
+	}
+	if albumId != "" {
+		s.albumItems[albumId] = append(s.albumItems[albumId], &item)
+	}
+	s.library = append(s.library, &item)
+	s.content[item.Id] = content
+	return &item
+}
+
+// RemoveAlbum removes an album (but not its items, which are still in the
+// library).
+func (s *Server) RemoveAlbum(albumId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	remove := func(albums []*googlephotos.Album) []*googlephotos.Album {
+		var kept []*googlephotos.Album
+		for _, a := range albums {
+			if a.Id != albumId {
+				kept = append(kept, a)
+			}
+		}
+		return kept
+	}
+	s.albums = remove(s.albums)
+	s.sharedAlbums = remove(s.sharedAlbums)
+	delete(s.albumItems, albumId)
+}
+
+// SetFavorite marks an item as a favorite (or not).
+func (s *Server) SetFavorite(itemId string, favorite bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.favorites[itemId] = favorite
+}
+
+// SetCategories sets the content categories (like "PETS") an item is in.
+func (s *Server) SetCategories(itemId string, categories ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.categories[itemId] = categories
+}
+
+// RemoveMediaItem removes an item from an album.
+func (s *Server) RemoveMediaItem(albumId string, itemId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.albumItems[albumId]
+	for i, item := range items {
+		if item.Id == itemId {
+			s.albumItems[albumId] = append(items[:i], items[i+1:]...)
+			return
+		}
+	}
+}
+
+// Downloads returns how many media items have been downloaded.
+func (s *Server) Downloads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloads
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleToken is an OAuth2 token endpoint (RFC 6749 section 5.1), which
+// refreshes to the only token the fake accepts.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token": AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+// authed wraps h to require the access token as a bearer token, as every
+// Library API method does.
+func (s *Server) authed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+AccessToken {
+			http.Error(w, "bad access token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// page returns the part of n things that pageToken and pageSize select, and
+// the token for the next page.  Page tokens are just offsets; the real ones
+// are opaque, and there's no nextPageToken on the last page.
+func page(n int, pageToken string, pageSize int, defaultSize int) (int, int, string) {
+	start, _ := strconv.Atoi(pageToken)
+	if start < 0 || start > n {
+		start = n
+	}
+	if pageSize < 1 {
+		pageSize = defaultSize
+	}
+	end := start + pageSize
+	if end >= n {
+		return start, n, ""
+	}
+	return start, end, strconv.Itoa(end)
+}
+
+func (s *Server) listAlbums(w http.ResponseWriter, r *http.Request, albums []*googlephotos.Album, key string) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	start, end, next := page(len(albums), r.URL.Query().Get("pageToken"), pageSize, 20)
+	resp := map[string]interface{}{}
+	var toRet []*googlephotos.Album
+	for _, a := range albums[start:end] {
+		album := *a
+		album.MediaItemsCount = googlephotos.MaybeQuotedInt64(len(s.albumItems[a.Id]))
+		toRet = append(toRet, &album)
+	}
+	if len(toRet) > 0 {
+		resp[key] = toRet
+	}
+	if next != "" {
+		resp["nextPageToken"] = next
+	}
+	writeJSON(w, resp)
+}
+
+// handleAlbums is albums.list: pages of 20 albums unless pageSize says
+// otherwise, with mediaItemsCount as a string.
+func (s *Server) handleAlbums(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listAlbums(w, r, s.albums, "albums")
+}
+
+// handleSharedAlbums is sharedAlbums.list, which pages like albums.list.
+func (s *Server) handleSharedAlbums(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listAlbums(w, r, s.sharedAlbums, "sharedAlbums")
+}
+
+// handleSearch is mediaItems.search: an album's items in album order, or
+// the library's items that match every filter, in pages of 25 unless
+// pageSize says otherwise.  As documented, albumId and filters can't be used
+// together.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	var req googlephotos.SearchMediaItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.AlbumId != "" && req.Filters != nil {
+		http.Error(w, "albumId and filters can't both be set", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []*googlephotos.MediaItem
+	if req.AlbumId != "" {
+		if !s.hasAlbum(req.AlbumId) {
+			// The reference doesn't say what an unknown album gives, only
+			// that it's an error.
+			http.Error(w, "album not found", http.StatusNotFound)
+			return
+		}
+		items = s.albumItems[req.AlbumId]
+	} else {
+		for _, item := range s.library {
+			ok, err := s.matches(item, req.Filters)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if ok {
+				items = append(items, item)
+			}
+		}
+	}
+	start, end, next := page(len(items), req.PageToken, req.PageSize, 25)
+	resp := map[string]interface{}{}
+	if end > start {
+		resp["mediaItems"] = items[start:end]
+	}
+	if next != "" {
+		resp["nextPageToken"] = next
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) hasAlbum(albumId string) bool {
+	for _, albums := range [][]*googlephotos.Album{s.albums, s.sharedAlbums} {
+		for _, a := range albums {
+			if a.Id == albumId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches is whether item passes the search filters (the Filters type in the
+// REST reference): a date filter matches any of its dates or ranges, a
+// content filter needs one included category (if any) and no excluded one,
+// and only one media type may be given.  Dates are compared in UTC; Google
+// doesn't document what time zone it uses.
+func (s *Server) matches(item *googlephotos.MediaItem, f *googlephotos.Filters) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	if f.DateFilter != nil {
+		created, err := time.Parse(time.RFC3339, item.MediaMetadata.CreationTime)
+		if err != nil {
+			return false, nil
+		}
+		if !matchesDate(googlephotos.DateOf(created), f.DateFilter) {
+			return false, nil
+		}
+	}
+	if f.ContentFilter != nil {
+		in := func(cats []string) bool {
+			for _, c := range cats {
+				for _, itemCat := range s.categories[item.Id] {
+					if c == itemCat {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		if len(f.ContentFilter.IncludedContentCategories) > 0 && !in(f.ContentFilter.IncludedContentCategories) {
+			return false, nil
+		}
+		if in(f.ContentFilter.ExcludedContentCategories) {
+			return false, nil
+		}
+	}
+	if f.MediaTypeFilter != nil {
+		if len(f.MediaTypeFilter.MediaTypes) != 1 {
+			return false, fmt.Errorf("exactly one media type is allowed")
+		}
+		isVideo := strings.HasPrefix(item.MimeType, "video/")
+		switch f.MediaTypeFilter.MediaTypes[0] {
+		case "ALL_MEDIA":
+		case "PHOTO":
+			if isVideo {
+				return false, nil
+			}
+		case "VIDEO":
+			if !isVideo {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("bad media type %s", f.MediaTypeFilter.MediaTypes[0])
+		}
+	}
+	if f.FeatureFilter != nil {
+		for _, feature := range f.FeatureFilter.IncludedFeatures {
+			if feature == "FAVORITES" && !s.favorites[item.Id] {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// matchesDate is the Date and DateRange types in the REST reference: a zero
+// year, month or day matches any, and ranges include both ends.
+func matchesDate(d googlephotos.Date, f *googlephotos.DateFilter) bool {
+	for _, want := range f.Dates {
+		if (want.Year == 0 || want.Year == d.Year) &&
+			(want.Month == 0 || want.Month == d.Month) &&
+			(want.Day == 0 || want.Day == d.Day) {
+			return true
+		}
+	}
+	for _, r := range f.Ranges {
+		if d.String() >= r.StartDate.String() && d.String() <= r.EndDate.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// handleMedia serves /media/<id>=d (or =dv for videos), and a still frame
+// for =w<width>-h<height>, the base URL parameters in the "Access media
+// items" guide.  This doesn't need the access token; the original picsync
+// client downloaded base URLs with a plain GET.
+func (s *Server) handleMedia(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/media/")
+	parts := strings.SplitN(name, "=", 2)
+	id := parts[0]
+	s.mu.Lock()
+	content, ok := s.content[id]
+	var mimeType string
+	for _, item := range s.library {
+		if item.Id == id {
+			mimeType = item.MimeType
+		}
+	}
+	if ok {
+		s.downloads++
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 2 && strings.HasPrefix(parts[1], "w") {
+		content = poster(id)
+		mimeType = "image/jpeg"
+	}
+	w.Header().Set("content-type", mimeType)
+	w.Header().Set("content-length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
+
+// poster returns a small JPEG that is always the same for the same id.
+func poster(id string) []byte {
+	sum := sha256.Sum256([]byte(id))
+	img := image.NewRGBA(image.Rect(0, 0, 16, 9))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{sum[0], sum[1], sum[2], 255}}, image.Point{}, draw.Src)
+	var out bytes.Buffer
+	jpeg.Encode(&out, img, nil)
+	return out.Bytes()
+}
@@ -1,6 +1,7 @@
 package googlephotos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -10,7 +11,12 @@ import (
 
 // GetUnmarshalJSON gets a JSON response from url and unmarshals into target
 func GetUnmarshalJSON(c *http.Client, url string, target interface{}) error {
-	req, err := http.NewRequest("GET", url, nil)
+	return GetUnmarshalJSONContext(context.Background(), c, url, target)
+}
+
+// GetUnmarshalJSONContext is GetUnmarshalJSON, giving up if ctx is done.
+func GetUnmarshalJSONContext(ctx context.Context, c *http.Client, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
@@ -36,7 +42,12 @@ func GetUnmarshalJSON(c *http.Client, url string, target interface{}) error {
 }
 
 func PostUnmarshalJSON(c *http.Client, url string, reqBody string, target interface{}) error {
-	req, err := http.NewRequest("POST", url, strings.NewReader(reqBody))
+	return PostUnmarshalJSONContext(context.Background(), c, url, reqBody, target)
+}
+
+// PostUnmarshalJSONContext is PostUnmarshalJSON, giving up if ctx is done.
+func PostUnmarshalJSONContext(ctx context.Context, c *http.Client, url string, reqBody string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(reqBody))
 	if err != nil {
 		return err
 	}
@@ -47,14 +58,15 @@ func PostUnmarshalJSON(c *http.Client, url string, reqBody string, target interf
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("server returned %s", resp.Status)
-	}
-
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
+	if resp.StatusCode != 200 {
+		// Google explains bad requests (like bad search filters) in the body.
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
 	err = json.Unmarshal(body, &target)
 	if err != nil {
 		return err
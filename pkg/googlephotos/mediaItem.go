@@ -1,15 +1,21 @@
 package googlephotos
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/andrewjjenkins/picsync/pkg/blob"
 	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type MediaItem struct {
@@ -57,6 +63,9 @@ type CachedMediaItem struct {
 	LastUpdated time.Time
 	LastUsed    time.Time
 	MediaItem   *MediaItem
+
+	// Video is set for videos (see IsVideo).
+	Video *CachedVideo
 }
 
 type UpdateCacheResult struct {
@@ -64,16 +73,206 @@ type UpdateCacheResult struct {
 	NextPageToken    string
 }
 
+// UpdateCacheCallback is called with each item of a page as soon as it is
+// known to be cached, one at a time but not in the page's order.
 type UpdateCacheCallback func(*CachedMediaItem)
 
-func (c *clientImpl) UpdateCacheForAlbumId(albumId string, nextPageToken string, cb UpdateCacheCallback) (*UpdateCacheResult, error) {
+// Download starts downloading the full-resolution content of item (the
+// video itself, for videos).  At most MaxConcurrentDownloads run at once;
+// the caller must close the body to let the next one start.
+func (c *clientImpl) Download(item *MediaItem) (*http.Response, error) {
+	if IsVideo(item) {
+		return c.download(item.BaseUrl+"=dv", c.prom.videosDownloadedFailure)
+	}
+	return c.download(item.BaseUrl+"=d", c.prom.mediaItemsDownloadedFailure)
+}
+
+func (c *clientImpl) download(url string, failures prometheus.Counter) (*http.Response, error) {
+	c.downloads.Acquire()
+	resp, err := c.downloadClient.Get(url)
+	if err != nil {
+		c.downloads.Release()
+		failures.Inc()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		c.downloads.Release()
+		failures.Inc()
+		return nil, fmt.Errorf("received HTTP %d", resp.StatusCode)
+	}
+	resp.Body = c.downloads.ReleaseOnClose(resp.Body)
+	return resp, nil
+}
+
+// Open starts reading the item with SHA256 sha256 (its poster, if poster)
+// from the blob store, or if it isn't there, downloads it and stores it.
+func (c *clientImpl) Open(item *MediaItem, sha256 string, poster bool) (*blob.Blob, error) {
+	if c.blobs != nil {
+		b, err := c.blobs.Open(sha256)
+		if err != nil {
+			fmt.Printf("Warning: can't read blob store: %v\n", err)
+		} else if b != nil {
+			return b, nil
+		}
+	}
+
+	download := c.Download
+	if poster {
+		download = c.DownloadPoster
+	}
+	resp, err := download(item)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(resp.Header.Get("content-length"), 10, 64)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	c.store(resp, sha256)
+	return &blob.Blob{
+		ReadCloser:  resp.Body,
+		Sha256:      sha256,
+		ContentType: resp.Header.Get("content-type"),
+		Size:        size,
+	}, nil
+}
+
+// store keeps resp's body in the blob store (if there is one) as it's read.
+// sha256 is what it should be, if known.
+func (c *clientImpl) store(resp *http.Response, sha256 string) {
+	if c.blobs != nil {
+		resp.Body = c.blobs.Tee(resp.Body, resp.ContentLength, resp.Header.Get("content-type"), sha256)
+	}
+}
+
+// hashMediaItem downloads item and returns a new (not yet stored) cache entry
+// with its hashes.
+func (c *clientImpl) hashMediaItem(item *MediaItem) (*cache.GooglephotoData, error) {
+	resp, err := c.Download(item)
+	if err != nil {
+		// FIXME: Maybe we want to skip updating cache for this item if we
+		// just have a download error rather than failing the entire call?
+		return nil, err
+	}
+	c.store(resp, "")
+	defer resp.Body.Close()
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	allHashes := io.MultiWriter(sha256Hash, md5Hash)
+	if _, err := io.Copy(allHashes, resp.Body); err != nil {
+		c.prom.mediaItemsDownloadedFailure.Inc()
+		return nil, err
+	}
+	c.prom.mediaItemsDownloadedSuccess.Inc()
+
+	// FIXME: resp.ContentLength may in theory be unknown, but is known
+	// for google photos.  A safer approach would be to make another
+	// member of the io.Multiwriter() that just counted bytes and threw them
+	// on the ground, and then ask it how many bytes we saw.
+	if resp.ContentLength > 0 {
+		c.prom.mediaItemsDownloadedBytes.Add(float64(resp.ContentLength))
+	}
+
+	return &cache.GooglephotoData{
+		BaseUrl:        item.BaseUrl,
+		GooglephotosId: item.Id,
+		Sha256:         hex.EncodeToString(sha256Hash.Sum(nil)),
+		Md5:            hex.EncodeToString(md5Hash.Sum(nil)),
+		Width:          int64(item.MediaMetadata.Width),
+		Height:         int64(item.MediaMetadata.Height),
+	}, nil
+}
+
+// UpdateCacheForAlbumId hashes the items in a page of an album that aren't
+// cached yet, and returns them all.  Videos are only downloaded as much as
+// the videos policy needs, and those it skips aren't returned.
+func (c *clientImpl) UpdateCacheForAlbumId(albumId string, nextPageToken string, videos VideoPolicy, cb UpdateCacheCallback) (*UpdateCacheResult, error) {
 	res, err := c.ListMediaItemsForAlbumId(albumId, nextPageToken)
-	toRet := &UpdateCacheResult{}
 	if err != nil {
 		return nil, err
 	}
+	return c.updateCache(res, videos, cb)
+}
+
+// UpdateCacheForSearch is UpdateCacheForAlbumId for a page of a search.
+func (c *clientImpl) UpdateCacheForSearch(ctx context.Context, req *SearchMediaItemsRequest, videos VideoPolicy, cb UpdateCacheCallback) (*UpdateCacheResult, error) {
+	res, err := c.SearchMediaItems(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return c.updateCache(res, videos, cb)
+}
+
+// UpdateCache is UpdateCacheForAlbumId for a page that was already listed
+// (or searched).  Items can be removed from it first, so that they aren't
+// downloaded.
+func (c *clientImpl) UpdateCache(page *SearchMediaItemsResponse, videos VideoPolicy, cb UpdateCacheCallback) (*UpdateCacheResult, error) {
+	return c.updateCache(page, videos, cb)
+}
+
+// updateCache makes sure every item in a page of results is in the cache.
+// For videos, that's only what the videos policy needs.
+func (c *clientImpl) updateCache(res *SearchMediaItemsResponse, videoPolicy VideoPolicy, cb UpdateCacheCallback) (*UpdateCacheResult, error) {
+	toRet := &UpdateCacheResult{}
 	toRet.NextPageToken = res.NextPageToken
-	for _, item := range res.MediaItems {
+
+	entries := make([]*cache.GooglephotoData, len(res.MediaItems))
+	videos := make([]*cache.VideoData, len(res.MediaItems))
+	errs := make([]error, len(res.MediaItems))
+	cached := make([]*CachedMediaItem, len(res.MediaItems))
+	// stale is set for photo entries of videos that have to be forgotten.
+	stale := make([]bool, len(res.MediaItems))
+
+	// report makes the result for item i, once it is known, and calls cb
+	// with it.
+	report := func(i int) {
+		cached[i] = c.cachedMediaItem(res.MediaItems[i], entries[i], videos[i], videoPolicy)
+		if cached[i] != nil {
+			cb(cached[i])
+		}
+	}
+
+	var toHash []int
+	for i, item := range res.MediaItems {
+		if IsVideo(item) {
+			if !videoReady(item) {
+				// Google is still processing it (or failed to); try again
+				// next time.
+				fmt.Printf("Skipping video %s: status is %s, not %s\n",
+					item.Filename, item.MediaMetadata.Video.Status, VideoReady)
+				c.prom.videosSkipped.WithLabelValues("not_ready").Inc()
+				continue
+			}
+			if videoPolicy.Policy == VideoSkip {
+				// Nothing about it is needed.
+				c.countVideo(VideoSkip, "policy")
+				continue
+			}
+			currentEntry, err := c.cache.GetGooglephoto(item.Id)
+			if err != nil {
+				return nil, err
+			}
+			currentVideo, err := c.cache.GetVideo(item.Id)
+			if err != nil {
+				return nil, err
+			}
+			if currentEntry != nil && currentVideo == nil {
+				// Videos cached before there was a videos table were
+				// hashed as photos, so those hashes aren't the video's.
+				currentEntry = nil
+				stale[i] = true
+			}
+			entries[i], videos[i] = currentEntry, currentVideo
+			if videoPolicy.needs(currentVideo, currentEntry != nil) {
+				toHash = append(toHash, i)
+			} else {
+				report(i)
+			}
+			continue
+		}
+
 		// First, see if it is already in the cache.  Google never changes
 		// the contents of a Google Photos ID, so if it is already present we don't
 		// need to download it again.
@@ -82,82 +281,117 @@ func (c *clientImpl) UpdateCacheForAlbumId(albumId string, nextPageToken string,
 			return nil, err
 		}
 		if currentEntry != nil {
-			// Update the timestamps and set back to the cache.
-			currentEntry.LastUpdated = time.Now()
-			currentEntry.LastUsed = currentEntry.LastUpdated
-			err = c.cache.UpsertGooglephoto(currentEntry)
-			if err != nil {
-				return nil, err
-			}
-			cached := CachedMediaItem{
-				CacheId:     currentEntry.Id,
-				Sha256:      currentEntry.Sha256,
-				Md5:         currentEntry.Md5,
-				LastUpdated: currentEntry.LastUpdated,
-				LastUsed:    currentEntry.LastUsed,
-				MediaItem:   item,
-			}
-			toRet.CachedMediaItems = append(toRet.CachedMediaItems, &cached)
-			cb(&cached)
+			entries[i] = currentEntry
+			report(i)
 			continue
 		}
 
 		// Item not in the cache.  We must download it and calculate hashes.
-		fullResUrl := item.BaseUrl + "=d"
-		resp, err := http.Get(fullResUrl)
+		toHash = append(toHash, i)
+	}
+
+	// Downloads run concurrently, on MaxConcurrentDownloads workers.  Each
+	// item is stored to the cache and reported as soon as it is hashed, one
+	// at a time (sqlite doesn't like concurrent writers).
+	var mu sync.Mutex
+	util.RunWorkers(c.maxDownloads, toHash, func(i int) {
+		item := res.MediaItems[i]
+		var entry *cache.GooglephotoData
+		video := videos[i]
+		var err error
+		if !IsVideo(item) {
+			entry, err = c.hashMediaItem(item)
+		} else {
+			entry, video, err = c.hashVideo(item, videos[i], entries[i] != nil, videoPolicy)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			err = c.storeHashed(item, entry, video, stale[i])
+		}
 		if err != nil {
-			// FIXME: Maybe we want to skip updating cache for this item if we
-			// just have a download error rather than failing the entire call?
-			c.prom.mediaItemsDownloadedFailure.Inc()
-			return nil, err
+			// FIXME: Again, maybe just skip individual errors?
+			errs[i] = err
+			return
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			c.prom.mediaItemsDownloadedFailure.Inc()
-			return nil, fmt.Errorf("received HTTP %d", resp.StatusCode)
+		if entry != nil {
+			entries[i] = entry
 		}
-		sha256Hash := sha256.New()
-		md5Hash := md5.New()
-		allHashes := io.MultiWriter(sha256Hash, md5Hash)
-		if _, err := io.Copy(allHashes, resp.Body); err != nil {
-			c.prom.mediaItemsDownloadedFailure.Inc()
-			return nil, err
+		videos[i] = video
+		report(i)
+	})
+	for _, i := range toHash {
+		if errs[i] != nil {
+			return nil, errs[i]
 		}
-		c.prom.mediaItemsDownloadedSuccess.Inc()
+	}
 
-		// FIXME: resp.ContentLength may in theory be unknown, but is known
-		// for google photos.  A safer approach would be to make another
-		// member of the io.Multiwriter() that just counted bytes and threw them
-		// on the ground, and then ask it how many bytes we saw.
-		if resp.ContentLength > 0 {
-			c.prom.mediaItemsDownloadedBytes.Add(float64(resp.ContentLength))
+	// Return in the original order.
+	for _, item := range cached {
+		if item != nil {
+			toRet.CachedMediaItems = append(toRet.CachedMediaItems, item)
 		}
+	}
+	return toRet, nil
+}
 
-		entry := cache.GooglephotoData{
-			BaseUrl:        item.BaseUrl,
-			GooglephotosId: item.Id,
-			Sha256:         hex.EncodeToString(sha256Hash.Sum(nil)),
-			Md5:            hex.EncodeToString(md5Hash.Sum(nil)),
-			Width:          int64(item.MediaMetadata.Width),
-			Height:         int64(item.MediaMetadata.Height),
-			LastUpdated:    time.Now(),
-			LastUsed:       time.Now(),
+// storeHashed stores what was found out about an item to the cache.  entry
+// is nil if the item (a video) wasn't hashed as a photo; then if stale, its
+// photo entry is forgotten.  Entries that were already cached were marked
+// used when they were got.
+func (c *clientImpl) storeHashed(item *MediaItem, entry *cache.GooglephotoData, video *cache.VideoData, stale bool) error {
+	if entry != nil {
+		entry.LastUpdated = time.Now()
+		if err := c.cache.UpsertGooglephoto(entry); err != nil {
+			return err
 		}
-		err = c.cache.UpsertGooglephoto(&entry)
-		if err != nil {
-			// FIXME: Again, maybe just skip individual errors?
-			return nil, err
+	} else if stale {
+		if err := c.cache.DeleteGooglephoto(item.Id); err != nil {
+			return err
 		}
-		cached := CachedMediaItem{
-			CacheId:     entry.Id,
-			Sha256:      entry.Sha256,
-			Md5:         entry.Md5,
-			LastUpdated: entry.LastUpdated,
-			LastUsed:    entry.LastUsed,
-			MediaItem:   item,
+	}
+	if video != nil {
+		video.LastUpdated = time.Now()
+		if err := c.cache.UpsertVideo(video); err != nil {
+			return err
 		}
-		toRet.CachedMediaItems = append(toRet.CachedMediaItems, &cached)
-		cb(&cached)
 	}
-	return toRet, nil
+	return nil
+}
+
+// cachedMediaItem is what is known about an item, or nil if it is skipped.
+func (c *clientImpl) cachedMediaItem(item *MediaItem, entry *cache.GooglephotoData, video *cache.VideoData, videoPolicy VideoPolicy) *CachedMediaItem {
+	if entry == nil && video == nil {
+		// Skipped
+		return nil
+	}
+	cached := CachedMediaItem{MediaItem: item}
+	if entry != nil {
+		cached.CacheId = entry.Id
+		cached.Sha256 = entry.Sha256
+		cached.Md5 = entry.Md5
+		cached.LastUpdated = entry.LastUpdated
+		cached.LastUsed = entry.LastUsed
+	}
+	if video != nil {
+		choice, reason, label, _ := videoPolicy.choose(video)
+		c.countVideo(choice, label)
+		if choice == VideoSkip {
+			fmt.Printf("Skipping video %s: %s\n", item.Filename, reason)
+			return nil
+		}
+		if entry == nil {
+			cached.LastUpdated = video.LastUpdated
+			cached.LastUsed = video.LastUsed
+		}
+		cached.Video = &CachedVideo{
+			Size:         video.Size,
+			Duration:     video.Duration,
+			PosterSha256: video.PosterSha256,
+			PosterMd5:    video.PosterMd5,
+			Poster:       choice == VideoPoster,
+		}
+	}
+	return &cached
 }
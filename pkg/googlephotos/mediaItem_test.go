@@ -0,0 +1,70 @@
+package googlephotos_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos/googlephotostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Each item is reported as soon as it is cached, not once the whole page is.
+func TestUpdateCacheReportsEachItem(t *testing.T) {
+	gp := googlephotostest.NewServer()
+	defer gp.Close()
+	reg := prometheus.NewRegistry()
+	c, err := cache.New(reg, filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := googlephotos.NewClient("id", "secret", context.Background(), gp.Token(), c, reg,
+		googlephotos.Options{BaseURL: gp.URL, TokenURL: gp.TokenURL(), MaxConcurrentDownloads: 1})
+	album := gp.AddAlbum("Family", false)
+	update := func(cb googlephotos.UpdateCacheCallback) []string {
+		t.Helper()
+		res, err := client.UpdateCacheForAlbumId(album.Id, "", googlephotos.VideoPolicy{}, cb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, cached := range res.CachedMediaItems {
+			names = append(names, cached.MediaItem.Filename)
+		}
+		return names
+	}
+
+	gp.AddMediaItem(album.Id, googlephotos.MediaItem{Filename: "a.jpg"}, []byte("photo a"))
+	update(func(*googlephotos.CachedMediaItem) {})
+	gp.AddMediaItem(album.Id, googlephotos.MediaItem{Filename: "b.jpg"}, []byte("photo b"))
+	gp.AddMediaItem(album.Id, googlephotos.MediaItem{Filename: "c.jpg"}, []byte("photo c"))
+
+	// a is already cached, so it's reported before anything is downloaded.
+	// b and c are reported as each is downloaded, after it is stored.
+	downloads := gp.Downloads()
+	var reported []string
+	got := update(func(cached *googlephotos.CachedMediaItem) {
+		stored, err := c.GetGooglephoto(cached.MediaItem.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reported = append(reported, fmt.Sprintf("%s after %d downloads, stored %t",
+			cached.MediaItem.Filename, gp.Downloads()-downloads, stored != nil))
+	})
+	want := fmt.Sprint([]string{
+		"a.jpg after 0 downloads, stored true",
+		"b.jpg after 1 downloads, stored true",
+		"c.jpg after 2 downloads, stored true",
+	})
+	if fmt.Sprint(reported) != want {
+		t.Errorf("reported %v, want %s", reported, want)
+	}
+
+	// The result is still in the page's order.
+	if fmt.Sprint(got) != "[a.jpg b.jpg c.jpg]" {
+		t.Errorf("got %v, want [a.jpg b.jpg c.jpg]", got)
+	}
+}
@@ -12,15 +12,27 @@ import (
 type promImpl struct {
 	promFactory promauto.Factory
 
-	listAlbumsSuccess           prometheus.Counter
-	listAlbumsFailure           prometheus.Counter
-	listMediaItemsSuccess       prometheus.Counter
-	listMediaItemsFailure       prometheus.Counter
-	listMediaItemsCount         prometheus.Counter
-	mediaItemsDownloadedSuccess prometheus.Counter
-	mediaItemsDownloadedFailure prometheus.Counter
-	mediaItemsDownloadedBytes   prometheus.Counter
-	tokenExpiry                 prometheus.GaugeFunc
+	listAlbumsSuccess             prometheus.Counter
+	listAlbumsFailure             prometheus.Counter
+	listMediaItemsSuccess         prometheus.Counter
+	listMediaItemsFailure         prometheus.Counter
+	listMediaItemsCount           prometheus.Counter
+	mediaItemsDownloadedSuccess   prometheus.Counter
+	mediaItemsDownloadedFailure   prometheus.Counter
+	mediaItemsDownloadedBytes     prometheus.Counter
+	mediaItemsDownloadsInFlight   prometheus.Gauge
+	videosDownloadedSuccess       prometheus.Counter
+	videosDownloadedFailure       prometheus.Counter
+	videosDownloadedBytes         prometheus.Counter
+	videosProbed                  prometheus.Counter
+	videoPostersDownloadedSuccess prometheus.Counter
+	videoPostersDownloadedFailure prometheus.Counter
+	videosOriginals               prometheus.Counter
+	videosPosters                 prometheus.Counter
+	videosSkipped                 *prometheus.CounterVec
+	httpRetries                   *prometheus.CounterVec
+	httpFailures                  *prometheus.CounterVec
+	tokenExpiry                   prometheus.GaugeFunc
 }
 
 func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
@@ -66,6 +78,66 @@ func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
 			Name: "googlephotos_mediaitems_downloaded_bytes",
 			Help: "Total bytes of all media item downloads",
 		})
+	c.prom.mediaItemsDownloadsInFlight = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "googlephotos_mediaitems_downloads_in_flight",
+			Help: "Number of media item downloads currently in progress",
+		})
+	c.prom.videosDownloadedSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "googlephotos_videos_downloaded_success",
+			Help: "Number of videos that were downloaded for hashing",
+		})
+	c.prom.videosDownloadedFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "googlephotos_videos_downloaded_failure",
+			Help: "Number of videos that failed to download",
+		})
+	c.prom.videosDownloadedBytes = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "googlephotos_videos_downloaded_bytes",
+			Help: "Total bytes of all videos downloaded for hashing",
+		})
+	c.prom.videosProbed = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "googlephotos_videos_probed",
+			Help: "Number of videos whose size or duration was checked without downloading all of it",
+		})
+	c.prom.videoPostersDownloadedSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "googlephotos_video_posters_downloaded_success",
+			Help: "Number of video posters (still frames) that were downloaded for hashing",
+		})
+	c.prom.videoPostersDownloadedFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "googlephotos_video_posters_downloaded_failure",
+			Help: "Number of video posters (still frames) that failed to download",
+		})
+	c.prom.videosOriginals = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "googlephotos_videos_synced_original",
+			Help: "Number of times a video was chosen to sync as the original video",
+		})
+	c.prom.videosPosters = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "googlephotos_videos_synced_poster",
+			Help: "Number of times a video was chosen to sync as a poster (still frame)",
+		})
+	c.prom.videosSkipped = c.prom.promFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "googlephotos_videos_skipped",
+			Help: "Number of times a video was skipped, by reason",
+		}, []string{"reason"})
+	c.prom.httpRetries = c.prom.promFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "googlephotos_http_retries",
+			Help: "Calls to Google Photos that failed and were retried",
+		}, []string{"endpoint"})
+	c.prom.httpFailures = c.prom.promFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "googlephotos_http_failures",
+			Help: "Calls to Google Photos that still failed after any retries",
+		}, []string{"endpoint"})
 
 	expiryGetter, err := c.newTokenExpiryGetter()
 	if err != nil {
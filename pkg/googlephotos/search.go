@@ -0,0 +1,443 @@
+package googlephotos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date is a calendar date for search filters.  Zero fields are wildcards
+// (e.g. Year 0 is "any year"), but only in Filters.DateFilter.Dates; ranges
+// need full dates.
+type Date struct {
+	Year  int `json:"year,omitempty"`
+	Month int `json:"month,omitempty"`
+	Day   int `json:"day,omitempty"`
+}
+
+// DateOf returns the (full) date of t.
+func DateOf(t time.Time) Date {
+	return Date{Year: t.Year(), Month: int(t.Month()), Day: t.Day()}
+}
+
+func (d Date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// DateRange is from StartDate to EndDate, including both.
+type DateRange struct {
+	StartDate Date `json:"startDate"`
+	EndDate   Date `json:"endDate"`
+}
+
+// DateFilter matches items created on any of Dates or in any of Ranges.
+type DateFilter struct {
+	Dates  []Date      `json:"dates,omitempty"`
+	Ranges []DateRange `json:"ranges,omitempty"`
+}
+
+// ContentFilter matches by what Google thinks is in the item, e.g. "PETS".
+// See ContentCategories.
+type ContentFilter struct {
+	IncludedContentCategories []string `json:"includedContentCategories,omitempty"`
+	ExcludedContentCategories []string `json:"excludedContentCategories,omitempty"`
+}
+
+// MediaTypeFilter is "ALL_MEDIA", "PHOTO" or "VIDEO" (only one).
+type MediaTypeFilter struct {
+	MediaTypes []string `json:"mediaTypes"`
+}
+
+// FeatureFilter only has one feature: "FAVORITES".
+type FeatureFilter struct {
+	IncludedFeatures []string `json:"includedFeatures"`
+}
+
+// Filters are what to search the whole library for.
+type Filters struct {
+	DateFilter           *DateFilter      `json:"dateFilter,omitempty"`
+	ContentFilter        *ContentFilter   `json:"contentFilter,omitempty"`
+	MediaTypeFilter      *MediaTypeFilter `json:"mediaTypeFilter,omitempty"`
+	FeatureFilter        *FeatureFilter   `json:"featureFilter,omitempty"`
+	IncludeArchivedMedia bool             `json:"includeArchivedMedia,omitempty"`
+}
+
+// SearchMediaItemsRequest is a page of a mediaItems:search.  Google only
+// allows one of AlbumId and Filters.
+type SearchMediaItemsRequest struct {
+	AlbumId   string   `json:"albumId,omitempty"`
+	PageSize  int      `json:"pageSize,omitempty"`
+	PageToken string   `json:"pageToken,omitempty"`
+	Filters   *Filters `json:"filters,omitempty"`
+}
+
+type SearchMediaItemsResponse struct {
+	MediaItems    []*MediaItem `json:"mediaItems"`
+	NextPageToken string       `json:"nextPageToken"`
+}
+
+// ContentCategories are the categories a ContentFilter can use.
+var ContentCategories = []string{
+	"NONE", "LANDSCAPES", "RECEIPTS", "CITYSCAPES", "LANDMARKS", "SELFIES",
+	"PEOPLE", "PETS", "WEDDINGS", "BIRTHDAYS", "DOCUMENTS", "TRAVEL",
+	"ANIMALS", "FOOD", "SPORT", "NIGHT", "PERFORMANCES", "WHITEBOARDS",
+	"SCREENSHOTS", "UTILITY", "ARTS", "CRAFTS", "FASHION", "HOUSES",
+	"GARDENS", "FLOWERS", "HOLIDAYS",
+}
+
+// maxDates is the most dates Google allows in one DateFilter.
+const maxDates = 5
+
+// maxContentCategories is the most categories Google allows to be included
+// (or excluded) in one search.
+const maxContentCategories = 10
+
+// SearchMediaItems gets a page of items that match req.
+func (c clientImpl) SearchMediaItems(ctx context.Context, req *SearchMediaItemsRequest) (*SearchMediaItemsResponse, error) {
+	if req.AlbumId != "" && req.Filters != nil {
+		return nil, fmt.Errorf("can't search an album with filters")
+	}
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := SearchMediaItemsResponse{}
+	url := c.baseURL + "/v1/mediaItems:search"
+	err = PostUnmarshalJSONContext(ctx, c.httpClient, url, string(reqBody), &resp)
+	if err != nil {
+		c.prom.listMediaItemsFailure.Inc()
+	} else {
+		c.prom.listMediaItemsSuccess.Inc()
+		c.prom.listMediaItemsCount.Add(float64(len(resp.MediaItems)))
+	}
+	return &resp, err
+}
+
+// Search is a search as a config describes it.  Dates can be relative to
+// when it is run, so Filters must be called each time the search is run.
+type Search struct {
+	// From and To are dates ("2006-01-02"), including both ends.  Either can
+	// be left out.
+	From string
+	To   string
+	// Last is a period up to today, like "90d", "2w", "6m" or "1y".  It
+	// can't be combined with From and To.
+	Last string
+	// OnThisDay is items created on today's date in previous years, or up to
+	// DaysAround (at most 2) days either side of it.  From, To and Last further limit
+	// which years.  In years without a Feb 29, Feb 29 items are on Feb 28.
+	OnThisDay  bool
+	DaysAround int
+
+	Include []string
+	Exclude []string
+
+	// MediaType is "photo", "video" or "all" (the default).
+	MediaType string
+	Favorites bool
+}
+
+// earliestDate is used for a range with no start; Google needs one.
+var earliestDate = Date{Year: 1, Month: 1, Day: 1}
+
+// Filters returns the API filters for s, as of now.  An item matches s if
+// any of them match it (and Matches does).  There is usually one, but Google
+// allows only maxDates dates in each, so onThisDay around Feb 29 can need
+// two.
+func (s *Search) Filters(now time.Time) ([]*Filters, error) {
+	f := Filters{}
+
+	if s.DaysAround < 0 || (s.DaysAround > 0 && !s.OnThisDay) {
+		return nil, fmt.Errorf("daysAround must be positive, and only with onThisDay")
+	}
+	if 2*s.DaysAround+1 > maxDates {
+		return nil, fmt.Errorf("daysAround can be at most %d", (maxDates-1)/2)
+	}
+	r, err := s.dateRange(now)
+	if err != nil {
+		return nil, err
+	}
+	var dates []Date
+	if s.OnThisDay {
+		// Google ORs dates and ranges, so the range is only checked by
+		// Matches.
+		for _, day := range s.days(now) {
+			day.Year = 0
+			dates = append(dates, day)
+		}
+	} else if r != nil {
+		f.DateFilter = &DateFilter{Ranges: []DateRange{*r}}
+	}
+
+	if len(s.Include) > 0 || len(s.Exclude) > 0 {
+		include, err := contentCategories(s.Include)
+		if err != nil {
+			return nil, err
+		}
+		exclude, err := contentCategories(s.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		f.ContentFilter = &ContentFilter{
+			IncludedContentCategories: include,
+			ExcludedContentCategories: exclude,
+		}
+	}
+
+	switch strings.ToLower(s.MediaType) {
+	case "", "all":
+	case "photo", "photos":
+		f.MediaTypeFilter = &MediaTypeFilter{MediaTypes: []string{"PHOTO"}}
+	case "video", "videos":
+		f.MediaTypeFilter = &MediaTypeFilter{MediaTypes: []string{"VIDEO"}}
+	default:
+		return nil, fmt.Errorf("bad media type %q (want photo, video or all)", s.MediaType)
+	}
+
+	if s.Favorites {
+		f.FeatureFilter = &FeatureFilter{IncludedFeatures: []string{"FAVORITES"}}
+	}
+
+	if len(dates) == 0 {
+		return []*Filters{&f}, nil
+	}
+	// The dates are all different, so no item matches more than one.
+	var filters []*Filters
+	for len(dates) > 0 {
+		n := len(dates)
+		if n > maxDates {
+			n = maxDates
+		}
+		each := f
+		each.DateFilter = &DateFilter{Dates: dates[:n]}
+		filters = append(filters, &each)
+		dates = dates[n:]
+	}
+	return filters, nil
+}
+
+// days returns the dates that OnThisDay is about as of now: today and
+// DaysAround either side, plus Feb 29 in years that don't have one if Feb
+// 28 is one of them.
+func (s *Search) days(now time.Time) []Date {
+	var days []Date
+	for d := -s.DaysAround; d <= s.DaysAround; d++ {
+		day := DateOf(now.AddDate(0, 0, d))
+		days = append(days, day)
+		if day.Month == 2 && day.Day == 28 && !isLeapYear(day.Year) {
+			days = append(days, Date{Year: day.Year, Month: 2, Day: 29})
+		}
+	}
+	return days
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// dateRange returns the range that From, To and Last make as of now, or nil
+// if there isn't one.
+func (s *Search) dateRange(now time.Time) (*DateRange, error) {
+	if s.Last != "" && (s.From != "" || s.To != "") {
+		return nil, fmt.Errorf("can't have both last and from/to")
+	}
+	if s.Last != "" {
+		start, err := relativeStart(s.Last, now)
+		if err != nil {
+			return nil, err
+		}
+		return &DateRange{StartDate: DateOf(start), EndDate: DateOf(now)}, nil
+	}
+	if s.From == "" && s.To == "" {
+		return nil, nil
+	}
+	r := &DateRange{StartDate: earliestDate, EndDate: DateOf(now)}
+	if s.From != "" {
+		from, err := time.Parse("2006-01-02", s.From)
+		if err != nil {
+			return nil, fmt.Errorf("bad from date %q (want YYYY-MM-DD)", s.From)
+		}
+		r.StartDate = DateOf(from)
+	}
+	if s.To != "" {
+		to, err := time.Parse("2006-01-02", s.To)
+		if err != nil {
+			return nil, fmt.Errorf("bad to date %q (want YYYY-MM-DD)", s.To)
+		}
+		r.EndDate = DateOf(to)
+	}
+	return r, nil
+}
+
+// CheckLocal returns an error if s can't be checked by Matches alone (for
+// albums, which Google won't search with filters).
+func (s *Search) CheckLocal() error {
+	if s.Favorites || len(s.Include) > 0 || len(s.Exclude) > 0 {
+		return fmt.Errorf("favorites and categories can't be used with an album")
+	}
+	return nil
+}
+
+// Matches checks item against s as of now, using the item's metadata (its
+// creation date and type).  It can't check favorites or categories (Google
+// does those).  Call Filters first to check s is valid.
+//
+// The creation date is the one in now's time zone, or in UTC: Google doesn't
+// say which it searches by, and near midnight they differ.  Checking only
+// the local one would drop items that Google's own date search returned.
+func (s *Search) Matches(item *MediaItem, now time.Time) bool {
+	switch strings.ToLower(s.MediaType) {
+	case "photo", "photos":
+		if strings.HasPrefix(item.MimeType, "video/") {
+			return false
+		}
+	case "video", "videos":
+		if !strings.HasPrefix(item.MimeType, "video/") {
+			return false
+		}
+	}
+
+	r, _ := s.dateRange(now)
+	if r == nil && !s.OnThisDay {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339, item.MediaMetadata.CreationTime)
+	if err != nil {
+		return false
+	}
+	for _, createdDate := range createdDates(created, now.Location()) {
+		if r != nil && (createdDate.before(r.StartDate) || r.EndDate.before(createdDate)) {
+			continue
+		}
+		if !s.OnThisDay {
+			return true
+		}
+		for _, day := range s.days(now) {
+			if createdDate.Month == day.Month && createdDate.Day == day.Day &&
+				createdDate.Year < day.Year {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// createdDates returns the date of created in loc, and in UTC if that's
+// different.
+func createdDates(created time.Time, loc *time.Location) []Date {
+	local, utc := DateOf(created.In(loc)), DateOf(created.UTC())
+	if local == utc {
+		return []Date{local}
+	}
+	return []Date{local, utc}
+}
+
+func (d Date) before(other Date) bool {
+	if d.Year != other.Year {
+		return d.Year < other.Year
+	}
+	if d.Month != other.Month {
+		return d.Month < other.Month
+	}
+	return d.Day < other.Day
+}
+
+// String describes s; it doesn't change as time passes.
+func (s *Search) String() string {
+	var parts []string
+	if s.Favorites {
+		parts = append(parts, "favorites")
+	}
+	if s.MediaType != "" {
+		parts = append(parts, "type "+strings.ToLower(s.MediaType))
+	}
+	if s.OnThisDay {
+		if s.DaysAround > 0 {
+			parts = append(parts, fmt.Sprintf("on this day +/-%dd", s.DaysAround))
+		} else {
+			parts = append(parts, "on this day")
+		}
+	}
+	if s.Last != "" {
+		parts = append(parts, "last "+s.Last)
+	}
+	if s.From != "" {
+		parts = append(parts, "from "+s.From)
+	}
+	if s.To != "" {
+		parts = append(parts, "to "+s.To)
+	}
+	if len(s.Include) > 0 {
+		parts = append(parts, "include "+strings.ToUpper(strings.Join(s.Include, ",")))
+	}
+	if len(s.Exclude) > 0 {
+		parts = append(parts, "exclude "+strings.ToUpper(strings.Join(s.Exclude, ",")))
+	}
+	if len(parts) == 0 {
+		return "everything"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// relativeStart returns the start of the period last (like "90d") that
+// ends now.
+func relativeStart(last string, now time.Time) (time.Time, error) {
+	s := strings.ToLower(strings.TrimSpace(last))
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n < 1 {
+		return now, fmt.Errorf("bad period %q (want e.g. 90d, 2w, 6m, 1y)", last)
+	}
+	switch strings.TrimSpace(s[i:]) {
+	case "d", "day", "days":
+		return now.AddDate(0, 0, -n), nil
+	case "w", "week", "weeks":
+		return now.AddDate(0, 0, -7*n), nil
+	case "m", "month", "months":
+		return addMonths(now, -n), nil
+	case "y", "year", "years":
+		return addMonths(now, -12*n), nil
+	}
+	return now, fmt.Errorf("bad period %q (want e.g. 90d, 2w, 6m, 1y)", last)
+}
+
+// addMonths is t.AddDate(0, months, 0), except that a day past the end of
+// the month it lands in is the last day of that month (a month before Mar
+// 31 is Feb 28, not Mar 3).
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	first = first.AddDate(0, months, 0)
+	day := t.Day()
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+func contentCategories(in []string) ([]string, error) {
+	if len(in) > maxContentCategories {
+		return nil, fmt.Errorf("at most %d content categories, not %d", maxContentCategories, len(in))
+	}
+	var out []string
+	for _, cat := range in {
+		upper := strings.ToUpper(cat)
+		found := false
+		for _, known := range ContentCategories {
+			if upper == known {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown content category %q", cat)
+		}
+		out = append(out, upper)
+	}
+	return out, nil
+}
@@ -0,0 +1,453 @@
+package googlephotos
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// at is 10:00 on date ("2006-01-02") in a time zone well ahead of UTC, so
+// that dates here and in UTC differ near midnight.
+func at(date string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", date+" 10:00", time.FixedZone("UTC+9", 9*60*60))
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// describe is filters' dates and ranges, one filter per line.
+func describe(filters []*Filters) string {
+	var lines []string
+	for _, f := range filters {
+		var parts []string
+		if f.DateFilter != nil {
+			for _, d := range f.DateFilter.Dates {
+				parts = append(parts, fmt.Sprintf("%02d-%02d", d.Month, d.Day))
+			}
+			for _, r := range f.DateFilter.Ranges {
+				parts = append(parts, fmt.Sprintf("%s..%s", r.StartDate, r.EndDate))
+			}
+		}
+		lines = append(lines, strings.Join(parts, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestSearchFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		search  Search
+		now     string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "everything",
+			search: Search{},
+			now:    "2022-10-18",
+			want:   "",
+		},
+		{
+			name:   "on this day",
+			search: Search{OnThisDay: true},
+			now:    "2022-10-18",
+			want:   "10-18",
+		},
+		{
+			name:   "on this day, days around",
+			search: Search{OnThisDay: true, DaysAround: 2},
+			now:    "2022-10-18",
+			want:   "10-16 10-17 10-18 10-19 10-20",
+		},
+		{
+			name:   "on this day, end of month",
+			search: Search{OnThisDay: true, DaysAround: 1},
+			now:    "2022-10-31",
+			want:   "10-30 10-31 11-01",
+		},
+		{
+			name:   "on this day, end of year",
+			search: Search{OnThisDay: true, DaysAround: 1},
+			now:    "2023-01-01",
+			want:   "12-31 01-01 01-02",
+		},
+		{
+			name:   "on this day, leap year",
+			search: Search{OnThisDay: true, DaysAround: 1},
+			now:    "2024-02-28",
+			want:   "02-27 02-28 02-29",
+		},
+		{
+			name:   "on this day, Feb 29 is on Feb 28",
+			search: Search{OnThisDay: true},
+			now:    "2023-02-28",
+			want:   "02-28 02-29",
+		},
+		{
+			name:   "on this day, Feb 29 needs another search",
+			search: Search{OnThisDay: true, DaysAround: 2},
+			now:    "2023-02-28",
+			want:   "02-26 02-27 02-28 02-29 03-01\n03-02",
+		},
+		{
+			name:   "on this day, years checked later",
+			search: Search{OnThisDay: true, Last: "1y"},
+			now:    "2022-10-18",
+			want:   "10-18",
+		},
+		{
+			name:    "too many days around",
+			search:  Search{OnThisDay: true, DaysAround: 3},
+			now:     "2022-10-18",
+			wantErr: "daysAround can be at most 2",
+		},
+		{
+			name:    "days around without on this day",
+			search:  Search{DaysAround: 1},
+			now:     "2022-10-18",
+			wantErr: "only with onThisDay",
+		},
+		{
+			name:   "last days",
+			search: Search{Last: "90d"},
+			now:    "2022-10-18",
+			want:   "2022-07-20..2022-10-18",
+		},
+		{
+			name:   "last weeks",
+			search: Search{Last: "2w"},
+			now:    "2022-10-18",
+			want:   "2022-10-04..2022-10-18",
+		},
+		{
+			name:   "last months",
+			search: Search{Last: "6m"},
+			now:    "2022-10-18",
+			want:   "2022-04-18..2022-10-18",
+		},
+		{
+			name:   "last months, from the end of a long month",
+			search: Search{Last: "1 month"},
+			now:    "2022-03-31",
+			want:   "2022-02-28..2022-03-31",
+		},
+		{
+			name:   "last year",
+			search: Search{Last: "1y"},
+			now:    "2022-10-18",
+			want:   "2021-10-18..2022-10-18",
+		},
+		{
+			name:   "last year, from Feb 29",
+			search: Search{Last: "1Y"},
+			now:    "2024-02-29",
+			want:   "2023-02-28..2024-02-29",
+		},
+		{
+			name:    "last with no number",
+			search:  Search{Last: "w"},
+			now:     "2022-10-18",
+			wantErr: `bad period "w"`,
+		},
+		{
+			name:    "last zero",
+			search:  Search{Last: "0d"},
+			now:     "2022-10-18",
+			wantErr: `bad period "0d"`,
+		},
+		{
+			name:    "last with unknown unit",
+			search:  Search{Last: "3h"},
+			now:     "2022-10-18",
+			wantErr: `bad period "3h"`,
+		},
+		{
+			name:    "last and from",
+			search:  Search{Last: "1y", From: "2022-01-01"},
+			now:     "2022-10-18",
+			wantErr: "can't have both last and from/to",
+		},
+		{
+			name:   "from and to",
+			search: Search{From: "2019-06-01", To: "2019-06-30"},
+			now:    "2022-10-18",
+			want:   "2019-06-01..2019-06-30",
+		},
+		{
+			name:   "only from",
+			search: Search{From: "2022-01-01"},
+			now:    "2022-10-18",
+			want:   "2022-01-01..2022-10-18",
+		},
+		{
+			name:   "only to",
+			search: Search{To: "2019-06-30"},
+			now:    "2022-10-18",
+			want:   "0001-01-01..2019-06-30",
+		},
+		{
+			name:    "bad from",
+			search:  Search{From: "June 2019"},
+			now:     "2022-10-18",
+			wantErr: `bad from date "June 2019"`,
+		},
+	}
+	for _, tt := range tests {
+		filters, err := tt.search.Filters(at(tt.now))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := describe(filters); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSearchFiltersKeepOtherFilters(t *testing.T) {
+	s := Search{OnThisDay: true, DaysAround: 2, Include: []string{"pets"}, MediaType: "photo", Favorites: true}
+	filters, err := s.Filters(at("2023-02-28"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 2 {
+		t.Fatalf("got %d filters, want 2", len(filters))
+	}
+	for i, f := range filters {
+		if f.ContentFilter == nil || f.ContentFilter.IncludedContentCategories[0] != "PETS" ||
+			f.MediaTypeFilter == nil || f.MediaTypeFilter.MediaTypes[0] != "PHOTO" ||
+			f.FeatureFilter == nil || f.FeatureFilter.IncludedFeatures[0] != "FAVORITES" {
+			t.Errorf("filter %d is %+v, want pets, photos and favorites", i, f)
+		}
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		search   Search
+		now      string
+		created  string
+		mimeType string
+		want     bool
+	}{
+		{
+			name:    "everything",
+			search:  Search{},
+			now:     "2022-10-18",
+			created: "not a time",
+			want:    true,
+		},
+		{
+			name:    "on this day",
+			search:  Search{OnThisDay: true},
+			now:     "2022-10-18",
+			created: "2019-10-18T12:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "on this day, this year",
+			search:  Search{OnThisDay: true},
+			now:     "2022-10-18",
+			created: "2022-10-18T00:30:00Z",
+			want:    false,
+		},
+		{
+			name:    "on this day, the day before",
+			search:  Search{OnThisDay: true},
+			now:     "2022-10-18",
+			created: "2019-10-17T12:00:00Z",
+			want:    false,
+		},
+		{
+			name:    "on this day, within days around",
+			search:  Search{OnThisDay: true, DaysAround: 1},
+			now:     "2022-10-18",
+			created: "2019-10-17T12:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "on this day, beyond days around",
+			search:  Search{OnThisDay: true, DaysAround: 1},
+			now:     "2022-10-18",
+			created: "2019-10-16T12:00:00Z",
+			want:    false,
+		},
+		{
+			// It's Oct 19 here (UTC+9), but Oct 18 in UTC, which Google
+			// may have searched by.
+			name:    "on this day, late in UTC",
+			search:  Search{OnThisDay: true},
+			now:     "2022-10-18",
+			created: "2019-10-18T23:30:00Z",
+			want:    true,
+		},
+		{
+			// Oct 17 in UTC, but Oct 18 here.
+			name:    "on this day, early here",
+			search:  Search{OnThisDay: true},
+			now:     "2022-10-18",
+			created: "2019-10-17T20:00:00Z",
+			want:    true,
+		},
+		{
+			// Oct 18 in UTC, but Oct 19 here.
+			name:    "on this day, day after here",
+			search:  Search{OnThisDay: true},
+			now:     "2022-10-18",
+			created: "2019-10-19T02:00:00Z",
+			want:    false,
+		},
+		{
+			name:    "on this day, the next month",
+			search:  Search{OnThisDay: true, DaysAround: 1},
+			now:     "2022-10-31",
+			created: "2020-11-01T12:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "on this day, the previous year",
+			search:  Search{OnThisDay: true, DaysAround: 1},
+			now:     "2023-01-01",
+			created: "2020-12-31T12:00:00Z",
+			want:    true,
+		},
+		{
+			// Dec 31 2022 is yesterday, not a previous year.
+			name:    "on this day, yesterday across new year",
+			search:  Search{OnThisDay: true, DaysAround: 1},
+			now:     "2023-01-01",
+			created: "2022-12-31T12:00:00Z",
+			want:    false,
+		},
+		{
+			name:    "on this day, the next year",
+			search:  Search{OnThisDay: true, DaysAround: 1},
+			now:     "2022-12-31",
+			created: "2021-01-01T12:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "on this day, Feb 29 on Feb 28",
+			search:  Search{OnThisDay: true},
+			now:     "2023-02-28",
+			created: "2020-02-29T12:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "on this day, Feb 29 not on Mar 1",
+			search:  Search{OnThisDay: true},
+			now:     "2023-03-01",
+			created: "2020-02-29T12:00:00Z",
+			want:    false,
+		},
+		{
+			name:    "on this day, Feb 29 in a leap year",
+			search:  Search{OnThisDay: true},
+			now:     "2024-02-28",
+			created: "2020-02-29T12:00:00Z",
+			want:    false,
+		},
+		{
+			name:    "on this day, limited years",
+			search:  Search{OnThisDay: true, From: "2020-01-01"},
+			now:     "2022-10-18",
+			created: "2019-10-18T12:00:00Z",
+			want:    false,
+		},
+		{
+			name:    "only from",
+			search:  Search{From: "2022-01-01"},
+			now:     "2022-10-18",
+			created: "2022-01-01T00:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "only from, before",
+			search:  Search{From: "2022-01-01"},
+			now:     "2022-10-18",
+			created: "2021-12-31T12:00:00Z",
+			want:    false,
+		},
+		{
+			// Already Jan 1 here.
+			name:    "only from, just before midnight in UTC",
+			search:  Search{From: "2022-01-01"},
+			now:     "2022-10-18",
+			created: "2021-12-31T23:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "only to",
+			search:  Search{To: "2019-06-30"},
+			now:     "2022-10-18",
+			created: "1999-06-30T12:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "only to, after",
+			search:  Search{To: "2019-06-30"},
+			now:     "2022-10-18",
+			created: "2019-07-01T12:00:00Z",
+			want:    false,
+		},
+		{
+			name:    "last, inside",
+			search:  Search{Last: "2w"},
+			now:     "2022-10-18",
+			created: "2022-10-05T12:00:00Z",
+			want:    true,
+		},
+		{
+			name:    "last, before",
+			search:  Search{Last: "2w"},
+			now:     "2022-10-18",
+			created: "2022-10-02T12:00:00Z",
+			want:    false,
+		},
+		{
+			name:    "bad creation time",
+			search:  Search{Last: "2w"},
+			now:     "2022-10-18",
+			created: "yesterday",
+			want:    false,
+		},
+		{
+			name:     "photos only",
+			search:   Search{MediaType: "photo"},
+			now:      "2022-10-18",
+			created:  "2022-10-18T12:00:00Z",
+			mimeType: "video/mp4",
+			want:     false,
+		},
+		{
+			name:     "videos only",
+			search:   Search{MediaType: "VIDEOS"},
+			now:      "2022-10-18",
+			created:  "2022-10-18T12:00:00Z",
+			mimeType: "video/mp4",
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		mimeType := tt.mimeType
+		if mimeType == "" {
+			mimeType = "image/jpeg"
+		}
+		item := &MediaItem{
+			MimeType:      mimeType,
+			MediaMetadata: MediaMetadata{CreationTime: tt.created},
+		}
+		if got := tt.search.Matches(item, at(tt.now)); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
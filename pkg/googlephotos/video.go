@@ -0,0 +1,383 @@
+package googlephotos
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+)
+
+// Video policies say what to sync for a video.
+const (
+	// VideoOriginal syncs the video itself (the default).
+	VideoOriginal = "original"
+
+	// VideoPoster syncs a still frame from the video, as a JPEG.
+	VideoPoster = "poster"
+
+	// VideoSkip doesn't sync videos.
+	VideoSkip = "skip"
+)
+
+// VideoReady is the VideoMediaMetadata.Status of a video that Google has
+// finished processing.  Other videos can't be downloaded yet.
+const VideoReady = "READY"
+
+// posterSuffix asks Google for a still frame of a video, no bigger than
+// 1920x1080.
+const posterSuffix = "=w1920-h1080"
+
+// VideoPolicy is what to do with videos.
+type VideoPolicy struct {
+	// Policy is VideoOriginal (the default), VideoPoster or VideoSkip.
+	Policy string
+
+	// MaxSize and MaxDuration, if set, limit which videos are synced as
+	// originals.  Oversized says what to do with the others: VideoPoster or
+	// VideoSkip (the default).  Google doesn't say how long videos are, so
+	// the duration is read from the start of the video (up to its movie
+	// header); it's unknown (and not limited) for videos that aren't MP4 or
+	// QuickTime.
+	MaxSize     int64
+	MaxDuration time.Duration
+	Oversized   string
+}
+
+// Check returns an error if p doesn't make sense.
+func (p VideoPolicy) Check() error {
+	switch p.Policy {
+	case "", VideoOriginal, VideoPoster, VideoSkip:
+	default:
+		return fmt.Errorf("unknown video policy %q (want %s, %s or %s)",
+			p.Policy, VideoOriginal, VideoPoster, VideoSkip)
+	}
+	switch p.Oversized {
+	case "", VideoPoster, VideoSkip:
+	default:
+		return fmt.Errorf("unknown oversized video policy %q (want %s or %s)",
+			p.Oversized, VideoPoster, VideoSkip)
+	}
+	if p.MaxSize < 0 || p.MaxDuration < 0 {
+		return fmt.Errorf("video limits can't be negative")
+	}
+	return nil
+}
+
+// CachedVideo is what's cached about a video, beyond the hashes of the
+// original.
+type CachedVideo struct {
+	Size         int64         // 0 or less if unknown
+	Duration     time.Duration // 0 or less if unknown
+	PosterSha256 string
+	PosterMd5    string
+
+	// Poster is set if the policy is to sync the video's poster.  Otherwise
+	// the original is synced, and only then are its hashes known.
+	Poster bool
+}
+
+// IsVideo returns true if item is a video rather than a photo.
+func IsVideo(item *MediaItem) bool {
+	return item.MediaMetadata.Video != nil || strings.HasPrefix(item.MimeType, "video/")
+}
+
+// videoReady returns true if a video can be downloaded.
+func videoReady(item *MediaItem) bool {
+	return item.MediaMetadata.Video == nil || item.MediaMetadata.Video.Status == VideoReady
+}
+
+// choose returns what to sync for video v under policy p: VideoOriginal,
+// VideoPoster, or VideoSkip and why (with a label for metrics).  ok is false
+// if it depends on a size or duration that hasn't been checked yet.
+func (p VideoPolicy) choose(v *cache.VideoData) (choice string, reason string, label string, ok bool) {
+	if p.Policy == VideoSkip {
+		return VideoSkip, "policy is to skip videos", "policy", true
+	}
+	if p.Policy == VideoPoster {
+		return VideoPoster, "", "", true
+	}
+
+	// The size is checked first, so the duration needn't be read for
+	// videos that are too big anyway.
+	if p.MaxSize > 0 {
+		if v.Size < 0 {
+			return "", "", "", false
+		}
+		if v.Size > p.MaxSize {
+			reason = fmt.Sprintf("%d bytes is bigger than %d", v.Size, p.MaxSize)
+			label = "too_big"
+		}
+	}
+	if reason == "" && p.MaxDuration > 0 {
+		if v.Duration < 0 {
+			return "", "", "", false
+		}
+		if v.Duration > p.MaxDuration {
+			reason = fmt.Sprintf("%s is longer than %s", v.Duration, p.MaxDuration)
+			label = "too_long"
+		}
+	}
+	if reason == "" {
+		return VideoOriginal, "", "", true
+	}
+	if p.Oversized == VideoPoster {
+		return VideoPoster, "", "", true
+	}
+	return VideoSkip, reason, label, true
+}
+
+// needs returns true if anything has to be downloaded to sync video v (nil if
+// nothing is cached) under policy p.  hashed is whether the original's hashes
+// are cached.
+func (p VideoPolicy) needs(v *cache.VideoData, hashed bool) bool {
+	if v == nil {
+		return p.Policy != VideoSkip
+	}
+	choice, _, _, ok := p.choose(v)
+	return !ok ||
+		(choice == VideoOriginal && !hashed) ||
+		(choice == VideoPoster && v.PosterSha256 == "")
+}
+
+// countVideo counts a choice made by VideoPolicy.choose.
+func (c *clientImpl) countVideo(choice string, label string) {
+	switch choice {
+	case VideoOriginal:
+		c.prom.videosOriginals.Inc()
+	case VideoPoster:
+		c.prom.videosPosters.Inc()
+	default:
+		c.prom.videosSkipped.WithLabelValues(label).Inc()
+	}
+}
+
+// DownloadPoster is Download for a still frame from a video (a JPEG).
+func (c *clientImpl) DownloadPoster(item *MediaItem) (*http.Response, error) {
+	return c.download(item.BaseUrl+posterSuffix, c.prom.videoPostersDownloadedFailure)
+}
+
+// hashVideo finds out what policy p needs to know about a video that isn't
+// cached yet: its size and duration, if p limits them; the hashes of the
+// original, if it will be synced; or the hashes of its poster, if that will
+// be.  v is what's already cached (or nil), and hashed is whether the
+// original's hashes are.  It returns a new cache entry for the original if it
+// was hashed (or nil), and the video's entry; neither is stored yet.
+func (c *clientImpl) hashVideo(item *MediaItem, v *cache.VideoData, hashed bool, p VideoPolicy) (*cache.GooglephotoData, *cache.VideoData, error) {
+	if v == nil {
+		v = &cache.VideoData{GooglephotosId: item.Id, Size: -1, Duration: -1}
+	} else {
+		copied := *v
+		v = &copied
+	}
+
+	var entry *cache.GooglephotoData
+	choice, _, _, ok := p.choose(v)
+	if !ok || (choice == VideoOriginal && !hashed) {
+		var err error
+		entry, err = c.readVideo(item, v, hashed, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		choice, _, _, _ = p.choose(v)
+	}
+
+	if choice == VideoPoster && v.PosterSha256 == "" {
+		if err := c.hashPoster(item, v); err != nil {
+			return nil, nil, err
+		}
+	}
+	return entry, v, nil
+}
+
+// readVideo downloads a video, reading only as much as p needs: the headers
+// for its size, up to its movie header for its duration, or all of it (to
+// hash it) if it will be synced and hashed is false.  v is updated with what
+// was read.  It returns a new cache entry if the video was hashed.
+func (c *clientImpl) readVideo(item *MediaItem, v *cache.VideoData, hashed bool, p VideoPolicy) (*cache.GooglephotoData, error) {
+	resp, err := c.Download(item)
+	if err != nil {
+		return nil, err
+	}
+	c.store(resp, "")
+	// Closed before getting the poster, which may need this download's slot.
+	defer resp.Body.Close()
+
+	// done is true if what's been read is enough.
+	done := func() bool {
+		choice, _, _, ok := p.choose(v)
+		if ok && (choice != VideoOriginal || hashed) {
+			c.prom.videosProbed.Inc()
+			return true
+		}
+		return false
+	}
+
+	v.Size = resp.ContentLength
+	if v.Size < 0 {
+		v.Size = 0
+	}
+	if done() {
+		return nil, nil
+	}
+
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	size := &byteCounter{}
+	body := io.TeeReader(resp.Body, io.MultiWriter(sha256Hash, md5Hash, size))
+
+	if v.Duration < 0 {
+		// Find the duration while hashing, so the video is only read once.
+		// If it can't be found (maybe it isn't MP4), it's unknown.
+		duration, err := mp4Duration(body)
+		if err != nil && !errors.Is(err, errNotMP4) && err != io.EOF && err != io.ErrUnexpectedEOF {
+			c.prom.videosDownloadedFailure.Inc()
+			return nil, err
+		}
+		v.Duration = duration
+		if done() {
+			return nil, nil
+		}
+	}
+
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		c.prom.videosDownloadedFailure.Inc()
+		return nil, err
+	}
+	if resp.ContentLength > 0 && size.n != resp.ContentLength {
+		c.prom.videosDownloadedFailure.Inc()
+		return nil, fmt.Errorf("video %s: got %d of %d bytes", item.Id, size.n, resp.ContentLength)
+	}
+	c.prom.videosDownloadedSuccess.Inc()
+	c.prom.videosDownloadedBytes.Add(float64(size.n))
+	v.Size = size.n
+
+	return &cache.GooglephotoData{
+		BaseUrl:        item.BaseUrl,
+		GooglephotosId: item.Id,
+		Sha256:         hex.EncodeToString(sha256Hash.Sum(nil)),
+		Md5:            hex.EncodeToString(md5Hash.Sum(nil)),
+		Width:          int64(item.MediaMetadata.Width),
+		Height:         int64(item.MediaMetadata.Height),
+	}, nil
+}
+
+// hashPoster downloads a video's poster, and records its hashes in v.
+func (c *clientImpl) hashPoster(item *MediaItem, v *cache.VideoData) error {
+	poster, err := c.DownloadPoster(item)
+	if err != nil {
+		return err
+	}
+	c.store(poster, "")
+	defer poster.Body.Close()
+	posterSha256 := sha256.New()
+	posterMd5 := md5.New()
+	if _, err := io.Copy(io.MultiWriter(posterSha256, posterMd5), poster.Body); err != nil {
+		c.prom.videoPostersDownloadedFailure.Inc()
+		return err
+	}
+	c.prom.videoPostersDownloadedSuccess.Inc()
+	v.PosterSha256 = hex.EncodeToString(posterSha256.Sum(nil))
+	v.PosterMd5 = hex.EncodeToString(posterMd5.Sum(nil))
+	return nil
+}
+
+type byteCounter struct {
+	n int64
+}
+
+func (b *byteCounter) Write(p []byte) (int, error) {
+	b.n += int64(len(p))
+	return len(p), nil
+}
+
+// errNotMP4 is returned by mp4Duration for videos it can't read the
+// duration of.
+var errNotMP4 = errors.New("not an MP4 video")
+
+// maxMoovSize is the biggest moov box mp4Duration will read into memory.
+// It's usually well under a megabyte.
+const maxMoovSize = 64 << 20
+
+// mp4Duration reads an MP4 or QuickTime file from r, and returns the
+// duration in its movie header (mvhd).  It reads up to the end of the moov
+// box that holds the header, or the end of r if there isn't one (in which
+// case the duration is 0).
+func mp4Duration(r io.Reader) (time.Duration, error) {
+	var header [16]byte
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0:
+			// The box goes to the end of the file.
+			return 0, nil
+		case 1:
+			// 64-bit size follows.
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen {
+			return 0, fmt.Errorf("%w: bad box %q size %d", errNotMP4, boxType, size)
+		}
+		if boxType == "moov" && size-headerLen <= maxMoovSize {
+			moov := make([]byte, size-headerLen)
+			if _, err := io.ReadFull(r, moov); err != nil {
+				return 0, err
+			}
+			return mvhdDuration(moov)
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, size-headerLen); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// mvhdDuration finds the movie header in the contents of a moov box and
+// returns the duration in it.
+func mvhdDuration(moov []byte) (time.Duration, error) {
+	for len(moov) >= 8 {
+		size := int(binary.BigEndian.Uint32(moov[:4]))
+		if size < 8 || size > len(moov) {
+			break
+		}
+		if string(moov[4:8]) == "mvhd" {
+			mvhd := moov[8:size]
+			var timescale, duration uint64
+			switch {
+			case len(mvhd) >= 20 && mvhd[0] == 0:
+				timescale = uint64(binary.BigEndian.Uint32(mvhd[12:]))
+				duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+			case len(mvhd) >= 32 && mvhd[0] == 1:
+				timescale = uint64(binary.BigEndian.Uint32(mvhd[20:]))
+				duration = binary.BigEndian.Uint64(mvhd[24:])
+			default:
+				return 0, fmt.Errorf("%w: bad movie header", errNotMP4)
+			}
+			if timescale == 0 {
+				return 0, fmt.Errorf("%w: movie header has no timescale", errNotMP4)
+			}
+			return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+		}
+		moov = moov[size:]
+	}
+	return 0, fmt.Errorf("%w: no movie header", errNotMP4)
+}
@@ -0,0 +1,178 @@
+package googlephotos_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos/googlephotostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func box(boxType string, payload []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(8+len(payload)))
+	b.WriteString(boxType)
+	b.Write(payload)
+	return b.Bytes()
+}
+
+// testMP4 is an MP4 with a movie header saying it is duration long, at the
+// start (like phones make), followed by size bytes of media.
+func testMP4(duration time.Duration, size int) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], uint32(duration.Milliseconds()))
+	var mp4 []byte
+	mp4 = append(mp4, box("ftyp", []byte("isom\x00\x00\x00\x00"))...)
+	mp4 = append(mp4, box("moov", box("mvhd", mvhd))...)
+	mp4 = append(mp4, box("mdat", make([]byte, size))...)
+	return mp4
+}
+
+func TestVideoPolicy(t *testing.T) {
+	gp := googlephotostest.NewServer()
+	defer gp.Close()
+	album := gp.AddAlbum("Videos", false)
+	content := testMP4(90*time.Second, 1<<20)
+	sum := md5.Sum(content)
+	videoMd5 := hex.EncodeToString(sum[:])
+	gp.AddMediaItem(album.Id, googlephotos.MediaItem{
+		Filename: "clip.mp4",
+		MimeType: "video/mp4",
+		MediaMetadata: googlephotos.MediaMetadata{
+			Video: &googlephotos.VideoMediaMetadata{Status: googlephotos.VideoReady},
+		},
+	}, content)
+
+	// What each sync is expected to do.  Each step uses the cache the step
+	// before left.
+	type step struct {
+		policy googlephotos.VideoPolicy
+
+		// wantSynced is "", "original" or "poster".
+		wantSynced string
+
+		// The downloads of the whole video, of just enough of it to check,
+		// and of its poster.
+		wantFull, wantProbed, wantPosters float64
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "skip downloads nothing",
+			steps: []step{
+				{policy: googlephotos.VideoPolicy{Policy: googlephotos.VideoSkip}},
+			},
+		},
+		{
+			name: "poster downloads only the poster",
+			steps: []step{
+				{policy: googlephotos.VideoPolicy{Policy: googlephotos.VideoPoster}, wantSynced: "poster", wantPosters: 1},
+				{policy: googlephotos.VideoPolicy{Policy: googlephotos.VideoPoster}, wantSynced: "poster"},
+				// Switching to originals needs the video after all.
+				{policy: googlephotos.VideoPolicy{}, wantSynced: "original", wantFull: 1},
+			},
+		},
+		{
+			name: "original is hashed once",
+			steps: []step{
+				{policy: googlephotos.VideoPolicy{}, wantSynced: "original", wantFull: 1},
+				{policy: googlephotos.VideoPolicy{}, wantSynced: "original"},
+			},
+		},
+		{
+			name: "too long reads only the start",
+			steps: []step{
+				{
+					policy:     googlephotos.VideoPolicy{MaxDuration: time.Minute, Oversized: googlephotos.VideoPoster},
+					wantSynced: "poster", wantProbed: 1, wantPosters: 1,
+				},
+				{policy: googlephotos.VideoPolicy{MaxDuration: time.Minute, Oversized: googlephotos.VideoPoster}, wantSynced: "poster"},
+				// The duration is cached, so a longer limit needs only
+				// the hashes.
+				{policy: googlephotos.VideoPolicy{MaxDuration: 2 * time.Minute}, wantSynced: "original", wantFull: 1},
+			},
+		},
+		{
+			name: "short enough is hashed in the same download",
+			steps: []step{
+				{policy: googlephotos.VideoPolicy{MaxDuration: 2 * time.Minute}, wantSynced: "original", wantFull: 1},
+			},
+		},
+		{
+			name: "too big reads nothing",
+			steps: []step{
+				{policy: googlephotos.VideoPolicy{MaxSize: 1000}, wantProbed: 1},
+				{policy: googlephotos.VideoPolicy{MaxSize: 1000}},
+				// The size was checked, but not the duration.
+				{policy: googlephotos.VideoPolicy{MaxDuration: time.Minute}, wantProbed: 1},
+			},
+		},
+		{
+			name: "small enough is hashed in the same download",
+			steps: []step{
+				{policy: googlephotos.VideoPolicy{MaxSize: 2 << 20}, wantSynced: "original", wantFull: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			c, err := cache.New(reg, filepath.Join(t.TempDir(), "cache.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := googlephotos.NewClient("id", "secret", context.Background(), gp.Token(), c, reg,
+				googlephotos.Options{BaseURL: gp.URL, TokenURL: gp.TokenURL()})
+
+			var full, probed, posters float64
+			for n, step := range tt.steps {
+				downloads := gp.Downloads()
+				res, err := client.UpdateCacheForAlbumId(album.Id, "", step.policy, func(*googlephotos.CachedMediaItem) {})
+				if err != nil {
+					t.Fatalf("step %d: %v", n+1, err)
+				}
+
+				synced := ""
+				if len(res.CachedMediaItems) == 1 {
+					cached := res.CachedMediaItems[0]
+					synced = "original"
+					if cached.Video.Poster {
+						synced = "poster"
+						if cached.Video.PosterMd5 == "" {
+							t.Errorf("step %d: poster has no MD5", n+1)
+						}
+					} else if cached.Md5 != videoMd5 {
+						t.Errorf("step %d: original MD5 %s, want %s", n+1, cached.Md5, videoMd5)
+					}
+				}
+				if synced != step.wantSynced {
+					t.Errorf("step %d: synced %q, want %q", n+1, synced, step.wantSynced)
+				}
+
+				gotFull := counter(t, reg, "googlephotos_videos_downloaded_success") - full
+				gotProbed := counter(t, reg, "googlephotos_videos_probed") - probed
+				gotPosters := counter(t, reg, "googlephotos_video_posters_downloaded_success") - posters
+				full, probed, posters = full+gotFull, probed+gotProbed, posters+gotPosters
+				if gotFull != step.wantFull || gotProbed != step.wantProbed || gotPosters != step.wantPosters {
+					t.Errorf("step %d: downloaded %v whole, %v probed, %v posters; want %v, %v, %v", n+1,
+						gotFull, gotProbed, gotPosters, step.wantFull, step.wantProbed, step.wantPosters)
+				}
+				if got, want := gp.Downloads()-downloads, int(step.wantFull+step.wantProbed+step.wantPosters); got != want {
+					t.Errorf("step %d: %d downloads, want %d", n+1, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package local
+
+import (
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Client interface {
+	ListFiles(dir Dir) ([]*File, error)
+	UpdateCacheForDir(dir Dir, cb UpdateCacheCallback) ([]*CachedFile, error)
+}
+
+// Options are optional settings for a Client.  The zero value is the
+// default for each.
+type Options struct {
+	// MaxConcurrentReads bounds how many files are read for hashing at once
+	// (default 1).
+	MaxConcurrentReads int
+}
+
+type clientImpl struct {
+	cache cache.Cache
+
+	reads    *util.Limiter
+	maxReads int
+
+	prom promImpl
+}
+
+// NewClient returns a Client that reads local files, recording their hashes
+// in c.
+func NewClient(c cache.Cache, reg prometheus.Registerer, opts Options) Client {
+	localClient := clientImpl{
+		cache:    c,
+		maxReads: opts.MaxConcurrentReads,
+	}
+
+	localClient.promRegister(reg)
+	localClient.reads = util.NewLimiter(opts.MaxConcurrentReads, localClient.prom.filesHashingInFlight)
+
+	return &localClient
+}
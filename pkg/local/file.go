@@ -0,0 +1,216 @@
+package local
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/util"
+)
+
+// Dir is a local directory to find images in.
+type Dir struct {
+	Path string
+
+	// Glob is matched against each file's name (not the full path).  If
+	// empty, any file with a common image extension matches.
+	Glob string
+
+	// If true, also look in subdirectories.
+	Recursive bool
+}
+
+var defaultExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+func (d Dir) matches(name string) (bool, error) {
+	if d.Glob == "" {
+		return defaultExtensions[strings.ToLower(filepath.Ext(name))], nil
+	}
+	return filepath.Match(d.Glob, name)
+}
+
+// File is a single file found in a Dir.
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+type CachedFile struct {
+	CacheId     int64
+	Sha256      string
+	Md5         string
+	LastUpdated time.Time
+	LastUsed    time.Time
+	File        *File
+}
+
+type UpdateCacheCallback func(*CachedFile)
+
+// ListFiles returns all the matching files in dir, sorted by path.  Hidden
+// files and directories (starting with ".") are skipped.  Paths are
+// absolute, so a file has the same cache entry however dir was written.
+func (c *clientImpl) ListFiles(dir Dir) ([]*File, error) {
+	root, err := filepath.Abs(dir.Path)
+	if err != nil {
+		c.prom.listFilesFailure.Inc()
+		return nil, err
+	}
+	var files []*File
+	walk := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && !dir.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		match, err := dir.matches(d.Name())
+		if err != nil {
+			return err
+		}
+		if !match {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, &File{
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	}
+	err = filepath.WalkDir(root, walk)
+	if err != nil {
+		c.prom.listFilesFailure.Inc()
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	c.prom.listFilesSuccess.Inc()
+	c.prom.listFilesCount.Add(float64(len(files)))
+	return files, nil
+}
+
+// UpdateCacheForDir hashes every matching file in dir that isn't already in
+// the cache.  A cache entry is only trusted if the file's size and
+// modification time haven't changed since it was hashed.
+func (c *clientImpl) UpdateCacheForDir(dir Dir, cb UpdateCacheCallback) ([]*CachedFile, error) {
+	files, err := c.ListFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*cache.LocalData, len(files))
+	errs := make([]error, len(files))
+	var toHash []int
+	for i, f := range files {
+		currentEntry, err := c.cache.GetLocal(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		if currentEntry != nil &&
+			currentEntry.Size == f.Size &&
+			currentEntry.ModTime.Equal(f.ModTime) {
+			entries[i] = currentEntry
+			continue
+		}
+
+		// File not in the cache, or it changed.  Read it and calculate hashes
+		// below.
+		entry := &cache.LocalData{
+			Path:    f.Path,
+			Size:    f.Size,
+			ModTime: f.ModTime,
+		}
+		if currentEntry != nil {
+			entry.Id = currentEntry.Id
+		}
+		entries[i] = entry
+		toHash = append(toHash, i)
+	}
+
+	// Reads run concurrently, on MaxConcurrentReads workers.
+	util.RunWorkers(c.maxReads, toHash, func(i int) {
+		entries[i].Sha256, entries[i].Md5, errs[i] = c.hashFile(entries[i].Path)
+	})
+
+	// Store what was hashed to the cache (one at a time, sqlite doesn't like
+	// concurrent writers), and report everything in the original order.
+	// Entries that were already cached were marked used when they were got.
+	for _, i := range toHash {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		entry := entries[i]
+		entry.LastUpdated = time.Now()
+		if err := c.cache.UpsertLocal(entry); err != nil {
+			return nil, err
+		}
+	}
+	var toRet []*CachedFile
+	for i, f := range files {
+		entry := entries[i]
+		cached := CachedFile{
+			CacheId:     entry.Id,
+			Sha256:      entry.Sha256,
+			Md5:         entry.Md5,
+			LastUpdated: entry.LastUpdated,
+			LastUsed:    entry.LastUsed,
+			File:        f,
+		}
+		toRet = append(toRet, &cached)
+		cb(&cached)
+	}
+	return toRet, nil
+}
+
+func (c *clientImpl) hashFile(path string) (string, string, error) {
+	c.reads.Acquire()
+	defer c.reads.Release()
+
+	f, err := os.Open(path)
+	if err != nil {
+		c.prom.filesHashedFailure.Inc()
+		return "", "", err
+	}
+	defer f.Close()
+
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	allHashes := io.MultiWriter(sha256Hash, md5Hash)
+	n, err := io.Copy(allHashes, f)
+	if err != nil {
+		c.prom.filesHashedFailure.Inc()
+		return "", "", err
+	}
+	c.prom.filesHashedSuccess.Inc()
+	c.prom.filesHashedBytes.Add(float64(n))
+	return hex.EncodeToString(sha256Hash.Sum(nil)), hex.EncodeToString(md5Hash.Sum(nil)), nil
+}
@@ -0,0 +1,59 @@
+package local
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type promImpl struct {
+	promFactory promauto.Factory
+
+	listFilesSuccess     prometheus.Counter
+	listFilesFailure     prometheus.Counter
+	listFilesCount       prometheus.Counter
+	filesHashedSuccess   prometheus.Counter
+	filesHashedFailure   prometheus.Counter
+	filesHashedBytes     prometheus.Counter
+	filesHashingInFlight prometheus.Gauge
+}
+
+func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
+	c.prom.promFactory = promauto.With(reg)
+
+	c.prom.listFilesSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "local_list_files_success",
+			Help: "Successful calls to list the files in a local directory",
+		})
+	c.prom.listFilesFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "local_list_files_failure",
+			Help: "Failed calls to list the files in a local directory",
+		})
+	c.prom.listFilesCount = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "local_list_files_count",
+			Help: "Total number of files from all calls to list",
+		})
+	c.prom.filesHashedSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "local_files_hashed_success",
+			Help: "Number of local files that were read and hashed",
+		})
+	c.prom.filesHashedFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "local_files_hashed_failure",
+			Help: "Number of local files that encountered an error while hashing",
+		})
+	c.prom.filesHashedBytes = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "local_files_hashed_bytes",
+			Help: "Total bytes of all local files hashed",
+		})
+	c.prom.filesHashingInFlight = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "local_files_hashing_in_flight",
+			Help: "Number of local files currently being read and hashed",
+		})
+	return nil
+}
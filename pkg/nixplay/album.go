@@ -48,7 +48,7 @@ func (a Album) String() string {
 // GetAlbums will get a list of Albums available to this user
 func (c *clientImpl) GetAlbums() ([]*Album, error) {
 	albums := []*Album{}
-	err := util.GetUnmarshalJSON(c.httpClient, "https://api.nixplay.com/albums/web/json/", &albums)
+	err := util.GetUnmarshalJSON(c.httpClient, c.baseURL+"/albums/web/json/", &albums)
 	if err != nil {
 		c.prom.getAlbumsFailure.Inc()
 	} else {
@@ -80,7 +80,7 @@ func (c *clientImpl) CreateAlbum(name string) (*Album, error) {
 	vals := url.Values{
 		"name": []string{name},
 	}
-	res, err := doPost(c.httpClient, "https://api.nixplay.com/album/create/json/", &vals)
+	res, err := c.doPost(c.baseURL+"/album/create/json/", &vals)
 	if err != nil {
 		c.prom.createAlbumFailure.Inc()
 		return nil, err
@@ -113,9 +113,9 @@ func (c *clientImpl) CreateAlbum(name string) (*Album, error) {
 
 func (c *clientImpl) DeleteAlbumByID(id int) error {
 	vals := url.Values{}
-	url := fmt.Sprintf("https://api.nixplay.com/album/%d/delete/json/", id)
+	url := fmt.Sprintf("%s/album/%d/delete/json/", c.baseURL, id)
 	fmt.Printf("POST to %s\n", url)
-	res, err := doPost(c.httpClient, url, &vals)
+	res, err := c.doPost(url, &vals)
 	if err != nil {
 		c.prom.deleteAlbumFailure.Inc()
 		return err
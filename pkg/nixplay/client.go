@@ -3,8 +3,11 @@ package nixplay
 import (
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/andrewjjenkins/picsync/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -17,38 +20,142 @@ type Client interface {
 	GetPhotos(albumID int, page int, limit int) ([]*Photo, error)
 	UploadPhoto(albumID int, filename string, filetype string, filesize uint64, body io.ReadCloser) error
 	DeletePhoto(id int) error
+	UpdatePhotoCaption(id int, caption string) error
 	CreatePlaylist(name string) (int, error)
 	GetPlaylists() ([]*Playlist, error)
 	GetPlaylistByName(name string) (*Playlist, error)
+	GetPlaylistsByName(name string) ([]*Playlist, error)
+	DeletePlaylistByID(playlistId int) error
+	DeletePlaylistsByName(name string, allowMultiple bool) (int, error)
 	PublishPlaylist(playlistId int, photos []*Photo) error
+	GetPlaylistItems(playlistId int) ([]*PlaylistItem, error)
+	AddPlaylistItems(playlistId int, photos []*Photo) error
+	RemovePlaylistItems(playlistId int, itemIds []int) error
+	GetFrames() ([]*Frame, error)
+	GetFrameByName(name string) (*Frame, error)
+	SetFramePlaylists(frameId int, playlistIds []int) error
+}
+
+// Options are optional settings for a Client.  The zero value is the
+// default for each.
+type Options struct {
+	// MaxConcurrentUploads bounds how many photos are uploaded at once
+	// (default 1).
+	MaxConcurrentUploads int
+
+	// CookieFile, if set, is where the login session is saved, so the next
+	// run can use it instead of logging in again.
+	CookieFile string
+
+	// BaseURL is where the Nixplay API is (default DefaultBaseURL).  This is
+	// for testing against a fake, like nixplaytest.
+	BaseURL string
+}
+
+// DefaultBaseURL is the real Nixplay API.
+const DefaultBaseURL = "https://api.nixplay.com"
+
+// writePolicy is for calls that change something but are safe to repeat
+// (like deleting).
+var writePolicy = util.RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    60 * time.Second,
+	Idempotent:  true,
+}
+
+// nonIdempotentPolicy is for POSTs that would make a duplicate if they were
+// repeated, so they are never retried.
+var nonIdempotentPolicy = util.RetryPolicy{
+	MaxAttempts: 1,
+}
+
+// retryEndpoints sets how each Nixplay call is retried.  GETs and DELETEs
+// are always retried.  POSTs are only retried if repeating them is
+// harmless; creating albums or playlists, getting upload receivers,
+// starting a photo upload and adding playlist items would make duplicates,
+// so they are not.  Setting a frame's playlists replaces them, so it is
+// safe to repeat.
+var retryEndpoints = []util.RetryEndpoint{
+	{Name: "albums", Method: "GET", PathPrefix: "/albums/web/json/", Policy: util.DefaultRetryPolicy},
+	{Name: "album_pictures", Method: "GET", PathPrefix: "/album/*/pictures/json/", Policy: util.DefaultRetryPolicy},
+	{Name: "album_create", Method: "POST", PathPrefix: "/album/create/json/", Policy: nonIdempotentPolicy},
+	{Name: "album_delete", Method: "POST", PathPrefix: "/album/*/delete/json/", Policy: writePolicy},
+	{Name: "upload_receivers", Method: "POST", PathPrefix: "/v3/upload/receivers/", Policy: nonIdempotentPolicy},
+	{Name: "photo_upload", Method: "POST", PathPrefix: "/v3/photo/upload/", Policy: nonIdempotentPolicy},
+	{Name: "picture_delete", Method: "POST", PathPrefix: "/picture/*/delete/json/", Policy: writePolicy},
+	{Name: "picture_caption", Method: "POST", PathPrefix: "/picture/*/caption/json/", Policy: writePolicy},
+	{Name: "playlist_items_add", Method: "POST", PathPrefix: "/v3/playlists/*/items", Policy: nonIdempotentPolicy},
+	{Name: "playlist_items", PathPrefix: "/v3/playlists/*/items", Policy: util.DefaultRetryPolicy},
+	{Name: "playlist_create", Method: "POST", PathPrefix: "/v3/playlists", Policy: nonIdempotentPolicy},
+	{Name: "playlists", PathPrefix: "/v3/playlists", Policy: util.DefaultRetryPolicy},
+	{Name: "frame_playlists", Method: "PUT", PathPrefix: "/v3/frames/*/playlists", Policy: writePolicy},
+	{Name: "frames", Method: "GET", PathPrefix: "/v3/frames", Policy: util.DefaultRetryPolicy},
 }
 
 type clientImpl struct {
 	httpClient *http.Client
+	session    *session
+	baseURL    string
+
+	uploads *util.Limiter
 
 	prom promImpl
 }
 
-// NewClient logs in to Nixplay and returns a Client for future requests
-func NewClient(username, password string, reg prometheus.Registerer) (Client, error) {
-	auth, err := doLogin(username, password)
+// NewClient logs in to Nixplay (or uses the session saved in
+// opts.CookieFile) and returns a Client for future requests.  If the session
+// expires, the Client logs in again.
+func NewClient(username, password string, reg prometheus.Registerer, opts Options) (Client, error) {
+	baseURL := strings.TrimSuffix(opts.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	apiURL, err := url.Parse(baseURL + "/")
+	if err != nil {
+		return nil, err
+	}
+	jar, err := newPersistentJar(opts.CookieFile)
 	if err != nil {
 		return nil, err
 	}
 	tr := &http.Transport{
 		ResponseHeaderTimeout: time.Duration(600 * time.Second),
 	}
-	httpClient := &http.Client{
-		Timeout:   time.Duration(600 * time.Second),
-		Transport: tr,
-		Jar:       auth.Jar,
-	}
 	client := clientImpl{
-		httpClient: httpClient,
+		baseURL: baseURL,
 	}
 	err = client.promRegister(reg)
 	if err != nil {
 		return nil, err
 	}
+	client.session = &session{
+		loginURL: baseURL + "/www-login/",
+		username: username,
+		password: password,
+		jar:      jar,
+		prom:     &client.prom,
+	}
+	client.httpClient = &http.Client{
+		Timeout: time.Duration(600 * time.Second),
+		Transport: &sessionTransport{
+			base: util.NewRetryTransport(
+				tr, retryEndpoints, client.prom.httpRetries, client.prom.httpFailures),
+			session: client.session,
+		},
+		Jar: jar,
+	}
+
+	token, err := csrfToken(jar, apiURL)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		err = client.session.login(client.session.current())
+		if err != nil {
+			return nil, err
+		}
+	}
+	client.uploads = util.NewLimiter(opts.MaxConcurrentUploads, client.prom.uploadPhotoInFlight)
 	return &client, nil
 }
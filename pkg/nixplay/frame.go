@@ -0,0 +1,112 @@
+package nixplay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Frame is a Nixplay frame on the account.
+type Frame struct {
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	SerialNumber string `json:"serialNumber"`
+	Model        string `json:"model"`
+
+	// Playlists are the IDs of the playlists the frame shows.
+	Playlists []int `json:"playlists"`
+}
+
+// GetFrames gets all the frames on this account, with their playlists.
+func (c *clientImpl) GetFrames() ([]*Frame, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/v3/frames", nil)
+	if err != nil {
+		c.prom.getFramesFailure.Inc()
+		return nil, err
+	}
+	req.Header.Set("accept", "application/json")
+	res, err := c.doNixplayCsrf(req)
+	if err != nil {
+		c.prom.getFramesFailure.Inc()
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.prom.getFramesFailure.Inc()
+		resBody, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("couldn't get frames: http %d: %s", res.StatusCode, resBody)
+	}
+	var frames []*Frame
+	err = json.NewDecoder(res.Body).Decode(&frames)
+	if err != nil {
+		c.prom.getFramesFailure.Inc()
+		return nil, err
+	}
+	c.prom.getFramesSuccess.Inc()
+	return frames, nil
+}
+
+// GetFrameByName gets a frame by its name, or its serial number.
+//
+// Like playlists, frame names are not guaranteed unique; the first one found
+// is returned.
+func (c *clientImpl) GetFrameByName(name string) (*Frame, error) {
+	frames, err := c.GetFrames()
+	if err != nil {
+		c.prom.getFrameByNameFailure.Inc()
+		return nil, err
+	}
+	for _, frame := range frames {
+		if frame.Name == name || frame.SerialNumber == name {
+			c.prom.getFrameByNameSuccess.Inc()
+			return frame, nil
+		}
+	}
+	c.prom.getFrameByNameFailure.Inc()
+	return nil, fmt.Errorf("did not find frame \"%s\" in %d frames", name, len(frames))
+}
+
+type framePlaylistsData struct {
+	Playlists []int `json:"playlists"`
+}
+
+// SetFramePlaylists makes a frame show exactly playlistIds.  To add a
+// playlist to what a frame already shows, include its current Playlists.
+func (c *clientImpl) SetFramePlaylists(frameId int, playlistIds []int) error {
+	if playlistIds == nil {
+		playlistIds = []int{}
+	}
+	body, err := json.Marshal(framePlaylistsData{Playlists: playlistIds})
+	if err != nil {
+		c.prom.setFramePlaylistsFailure.Inc()
+		return err
+	}
+	u := fmt.Sprintf("%s/v3/frames/%d/playlists", c.baseURL, frameId)
+	req, err := http.NewRequest("PUT", u, bytes.NewBuffer(body))
+	if err != nil {
+		c.prom.setFramePlaylistsFailure.Inc()
+		return err
+	}
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("content-type", "application/json")
+	res, err := c.doNixplayCsrf(req)
+	if err != nil {
+		c.prom.setFramePlaylistsFailure.Inc()
+		return err
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.prom.setFramePlaylistsFailure.Inc()
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		c.prom.setFramePlaylistsFailure.Inc()
+		return fmt.Errorf("couldn't set playlists of frame %d: http %d: %s", frameId,
+			res.StatusCode, resBody)
+	}
+	c.prom.setFramePlaylistsSuccess.Inc()
+	return nil
+}
@@ -6,12 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"strings"
 
-	"golang.org/x/net/publicsuffix"
-
 	"github.com/andrewjjenkins/picsync/pkg/util"
 )
 
@@ -40,9 +37,8 @@ type auth struct {
 	Jar   http.CookieJar
 }
 
-// doLogin logs in to nixplay
-func doLogin(username string, password string) (auth, error) {
-	uStr := "https://api.nixplay.com/www-login/"
+// doLogin logs in to nixplay at uStr, putting the session cookies in jar
+func doLogin(uStr string, username string, password string, jar http.CookieJar) (auth, error) {
 	u, err := url.Parse(uStr)
 	if err != nil {
 		return auth{}, err
@@ -61,15 +57,10 @@ func doLogin(username string, password string) (auth, error) {
 	}
 	defer resp.Body.Close()
 
-	jar, err := cookiejar.New(&cookiejar.Options{
-		PublicSuffixList: publicsuffix.List,
-	})
-	if err != nil {
-		return auth{}, err
-	}
 	cookies := util.ReadSetCookies(resp.Header)
 	for _, c := range cookies {
-		if !strings.HasSuffix(c.Domain, ".nixplay.com") && c.Name != "AWSELB" {
+		// Cookies with no domain are only sent back to the login host.
+		if c.Domain != "" && !strings.HasSuffix(c.Domain, ".nixplay.com") && c.Name != "AWSELB" {
 			fmt.Printf("Skipping cookie %s, domain %s dangerous\n", c.Name, c.Domain)
 			continue
 		}
@@ -114,7 +105,7 @@ func doLogin(username string, password string) (auth, error) {
 	}, nil
 }
 
-func doPost(c *http.Client, urlString string, values *url.Values) (*http.Response, error) {
+func (c *clientImpl) doPost(urlString string, values *url.Values) (*http.Response, error) {
 	req, err := http.NewRequest(
 		"POST",
 		urlString,
@@ -126,30 +117,32 @@ func doPost(c *http.Client, urlString string, values *url.Values) (*http.Respons
 	req.Header.Set("accept", "application/json")
 	req.Header.Set("content-type", "application/x-www-form-urlencoded; charset=UTF-8")
 
-	return doNixplayCsrf(c, req)
+	return c.doNixplayCsrf(req)
 }
 
-func doNixplayCsrf(c *http.Client, req *http.Request) (*http.Response, error) {
-	var csrfToken string
-	cookies := c.Jar.Cookies(req.URL)
-	for _, cookie := range cookies {
-		if cookie.Name == "prod.csrftoken" {
-			if csrfToken != "" {
-				return nil, fmt.Errorf(
-					"multiple Nixplay CSRF protection cookies (%s)",
-					csrfToken,
-				)
-			}
-			csrfToken = cookie.Value
+func (c *clientImpl) doNixplayCsrf(req *http.Request) (*http.Response, error) {
+	token, err := csrfToken(c.httpClient.Jar, req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		// The session has probably expired; log in again.
+		err = c.session.login(c.session.current())
+		if err != nil {
+			return nil, err
+		}
+		token, err = csrfToken(c.httpClient.Jar, req.URL)
+		if err != nil {
+			return nil, err
 		}
 	}
-	if csrfToken == "" {
+	if token == "" {
 		return nil, fmt.Errorf("no Nixplay CSRF protection cookie found")
 	}
-	req.Header.Set("X-CSRFToken", csrfToken)
+	req.Header.Set("X-CSRFToken", token)
 
 	req.Header.Set("Origin", "https://app.nixplay.com")
 	req.Header.Set("Referer", "https://app.nixplay.com/")
 
-	return c.Do(req)
+	return c.httpClient.Do(req)
 }
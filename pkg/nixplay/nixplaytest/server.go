@@ -0,0 +1,752 @@
+// Package nixplaytest is a fake Nixplay API for testing, built on
+// net/http/httptest.  Point a nixplay.Client at it with Options.BaseURL.
+//
+// It implements login (with session and CSRF cookies), albums, pictures
+// (and their captions), the upload receiver, the S3 upload form, playlists
+// and frames.  It keeps everything in memory.
+//
+// Nixplay has no published API.  Most of this fake follows the requests the
+// original picsync client sent to api.nixplay.com, which worked against the
+// real service: login, albums, pictures, uploads, creating and listing
+// playlists, and emptying and filling a playlist's items.  The rest (listing
+// a playlist's items, removing some of them, captions, deleting playlists
+// and frames) only follows what pkg/nixplay sends now, and hasn't been
+// checked against the real service.  Each handler says which it is.
+package nixplaytest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andrewjjenkins/picsync/pkg/nixplay"
+)
+
+const (
+	sessionCookie = "prod.sessionid"
+	csrfCookie    = "prod.csrftoken"
+)
+
+// Server is a fake Nixplay.  URL (from the embedded httptest.Server) is the
+// base URL to give the client.
+type Server struct {
+	*httptest.Server
+
+	Username string
+	Password string
+
+	mu         sync.Mutex
+	nextID     int
+	session    string
+	csrf       string
+	logins     int
+	albums     []*nixplay.Album
+	photos     map[int][]*nixplay.Photo
+	playlists  []*nixplay.Playlist
+	items      map[int][]*nixplay.PlaylistItem
+	itemsAdded int
+	emptyItems bool
+	frames     []*nixplay.Frame
+	receivers  map[string]int
+	uploads    map[string]*pendingUpload
+}
+
+type pendingUpload struct {
+	albumID  int
+	filename string
+}
+
+// NewServer starts a fake Nixplay that accepts username and password.  Call
+// Close when done.
+func NewServer(username, password string) *Server {
+	s := &Server{
+		Username:  username,
+		Password:  password,
+		nextID:    1000,
+		photos:    make(map[int][]*nixplay.Photo),
+		items:     make(map[int][]*nixplay.PlaylistItem),
+		receivers: make(map[string]int),
+		uploads:   make(map[string]*pendingUpload),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/www-login/", s.handleLogin)
+	mux.HandleFunc("/albums/web/json/", s.authed(s.handleAlbums))
+	mux.HandleFunc("/album/", s.authed(s.handleAlbum))
+	mux.HandleFunc("/picture/", s.authed(s.handlePicture))
+	mux.HandleFunc("/v3/upload/receivers/", s.authed(s.handleReceivers))
+	mux.HandleFunc("/v3/photo/upload/", s.authed(s.handlePhotoUpload))
+	mux.HandleFunc("/v3/playlists", s.authed(s.handlePlaylists))
+	mux.HandleFunc("/v3/playlists/", s.authed(s.handlePlaylist))
+	mux.HandleFunc("/v3/frames", s.authed(s.handleFrames))
+	mux.HandleFunc("/v3/frames/", s.authed(s.handleFrame))
+	mux.HandleFunc("/s3/", s.handleS3)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// id returns a new ID.  s.mu must be held.
+func (s *Server) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+// ExpireSession forgets the current login, as if it timed out.  The next
+// request gets a 401 until the client logs in again.
+func (s *Server) ExpireSession() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = ""
+}
+
+// Logins returns how many times the client has logged in.
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Albums returns a copy of all the albums.
+func (s *Server) Albums() []nixplay.Album {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var albums []nixplay.Album
+	for _, a := range s.albums {
+		albums = append(albums, *a)
+	}
+	return albums
+}
+
+// Photos returns a copy of the photos in an album.
+func (s *Server) Photos(albumID int) []nixplay.Photo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var photos []nixplay.Photo
+	for _, p := range s.photos[albumID] {
+		photos = append(photos, *p)
+	}
+	return photos
+}
+
+// Playlists returns a copy of all the playlists.
+func (s *Server) Playlists() []nixplay.Playlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var playlists []nixplay.Playlist
+	for _, p := range s.playlists {
+		playlists = append(playlists, *p)
+	}
+	return playlists
+}
+
+// AddPlaylist adds an empty playlist, as if it were made in the Nixplay app
+// (so there can be more than one with the same name).
+func (s *Server) AddPlaylist(name string) nixplay.Playlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	playlist := &nixplay.Playlist{
+		Id:           s.id(),
+		Name:         name,
+		PlaylistName: name,
+		Type:         "playlist",
+	}
+	s.playlists = append(s.playlists, playlist)
+	return *playlist
+}
+
+// RenamePlaylist renames a playlist, as if in the Nixplay app.
+func (s *Server) RenamePlaylist(id int, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.playlists {
+		if p.Id == id {
+			p.Name = name
+			p.PlaylistName = name
+		}
+	}
+}
+
+// PlaylistItems returns the picture IDs in a playlist, in order.
+func (s *Server) PlaylistItems(playlistID int) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int{}
+	for _, item := range s.items[playlistID] {
+		ids = append(ids, item.PictureId)
+	}
+	return ids
+}
+
+// PlaylistItemsAdded returns how many items have been added to playlists.
+func (s *Server) PlaylistItemsAdded() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.itemsAdded
+}
+
+// EmptyOnDeleteItems makes a DELETE of a playlist's items empty it, even if
+// it says which items to remove, in case that's what the real one does.
+func (s *Server) EmptyOnDeleteItems(empty bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emptyItems = empty
+}
+
+// AddFrame adds a frame to the account, showing no playlists.
+func (s *Server) AddFrame(name, serialNumber string) nixplay.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frame := &nixplay.Frame{
+		Id:           s.id(),
+		Name:         name,
+		SerialNumber: serialNumber,
+		Model:        "W10F",
+		Playlists:    []int{},
+	}
+	s.frames = append(s.frames, frame)
+	return *frame
+}
+
+// Frames returns a copy of all the frames.
+func (s *Server) Frames() []nixplay.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var frames []nixplay.Frame
+	for _, f := range s.frames {
+		frame := *f
+		frame.Playlists = append([]int{}, f.Playlists...)
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleLogin is the original client's login: a form POST of email and
+// password, answered with the session and CSRF cookies and a JSON body whose
+// "errors" is a list on success and a map of messages on failure.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	if r.PostForm.Get("email") != s.Username || r.PostForm.Get("password") != s.Password {
+		writeJSON(w, map[string]interface{}{
+			"valid":   false,
+			"success": false,
+			"errors": map[string]interface{}{
+				"__all__": map[string]interface{}{
+					"messages": [][]string{{"Invalid email or password"}},
+				},
+			},
+		})
+		return
+	}
+
+	s.mu.Lock()
+	s.logins++
+	s.session = fmt.Sprintf("session-%d", s.id())
+	s.csrf = fmt.Sprintf("csrf-%d", s.id())
+	session, csrf := s.session, s.csrf
+	s.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: session, Path: "/"})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: csrf, Path: "/"})
+	writeJSON(w, map[string]interface{}{
+		"valid":   true,
+		"success": true,
+		"errors":  []string{},
+		"token":   session,
+	})
+}
+
+// authed wraps h to require a current session, and a matching CSRF token
+// for anything but GET.  The original client sent the CSRF cookie back as
+// X-CSRFToken; a 401 for an expired session is what picsync was asked to
+// handle when it started logging in again, not something seen from Nixplay.
+func (s *Server) authed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		session, csrf := s.session, s.csrf
+		s.mu.Unlock()
+
+		c, err := r.Cookie(sessionCookie)
+		if err != nil || session == "" || c.Value != session {
+			http.Error(w, "not logged in", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != "GET" && r.Header.Get("X-CSRFToken") != csrf {
+			http.Error(w, "CSRF verification failed", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// pathParts splits the URL path, without empty leading or trailing parts.
+func pathParts(r *http.Request) []string {
+	return strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+}
+
+func (s *Server) findAlbum(id int) *nixplay.Album {
+	for _, a := range s.albums {
+		if a.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// handleAlbums is the original client's album listing, /albums/web/json/.
+func (s *Server) handleAlbums(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albums := []*nixplay.Album{}
+	for _, a := range s.albums {
+		a.PhotoCount = len(s.photos[a.ID])
+		albums = append(albums, a)
+	}
+	writeJSON(w, albums)
+}
+
+// handleAlbum handles /album/create/json/, /album/<id>/delete/json/ and
+// /album/<id>/pictures/json/, all as the original client used them.  A
+// short page means there are no more, which is how the original client
+// stopped paging.
+func (s *Server) handleAlbum(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(parts) == 3 && parts[1] == "create" && parts[2] == "json" && r.Method == "POST" {
+		r.ParseForm()
+		album := &nixplay.Album{
+			ID:        s.id(),
+			Title:     r.PostForm.Get("name"),
+			AlbumType: "Web",
+		}
+		s.albums = append(s.albums, album)
+		writeJSON(w, []*nixplay.Album{album})
+		return
+	}
+
+	if len(parts) != 4 || parts[3] != "json" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || s.findAlbum(id) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case parts[2] == "delete" && r.Method == "POST":
+		for i, a := range s.albums {
+			if a.ID == id {
+				s.albums = append(s.albums[:i], s.albums[i+1:]...)
+				break
+			}
+		}
+		delete(s.photos, id)
+		writeJSON(w, map[string]interface{}{})
+	case parts[2] == "pictures" && r.Method == "GET":
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 {
+			limit = 100
+		}
+		photos := []*nixplay.Photo{}
+		all := s.photos[id]
+		for i := (page - 1) * limit; i < len(all) && i < page*limit; i++ {
+			photos = append(photos, all[i])
+		}
+		writeJSON(w, map[string]interface{}{"photos": photos})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handlePicture handles /picture/<id>/delete/json/, as the original client
+// used it, and /picture/<id>/caption/json/.  The caption request is only what
+// pkg/nixplay sends (a form with "caption", shaped like delete); it hasn't
+// been checked against the real service.
+func (s *Server) handlePicture(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r)
+	if len(parts) != 4 || (parts[2] != "delete" && parts[2] != "caption") || r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for albumID, photos := range s.photos {
+		for i, p := range photos {
+			if p.ID == id && parts[2] == "caption" {
+				p.Caption = r.PostForm.Get("caption")
+				writeJSON(w, map[string]interface{}{})
+				return
+			}
+			if p.ID == id {
+				s.photos[albumID] = append(photos[:i], photos[i+1:]...)
+				writeJSON(w, map[string]interface{}{})
+				return
+			}
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// handleReceivers is the original client's first upload step: a form POST
+// with the album ID, answered with an upload token.
+func (s *Server) handleReceivers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	r.ParseForm()
+	albumID, err := strconv.Atoi(r.PostForm.Get("albumId"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || s.findAlbum(albumID) == nil {
+		http.Error(w, "no such album", http.StatusBadRequest)
+		return
+	}
+	token := fmt.Sprintf("receiver-%d", s.id())
+	s.receivers[token] = albumID
+	writeJSON(w, map[string]string{"token": token})
+}
+
+// handlePhotoUpload is the original client's second upload step: a form
+// POST with the upload token and the file's name, type and size, answered
+// with the S3 form fields and where to post them.
+func (s *Server) handlePhotoUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albumID, ok := s.receivers[r.PostForm.Get("uploadToken")]
+	if !ok {
+		http.Error(w, "bad upload token", http.StatusBadRequest)
+		return
+	}
+	key := fmt.Sprintf("upload/%d", s.id())
+	s.uploads[key] = &pendingUpload{
+		albumID:  albumID,
+		filename: r.PostForm.Get("fileName"),
+	}
+	fileSize, _ := strconv.Atoi(r.PostForm.Get("fileSize"))
+	writeJSON(w, map[string]interface{}{
+		"data": map[string]interface{}{
+			"acl":            "private",
+			"key":            key,
+			"AWSAccessKeyId": "fake",
+			"Policy":         "fake",
+			"Signature":      "fake",
+			"userUploadId":   key,
+			"batchUploadId":  key,
+			"fileType":       r.PostForm.Get("fileType"),
+			"fileSize":       fileSize,
+			"s3UploadUrl":    s.URL + "/s3/",
+		},
+	})
+}
+
+// handleS3 is the S3 form upload, the original client's last upload step.
+// Unlike the rest of Nixplay, this doesn't need a login; the key is the
+// authorization.  The photo appears in the album once the upload is done;
+// the real one takes a while (the original client waited 5 seconds before
+// listing the album again).
+func (s *Server) handleS3(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var key string
+	var size int
+	hash := md5.New()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch part.FormName() {
+		case "key":
+			b, _ := io.ReadAll(part)
+			key = string(b)
+		case "file":
+			n, err := io.Copy(hash, part)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			size = int(n)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[key]
+	if !ok || size == 0 {
+		http.Error(w, "bad upload", http.StatusBadRequest)
+		return
+	}
+	delete(s.uploads, key)
+	id := s.id()
+	s.photos[upload.albumID] = append(s.photos[upload.albumID], &nixplay.Photo{
+		ID:         id,
+		AlbumID:    upload.albumID,
+		Filename:   upload.filename,
+		S3Filename: key,
+		Md5:        hex.EncodeToString(hash.Sum(nil)),
+		URL:        fmt.Sprintf("%s/s3/%s", s.URL, key),
+		Published:  true,
+	})
+	w.WriteHeader(http.StatusCreated)
+}
+
+type playlistRequest struct {
+	Name string `json:"name"`
+}
+
+type playlistItemsRequest struct {
+	Items []struct {
+		Id        int `json:"id"`
+		PictureId int `json:"pictureId"`
+	} `json:"items"`
+}
+
+// handlePlaylists lists playlists (GET) and creates them (POST of a JSON
+// name, answered with its playlistId), as the original client did.
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case "GET":
+		playlists := []*nixplay.Playlist{}
+		for _, p := range s.playlists {
+			p.PictureCount = len(s.items[p.Id])
+			playlists = append(playlists, p)
+		}
+		writeJSON(w, playlists)
+	case "POST":
+		var req playlistRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		playlist := &nixplay.Playlist{
+			Id:           s.id(),
+			Name:         req.Name,
+			PlaylistName: req.Name,
+			Type:         "playlist",
+		}
+		s.playlists = append(s.playlists, playlist)
+		writeJSON(w, map[string]int{"playlistId": playlist.Id})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handlePlaylist handles /v3/playlists/<id> and /v3/playlists/<id>/items.
+// The original client emptied a playlist with a DELETE of its items (no
+// body), and added to it with a POST of picture IDs, which appends (its
+// comments say the real one does).  Listing the items (GET), and a DELETE
+// with a body of item IDs that removes only those, are only what pkg/nixplay
+// sends (see EmptyOnDeleteItems).
+func (s *Server) handlePlaylist(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r)
+	if len(parts) == 3 && r.Method == "DELETE" {
+		s.handleDeletePlaylist(w, r, parts[2])
+		return
+	}
+	if len(parts) != 4 || parts[3] != "items" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, p := range s.playlists {
+		found = found || p.Id == id
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		items := []*nixplay.PlaylistItem{}
+		items = append(items, s.items[id]...)
+		writeJSON(w, map[string]interface{}{"items": items})
+	case "DELETE":
+		// With no body, this deletes everything.
+		var req playlistItemsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err == io.EOF || s.emptyItems {
+			delete(s.items, id)
+			writeJSON(w, map[string]interface{}{})
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		remove := make(map[int]bool)
+		for _, item := range req.Items {
+			remove[item.Id] = true
+		}
+		var kept []*nixplay.PlaylistItem
+		for _, item := range s.items[id] {
+			if !remove[item.Id] {
+				kept = append(kept, item)
+			}
+		}
+		s.items[id] = kept
+		writeJSON(w, map[string]interface{}{})
+	case "POST":
+		// Like the real thing, this adds to the playlist; it doesn't
+		// replace it.
+		var req playlistItemsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, item := range req.Items {
+			s.items[id] = append(s.items[id], &nixplay.PlaylistItem{
+				Id:        s.id(),
+				PictureId: item.PictureId,
+			})
+		}
+		s.itemsAdded += len(req.Items)
+		writeJSON(w, map[string]interface{}{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleFrames lists the account's frames.  This is only what pkg/nixplay
+// sends; it hasn't been checked against the real service.
+func (s *Server) handleFrames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames := []*nixplay.Frame{}
+	frames = append(frames, s.frames...)
+	writeJSON(w, frames)
+}
+
+type framePlaylistsRequest struct {
+	Playlists []int `json:"playlists"`
+}
+
+// handleFrame handles /v3/frames/<id>/playlists, a PUT of every playlist
+// the frame shows.  This is only what pkg/nixplay sends; it hasn't been
+// checked against the real service.
+func (s *Server) handleFrame(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r)
+	if len(parts) != 4 || parts[3] != "playlists" || r.Method != "PUT" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var req framePlaylistsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, frame := range s.frames {
+		if frame.Id != id {
+			continue
+		}
+		for _, playlistID := range req.Playlists {
+			found := false
+			for _, p := range s.playlists {
+				found = found || p.Id == playlistID
+			}
+			if !found {
+				http.Error(w, fmt.Sprintf("no playlist %d", playlistID), http.StatusBadRequest)
+				return
+			}
+		}
+		frame.Playlists = append([]int{}, req.Playlists...)
+		writeJSON(w, map[string]interface{}{})
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// handleDeletePlaylist deletes a playlist, and takes it off any frames.  This
+// is only what pkg/nixplay sends; it hasn't been checked against the real
+// service.
+func (s *Server) handleDeletePlaylist(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, p := range s.playlists {
+		if p.Id != id {
+			continue
+		}
+		s.playlists = append(s.playlists[:i], s.playlists[i+1:]...)
+		delete(s.items, id)
+		for _, frame := range s.frames {
+			var kept []int
+			for _, playlistID := range frame.Playlists {
+				if playlistID != id {
+					kept = append(kept, playlistID)
+				}
+			}
+			frame.Playlists = append([]int{}, kept...)
+		}
+		writeJSON(w, map[string]interface{}{})
+		return
+	}
+	http.NotFound(w, r)
+}
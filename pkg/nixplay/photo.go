@@ -39,7 +39,8 @@ func (c *clientImpl) GetPhotos(albumID int, page int, limit int) ([]*Photo, erro
 	}
 	photos := getPhotosResponse{}
 	u := fmt.Sprintf(
-		"https://api.nixplay.com/album/%d/pictures/json/?page=%d&limit=%d",
+		"%s/album/%d/pictures/json/?page=%d&limit=%d",
+		c.baseURL,
 		albumID,
 		page,
 		limit,
@@ -65,12 +66,12 @@ type recResponse struct {
 	Token string `json:"token"`
 }
 
-func getUploadToken(c *http.Client, albumID int) (string, error) {
+func (c *clientImpl) getUploadToken(albumID int) (string, error) {
 	vals := url.Values{
 		"albumId": {fmt.Sprintf("%d", albumID)},
 		"total":   {"1"},
 	}
-	resp, err := doPost(c, "https://api.nixplay.com/v3/upload/receivers/", &vals)
+	resp, err := c.doPost(c.baseURL+"/v3/upload/receivers/", &vals)
 	if err != nil {
 		return "", err
 	}
@@ -114,7 +115,7 @@ type uploader struct {
 	} `json:"data"`
 }
 
-func getUploader(c *http.Client, v uploadVals) (*uploader, error) {
+func (c *clientImpl) getUploader(v uploadVals) (*uploader, error) {
 	vals := url.Values{
 		"uploadToken": {v.Token},
 		"albumId":     {fmt.Sprintf("%d", v.AlbumID)},
@@ -122,7 +123,7 @@ func getUploader(c *http.Client, v uploadVals) (*uploader, error) {
 		"fileType":    {v.FileType},
 		"fileSize":    {fmt.Sprintf("%d", v.FileSize)},
 	}
-	resp, err := doPost(c, "https://api.nixplay.com/v3/photo/upload/", &vals)
+	resp, err := c.doPost(c.baseURL+"/v3/photo/upload/", &vals)
 	if err != nil {
 		return nil, err
 	}
@@ -143,6 +144,7 @@ func getUploader(c *http.Client, v uploadVals) (*uploader, error) {
 }
 
 func uploadS3(u *uploader, filename string, body io.ReadCloser) error {
+	defer body.Close()
 
 	reqBody := &bytes.Buffer{}
 	writer := multipart.NewWriter(reqBody)
@@ -202,14 +204,17 @@ func uploadS3(u *uploader, filename string, body io.ReadCloser) error {
 
 // UploadPhoto uploads a photo to an album
 func (c *clientImpl) UploadPhoto(albumID int, filename string, filetype string, filesize uint64, body io.ReadCloser) error {
-	uploadToken, err := getUploadToken(c.httpClient, albumID)
+	c.uploads.Acquire()
+	defer c.uploads.Release()
+
+	uploadToken, err := c.getUploadToken(albumID)
 	if err != nil {
+		body.Close()
 		c.prom.uploadPhotoFailure.Inc()
 		return err
 	}
 
-	uploader, err := getUploader(
-		c.httpClient,
+	uploader, err := c.getUploader(
 		uploadVals{
 			Token:    uploadToken,
 			AlbumID:  albumID,
@@ -219,6 +224,7 @@ func (c *clientImpl) UploadPhoto(albumID int, filename string, filetype string,
 		},
 	)
 	if err != nil {
+		body.Close()
 		c.prom.uploadPhotoFailure.Inc()
 		return err
 	}
@@ -234,14 +240,14 @@ func (c *clientImpl) UploadPhoto(albumID int, filename string, filetype string,
 }
 
 func (c *clientImpl) DeletePhoto(id int) error {
-	u := fmt.Sprintf("https://api.nixplay.com/picture/%d/delete/json/", id)
+	u := fmt.Sprintf("%s/picture/%d/delete/json/", c.baseURL, id)
 	req, err := http.NewRequest("POST", u, nil)
 	if err != nil {
 		c.prom.deletePhotoFailure.Inc()
 		return err
 	}
 	req.Header.Set("accept", "application/json")
-	res, err := doNixplayCsrf(c.httpClient, req)
+	res, err := c.doNixplayCsrf(req)
 	if err != nil {
 		c.prom.deletePhotoFailure.Inc()
 		return err
@@ -256,3 +262,25 @@ func (c *clientImpl) DeletePhoto(id int) error {
 	// We don't care about what's in the body; 200 OK is good enough
 	return nil
 }
+
+// UpdatePhotoCaption sets the caption of a photo, without changing the
+// image.
+func (c *clientImpl) UpdatePhotoCaption(id int, caption string) error {
+	vals := url.Values{
+		"caption": {caption},
+	}
+	res, err := c.doPost(fmt.Sprintf("%s/picture/%d/caption/json/", c.baseURL, id), &vals)
+	if err != nil {
+		c.prom.updateCaptionFailure.Inc()
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.prom.updateCaptionFailure.Inc()
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("couldn't caption nixplay photo %d: http %d: %s",
+			id, res.StatusCode, resBody)
+	}
+	c.prom.updateCaptionSuccess.Inc()
+	return nil
+}
@@ -45,7 +45,7 @@ func (c *clientImpl) CreatePlaylist(name string) (int, error) {
 		c.prom.createPlaylistFailure.Inc()
 		return -1, err
 	}
-	u := "https://api.nixplay.com/v3/playlists"
+	u := c.baseURL + "/v3/playlists"
 	req, err := http.NewRequest("POST", u, bytes.NewBuffer(body))
 	if err != nil {
 		c.prom.createPlaylistFailure.Inc()
@@ -53,7 +53,7 @@ func (c *clientImpl) CreatePlaylist(name string) (int, error) {
 	}
 	req.Header.Set("accept", "application/json")
 	req.Header.Set("content-type", "application/json")
-	res, err := doNixplayCsrf(c.httpClient, req)
+	res, err := c.doNixplayCsrf(req)
 	if err != nil {
 		c.prom.createPlaylistFailure.Inc()
 		return -1, err
@@ -82,13 +82,13 @@ func (c *clientImpl) CreatePlaylist(name string) (int, error) {
 
 // GetPlaylists gets all configured slideshows for this account
 func (c *clientImpl) GetPlaylists() ([]*Playlist, error) {
-	req, err := http.NewRequest("GET", "https://api.nixplay.com/v3/playlists", nil)
+	req, err := http.NewRequest("GET", c.baseURL+"/v3/playlists", nil)
 	req.Header.Set("accept", "application/json")
 	if err != nil {
 		c.prom.getPlaylistsFailure.Inc()
 		return nil, err
 	}
-	res, err := doNixplayCsrf(c.httpClient, req)
+	res, err := c.doNixplayCsrf(req)
 	if err != nil {
 		c.prom.getPlaylistsFailure.Inc()
 		return nil, err
@@ -112,21 +112,147 @@ func (c *clientImpl) GetPlaylists() ([]*Playlist, error) {
 // GetPlaylistByName gets a particular slideshow by the name
 //
 // Playlist names are not guaranteed unique - if you have defined multiple
-// playlists with the same name, then the first one found will be returned.
+// playlists with the same name, this is an error (like albums, see
+// DeletePlaylistsByName).
 func (c *clientImpl) GetPlaylistByName(name string) (*Playlist, error) {
 	playlists, err := c.GetPlaylists()
 	if err != nil {
 		c.prom.getPlaylistByNameFailure.Inc()
 		return nil, err
 	}
+	matching := playlistsNamed(playlists, name)
+	if len(matching) == 0 {
+		c.prom.getPlaylistByNameFailure.Inc()
+		return nil, fmt.Errorf("did not find playlist \"%s\" in %d playlists", name, len(playlists))
+	}
+	if len(matching) > 1 {
+		c.prom.getPlaylistByNameFailure.Inc()
+		return nil, fmt.Errorf(
+			"multiple nixplay playlists named %s, you must delete all but one",
+			name,
+		)
+	}
+	c.prom.getPlaylistByNameSuccess.Inc()
+	return matching[0], nil
+}
+
+// GetPlaylistsByName gets all the playlists named name (there can be more
+// than one).
+func (c *clientImpl) GetPlaylistsByName(name string) ([]*Playlist, error) {
+	playlists, err := c.GetPlaylists()
+	if err != nil {
+		c.prom.getPlaylistByNameFailure.Inc()
+		return nil, err
+	}
+	matching := playlistsNamed(playlists, name)
+	if len(matching) == 0 {
+		c.prom.getPlaylistByNameFailure.Inc()
+	} else {
+		c.prom.getPlaylistByNameSuccess.Inc()
+	}
+	return matching, nil
+}
+
+func playlistsNamed(playlists []*Playlist, name string) []*Playlist {
+	var matching []*Playlist
 	for _, playlist := range playlists {
 		if playlist.Name == name {
-			c.prom.getPlaylistByNameSuccess.Inc()
-			return playlist, nil
+			matching = append(matching, playlist)
+		}
+	}
+	return matching
+}
+
+// DeletePlaylistByID deletes a playlist.  The photos in it are not deleted.
+func (c *clientImpl) DeletePlaylistByID(playlistId int) error {
+	u := fmt.Sprintf("%s/v3/playlists/%d", c.baseURL, playlistId)
+	req, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		c.prom.deletePlaylistFailure.Inc()
+		return err
+	}
+	req.Header.Set("accept", "application/json")
+	res, err := c.doNixplayCsrf(req)
+	if err != nil {
+		c.prom.deletePlaylistFailure.Inc()
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.prom.deletePlaylistFailure.Inc()
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("couldn't delete playlist %d: http %d: %s", playlistId,
+			res.StatusCode, resBody)
+	}
+	c.prom.deletePlaylistSuccess.Inc()
+	return nil
+}
+
+// DeletePlaylistsByName deletes the playlist named name.  If there is more
+// than one, they are all deleted if allowMultiple, otherwise none are.
+func (c *clientImpl) DeletePlaylistsByName(name string, allowMultiple bool) (int, error) {
+	matching, err := c.GetPlaylistsByName(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(matching) > 1 && !allowMultiple {
+		return 0, fmt.Errorf(
+			"%d playlists named %s, but only allowed to delete one (see \"--delete-multiple\")",
+			len(matching),
+			name,
+		)
+	}
+	var deletedCount int
+	for _, playlist := range matching {
+		err := c.DeletePlaylistByID(playlist.Id)
+		if err != nil {
+			return deletedCount, err
 		}
+		deletedCount++
 	}
-	c.prom.getPlaylistByNameFailure.Inc()
-	return nil, fmt.Errorf("did not find playlist \"%s\" in %d playlists", name, len(playlists))
+	return deletedCount, nil
+}
+
+// PlaylistItem is one slide in a playlist.  The same photo can be in a
+// playlist more than once, as different items.
+type PlaylistItem struct {
+	Id        int `json:"id"`
+	PictureId int `json:"pictureId"`
+}
+
+type playlistItemsResponse struct {
+	Items []*PlaylistItem `json:"items"`
+}
+
+// GetPlaylistItems returns the items in a playlist, in the order they're
+// shown.
+func (c *clientImpl) GetPlaylistItems(playlistId int) ([]*PlaylistItem, error) {
+	u := fmt.Sprintf("%s/v3/playlists/%d/items", c.baseURL, playlistId)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		c.prom.getPlaylistItemsFailure.Inc()
+		return nil, err
+	}
+	req.Header.Set("accept", "application/json")
+	res, err := c.doNixplayCsrf(req)
+	if err != nil {
+		c.prom.getPlaylistItemsFailure.Inc()
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.prom.getPlaylistItemsFailure.Inc()
+		resBody, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("couldn't get playlist items: http %d: %s", res.StatusCode, resBody)
+	}
+	var items playlistItemsResponse
+	err = json.NewDecoder(res.Body).Decode(&items)
+	if err != nil {
+		c.prom.getPlaylistItemsFailure.Inc()
+		return nil, err
+	}
+	c.prom.getPlaylistItemsSuccess.Inc()
+	return items.Items, nil
 }
 
 type publishPlaylistDataItem struct {
@@ -136,79 +262,105 @@ type publishPlaylistData struct {
 	Items []publishPlaylistDataItem `json:"items"`
 }
 
-func (c *clientImpl) PublishPlaylist(playlistId int, photos []*Photo) error {
+// AddPlaylistItems adds photos to the end of a playlist, in order.
+func (c *clientImpl) AddPlaylistItems(playlistId int, photos []*Photo) error {
 	data := publishPlaylistData{}
 	for _, p := range photos {
 		data.Items = append(data.Items, publishPlaylistDataItem{
 			PictureId: p.ID,
 		})
 	}
-	body, err := json.Marshal(data)
+	err := c.playlistItemsRequest("POST", playlistId, data)
 	if err != nil {
-		c.prom.publishPlaylistFailure.Inc()
-		return err
+		c.prom.addPlaylistItemsFailure.Inc()
+		return fmt.Errorf("couldn't add items to playlist: %v", err)
 	}
+	c.prom.addPlaylistItemsSuccess.Inc()
+	return nil
+}
 
-	// First we have to delete all items (sigh)
-	//
-	// FIXME: I think there's an API for deleting individual items; we'd have
-	// to repeat the computeWork approach.  As implemented, we're wastefully
-	// clearing the playlist and then re-writing it; this is just metadata
-	// (an array of photo IDs, just integers), so the waste isn't very big
-	// (like deleting/uploading images).  As well, it only happens when an
-	// image is actually changed.
-	// If you don't delete all the items, then the POST just adds items again,
-	// e.g. if your album has 384 pictures in it and you added one, then your
-	// playlist ends up with 384+385 pictures in it (two copies of each old, and
-	// one copy of the new), and this repeats until you hit the 2000-photo limit.
-	u := fmt.Sprintf("https://api.nixplay.com/v3/playlists/%d/items", playlistId)
-	req, err := http.NewRequest("DELETE", u, nil)
+type removePlaylistDataItem struct {
+	Id int `json:"id"`
+}
+type removePlaylistData struct {
+	Items []removePlaylistDataItem `json:"items"`
+}
+
+// RemovePlaylistItems removes items (by PlaylistItem.Id, not the photo's ID)
+// from a playlist.  The rest stay in the same order.
+//
+// This is the same DELETE that empties a playlist (see PublishPlaylist), with
+// the items in the body.  It hasn't been seen working against the real
+// Nixplay, which might ignore the body, so callers should list the items
+// afterwards to check.
+func (c *clientImpl) RemovePlaylistItems(playlistId int, itemIds []int) error {
+	data := removePlaylistData{}
+	for _, id := range itemIds {
+		data.Items = append(data.Items, removePlaylistDataItem{Id: id})
+	}
+	err := c.playlistItemsRequest("DELETE", playlistId, data)
+	if err != nil {
+		c.prom.removePlaylistItemsFailure.Inc()
+		return fmt.Errorf("couldn't remove items from playlist: %v", err)
+	}
+	c.prom.removePlaylistItemsSuccess.Inc()
+	return nil
+}
+
+// playlistItemsRequest sends data (or nothing, if nil) to a playlist's
+// items.  We don't care about what's in the body of a 200 OK.
+func (c *clientImpl) playlistItemsRequest(method string, playlistId int, data interface{}) error {
+	var body io.Reader
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(b)
+	}
+	u := fmt.Sprintf("%s/v3/playlists/%d/items", c.baseURL, playlistId)
+	req, err := http.NewRequest(method, u, body)
 	if err != nil {
-		c.prom.publishPlaylistFailure.Inc()
 		return err
 	}
+	if data != nil {
+		req.Header.Set("content-type", "application/json")
+	}
 	req.Header.Set("accept", "application/json")
-	res, err := doNixplayCsrf(c.httpClient, req)
+	res, err := c.doNixplayCsrf(req)
 	if err != nil {
-		c.prom.publishPlaylistFailure.Inc()
 		return err
 	}
 	defer res.Body.Close()
 	resBody, err := io.ReadAll(res.Body)
 	if err != nil {
-		c.prom.publishPlaylistFailure.Inc()
 		return err
 	}
 	if res.StatusCode != http.StatusOK {
-		c.prom.publishPlaylistFailure.Inc()
-		return fmt.Errorf("couldn't delete items from playlist: http %d: %s", res.StatusCode, resBody)
+		return fmt.Errorf("http %d: %s", res.StatusCode, resBody)
 	}
+	return nil
+}
 
-	// Now POST back the list of items for the playlist.
-	req, err = http.NewRequest("POST", u, bytes.NewBuffer(body))
-	if err != nil {
-		c.prom.publishPlaylistFailure.Inc()
-		return err
-	}
-	req.Header.Set("content-type", "application/json")
-	req.Header.Set("accept", "application/json")
-	res, err = doNixplayCsrf(c.httpClient, req)
+// PublishPlaylist replaces everything in a playlist with photos.  Frames
+// briefly show an empty playlist while this happens; syncs only use it if
+// updating the playlist item by item fails.
+func (c *clientImpl) PublishPlaylist(playlistId int, photos []*Photo) error {
+	// If you don't delete all the items, then the POST just adds items again,
+	// e.g. if your album has 384 pictures in it and you added one, then your
+	// playlist ends up with 384+385 pictures in it (two copies of each old, and
+	// one copy of the new), and this repeats until you hit the 2000-photo limit.
+	err := c.playlistItemsRequest("DELETE", playlistId, nil)
 	if err != nil {
 		c.prom.publishPlaylistFailure.Inc()
-		return err
+		return fmt.Errorf("couldn't delete items from playlist: %v", err)
 	}
-	defer res.Body.Close()
-	resBody, err = io.ReadAll(res.Body)
+
+	err = c.AddPlaylistItems(playlistId, photos)
 	if err != nil {
 		c.prom.publishPlaylistFailure.Inc()
-		return err
-	}
-	if res.StatusCode != http.StatusOK {
-		c.prom.publishPlaylistFailure.Inc()
-		return fmt.Errorf("couldn't publish playlist: http %d: %s", res.StatusCode, resBody)
+		return fmt.Errorf("couldn't publish playlist: %v", err)
 	}
-
 	c.prom.publishPlaylistSuccess.Inc()
-	// If we got 200 OK, we don't care about the body.
 	return nil
 }
@@ -8,31 +8,52 @@ import (
 type promImpl struct {
 	promFactory promauto.Factory
 
-	getAlbumsSuccess         prometheus.Counter
-	getAlbumsFailure         prometheus.Counter
-	getAlbumByNameSuccess    prometheus.Counter
-	getAlbumByNameFailure    prometheus.Counter
-	getAlbumByNameEmpty      prometheus.Counter
-	getPhotosSuccess         prometheus.Counter
-	getPhotosFailure         prometheus.Counter
-	getPhotosPhotoCount      prometheus.Counter
-	uploadPhotoSuccess       prometheus.Counter
-	uploadPhotoFailure       prometheus.Counter
-	uploadPhotoTotalBytes    prometheus.Counter
-	deletePhotoSuccess       prometheus.Counter
-	deletePhotoFailure       prometheus.Counter
-	createAlbumSuccess       prometheus.Counter
-	createAlbumFailure       prometheus.Counter
-	deleteAlbumSuccess       prometheus.Counter
-	deleteAlbumFailure       prometheus.Counter
-	createPlaylistSuccess    prometheus.Counter
-	createPlaylistFailure    prometheus.Counter
-	getPlaylistsSuccess      prometheus.Counter
-	getPlaylistsFailure      prometheus.Counter
-	getPlaylistByNameSuccess prometheus.Counter
-	getPlaylistByNameFailure prometheus.Counter
-	publishPlaylistSuccess   prometheus.Counter
-	publishPlaylistFailure   prometheus.Counter
+	getAlbumsSuccess           prometheus.Counter
+	getAlbumsFailure           prometheus.Counter
+	getAlbumByNameSuccess      prometheus.Counter
+	getAlbumByNameFailure      prometheus.Counter
+	getAlbumByNameEmpty        prometheus.Counter
+	getPhotosSuccess           prometheus.Counter
+	getPhotosFailure           prometheus.Counter
+	getPhotosPhotoCount        prometheus.Counter
+	uploadPhotoSuccess         prometheus.Counter
+	uploadPhotoFailure         prometheus.Counter
+	uploadPhotoTotalBytes      prometheus.Counter
+	uploadPhotoInFlight        prometheus.Gauge
+	deletePhotoSuccess         prometheus.Counter
+	deletePhotoFailure         prometheus.Counter
+	updateCaptionSuccess       prometheus.Counter
+	updateCaptionFailure       prometheus.Counter
+	createAlbumSuccess         prometheus.Counter
+	createAlbumFailure         prometheus.Counter
+	deleteAlbumSuccess         prometheus.Counter
+	deleteAlbumFailure         prometheus.Counter
+	createPlaylistSuccess      prometheus.Counter
+	createPlaylistFailure      prometheus.Counter
+	getPlaylistsSuccess        prometheus.Counter
+	getPlaylistsFailure        prometheus.Counter
+	getPlaylistByNameSuccess   prometheus.Counter
+	getPlaylistByNameFailure   prometheus.Counter
+	deletePlaylistSuccess      prometheus.Counter
+	deletePlaylistFailure      prometheus.Counter
+	publishPlaylistSuccess     prometheus.Counter
+	publishPlaylistFailure     prometheus.Counter
+	getPlaylistItemsSuccess    prometheus.Counter
+	getPlaylistItemsFailure    prometheus.Counter
+	addPlaylistItemsSuccess    prometheus.Counter
+	addPlaylistItemsFailure    prometheus.Counter
+	removePlaylistItemsSuccess prometheus.Counter
+	removePlaylistItemsFailure prometheus.Counter
+	getFramesSuccess           prometheus.Counter
+	getFramesFailure           prometheus.Counter
+	getFrameByNameSuccess      prometheus.Counter
+	getFrameByNameFailure      prometheus.Counter
+	setFramePlaylistsSuccess   prometheus.Counter
+	setFramePlaylistsFailure   prometheus.Counter
+	loginSuccess               prometheus.Counter
+	loginFailure               prometheus.Counter
+	httpRetries                *prometheus.CounterVec
+	httpFailures               *prometheus.CounterVec
 }
 
 func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
@@ -98,6 +119,18 @@ func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
 			Help: "Failures when deleting photo",
 		},
 	)
+	c.prom.updateCaptionSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_update_caption_success",
+			Help: "Photo captions updated successfully",
+		},
+	)
+	c.prom.updateCaptionFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_update_caption_failure",
+			Help: "Failures when updating a photo caption",
+		},
+	)
 	c.prom.uploadPhotoSuccess = c.prom.promFactory.NewCounter(
 		prometheus.CounterOpts{
 			Name: "nixplay_upload_photos_success",
@@ -116,6 +149,12 @@ func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
 			Help: "Total count of bytes of photos successfully uploaded",
 		},
 	)
+	c.prom.uploadPhotoInFlight = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nixplay_upload_photos_in_flight",
+			Help: "Number of photo uploads currently in progress",
+		},
+	)
 	c.prom.createAlbumSuccess = c.prom.promFactory.NewCounter(
 		prometheus.CounterOpts{
 			Name: "nixplay_create_album_success",
@@ -177,6 +216,18 @@ func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
 			Help: "Failed calls to get an playlist by name",
 		},
 	)
+	c.prom.deletePlaylistSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_delete_playlist_success",
+			Help: "Successful calls to delete a playlist",
+		},
+	)
+	c.prom.deletePlaylistFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_delete_playlist_failure",
+			Help: "Failed calls to delete a playlist",
+		},
+	)
 	c.prom.publishPlaylistSuccess = c.prom.promFactory.NewCounter(
 		prometheus.CounterOpts{
 			Name: "nixplay_publish_playlist_success",
@@ -189,6 +240,104 @@ func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
 			Help: "Failed calls to publish a playlist",
 		},
 	)
+	c.prom.getPlaylistItemsSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_get_playlist_items_success",
+			Help: "Successful calls to list the items in a playlist",
+		},
+	)
+	c.prom.getPlaylistItemsFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_get_playlist_items_failure",
+			Help: "Failed calls to list the items in a playlist",
+		},
+	)
+	c.prom.addPlaylistItemsSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_add_playlist_items_success",
+			Help: "Successful calls to add items to a playlist",
+		},
+	)
+	c.prom.addPlaylistItemsFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_add_playlist_items_failure",
+			Help: "Failed calls to add items to a playlist",
+		},
+	)
+	c.prom.removePlaylistItemsSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_remove_playlist_items_success",
+			Help: "Successful calls to remove items from a playlist",
+		},
+	)
+	c.prom.removePlaylistItemsFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_remove_playlist_items_failure",
+			Help: "Failed calls to remove items from a playlist",
+		},
+	)
+	c.prom.getFramesSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_get_frames_success",
+			Help: "Successful calls to list the user's frames",
+		},
+	)
+	c.prom.getFramesFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_get_frames_failure",
+			Help: "Failed calls to list the user's frames",
+		},
+	)
+	c.prom.getFrameByNameSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_get_frame_by_name_success",
+			Help: "Successful calls to get a frame by name",
+		},
+	)
+	c.prom.getFrameByNameFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_get_frame_by_name_failure",
+			Help: "Failed calls to get a frame by name",
+		},
+	)
+	c.prom.setFramePlaylistsSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_set_frame_playlists_success",
+			Help: "Successful calls to set the playlists a frame shows",
+		},
+	)
+	c.prom.setFramePlaylistsFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_set_frame_playlists_failure",
+			Help: "Failed calls to set the playlists a frame shows",
+		},
+	)
+	c.prom.loginSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_login_success",
+			Help: "Successful logins (including logging in again when a session expires)",
+		},
+	)
+	c.prom.loginFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nixplay_login_failure",
+			Help: "Failed logins",
+		},
+	)
+	c.prom.httpRetries = c.prom.promFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nixplay_http_retries",
+			Help: "Calls to Nixplay that failed and were retried",
+		},
+		[]string{"endpoint"},
+	)
+	c.prom.httpFailures = c.prom.promFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nixplay_http_failures",
+			Help: "Calls to Nixplay that still failed after any retries",
+		},
+		[]string{"endpoint"},
+	)
 
 	return nil
 }
@@ -0,0 +1,232 @@
+package nixplay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+type savedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// persistentJar is a cookie jar that remembers every cookie it is given, so
+// they can be saved to a file and loaded by the next run.
+type persistentJar struct {
+	*cookiejar.Jar
+
+	mu       sync.Mutex
+	filename string
+	saved    map[string]savedCookie
+}
+
+// newPersistentJar returns a jar, loading any cookies saved in filename.  If
+// filename is empty, the jar is only kept in memory.
+func newPersistentJar(filename string) (*persistentJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{
+		PublicSuffixList: publicsuffix.List,
+	})
+	if err != nil {
+		return nil, err
+	}
+	j := &persistentJar{
+		Jar:      jar,
+		filename: filename,
+		saved:    make(map[string]savedCookie),
+	}
+	if filename == "" {
+		return j, nil
+	}
+
+	in, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cookies []savedCookie
+	if err = json.Unmarshal(in, &cookies); err != nil {
+		return nil, fmt.Errorf("bad Nixplay cookie file %s: %v", filename, err)
+	}
+	for _, c := range cookies {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return nil, fmt.Errorf("bad Nixplay cookie file %s: %v", filename, err)
+		}
+		j.Jar.SetCookies(u, []*http.Cookie{c.Cookie})
+		j.saved[cookieKey(u, c.Cookie)] = c
+	}
+	return j, nil
+}
+
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := c.Domain
+	if domain == "" {
+		domain = u.Host
+	}
+	return domain + "|" + c.Path + "|" + c.Name
+}
+
+// SetCookies implements http.CookieJar, saving the cookies if there is a
+// file to save to.
+func (j *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		key := cookieKey(u, c)
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(time.Now())) {
+			delete(j.saved, key)
+			continue
+		}
+		toSave := *c
+		if toSave.MaxAge > 0 {
+			// MaxAge is relative to now, which won't be true when loaded.
+			toSave.Expires = time.Now().Add(time.Duration(toSave.MaxAge) * time.Second)
+			toSave.MaxAge = 0
+		}
+		j.saved[key] = savedCookie{URL: u.String(), Cookie: &toSave}
+	}
+	if j.filename == "" {
+		return
+	}
+	if err := j.save(); err != nil {
+		fmt.Printf("Warning: couldn't save Nixplay cookies to %s: %v\n", j.filename, err)
+	}
+}
+
+func (j *persistentJar) save() error {
+	var keys []string
+	for key := range j.saved {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var cookies []savedCookie
+	for _, key := range keys {
+		cookies = append(cookies, j.saved[key])
+	}
+	out, err := json.MarshalIndent(cookies, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename, so a crash can't leave a half-written file.
+	tmp := j.filename + ".tmp"
+	if err = os.WriteFile(tmp, out, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.filename)
+}
+
+func csrfToken(jar http.CookieJar, u *url.URL) (string, error) {
+	var token string
+	for _, cookie := range jar.Cookies(u) {
+		if cookie.Name == "prod.csrftoken" {
+			if token != "" {
+				return "", fmt.Errorf(
+					"multiple Nixplay CSRF protection cookies (%s)",
+					token,
+				)
+			}
+			token = cookie.Value
+		}
+	}
+	return token, nil
+}
+
+// session tracks the login, so that if it expires we can log in again.
+type session struct {
+	loginURL string
+	username string
+	password string
+	jar      *persistentJar
+	prom     *promImpl
+
+	mu sync.Mutex
+	// generation counts logins.  A request that fails remembers the
+	// generation it was sent with, so that if several fail at once we only
+	// log in again once.
+	generation int
+}
+
+func (s *session) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation
+}
+
+// login logs in if nobody has since seenGeneration.
+func (s *session) login(seenGeneration int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.generation != seenGeneration {
+		return nil
+	}
+	_, err := doLogin(s.loginURL, s.username, s.password, s.jar)
+	if err != nil {
+		s.prom.loginFailure.Inc()
+		return err
+	}
+	s.prom.loginSuccess.Inc()
+	s.generation++
+	return nil
+}
+
+// sessionTransport logs in again and repeats a request if Nixplay says the
+// session has expired (401 or 403).
+type sessionTransport struct {
+	base    http.RoundTripper
+	session *session
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	generation := t.session.current()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return resp, nil
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// Can't send it again.
+		return resp, nil
+	}
+	if err := t.session.login(generation); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Nixplay session expired and login failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// The http.Client put the old cookies (and maybe CSRF token) on the
+	// request; replace them with the new ones.
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Del("Cookie")
+	for _, c := range t.session.jar.Cookies(req.URL) {
+		retry.AddCookie(c)
+	}
+	if retry.Header.Get("X-CSRFToken") != "" {
+		token, err := csrfToken(t.session.jar, req.URL)
+		if err != nil {
+			return nil, err
+		}
+		retry.Header.Set("X-CSRFToken", token)
+	}
+	return t.base.RoundTrip(retry)
+}
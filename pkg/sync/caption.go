@@ -0,0 +1,146 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// CaptionData is what a caption template can use, for one source item.
+type CaptionData struct {
+	Description  string
+	Filename     string
+	CreationTime time.Time
+	Source       string
+}
+
+// Captions makes a caption for each item from a text/template, like
+// `{{.Description}} - {{.CreationTime | date "Jan 2006"}}`.  The template is
+// given a CaptionData.
+type Captions struct {
+	tmpl *template.Template
+}
+
+var captionFuncs = template.FuncMap{
+	// date formats a time with a Go layout, or is empty if the time isn't
+	// known.
+	"date": func(layout string, t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(layout)
+	},
+}
+
+// NewCaptions parses a caption template.
+func NewCaptions(text string) (*Captions, error) {
+	tmpl, err := template.New("caption").Funcs(captionFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Captions{tmpl: tmpl}, nil
+}
+
+// Caption returns the caption for item, which came from the named source.
+// Leading and trailing space is trimmed.
+func (c *Captions) Caption(item SourceItem, source string) (string, error) {
+	var out bytes.Buffer
+	err := c.tmpl.Execute(&out, CaptionData{
+		Description:  item.Description(),
+		Filename:     item.Filename(),
+		CreationTime: item.Created(),
+		Source:       source,
+	})
+	if err != nil {
+		return "", fmt.Errorf("caption for %s: %v", item.Filename(), err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// CaptionedItem is a DestinationItem that has a caption.
+type CaptionedItem interface {
+	Caption() string
+}
+
+// Captioner is a Destination that can change the captions of its items
+// without uploading them again.
+type Captioner interface {
+	SetCaption(item DestinationItem, caption string) error
+}
+
+// CaptionUpdate is a destination item whose caption must change.
+type CaptionUpdate struct {
+	Item    DestinationItem
+	Caption string
+}
+
+// captionsByMd5 returns the caption for every source item, by MD5.
+func captionsByMd5(captions *Captions, sources []Source, sourceItems []SourceItem, sourceOf map[SourceItem]int) (map[string]string, error) {
+	byMd5 := make(map[string]string, len(sourceItems))
+	for _, item := range sourceItems {
+		caption, err := captions.Caption(item, sources[sourceOf[item]].Name())
+		if err != nil {
+			return nil, err
+		}
+		byMd5[item.Md5()] = caption
+	}
+	return byMd5, nil
+}
+
+// CalcCaptions returns the destination items whose captions are not what
+// byMd5 (the caption for each source item, by MD5) says.  Items that aren't
+// from a source are left alone.
+func CalcCaptions(byMd5 map[string]string, destItems []DestinationItem) []CaptionUpdate {
+	var updates []CaptionUpdate
+	for _, item := range destItems {
+		caption, ok := byMd5[item.Md5()]
+		if !ok {
+			continue
+		}
+		var current string
+		if captioned, ok := item.(CaptionedItem); ok {
+			current = captioned.Caption()
+		}
+		if current != caption {
+			updates = append(updates, CaptionUpdate{Item: item, Caption: caption})
+		}
+	}
+	return updates
+}
+
+// caption sets the captions in work.  Items that were just uploaded aren't
+// in work.ToCaption (they had no destination item yet), so if there were
+// uploads the destination is listed again to find them.
+func (s *syncerImpl) caption(work *Work, dest Destination) error {
+	if work.captions == nil {
+		return nil
+	}
+	captioner := dest.(Captioner)
+	updates := work.ToCaption
+	if len(work.ToUpload) > 0 {
+		destItems, err := dest.Items(func(DestinationItem) {})
+		if err != nil {
+			return err
+		}
+		uploaded := make(map[string]string, len(work.ToUpload))
+		for _, item := range work.ToUpload {
+			uploaded[item.Md5()] = work.captions[item.Md5()]
+		}
+		updates = append(updates, CalcCaptions(uploaded, destItems)...)
+	}
+
+	for i, update := range updates {
+		fmt.Fprintf(os.Stdout, "\033[2K\rCaptioning image %d/%d...", i+1, len(updates))
+		err := captioner.SetCaption(update.Item, update.Caption)
+		if err != nil {
+			fmt.Printf("\nError captioning photo %s (skipping): %v\n", update.Item.Filename(), err)
+		}
+	}
+	if len(updates) > 0 {
+		fmt.Printf("DONE.  Captioning complete.\n")
+	}
+	return nil
+}
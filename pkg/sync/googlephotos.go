@@ -0,0 +1,275 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+)
+
+type googlephotosAlbumSource struct {
+	client googlephotos.Client
+	ref    googlephotos.AlbumRef
+	videos googlephotos.VideoPolicy
+
+	// match, if set, picks which items to return, before they are hashed.
+	match func(*googlephotos.MediaItem) bool
+}
+
+// NewGooglephotosAlbumSource returns a Source for every item in a Google
+// Photos album (or, if ref is by title, every album it matches).  Titles
+// are resolved each time Items is called.  Items are hashed via the Google
+// Photos cache.  Videos are synced as videos says.
+func NewGooglephotosAlbumSource(client googlephotos.Client, ref googlephotos.AlbumRef, videos googlephotos.VideoPolicy) Source {
+	return &googlephotosAlbumSource{
+		client: client,
+		ref:    ref,
+		videos: videos,
+	}
+}
+
+func (s *googlephotosAlbumSource) Name() string {
+	if s.ref.IsTitle() {
+		return fmt.Sprintf("googlephotos %s", s.ref)
+	}
+	return fmt.Sprintf("googlephotos album %s", s.ref)
+}
+
+func (s *googlephotosAlbumSource) Items(progress SourceProgressFunc) ([]SourceItem, error) {
+	ctx := context.Background()
+	albumIds, err := s.client.ResolveAlbumRef(ctx, s.ref, false)
+	if err != nil {
+		return nil, err
+	}
+	if s.ref.IsTitle() {
+		fmt.Printf("Google Photos %s is album(s) %s\n", s.ref, strings.Join(albumIds, ", "))
+	}
+	items, err := s.albumsItems(albumIds, progress)
+	if err == nil || !s.ref.IsTitle() {
+		return items, err
+	}
+
+	// Maybe an album the title resolved to before (and was cached) is gone.
+	// Look it up again, and only try again if that changes anything.
+	freshIds, freshErr := s.client.ResolveAlbumRef(ctx, s.ref, true)
+	if freshErr != nil || strings.Join(freshIds, " ") == strings.Join(albumIds, " ") {
+		return nil, err
+	}
+	fmt.Printf("Google Photos %s is now album(s) %s\n", s.ref, strings.Join(freshIds, ", "))
+	return s.albumsItems(freshIds, progress)
+}
+
+func (s *googlephotosAlbumSource) albumsItems(albumIds []string, progress SourceProgressFunc) ([]SourceItem, error) {
+	var items []SourceItem
+	for _, albumId := range albumIds {
+		albumId := albumId
+		albumItems, err := googlephotosPages(s.client, progress,
+			func(pageToken string, cb googlephotos.UpdateCacheCallback) (*googlephotos.UpdateCacheResult, error) {
+				res, err := s.client.ListMediaItemsForAlbumId(albumId, pageToken)
+				if err != nil {
+					return nil, err
+				}
+				return s.client.UpdateCache(matching(res, s.match), s.videos, cb)
+			})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, albumItems...)
+	}
+	return items, nil
+}
+
+type googlephotosSearchSource struct {
+	client googlephotos.Client
+	search googlephotos.Search
+	album  *googlephotos.AlbumRef
+	videos googlephotos.VideoPolicy
+}
+
+// NewGooglephotosSearchSource returns a Source for every item in the Google
+// Photos library that matches search, or if album isn't nil, every item in
+// album that matches (which can only use what search.Matches can check).
+// Dates in search are worked out each time Items is called, so what it
+// returns changes as days go by.  Videos are synced as videos says.
+func NewGooglephotosSearchSource(client googlephotos.Client, search googlephotos.Search, album *googlephotos.AlbumRef, videos googlephotos.VideoPolicy) Source {
+	return &googlephotosSearchSource{
+		client: client,
+		search: search,
+		album:  album,
+		videos: videos,
+	}
+}
+
+func (s *googlephotosSearchSource) Name() string {
+	if s.album != nil {
+		return fmt.Sprintf("googlephotos search %s in album %s", &s.search, s.album)
+	}
+	return fmt.Sprintf("googlephotos search %s", &s.search)
+}
+
+func (s *googlephotosSearchSource) Items(progress SourceProgressFunc) ([]SourceItem, error) {
+	now := time.Now()
+	filters, err := s.search.Filters(now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Google can't do all of the date checks (and can't do any for albums),
+	// so check everything here too, before anything is downloaded.
+	var listed, matched int
+	match := func(item *googlephotos.MediaItem) bool {
+		listed++
+		if !s.search.Matches(item, now) {
+			return false
+		}
+		matched++
+		return true
+	}
+
+	var items []SourceItem
+	if s.album != nil {
+		albumSource := &googlephotosAlbumSource{
+			client: s.client,
+			ref:    *s.album,
+			videos: s.videos,
+			match:  match,
+		}
+		items, err = albumSource.Items(progress)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ctx := context.Background()
+		for _, f := range filters {
+			f := f
+			found, err := googlephotosPages(s.client, progress,
+				func(pageToken string, cb googlephotos.UpdateCacheCallback) (*googlephotos.UpdateCacheResult, error) {
+					res, err := s.client.SearchMediaItems(ctx, &googlephotos.SearchMediaItemsRequest{
+						PageSize:  100,
+						PageToken: pageToken,
+						Filters:   f,
+					})
+					if err != nil {
+						return nil, err
+					}
+					return s.client.UpdateCache(matching(res, match), s.videos, cb)
+				})
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, found...)
+		}
+	}
+
+	if matched != listed {
+		fmt.Printf("\n%d of %d items match %s as of %s\n",
+			matched, listed, &s.search, now.Format("2006-01-02"))
+	}
+	return items, nil
+}
+
+// matching returns page with only the items that match accepts (all of
+// them, if match is nil).
+func matching(page *googlephotos.SearchMediaItemsResponse, match func(*googlephotos.MediaItem) bool) *googlephotos.SearchMediaItemsResponse {
+	if match == nil {
+		return page
+	}
+	matched := &googlephotos.SearchMediaItemsResponse{NextPageToken: page.NextPageToken}
+	for _, item := range page.MediaItems {
+		if match(item) {
+			matched.MediaItems = append(matched.MediaItems, item)
+		}
+	}
+	return matched
+}
+
+// googlephotosPages calls page for each page of results, and returns all
+// the items.
+func googlephotosPages(
+	client googlephotos.Client,
+	progress SourceProgressFunc,
+	page func(pageToken string, cb googlephotos.UpdateCacheCallback) (*googlephotos.UpdateCacheResult, error),
+) ([]SourceItem, error) {
+	var items []SourceItem
+	cb := func(cached *googlephotos.CachedMediaItem) {
+		progress(&googlephotosItem{client: client, cached: cached})
+	}
+
+	var nextPageToken string
+	for ok := true; ok; ok = (nextPageToken != "") {
+		res, err := page(nextPageToken, cb)
+		if err != nil {
+			return nil, err
+		}
+		nextPageToken = res.NextPageToken
+		for _, cached := range res.CachedMediaItems {
+			items = append(items, &googlephotosItem{
+				client: client,
+				cached: cached,
+				poster: cached.Video != nil && cached.Video.Poster,
+			})
+		}
+	}
+	return items, nil
+}
+
+type googlephotosItem struct {
+	client googlephotos.Client
+	cached *googlephotos.CachedMediaItem
+
+	// poster is set to sync a still frame of a video instead of the video.
+	poster bool
+}
+
+func (i *googlephotosItem) ID() string { return i.cached.MediaItem.Id }
+
+func (i *googlephotosItem) Filename() string {
+	if i.poster {
+		name := i.cached.MediaItem.Filename
+		return strings.TrimSuffix(name, path.Ext(name)) + ".jpg"
+	}
+	return i.cached.MediaItem.Filename
+}
+
+func (i *googlephotosItem) Md5() string {
+	if i.poster {
+		return i.cached.Video.PosterMd5
+	}
+	return i.cached.Md5
+}
+
+func (i *googlephotosItem) Sha256() string {
+	if i.poster {
+		return i.cached.Video.PosterSha256
+	}
+	return i.cached.Sha256
+}
+
+func (i *googlephotosItem) Description() string {
+	return i.cached.MediaItem.Description
+}
+
+func (i *googlephotosItem) Created() time.Time {
+	created, _ := time.Parse(time.RFC3339, i.cached.MediaItem.MediaMetadata.CreationTime)
+	return created
+}
+
+// CachedMediaItem returns the underlying Google Photos item.
+func (i *googlephotosItem) CachedMediaItem() *googlephotos.CachedMediaItem {
+	return i.cached
+}
+
+func (i *googlephotosItem) Open() (*Content, error) {
+	b, err := i.client.Open(i.cached.MediaItem, i.Sha256(), i.poster)
+	if err != nil {
+		return nil, fmt.Errorf("failed downloading Googlephoto to upload (%v)", err)
+	}
+	return &Content{
+		Body:        b,
+		ContentType: b.ContentType,
+		Size:        uint64(b.Size),
+	}, nil
+}
@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos/googlephotostest"
+)
+
+// Items that don't match a search are never downloaded.
+func TestGooglephotosSearchSource(t *testing.T) {
+	gp := googlephotostest.NewServer()
+	defer gp.Close()
+	album := gp.AddAlbum("Family", false)
+	for n, age := range []int{1, 10, 100, 1000} {
+		// The oldest is only in the library.
+		albumId := album.Id
+		if age == 1000 {
+			albumId = ""
+		}
+		gp.AddMediaItem(albumId, googlephotos.MediaItem{
+			Filename: fmt.Sprintf("%dd.jpg", age),
+			MediaMetadata: googlephotos.MediaMetadata{
+				CreationTime: time.Now().AddDate(0, 0, -age).UTC().Format(time.RFC3339),
+			},
+		}, []byte(fmt.Sprintf("photo %d", n)))
+	}
+
+	tests := []struct {
+		name          string
+		search        googlephotos.Search
+		album         *googlephotos.AlbumRef
+		want          string
+		wantDownloads int
+	}{
+		{
+			name:          "library",
+			search:        googlephotos.Search{Last: "30d"},
+			want:          "[10d.jpg 1d.jpg]",
+			wantDownloads: 2,
+		},
+		{
+			name:          "album",
+			search:        googlephotos.Search{Last: "30d"},
+			album:         &googlephotos.AlbumRef{ID: album.Id},
+			want:          "[10d.jpg 1d.jpg]",
+			wantDownloads: 2,
+		},
+		{
+			name:          "album, none match",
+			search:        googlephotos.Search{From: "1999-01-01", To: "1999-12-31"},
+			album:         &googlephotos.AlbumRef{ID: album.Id},
+			want:          "[]",
+			wantDownloads: 0,
+		},
+		{
+			name:          "album, all match",
+			search:        googlephotos.Search{MediaType: "photo"},
+			album:         &googlephotos.AlbumRef{ID: album.Id},
+			want:          "[100d.jpg 10d.jpg 1d.jpg]",
+			wantDownloads: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Start from an empty cache, so everything that is returned has
+			// to be downloaded.
+			client, _ := newGooglephotosClient(t, gp)
+			downloads := gp.Downloads()
+			source := NewGooglephotosSearchSource(client, tt.search, tt.album, googlephotos.VideoPolicy{})
+			items, err := source.Items(func(SourceItem) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(filenames(items)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if got := gp.Downloads() - downloads; got != tt.wantDownloads {
+				t.Errorf("downloaded %d items, want %d", got, tt.wantDownloads)
+			}
+		})
+	}
+}
+
+// A title that resolved to an album that's gone is looked up again.
+func TestGooglephotosAlbumSourceRefreshes(t *testing.T) {
+	gp := googlephotostest.NewServer()
+	defer gp.Close()
+	client, _ := newGooglephotosClient(t, gp)
+	ref, err := googlephotos.ParseAlbumRef("title:Family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewGooglephotosAlbumSource(client, ref, googlephotos.VideoPolicy{})
+	items := func() ([]string, error) {
+		t.Helper()
+		items, err := source.Items(func(SourceItem) {})
+		return filenames(items), err
+	}
+
+	old := gp.AddAlbum("Family", false)
+	gp.AddMediaItem(old.Id, googlephotos.MediaItem{Filename: "old.jpg"}, []byte("old"))
+	if got, err := items(); err != nil || fmt.Sprint(got) != "[old.jpg]" {
+		t.Fatalf("got %v (%v), want [old.jpg]", got, err)
+	}
+
+	// The album is replaced by another with the same title.  The cached ID
+	// fails, so the title is resolved again.
+	gp.RemoveAlbum(old.Id)
+	replacement := gp.AddAlbum("Family", false)
+	gp.AddMediaItem(replacement.Id, googlephotos.MediaItem{Filename: "new.jpg"}, []byte("new"))
+	if got, err := items(); err != nil || fmt.Sprint(got) != "[new.jpg]" {
+		t.Fatalf("got %v (%v), want [new.jpg]", got, err)
+	}
+
+	// Once it's gone for good, resolving it again doesn't help, and the
+	// listing's error is returned.
+	gp.RemoveAlbum(replacement.Id)
+	if got, err := items(); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got %v (%v), want the listing's 404", got, err)
+	}
+
+	// An album by ID has nothing to look up again.
+	byId := NewGooglephotosAlbumSource(client, googlephotos.AlbumRef{ID: old.Id}, googlephotos.VideoPolicy{})
+	if _, err := byId.Items(func(SourceItem) {}); err == nil {
+		t.Errorf("listed an album that's gone")
+	}
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos/googlephotostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counter returns the total of the counters called name in reg.
+func counter(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, family := range families {
+		if family.GetName() == name {
+			for _, m := range family.GetMetric() {
+				total += m.GetCounter().GetValue()
+			}
+		}
+	}
+	return total
+}
+
+func md5Of(content []byte) string {
+	sum := md5.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// newGooglephotosClient returns a client of gp with a new cache.
+func newGooglephotosClient(t *testing.T, gp *googlephotostest.Server) (googlephotos.Client, cache.Cache) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	c, err := cache.New(reg, filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := googlephotos.NewClient("id", "secret", context.Background(), gp.Token(), c, reg,
+		googlephotos.Options{BaseURL: gp.URL, TokenURL: gp.TokenURL()})
+	return client, c
+}
+
+// filenames returns the filenames of items, sorted.
+func filenames(items []SourceItem) []string {
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Filename())
+	}
+	sort.Strings(names)
+	return names
+}
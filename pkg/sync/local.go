@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/local"
+)
+
+type localSource struct {
+	client local.Client
+	dir    local.Dir
+}
+
+// NewLocalSource returns a Source for the matching files in a local
+// directory.  Files are hashed via the local files cache.
+func NewLocalSource(client local.Client, dir local.Dir) Source {
+	return &localSource{
+		client: client,
+		dir:    dir,
+	}
+}
+
+func (s *localSource) Name() string {
+	return fmt.Sprintf("local directory %s", s.dir.Path)
+}
+
+func (s *localSource) Items(progress SourceProgressFunc) ([]SourceItem, error) {
+	cached, err := s.client.UpdateCacheForDir(s.dir, func(f *local.CachedFile) {
+		progress(&localItem{cached: f})
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]SourceItem, 0, len(cached))
+	for _, f := range cached {
+		items = append(items, &localItem{cached: f})
+	}
+	return items, nil
+}
+
+type localItem struct {
+	cached *local.CachedFile
+}
+
+func (i *localItem) ID() string       { return i.cached.File.Path }
+func (i *localItem) Filename() string { return filepath.Base(i.cached.File.Path) }
+func (i *localItem) Md5() string      { return i.cached.Md5 }
+func (i *localItem) Sha256() string   { return i.cached.Sha256 }
+
+// Description is empty; local files don't have one.
+func (i *localItem) Description() string { return "" }
+
+// Created is the file's modification time; it's the best guess without
+// reading EXIF.
+func (i *localItem) Created() time.Time { return i.cached.File.ModTime }
+
+// CachedFile returns the underlying local file.
+func (i *localItem) CachedFile() *local.CachedFile {
+	return i.cached
+}
+
+func (i *localItem) Open() (*Content, error) {
+	f, err := os.Open(i.cached.File.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(i.cached.File.Path))
+	if contentType == "" {
+		// Sniff it from the first few bytes instead.
+		head := make([]byte, 512)
+		n, err := io.ReadFull(f, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			f.Close()
+			return nil, err
+		}
+		contentType = http.DetectContentType(head[:n])
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return &Content{
+		Body:        f,
+		ContentType: contentType,
+		Size:        uint64(info.Size()),
+	}, nil
+}
@@ -0,0 +1,61 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/local"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A file has the same cache entry however its directory is written.
+func TestLocalSourceCachesByAbsolutePath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "photos"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "photos", "a.jpg"), []byte("photo a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	abs, err := filepath.Abs(filepath.Join("photos", "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewRegistry()
+	c, err := cache.New(reg, filepath.Join(dir, "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := local.NewClient(c, reg, local.Options{})
+	for _, path := range []string{"photos", "./photos/", "photos/../photos", filepath.Join(dir, "photos")} {
+		items, err := NewLocalSource(client, local.Dir{Path: path}).Items(func(SourceItem) {})
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, item.ID())
+		}
+		if fmt.Sprint(ids) != fmt.Sprint([]string{abs}) {
+			t.Errorf("%s: got %v, want [%s]", path, ids, abs)
+		}
+	}
+	if hashed := counter(t, reg, "local_files_hashed_success"); hashed != 1 {
+		t.Errorf("hashed %v times, want once", hashed)
+	}
+	if entry, err := c.GetLocal(abs); err != nil || entry == nil {
+		t.Errorf("no cache entry for %s (%v)", abs, err)
+	}
+}
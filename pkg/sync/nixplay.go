@@ -0,0 +1,677 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	gosync "sync"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/nixplay"
+)
+
+// NixplayOptions says how a Nixplay destination uses the cache.
+type NixplayOptions struct {
+	// MaxAge is how long a listing of the album in the cache is used instead
+	// of listing it again.  Once it's older, the album is listed in full and
+	// anything that changed outside picsync is reported.  Zero lists the
+	// album every time.
+	MaxAge time.Duration
+}
+
+// digestDelay is how long Nixplay is given to process uploads before the
+// album is listed again.
+var digestDelay = 5 * time.Second
+
+type nixplayDestination struct {
+	client    nixplay.Client
+	cache     cache.Cache
+	albumName string
+	opts      NixplayOptions
+
+	// mu protects album, photos and uploaded, since Upload and Delete are
+	// called concurrently.  uploaded is set once the cached listing of album
+	// has been dropped for this batch of uploads.
+	mu       gosync.Mutex
+	album    *nixplay.Album
+	uploaded bool
+
+	// photos are what's in album, kept up to date as photos are deleted and
+	// captioned, or nil if album must be listed (again).
+	photos []*nixplay.Photo
+
+	// playlists, if set, are published instead of the default playlist.
+	playlists []*Playlist
+}
+
+// NewNixplayDestination returns a Destination for the Nixplay album named
+// albumName.  The album is created if it doesn't exist, and a playlist named
+// "ss_<albumName>" (or the playlists set with SetPlaylists) is published with
+// its contents.  Playlists are tracked by ID in c, so they're still found if
+// they are renamed in Nixplay, and the album's photos are recorded in c each
+// time it's listed.
+func NewNixplayDestination(client nixplay.Client, c cache.Cache, albumName string, opts NixplayOptions) Destination {
+	return &nixplayDestination{
+		client:    client,
+		cache:     c,
+		albumName: albumName,
+		opts:      opts,
+	}
+}
+
+func (d *nixplayDestination) Name() string {
+	return fmt.Sprintf("nixplay album %s", d.albumName)
+}
+
+// getAlbum gets the nixplay album specified by the user.  If it doesn't exist
+// it is created, unless create is false, in which case nil is returned.
+func (d *nixplayDestination) getAlbum(create bool) (*nixplay.Album, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.album != nil {
+		return d.album, nil
+	}
+
+	// It is possible for there to be multiple albums with the same name
+	// (they will have different IDs).  We don't support that however.
+	npAlbums, err := d.client.GetAlbumsByName(d.albumName)
+	if err != nil {
+		return nil, err
+	}
+	if len(npAlbums) == 0 {
+		if !create {
+			return nil, nil
+		}
+		fmt.Printf("Could not get nixplay album %s, creating.\n", d.albumName)
+		d.album, err = d.client.CreateAlbum(d.albumName)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(npAlbums) > 1 {
+		// See "picsync nixplay delete album --delete-multiple"
+		return nil, fmt.Errorf(
+			"multiple nixplay albums named %s, you must delete all but one",
+			d.albumName,
+		)
+	} else {
+		d.album = npAlbums[0]
+	}
+	return d.album, nil
+}
+
+func (d *nixplayDestination) getPhotos(album *nixplay.Album, progress func(*nixplay.Photo)) ([]*nixplay.Photo, error) {
+	var npPhotos []*nixplay.Photo
+	page := 1
+	limit := 100
+	for {
+		photos, err := d.client.GetPhotos(album.ID, page, limit)
+		if err != nil {
+			return nil, err
+		}
+		page++
+		npPhotos = append(npPhotos, photos...)
+		for _, p := range photos {
+			progress(p)
+		}
+		if len(photos) < limit {
+			break
+		}
+	}
+	return npPhotos, nil
+}
+
+// listPhotos returns the photos in album: the ones already known this sync,
+// or the ones in the cache if they're recent enough, or else it lists them
+// and records them in the cache.  progress is called for each one listed.
+func (d *nixplayDestination) listPhotos(album *nixplay.Album, progress func(*nixplay.Photo)) ([]*nixplay.Photo, error) {
+	d.mu.Lock()
+	if d.photos != nil {
+		photos := append([]*nixplay.Photo{}, d.photos...)
+		d.mu.Unlock()
+		return photos, nil
+	}
+	d.mu.Unlock()
+
+	cached, cachedPhotos, err := d.cache.GetNixplayAlbum(album.ID)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && time.Since(cached.LastUpdated) < d.opts.MaxAge {
+		fmt.Printf("Using %d cached photos for nixplay album %s (listed %s ago)\n",
+			len(cachedPhotos), album.Title, time.Since(cached.LastUpdated).Round(time.Second))
+		photos := make([]*nixplay.Photo, 0, len(cachedPhotos))
+		for _, n := range cachedPhotos {
+			photos = append(photos, &nixplay.Photo{
+				ID:       n.NixplayId,
+				AlbumID:  n.AlbumId,
+				Filename: n.Filename,
+				URL:      n.URL,
+				SortDate: n.SortDate,
+				Md5:      n.Md5,
+				Caption:  n.Caption,
+			})
+		}
+		d.setPhotos(photos)
+		return photos, nil
+	}
+
+	photos, err := d.getPhotos(album, progress)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		reportDrift(album, cachedPhotos, photos)
+	}
+	entries := make([]*cache.NixplayData, 0, len(photos))
+	for _, p := range photos {
+		entries = append(entries, nixplayEntry(p))
+	}
+	err = d.cache.ReplaceNixplayAlbum(&cache.NixplayAlbumData{
+		AlbumId: album.ID,
+		Title:   album.Title,
+	}, entries)
+	if err != nil {
+		return nil, err
+	}
+	d.setPhotos(photos)
+	return photos, nil
+}
+
+func (d *nixplayDestination) setPhotos(photos []*nixplay.Photo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.photos = append([]*nixplay.Photo{}, photos...)
+}
+
+// nixplayEntry is the cache entry for p.
+func nixplayEntry(p *nixplay.Photo) *cache.NixplayData {
+	return &cache.NixplayData{
+		AlbumId:   p.AlbumID,
+		NixplayId: p.ID,
+		Filename:  p.Filename,
+		URL:       p.URL,
+		SortDate:  p.SortDate,
+		Md5:       p.Md5,
+		Caption:   p.Caption,
+	}
+}
+
+// reportDrift says how album changed, outside picsync, since it was cached.
+func reportDrift(album *nixplay.Album, cached []*cache.NixplayData, photos []*nixplay.Photo) {
+	wasCached := make(map[int]bool, len(cached))
+	for _, n := range cached {
+		wasCached[n.NixplayId] = true
+	}
+	added := 0
+	for _, p := range photos {
+		if wasCached[p.ID] {
+			delete(wasCached, p.ID)
+		} else {
+			added++
+		}
+	}
+	if added > 0 || len(wasCached) > 0 {
+		fmt.Fprintf(os.Stdout, "\033[2K\rNixplay album %s changed outside picsync since "+
+			"it was cached: %d photos added, %d removed\n", album.Title, added, len(wasCached))
+	}
+}
+
+func (d *nixplayDestination) Items(progress DestinationProgressFunc) ([]DestinationItem, error) {
+	// Don't create the album just to list it; that waits until there is
+	// something to upload.
+	album, err := d.getAlbum(false)
+	if err != nil {
+		return nil, err
+	}
+	if album == nil {
+		return []DestinationItem{}, nil
+	}
+	photos, err := d.listPhotos(album, func(p *nixplay.Photo) {
+		progress(&nixplayItem{photo: p})
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]DestinationItem, 0, len(photos))
+	for _, p := range photos {
+		items = append(items, &nixplayItem{photo: p})
+	}
+	return items, nil
+}
+
+func (d *nixplayDestination) Upload(item SourceItem) error {
+	album, err := d.getAlbum(true)
+	if err != nil {
+		return err
+	}
+	content, err := item.Open()
+	if err != nil {
+		return err
+	}
+	// Nixplay doesn't say what ID an upload gets, so the album must be
+	// listed again to find out.  The cached listing is dropped before the
+	// first upload, so it can't be used if the sync stops part way.
+	d.mu.Lock()
+	if !d.uploaded {
+		if err := d.cache.DeleteNixplayAlbum(album.ID); err != nil {
+			d.mu.Unlock()
+			content.Body.Close()
+			return err
+		}
+		d.uploaded = true
+	}
+	d.photos = nil
+	d.mu.Unlock()
+	return d.client.UploadPhoto(album.ID, item.Filename(), content.ContentType,
+		content.Size, content.Body)
+}
+
+func (d *nixplayDestination) Delete(item DestinationItem) error {
+	npItem, ok := item.(*nixplayItem)
+	if !ok {
+		return fmt.Errorf("cannot delete non-nixplay item %s", item.Filename())
+	}
+	if err := d.client.DeletePhoto(npItem.photo.ID); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	for i, p := range d.photos {
+		if p.ID == npItem.photo.ID {
+			d.photos = append(d.photos[:i], d.photos[i+1:]...)
+			break
+		}
+	}
+	d.mu.Unlock()
+	// The photo is gone either way, so this isn't a failed delete.
+	if err := d.cache.DeleteNixplay(npItem.photo.ID); err != nil {
+		fmt.Printf("Warning: deleted %s from Nixplay but couldn't update the cache: %v\n",
+			npItem.Filename(), err)
+	}
+	return nil
+}
+
+func (d *nixplayDestination) SetCaption(item DestinationItem, caption string) error {
+	npItem, ok := item.(*nixplayItem)
+	if !ok {
+		return fmt.Errorf("cannot caption non-nixplay item %s", item.Filename())
+	}
+	if err := d.client.UpdatePhotoCaption(npItem.photo.ID, caption); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	npItem.photo.Caption = caption
+	d.mu.Unlock()
+	if err := d.cache.UpsertNixplay(nixplayEntry(npItem.photo)); err != nil {
+		fmt.Printf("Warning: captioned %s in Nixplay but couldn't update the cache: %v\n",
+			npItem.Filename(), err)
+	}
+	return nil
+}
+
+func (d *nixplayDestination) SetPlaylists(playlists []*Playlist) {
+	d.playlists = playlists
+}
+
+func (d *nixplayDestination) getPlaylists() []*Playlist {
+	if len(d.playlists) > 0 {
+		return d.playlists
+	}
+	// The default name always works.
+	name, _ := PlaylistName("", d.albumName)
+	return []*Playlist{{
+		PlaylistOptions: PlaylistOptions{Name: name},
+	}}
+}
+
+// findPlaylist returns the ID of the playlist published as name, or -1 if
+// there isn't one.  Like albums, there must not be more than one playlist
+// with the same name.  If the playlist was published before, it's found by
+// the ID in the cache even if it has been renamed.
+func (d *nixplayDestination) findPlaylist(name string) (int, error) {
+	playlists, err := d.client.GetPlaylists()
+	if err != nil {
+		return -1, err
+	}
+	var named []*nixplay.Playlist
+	for _, pl := range playlists {
+		if pl.Name == name {
+			named = append(named, pl)
+		}
+	}
+	if len(named) > 1 {
+		// See "picsync nixplay delete playlist --delete-multiple"
+		return -1, fmt.Errorf(
+			"multiple nixplay playlists named %s, you must delete all but one",
+			name,
+		)
+	}
+
+	cached, err := d.cache.GetPlaylist(name)
+	if err != nil {
+		return -1, err
+	}
+	if cached != nil {
+		for _, pl := range playlists {
+			if pl.Id == cached.PlaylistId {
+				if pl.Name != name {
+					fmt.Printf("Playlist %s is named %s in Nixplay, still publishing to it\n",
+						name, pl.Name)
+				}
+				return pl.Id, nil
+			}
+		}
+	}
+	if len(named) == 1 {
+		return named[0].Id, nil
+	}
+	return -1, nil
+}
+
+// rememberPlaylist caches the ID of the playlist published as name.
+func (d *nixplayDestination) rememberPlaylist(name string, playlistId int) error {
+	return d.cache.UpsertPlaylist(&cache.PlaylistData{
+		Name:       name,
+		PlaylistId: playlistId,
+	})
+}
+
+func (d *nixplayDestination) PlanPublish(changed bool) ([]*PlannedPublish, error) {
+	var plans []*PlannedPublish
+	for _, playlist := range d.getPlaylists() {
+		plan := PlannedPublish{
+			Playlist: playlist.Name,
+			Publish:  changed || playlist.Curated(),
+		}
+		playlistId, err := d.findPlaylist(playlist.Name)
+		if err != nil {
+			return nil, err
+		}
+		if playlistId != -1 {
+			plan.ID = strconv.Itoa(playlistId)
+		} else {
+			plan.Create = true
+			plan.Publish = true
+		}
+		frames, err := d.unassignedFrames(playlist, playlistId)
+		if err != nil {
+			return nil, err
+		}
+		for _, frame := range frames {
+			plan.Frames = append(plan.Frames, frame.Name)
+		}
+		plans = append(plans, &plan)
+	}
+	return plans, nil
+}
+
+// unassignedFrames returns the frames the playlist should be assigned to,
+// but isn't.  It's an error if one of them doesn't exist.
+func (d *nixplayDestination) unassignedFrames(playlist *Playlist, playlistId int) ([]*nixplay.Frame, error) {
+	if len(playlist.Frames) == 0 {
+		return nil, nil
+	}
+	frames, err := d.client.GetFrames()
+	if err != nil {
+		return nil, err
+	}
+	var unassigned []*nixplay.Frame
+	for _, name := range playlist.Frames {
+		frame := findFrame(frames, name)
+		if frame == nil {
+			return nil, fmt.Errorf("did not find frame \"%s\" in %d frames", name, len(frames))
+		}
+		if !frameShows(frame, playlistId) {
+			unassigned = append(unassigned, frame)
+		}
+	}
+	return unassigned, nil
+}
+
+// assignFrames assigns the playlist to the frames that don't show it yet,
+// keeping whatever else they show.
+func (d *nixplayDestination) assignFrames(playlist *Playlist, playlistId int) error {
+	frames, err := d.unassignedFrames(playlist, playlistId)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		playlists := append(append([]int{}, frame.Playlists...), playlistId)
+		err = d.client.SetFramePlaylists(frame.Id, playlists)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Assigned playlist %s to frame %s\n", playlist.Name, frame.Name)
+	}
+	return nil
+}
+
+// findFrame finds a frame by name or serial number, or returns nil.
+func findFrame(frames []*nixplay.Frame, name string) *nixplay.Frame {
+	for _, frame := range frames {
+		if frame.Name == name || frame.SerialNumber == name {
+			return frame
+		}
+	}
+	return nil
+}
+
+func frameShows(frame *nixplay.Frame, playlistId int) bool {
+	for _, id := range frame.Playlists {
+		if id == playlistId {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *nixplayDestination) Publish(changed bool) error {
+	album, err := d.getAlbum(true)
+	if err != nil {
+		return err
+	}
+
+	if d.uploaded {
+		fmt.Printf("Sleeping for %s to let nixplay digest uploaded photos...\n", digestDelay)
+		time.Sleep(digestDelay)
+		d.uploaded = false
+	}
+
+	// Now, get the photos again (if they aren't known) and put them in the
+	// playlists
+	var refreshCount int
+	npPhotos, err := d.listPhotos(album, func(p *nixplay.Photo) {
+		refreshCount++
+		fmt.Fprintf(os.Stdout, "\033[2K\rRefreshing playlist image %d...", refreshCount)
+	})
+	if err != nil {
+		return err
+	}
+	if refreshCount > 0 {
+		fmt.Fprintf(os.Stdout, "\033[2K\rRefreshed %d playlist images for album %s\n",
+			len(npPhotos), album.Title)
+	}
+
+	items := make([]DestinationItem, 0, len(npPhotos))
+	for _, p := range npPhotos {
+		items = append(items, &nixplayItem{photo: p})
+	}
+	for _, playlist := range d.getPlaylists() {
+		err = d.publishPlaylist(playlist, items, changed)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishPlaylist makes playlist show its share of items.
+func (d *nixplayDestination) publishPlaylist(playlist *Playlist, items []DestinationItem, changed bool) error {
+	var npPhotos []*nixplay.Photo
+	for _, item := range playlist.Items(items) {
+		npPhotos = append(npPhotos, item.(*nixplayItem).photo)
+	}
+
+	plName := playlist.Name
+	playlistId, err := d.findPlaylist(plName)
+	if err != nil {
+		return err
+	}
+	neededCreate := false
+	if playlistId == -1 {
+		fmt.Printf("Could not find playlist %s, creating\n", plName)
+		if len(playlist.Frames) == 0 {
+			fmt.Printf(
+				"If this works, you must then assign the playlist %s to frames - "+
+					"set frames for this album, or use \"picsync nixplay frames assign\" "+
+					"(it will be updated once you've assigned it)\n",
+				plName,
+			)
+		}
+		neededCreate = true
+		playlistId, err = d.client.CreatePlaylist(plName)
+		if err != nil {
+			return err
+		}
+	}
+	err = d.rememberPlaylist(plName, playlistId)
+	if err != nil {
+		return err
+	}
+
+	// Curated playlists are checked even if nothing was uploaded or deleted,
+	// in case their config changed.
+	updated := false
+	if changed || neededCreate || playlist.Curated() {
+		updated, err = d.updatePlaylist(playlistId, npPhotos)
+		if err != nil {
+			return err
+		}
+	}
+	if updated {
+		fmt.Printf("Published %d photos to playlist %s\n", len(npPhotos), plName)
+	} else {
+		fmt.Printf(
+			"No changes required for slideshow %s (%d photos)\n",
+			plName,
+			len(npPhotos),
+		)
+	}
+	return d.assignFrames(playlist, playlistId)
+}
+
+// updatePlaylist makes a playlist show photos, in order, changing as few
+// items as it can so frames keep showing the rest.  If that fails, or the
+// playlist doesn't show photos afterwards, the whole playlist is published
+// again.
+// It returns whether the playlist changed.
+func (d *nixplayDestination) updatePlaylist(playlistId int, photos []*nixplay.Photo) (bool, error) {
+	updated, err := d.diffPlaylist(playlistId, photos)
+	if err == nil {
+		return updated, nil
+	}
+	fmt.Printf("Could not update playlist item by item (%v), publishing all of it\n", err)
+	return true, d.client.PublishPlaylist(playlistId, photos)
+}
+
+func (d *nixplayDestination) diffPlaylist(playlistId int, photos []*nixplay.Photo) (bool, error) {
+	items, err := d.client.GetPlaylistItems(playlistId)
+	if err != nil {
+		return false, err
+	}
+	remove, add := playlistDiff(items, photos)
+	if len(remove) == 0 && len(add) == 0 {
+		return false, nil
+	}
+	if len(remove) > 0 {
+		err = d.client.RemovePlaylistItems(playlistId, remove)
+		if err != nil {
+			return false, err
+		}
+	}
+	if len(add) > 0 {
+		err = d.client.AddPlaylistItems(playlistId, add)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Removing some items is a DELETE like the one that empties a playlist,
+	// with the items in the body.  If Nixplay ignored the body, the playlist
+	// is now just what was added, and no error said so.
+	items, err = d.client.GetPlaylistItems(playlistId)
+	if err != nil {
+		return false, err
+	}
+	if !playlistShows(items, photos) {
+		return false, fmt.Errorf("playlist has %d items after updating, not the %d photos it should",
+			len(items), len(photos))
+	}
+	fmt.Printf("Updated playlist items: %d removed, %d added, %d kept\n",
+		len(remove), len(add), len(photos)-len(add))
+	return true, nil
+}
+
+// playlistShows returns whether items are photos, in order.
+func playlistShows(items []*nixplay.PlaylistItem, photos []*nixplay.Photo) bool {
+	if len(items) != len(photos) {
+		return false
+	}
+	for i, item := range items {
+		if item.PictureId != photos[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// playlistDiff works out which items to remove from a playlist, and which
+// photos to add to the end, so that it shows photos in order.
+//
+// Nixplay can only add items at the end; there's no way to insert or move
+// one.  So the items kept must be the first photos, in order, with
+// everything after them added again.  Keeping the longest such run (found by
+// matching photos against the items in order) is the fewest changes
+// possible.  A longest common subsequence would keep more items, but they
+// couldn't be put in the right order.  So appending and deleting are cheap,
+// and inserting or moving a photo re-adds every photo after it; a new photo
+// at the start re-adds the whole playlist.
+func playlistDiff(items []*nixplay.PlaylistItem, photos []*nixplay.Photo) ([]int, []*nixplay.Photo) {
+	var remove []int
+	next := 0
+	for _, item := range items {
+		if next < len(photos) && item.PictureId == photos[next].ID {
+			next++
+			continue
+		}
+		remove = append(remove, item.Id)
+	}
+	return remove, photos[next:]
+}
+
+type nixplayItem struct {
+	photo *nixplay.Photo
+}
+
+func (i *nixplayItem) ID() string       { return strconv.Itoa(i.photo.ID) }
+func (i *nixplayItem) Filename() string { return i.photo.Filename }
+func (i *nixplayItem) Md5() string      { return i.photo.Md5 }
+func (i *nixplayItem) Caption() string  { return i.photo.Caption }
+
+// nixplaySortDateLayout is how Nixplay writes SortDate, e.g. "20180731232531".
+const nixplaySortDateLayout = "20060102150405"
+
+// Created is when Nixplay thinks the photo was taken, or the zero time if it
+// doesn't know.
+func (i *nixplayItem) Created() time.Time {
+	t, err := time.Parse(nixplaySortDateLayout, i.photo.SortDate)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Photo returns the underlying Nixplay photo.
+func (i *nixplayItem) Photo() *nixplay.Photo {
+	return i.photo
+}
@@ -0,0 +1,247 @@
+package sync
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andrewjjenkins/picsync/pkg/nixplay"
+	"github.com/andrewjjenkins/picsync/pkg/nixplay/nixplaytest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPlaylistDiff(t *testing.T) {
+	// Playlist items are numbered 100 + the photo ID they show.
+	tests := []struct {
+		name       string
+		playlist   []int
+		photos     []int
+		wantRemove []int
+		wantAdd    []int
+	}{
+		{
+			name:     "unchanged",
+			playlist: []int{1, 2, 3},
+			photos:   []int{1, 2, 3},
+		},
+		{
+			name:    "empty playlist",
+			photos:  []int{1, 2},
+			wantAdd: []int{1, 2},
+		},
+		{
+			name:       "no photos",
+			playlist:   []int{1, 2},
+			wantRemove: []int{101, 102},
+		},
+		{
+			name:     "append",
+			playlist: []int{1, 2, 3},
+			photos:   []int{1, 2, 3, 4, 5},
+			wantAdd:  []int{4, 5},
+		},
+		{
+			name:       "delete",
+			playlist:   []int{1, 2, 3, 4},
+			photos:     []int{1, 3, 4},
+			wantRemove: []int{102},
+		},
+		{
+			name:       "delete first and last",
+			playlist:   []int{1, 2, 3, 4},
+			photos:     []int{2, 3},
+			wantRemove: []int{101, 104},
+		},
+		{
+			name:       "insert in the middle",
+			playlist:   []int{1, 2, 3, 4},
+			photos:     []int{1, 2, 9, 3, 4},
+			wantRemove: []int{103, 104},
+			wantAdd:    []int{9, 3, 4},
+		},
+		{
+			name:       "insert at the start",
+			playlist:   []int{1, 2, 3},
+			photos:     []int{9, 1, 2, 3},
+			wantRemove: []int{101, 102, 103},
+			wantAdd:    []int{9, 1, 2, 3},
+		},
+		{
+			name:       "swap",
+			playlist:   []int{1, 2, 3, 4},
+			photos:     []int{1, 3, 2, 4},
+			wantRemove: []int{102, 104},
+			wantAdd:    []int{2, 4},
+		},
+		{
+			name:       "reverse",
+			playlist:   []int{1, 2, 3},
+			photos:     []int{3, 2, 1},
+			wantRemove: []int{101, 102},
+			wantAdd:    []int{2, 1},
+		},
+		{
+			name:       "move first to last",
+			playlist:   []int{1, 2, 3},
+			photos:     []int{2, 3, 1},
+			wantRemove: []int{101},
+			wantAdd:    []int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []*nixplay.PlaylistItem
+			for _, id := range tt.playlist {
+				items = append(items, &nixplay.PlaylistItem{Id: 100 + id, PictureId: id})
+			}
+			var photos []*nixplay.Photo
+			for _, id := range tt.photos {
+				photos = append(photos, &nixplay.Photo{ID: id})
+			}
+
+			remove, add := playlistDiff(items, photos)
+
+			var addIds []int
+			for _, photo := range add {
+				addIds = append(addIds, photo.ID)
+			}
+			if !reflect.DeepEqual(remove, tt.wantRemove) {
+				t.Errorf("removed %v, want %v", remove, tt.wantRemove)
+			}
+			if !reflect.DeepEqual(addIds, tt.wantAdd) {
+				t.Errorf("added %v, want %v", addIds, tt.wantAdd)
+			}
+
+			// Applying the diff shows photos, in order.
+			var shown []int
+			removed := make(map[int]bool)
+			for _, id := range remove {
+				removed[id] = true
+			}
+			for _, item := range items {
+				if !removed[item.Id] {
+					shown = append(shown, item.PictureId)
+				}
+			}
+			shown = append(shown, addIds...)
+			if len(shown) == 0 {
+				shown = nil
+			}
+			if !reflect.DeepEqual(shown, tt.photos) {
+				t.Errorf("playlist shows %v, want %v", shown, tt.photos)
+			}
+		})
+	}
+}
+
+func TestUpdatePlaylist(t *testing.T) {
+	for _, empties := range []bool{false, true} {
+		t.Run(fmt.Sprintf("delete empties %t", empties), func(t *testing.T) {
+			np := nixplaytest.NewServer("user@example.com", "password")
+			defer np.Close()
+			np.EmptyOnDeleteItems(empties)
+			client, err := nixplay.NewClient(np.Username, np.Password, prometheus.NewRegistry(),
+				nixplay.Options{BaseURL: np.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := &nixplayDestination{client: client}
+			playlistId := np.AddPlaylist("ss_Family").Id
+			photos := func(ids ...int) []*nixplay.Photo {
+				var photos []*nixplay.Photo
+				for _, id := range ids {
+					photos = append(photos, &nixplay.Photo{ID: id})
+				}
+				return photos
+			}
+			if err := client.AddPlaylistItems(playlistId, photos(1, 2, 3)); err != nil {
+				t.Fatal(err)
+			}
+
+			// Appending doesn't remove anything, so it works either way.
+			updated, err := d.updatePlaylist(playlistId, photos(1, 2, 3, 4))
+			if err != nil || !updated {
+				t.Fatalf("appending: updated %t, %v", updated, err)
+			}
+			if got := np.PlaylistItems(playlistId); fmt.Sprint(got) != "[1 2 3 4]" {
+				t.Errorf("appending: playlist has %v", got)
+			}
+
+			// If removing an item empties the playlist, it's noticed, and the
+			// whole playlist is published.
+			added := np.PlaylistItemsAdded()
+			updated, err = d.updatePlaylist(playlistId, photos(1, 3, 4))
+			if err != nil || !updated {
+				t.Fatalf("deleting: updated %t, %v", updated, err)
+			}
+			if got := np.PlaylistItems(playlistId); fmt.Sprint(got) != "[1 3 4]" {
+				t.Errorf("deleting: playlist has %v, want [1 3 4]", got)
+			}
+			wantAdded := 0
+			if empties {
+				wantAdded = 3
+			}
+			if got := np.PlaylistItemsAdded() - added; got != wantAdded {
+				t.Errorf("deleting: added %d items, want %d", got, wantAdded)
+			}
+		})
+	}
+}
+
+func TestAssignFrames(t *testing.T) {
+	np := nixplaytest.NewServer("user@example.com", "password")
+	defer np.Close()
+	reg := prometheus.NewRegistry()
+	client, err := nixplay.NewClient(np.Username, np.Password, reg, nixplay.Options{BaseURL: np.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &nixplayDestination{client: client}
+	other := np.AddPlaylist("Other").Id
+	playlistId := np.AddPlaylist("ss_Family").Id
+	kitchen := np.AddFrame("Kitchen", "SN1")
+	np.AddFrame("Hall", "SN2")
+	if err := client.SetFramePlaylists(kitchen.Id, []int{other}); err != nil {
+		t.Fatal(err)
+	}
+	shows := func() string {
+		var shows []string
+		for _, frame := range np.Frames() {
+			shows = append(shows, fmt.Sprintf("%s%v", frame.Name, frame.Playlists))
+		}
+		return fmt.Sprint(shows)
+	}
+
+	// By name or serial number.  A frame that already has playlists keeps
+	// them.
+	playlist := &Playlist{PlaylistOptions: PlaylistOptions{Name: "ss_Family", Frames: []string{"Kitchen", "SN2"}}}
+	if err := d.assignFrames(playlist, playlistId); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("[Kitchen[%d %d] Hall[%d]]", other, playlistId, playlistId)
+	if got := shows(); got != want {
+		t.Errorf("frames show %s, want %s", got, want)
+	}
+
+	// Frames that already show it are left alone.
+	set := counter(t, reg, "nixplay_set_frame_playlists_success")
+	if err := d.assignFrames(playlist, playlistId); err != nil {
+		t.Fatal(err)
+	}
+	if got := counter(t, reg, "nixplay_set_frame_playlists_success") - set; got != 0 {
+		t.Errorf("set playlists of %v frames that already showed it", got)
+	}
+
+	// An unknown frame is an error, before any frame is changed.
+	np.AddFrame("Bedroom", "SN3")
+	playlist.Frames = []string{"SN3", "Attic"}
+	err = d.assignFrames(playlist, playlistId)
+	if err == nil || !strings.Contains(err.Error(), `did not find frame "Attic" in 3 frames`) {
+		t.Errorf("got %v, want an unknown frame error", err)
+	}
+	want = fmt.Sprintf("[Kitchen[%d %d] Hall[%d] Bedroom[]]", other, playlistId, playlistId)
+	if got := shows(); got != want {
+		t.Errorf("frames show %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Orders are the ways a destination's items can be ordered.
+const (
+	// OrderSource is the order the sources list them in, one source after
+	// another.
+	OrderSource = "source"
+
+	// OrderCaptured is oldest first, by when each was taken.
+	OrderCaptured = "captured"
+
+	// OrderCapturedDesc is newest first.
+	OrderCapturedDesc = "captured-desc"
+
+	// OrderFilename is by filename.
+	OrderFilename = "filename"
+
+	// OrderRandom is shuffled, the same way every time for the same seed.
+	OrderRandom = "random"
+
+	// OrderInterleave takes one item from each source in turn.
+	OrderInterleave = "interleave"
+)
+
+// OrderOptions says what order a destination shows its items in.
+type OrderOptions struct {
+	// By is one of the Orders, or empty to leave the order to the
+	// destination.
+	By string
+
+	// Seed is for OrderRandom.
+	Seed string
+}
+
+// CheckOrder returns an error if order isn't one of the Orders.
+func CheckOrder(order string) error {
+	switch order {
+	case "", OrderSource, OrderCaptured, OrderCapturedDesc, OrderFilename, OrderRandom, OrderInterleave:
+		return nil
+	}
+	return fmt.Errorf("unknown order %q (want %s, %s, %s, %s, %s or %s)", order,
+		OrderSource, OrderCaptured, OrderCapturedDesc, OrderFilename, OrderRandom, OrderInterleave)
+}
+
+// DatedItem is a DestinationItem that knows when it was taken.
+type DatedItem interface {
+	Created() time.Time
+}
+
+// orderKey is what destination items are sorted by.  Items that aren't from
+// a source sort by when they were taken (or filename), after the others
+// for the orders that only sources know.
+type orderKey struct {
+	fromSource bool
+	rank       float64
+	created    time.Time
+	filename   string
+}
+
+// newSorter returns a function that sorts destination items by opts, using
+// what the source items (matched by MD5) say about them.  sources are in
+// the order they're configured, and sourceOf is which of them (by index)
+// each item came from.
+func newSorter(opts OrderOptions, sources []Source, sourceItems []SourceItem, sourceOf map[SourceItem]int) func([]DestinationItem) {
+	keys := make(map[string]orderKey, len(sourceItems))
+	positions := make([]int, len(sources))
+	for i, item := range sourceItems {
+		if _, ok := keys[item.Md5()]; ok {
+			continue
+		}
+		key := orderKey{
+			fromSource: true,
+			created:    item.Created(),
+			filename:   item.Filename(),
+		}
+		switch opts.By {
+		case OrderRandom:
+			key.rank = hashFraction(opts.Seed, "order", item.Md5())
+		case OrderInterleave:
+			// The nth item from each source comes before the (n+1)th from
+			// any, and sources take turns in their configured order.
+			source := sourceOf[item]
+			key.rank = float64(positions[source])*float64(len(sources)+1) + float64(source)
+			positions[source]++
+		default:
+			key.rank = float64(i)
+		}
+		keys[item.Md5()] = key
+	}
+
+	keyOf := func(item DestinationItem) orderKey {
+		if key, ok := keys[item.Md5()]; ok {
+			return key
+		}
+		key := orderKey{filename: item.Filename()}
+		if dated, ok := item.(DatedItem); ok {
+			key.created = dated.Created()
+		}
+		return key
+	}
+
+	return func(items []DestinationItem) {
+		sort.SliceStable(items, func(i, j int) bool {
+			a, b := keyOf(items[i]), keyOf(items[j])
+			switch opts.By {
+			case OrderCaptured:
+				if !a.created.Equal(b.created) {
+					return a.created.Before(b.created)
+				}
+			case OrderCapturedDesc:
+				if !a.created.Equal(b.created) {
+					return a.created.After(b.created)
+				}
+			case OrderFilename:
+			default:
+				if a.fromSource != b.fromSource {
+					return a.fromSource
+				}
+				if a.fromSource && a.rank != b.rank {
+					return a.rank < b.rank
+				}
+				if !a.fromSource && !a.created.Equal(b.created) {
+					return a.created.Before(b.created)
+				}
+			}
+			return a.filename < b.filename
+		})
+	}
+}
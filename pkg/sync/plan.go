@@ -0,0 +1,191 @@
+package sync
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PlannedItem identifies a single item to upload, delete or keep.
+type PlannedItem struct {
+	// Source is the name of the Source an upload comes from.  Empty for
+	// destination items.
+	Source   string `json:"source,omitempty" yaml:"source,omitempty"`
+	ID       string `json:"id" yaml:"id"`
+	Filename string `json:"filename" yaml:"filename"`
+	Md5      string `json:"md5" yaml:"md5"`
+	Sha256   string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+
+	// Caption is the new caption, for captions.
+	Caption string `json:"caption,omitempty" yaml:"caption,omitempty"`
+}
+
+func (i PlannedItem) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s", i.Source, i.ID, i.Md5, i.Caption)
+}
+
+// PlannedPublish is what a Destination will do to one playlist when it is
+// published.
+type PlannedPublish struct {
+	// Playlist is the name of whatever is published (for Nixplay, the
+	// playlist).
+	Playlist string `json:"playlist" yaml:"playlist"`
+
+	// ID is the existing playlist's ID, or empty if it will be created.
+	ID string `json:"id,omitempty" yaml:"id,omitempty"`
+
+	Create  bool `json:"create" yaml:"create"`
+	Publish bool `json:"publish" yaml:"publish"`
+
+	// Frames are the frames that the playlist will be assigned to (that
+	// don't already show it).
+	Frames []string `json:"frames,omitempty" yaml:"frames,omitempty"`
+}
+
+// Plan is a serializable record of the Work for one Destination.  It can be
+// reviewed and later passed to Apply.
+type Plan struct {
+	Destination string            `json:"destination" yaml:"destination"`
+	Sources     []string          `json:"sources" yaml:"sources"`
+	Uploads     []PlannedItem     `json:"uploads" yaml:"uploads"`
+	Deletes     []PlannedItem     `json:"deletes" yaml:"deletes"`
+	Orphaned    []PlannedItem     `json:"orphaned,omitempty" yaml:"orphaned,omitempty"`
+	Publish     []*PlannedPublish `json:"publish" yaml:"publish"`
+
+	// Captions are the captions of photos already in the destination that
+	// will change.  Uploads are captioned too, once they're uploaded.
+	Captions []PlannedItem `json:"captions,omitempty" yaml:"captions,omitempty"`
+}
+
+func newPlan(
+	work *Work,
+	sourceOf map[SourceItem]int,
+	sources []Source,
+	dest Destination,
+	publish []*PlannedPublish,
+) *Plan {
+	plan := Plan{
+		Destination: dest.Name(),
+		Uploads:     []PlannedItem{},
+		Deletes:     []PlannedItem{},
+		Publish:     publish,
+	}
+	for _, source := range sources {
+		plan.Sources = append(plan.Sources, source.Name())
+	}
+	for _, up := range work.ToUpload {
+		plan.Uploads = append(plan.Uploads, PlannedItem{
+			Source:   sources[sourceOf[up]].Name(),
+			ID:       up.ID(),
+			Filename: up.Filename(),
+			Md5:      up.Md5(),
+			Sha256:   up.Sha256(),
+		})
+	}
+	for _, del := range work.ToDelete {
+		plan.Deletes = append(plan.Deletes, PlannedItem{
+			ID:       del.ID(),
+			Filename: del.Filename(),
+			Md5:      del.Md5(),
+		})
+	}
+	for _, orphan := range work.Orphaned {
+		plan.Orphaned = append(plan.Orphaned, PlannedItem{
+			ID:       orphan.ID(),
+			Filename: orphan.Filename(),
+			Md5:      orphan.Md5(),
+		})
+	}
+	for _, update := range work.ToCaption {
+		plan.Captions = append(plan.Captions, PlannedItem{
+			ID:       update.Item.ID(),
+			Filename: update.Item.Filename(),
+			Md5:      update.Item.Md5(),
+			Caption:  update.Caption,
+		})
+	}
+	return &plan
+}
+
+// checkStale returns an error describing the first difference between the
+// uploads, deletes and publish of p and current.
+func (p *Plan) checkStale(current *Plan) error {
+	if p.Destination != current.Destination {
+		return fmt.Errorf("plan is for %s, not %s", p.Destination, current.Destination)
+	}
+	if err := diffPlannedItems("upload", p.Uploads, current.Uploads); err != nil {
+		return err
+	}
+	if err := diffPlannedItems("delete", p.Deletes, current.Deletes); err != nil {
+		return err
+	}
+	if err := diffPlannedItems("caption", p.Captions, current.Captions); err != nil {
+		return err
+	}
+	if len(p.Publish) != len(current.Publish) {
+		return fmt.Errorf("publish changed (planned %d playlists, now %d)",
+			len(p.Publish), len(current.Publish))
+	}
+	for i := range p.Publish {
+		if !reflect.DeepEqual(p.Publish[i], current.Publish[i]) {
+			return fmt.Errorf("publish changed (planned %+v, now %+v)", *p.Publish[i], *current.Publish[i])
+		}
+	}
+	return nil
+}
+
+func diffPlannedItems(what string, planned, current []PlannedItem) error {
+	currentKeys := make(map[string]bool)
+	for _, item := range current {
+		currentKeys[item.key()] = true
+	}
+	plannedKeys := make(map[string]bool)
+	for _, item := range planned {
+		plannedKeys[item.key()] = true
+		if !currentKeys[item.key()] {
+			return fmt.Errorf("planned %s of %s (%s) is no longer needed", what, item.Filename, item.ID)
+		}
+	}
+	for _, item := range current {
+		if !plannedKeys[item.key()] {
+			return fmt.Errorf("%s of %s (%s) is needed but not planned", what, item.Filename, item.ID)
+		}
+	}
+	return nil
+}
+
+// Plan works out what Sync would do, without doing it.
+func (s *syncerImpl) Plan(sources []Source, dest Destination, opts Options) (*Plan, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no sources for %s", dest.Name())
+	}
+	work, sourceOf, err := s.calcWork(sources, dest, opts)
+	if err != nil {
+		return nil, err
+	}
+	publish, err := dest.PlanPublish(work.changed(opts))
+	if err != nil {
+		return nil, err
+	}
+	return newPlan(work, sourceOf, sources, dest, publish), nil
+}
+
+// Apply does the work in plan.  sources and dest are enumerated again, and
+// if the work needed now is not exactly what was planned, nothing is done.
+func (s *syncerImpl) Apply(plan *Plan, sources []Source, dest Destination, opts Options) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no sources for %s", dest.Name())
+	}
+	work, sourceOf, err := s.calcWork(sources, dest, opts)
+	if err != nil {
+		return err
+	}
+	publish, err := dest.PlanPublish(work.changed(opts))
+	if err != nil {
+		return err
+	}
+	current := newPlan(work, sourceOf, sources, dest, publish)
+	if err := plan.checkStale(current); err != nil {
+		return fmt.Errorf("plan for %s is stale, run plan again: %v", dest.Name(), err)
+	}
+	return s.doWork(work, dest, opts)
+}
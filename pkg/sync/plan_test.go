@@ -0,0 +1,141 @@
+package sync
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func testPlan() *Plan {
+	return &Plan{
+		Destination: "nixplay album Family",
+		Sources:     []string{"googlephotos album AP5WpWre"},
+		Uploads: []PlannedItem{
+			{Source: "googlephotos album AP5WpWre", ID: "gp1", Filename: "a.jpg", Md5: "md5a", Sha256: "sha256a"},
+			{Source: "googlephotos album AP5WpWre", ID: "gp2", Filename: "b.jpg", Md5: "md5b", Sha256: "sha256b"},
+		},
+		Deletes:  []PlannedItem{{ID: "1001", Filename: "c.jpg", Md5: "md5c"}},
+		Orphaned: []PlannedItem{{ID: "1002", Filename: "d.jpg", Md5: "md5d"}},
+		Captions: []PlannedItem{{ID: "1003", Filename: "e.jpg", Md5: "md5e", Caption: "Beach"}},
+		Publish: []*PlannedPublish{
+			{Playlist: "ss_Family", ID: "2001", Publish: true, Frames: []string{"Kitchen"}},
+		},
+	}
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	want := testPlan()
+	for _, format := range []struct {
+		name      string
+		marshal   func(interface{}) ([]byte, error)
+		unmarshal func([]byte, interface{}) error
+	}{
+		{"json", json.Marshal, json.Unmarshal},
+		{"yaml", yaml.Marshal, yaml.Unmarshal},
+	} {
+		out, err := format.marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &Plan{}
+		if err := format.unmarshal(out, got); err != nil {
+			t.Fatalf("%s: %v", format.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", format.name, got, want)
+		}
+		if err := got.checkStale(want); err != nil {
+			t.Errorf("%s: read back plan is stale: %v", format.name, err)
+		}
+	}
+}
+
+func TestPlanCheckStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(p *Plan)
+		wantErr string
+	}{
+		{
+			name:   "same",
+			change: func(p *Plan) {},
+		},
+		{
+			name: "uploads in another order",
+			change: func(p *Plan) {
+				p.Uploads[0], p.Uploads[1] = p.Uploads[1], p.Uploads[0]
+			},
+		},
+		{
+			// Orphans are only reported, never acted on.
+			name:   "orphans changed",
+			change: func(p *Plan) { p.Orphaned = nil },
+		},
+		{
+			name:    "other destination",
+			change:  func(p *Plan) { p.Destination = "nixplay album Work" },
+			wantErr: "plan is for nixplay album Family, not nixplay album Work",
+		},
+		{
+			name:    "upload no longer needed",
+			change:  func(p *Plan) { p.Uploads = p.Uploads[:1] },
+			wantErr: "planned upload of b.jpg (gp2) is no longer needed",
+		},
+		{
+			name: "upload not planned",
+			change: func(p *Plan) {
+				p.Uploads = append(p.Uploads, PlannedItem{Source: "googlephotos album AP5WpWre", ID: "gp3", Filename: "f.jpg", Md5: "md5f"})
+			},
+			wantErr: "upload of f.jpg (gp3) is needed but not planned",
+		},
+		{
+			name:    "upload changed",
+			change:  func(p *Plan) { p.Uploads[0].Md5 = "md5a2" },
+			wantErr: "planned upload of a.jpg (gp1) is no longer needed",
+		},
+		{
+			name:    "delete no longer needed",
+			change:  func(p *Plan) { p.Deletes = nil },
+			wantErr: "planned delete of c.jpg (1001) is no longer needed",
+		},
+		{
+			name:    "caption changed",
+			change:  func(p *Plan) { p.Captions[0].Caption = "Mountains" },
+			wantErr: "planned caption of e.jpg (1003) is no longer needed",
+		},
+		{
+			name: "another playlist",
+			change: func(p *Plan) {
+				p.Publish = append(p.Publish, &PlannedPublish{Playlist: "ss_All", Create: true, Publish: true})
+			},
+			wantErr: "publish changed (planned 1 playlists, now 2)",
+		},
+		{
+			name:    "playlist publish changed",
+			change:  func(p *Plan) { p.Publish[0].Publish = false },
+			wantErr: "publish changed",
+		},
+		{
+			name:    "playlist frames changed",
+			change:  func(p *Plan) { p.Publish[0].Frames = nil },
+			wantErr: "publish changed",
+		},
+	}
+	for _, tt := range tests {
+		current := testPlan()
+		tt.change(current)
+		err := testPlan().checkStale(current)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
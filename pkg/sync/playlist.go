@@ -0,0 +1,145 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultPlaylistName is the template for a playlist's name, unless it's
+// configured.
+const DefaultPlaylistName = "ss_{{.Album}}"
+
+// playlistNameData is what a playlist name template can use.
+type playlistNameData struct {
+	Album string
+}
+
+// PlaylistName returns the name that nameTemplate (DefaultPlaylistName if
+// it's "") gives the playlist of album.
+func PlaylistName(nameTemplate string, album string) (string, error) {
+	if nameTemplate == "" {
+		nameTemplate = DefaultPlaylistName
+	}
+	tmpl, err := template.New("playlist").Parse(nameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("bad playlist name: %v", err)
+	}
+	var name strings.Builder
+	if err := tmpl.Execute(&name, playlistNameData{Album: album}); err != nil {
+		return "", fmt.Errorf("bad playlist name: %v", err)
+	}
+	if strings.TrimSpace(name.String()) == "" {
+		return "", fmt.Errorf("playlist name %q is empty", nameTemplate)
+	}
+	return name.String(), nil
+}
+
+// PlaylistOptions is one playlist (or slideshow) that a destination publishes
+// its items to.
+type PlaylistOptions struct {
+	// Name is the playlist's name.
+	Name string
+
+	// Order is the order the playlist shows items in.
+	Order OrderOptions
+
+	// Sources, if set, limits the playlist to items from these sources: each
+	// is a source's Name, or the end of it (like "title:Family").
+	Sources []string
+
+	// MaxPhotos, if set, limits the playlist to the first MaxPhotos items (in
+	// Order).
+	MaxPhotos int
+
+	// Frames are the frames (by name or serial number) the playlist is
+	// assigned to.  It is added to what each frame already shows; their
+	// other playlists are kept.
+	Frames []string
+}
+
+// Playlist is a PlaylistOptions for a particular sync.
+type Playlist struct {
+	PlaylistOptions
+
+	fromSources map[string]bool
+	sortItems   func([]DestinationItem)
+}
+
+// Playlister is a Destination that publishes its items to playlists.
+// SetPlaylists is called before PlanPublish and Publish.
+type Playlister interface {
+	SetPlaylists(playlists []*Playlist)
+}
+
+// newPlaylists works out the items in each playlist.
+func newPlaylists(opts []PlaylistOptions, sources []Source, sourceItems []SourceItem, sourceOf map[SourceItem]int) ([]*Playlist, error) {
+	names := make(map[string]bool)
+	var playlists []*Playlist
+	for _, o := range opts {
+		if names[o.Name] {
+			return nil, fmt.Errorf("playlist %s is configured more than once", o.Name)
+		}
+		names[o.Name] = true
+		if err := CheckOrder(o.Order.By); err != nil {
+			return nil, fmt.Errorf("playlist %s: %v", o.Name, err)
+		}
+		p := &Playlist{PlaylistOptions: o}
+		if o.Order.By != "" {
+			p.sortItems = newSorter(o.Order, sources, sourceItems, sourceOf)
+		}
+		if len(o.Sources) > 0 {
+			p.fromSources = make(map[string]bool)
+			for _, want := range o.Sources {
+				found := false
+				for _, source := range sources {
+					if matchesSource(source.Name(), want) {
+						found = true
+					}
+				}
+				if !found {
+					return nil, fmt.Errorf("playlist %s: no source %s", o.Name, want)
+				}
+			}
+			for _, item := range sourceItems {
+				for _, want := range o.Sources {
+					if matchesSource(sources[sourceOf[item]].Name(), want) {
+						p.fromSources[item.Md5()] = true
+					}
+				}
+			}
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, nil
+}
+
+func matchesSource(name string, want string) bool {
+	return name == want || strings.HasSuffix(name, " "+want)
+}
+
+// Items returns the items that are in the playlist, in order.  items is not
+// modified.
+func (p *Playlist) Items(items []DestinationItem) []DestinationItem {
+	chosen := make([]DestinationItem, 0, len(items))
+	for _, item := range items {
+		if p.fromSources == nil || p.fromSources[item.Md5()] {
+			chosen = append(chosen, item)
+		}
+	}
+	if p.sortItems != nil {
+		p.sortItems(chosen)
+	}
+	if p.MaxPhotos > 0 && len(chosen) > p.MaxPhotos {
+		chosen = chosen[:p.MaxPhotos]
+	}
+	return chosen
+}
+
+// Curated returns whether the playlist is anything other than all of the
+// destination's items in its own order.  Curated playlists are checked on
+// every sync, since changing the config can change them even if no items
+// changed.
+func (p *Playlist) Curated() bool {
+	return p.sortItems != nil || p.fromSources != nil || p.MaxPhotos > 0
+}
@@ -0,0 +1,33 @@
+package sync
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type promImpl struct {
+	promFactory promauto.Factory
+
+	orphanedRetained *prometheus.GaugeVec
+	uploadsPending   *prometheus.GaugeVec
+}
+
+func (s *syncerImpl) promRegister(reg prometheus.Registerer) error {
+	s.prom.promFactory = promauto.With(reg)
+
+	s.prom.orphanedRetained = s.prom.promFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sync_orphaned_retained_photos",
+			Help: "Photos in a destination that are in no source, but kept because delete is disabled",
+		},
+		[]string{"destination"},
+	)
+	s.prom.uploadsPending = s.prom.promFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sync_uploads_pending",
+			Help: "Uploads to a destination that are queued or in progress",
+		},
+		[]string{"destination"},
+	)
+	return nil
+}
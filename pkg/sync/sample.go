@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Selections are the ways Sample can choose items.
+const (
+	SelectRandom   = "random"
+	SelectNewest   = "newest"
+	SelectOldest   = "oldest"
+	SelectWeighted = "weighted"
+)
+
+// SampleOptions limits how many source items are synced.
+type SampleOptions struct {
+	// MaxPhotos is the most items to sync; 0 means all of them.
+	MaxPhotos int
+
+	// Selection is how to choose which items, when there are more than
+	// MaxPhotos: SelectRandom (the default), SelectNewest, SelectOldest, or
+	// SelectWeighted (random, but newer items are more likely).
+	Selection string
+
+	// Seed makes random choices repeatable: the same items are chosen every
+	// time for the same seed.
+	Seed string
+
+	// ReshuffleEvery, if set, changes random choices over time.  Each item
+	// is reconsidered once per ReshuffleEvery, at its own time within the
+	// period, so the choice rotates gradually rather than all at once.
+	ReshuffleEvery time.Duration
+}
+
+// CheckSelection returns an error if selection isn't a known Selection.
+func CheckSelection(selection string) error {
+	switch selection {
+	case "", SelectRandom, SelectNewest, SelectOldest, SelectWeighted:
+		return nil
+	}
+	return fmt.Errorf("unknown selection %q (want %s, %s, %s or %s)", selection,
+		SelectRandom, SelectNewest, SelectOldest, SelectWeighted)
+}
+
+// Sample returns at most opts.MaxPhotos of items, chosen as of now.  Items
+// are identified by MD5, so an item chosen from one source would also be
+// chosen from another.  The returned items are in their original order.
+func Sample(items []SourceItem, opts SampleOptions, now time.Time) ([]SourceItem, error) {
+	if err := CheckSelection(opts.Selection); err != nil {
+		return nil, err
+	}
+	if opts.MaxPhotos <= 0 || len(items) <= opts.MaxPhotos {
+		return items, nil
+	}
+
+	// Higher scores are chosen first.
+	scores := make(map[SourceItem]float64, len(items))
+	for _, item := range items {
+		switch opts.Selection {
+		case SelectNewest:
+			scores[item] = float64(item.Created().Unix())
+		case SelectOldest:
+			scores[item] = -float64(item.Created().Unix())
+		case SelectWeighted:
+			// Efraimidis-Spirakis: u^(1/weight) is a weighted random key.
+			ageYears := now.Sub(item.Created()).Hours() / (24 * 365)
+			if ageYears < 0 || item.Created().IsZero() {
+				ageYears = 0
+			}
+			weight := 1 / (1 + ageYears)
+			scores[item] = math.Pow(sampleRandom(item, opts, now), 1/weight)
+		default:
+			scores[item] = sampleRandom(item, opts, now)
+		}
+	}
+
+	sorted := make([]SourceItem, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if scores[sorted[i]] != scores[sorted[j]] {
+			return scores[sorted[i]] > scores[sorted[j]]
+		}
+		return sorted[i].Md5() < sorted[j].Md5()
+	})
+	chosen := make(map[SourceItem]bool, opts.MaxPhotos)
+	for _, item := range sorted[:opts.MaxPhotos] {
+		chosen[item] = true
+	}
+
+	var sampled []SourceItem
+	for _, item := range items {
+		if chosen[item] {
+			sampled = append(sampled, item)
+		}
+	}
+	return sampled, nil
+}
+
+// sampleRandom returns a number in (0, 1) that depends only on the item,
+// the seed and (if reshuffling) which period now is in for this item.
+func sampleRandom(item SourceItem, opts SampleOptions, now time.Time) float64 {
+	var epoch int64
+	if opts.ReshuffleEvery > 0 {
+		// Offset each item's periods by its own phase, so they don't all
+		// reshuffle at once.
+		phase := hashFraction(opts.Seed, "phase", item.Md5())
+		offset := time.Duration(phase * float64(opts.ReshuffleEvery))
+		epoch = int64((time.Duration(now.UnixNano()) + offset) / opts.ReshuffleEvery)
+	}
+	return hashFraction(opts.Seed, fmt.Sprint(epoch), item.Md5())
+}
+
+// hashFraction hashes parts to a number in (0, 1).
+func hashFraction(parts ...string) float64 {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	n := binary.BigEndian.Uint64(h.Sum(nil)[:8]) >> 11
+	return (float64(n) + 0.5) / (1 << 53)
+}
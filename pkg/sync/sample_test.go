@@ -0,0 +1,218 @@
+package sync
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testItem is a SourceItem that is only metadata.
+type testItem struct {
+	id      string
+	md5     string
+	created time.Time
+}
+
+func (i *testItem) ID() string              { return i.id }
+func (i *testItem) Filename() string        { return i.id + ".jpg" }
+func (i *testItem) Md5() string             { return i.md5 }
+func (i *testItem) Sha256() string          { return "sha-" + i.md5 }
+func (i *testItem) Description() string     { return "" }
+func (i *testItem) Created() time.Time      { return i.created }
+func (i *testItem) Open() (*Content, error) { return nil, fmt.Errorf("%s has no content", i.id) }
+
+var sampleNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// sampleItems returns n items, one taken each day before sampleNow.
+func sampleItems(n int) []SourceItem {
+	items := make([]SourceItem, n)
+	for i := range items {
+		items[i] = &testItem{
+			id:      fmt.Sprintf("item%d", i),
+			md5:     fmt.Sprintf("md5-%d", i),
+			created: sampleNow.AddDate(0, 0, -i),
+		}
+	}
+	return items
+}
+
+func ids(items []SourceItem) []string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ID())
+	}
+	return ids
+}
+
+func TestSampleSize(t *testing.T) {
+	items := sampleItems(100)
+	tests := []struct {
+		maxPhotos int
+		want      int
+	}{
+		{0, 100},
+		{-1, 100},
+		{10, 10},
+		{99, 99},
+		{100, 100},
+		{1000, 100},
+	}
+	for _, selection := range []string{"", SelectRandom, SelectNewest, SelectOldest, SelectWeighted} {
+		for _, tt := range tests {
+			opts := SampleOptions{MaxPhotos: tt.maxPhotos, Selection: selection, Seed: "s"}
+			sampled, err := Sample(items, opts, sampleNow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sampled) != tt.want {
+				t.Errorf("%q, MaxPhotos %d: sampled %d, want %d", selection, tt.maxPhotos, len(sampled), tt.want)
+			}
+			// Chosen items stay in their original order.
+			last := -1
+			for _, item := range sampled {
+				var i int
+				fmt.Sscanf(item.ID(), "item%d", &i)
+				if i <= last {
+					t.Errorf("%q, MaxPhotos %d: out of order: %v", selection, tt.maxPhotos, ids(sampled))
+					break
+				}
+				last = i
+			}
+		}
+	}
+
+	if _, err := Sample(items, SampleOptions{MaxPhotos: 1, Selection: "best"}, sampleNow); err == nil {
+		t.Errorf("unknown selection: no error")
+	}
+}
+
+func TestSampleNewestOldest(t *testing.T) {
+	items := sampleItems(10)
+	newest, err := Sample(items, SampleOptions{MaxPhotos: 3, Selection: SelectNewest}, sampleNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(newest), []string{"item0", "item1", "item2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("newest: %v, want %v", got, want)
+	}
+	oldest, err := Sample(items, SampleOptions{MaxPhotos: 3, Selection: SelectOldest}, sampleNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(oldest), []string{"item7", "item8", "item9"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("oldest: %v, want %v", got, want)
+	}
+}
+
+func TestSampleDeterministic(t *testing.T) {
+	items := sampleItems(200)
+	for _, selection := range []string{SelectRandom, SelectWeighted} {
+		opts := SampleOptions{MaxPhotos: 20, Selection: selection, Seed: "frame1"}
+		first, err := Sample(items, opts, sampleNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The same seed chooses the same items, even from another source
+		// that lists them in another order.
+		reversed := make([]SourceItem, len(items))
+		for i, item := range items {
+			reversed[len(items)-1-i] = item
+		}
+		again, err := Sample(reversed, opts, sampleNow.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameItems(first, again) {
+			t.Errorf("%s: same seed chose %v, then %v", selection, ids(first), ids(again))
+		}
+
+		opts.Seed = "frame2"
+		other, err := Sample(items, opts, sampleNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sameItems(first, other) {
+			t.Errorf("%s: different seeds both chose %v", selection, ids(first))
+		}
+	}
+}
+
+func TestSampleReshuffle(t *testing.T) {
+	items := sampleItems(1000)
+	opts := SampleOptions{MaxPhotos: 100, Seed: "s", ReshuffleEvery: 24 * time.Hour}
+	sample := func(at time.Time) []SourceItem {
+		sampled, err := Sample(items, opts, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sampled
+	}
+	first := sample(sampleNow)
+
+	// Items are reconsidered at their own time in the period, so a little
+	// later only a few have changed...
+	if changed := 100 - overlap(first, sample(sampleNow.Add(time.Hour))); changed == 0 || changed > 20 {
+		t.Errorf("an hour later, %d of 100 changed, want a few", changed)
+	}
+	// ... and a period later most have.
+	if kept := overlap(first, sample(sampleNow.Add(24*time.Hour))); kept > 30 {
+		t.Errorf("a period later, %d of 100 kept, want about 10", kept)
+	}
+}
+
+func TestSampleWeighted(t *testing.T) {
+	// Half the items are from today, half from ten years ago.
+	var items []SourceItem
+	for i := 0; i < 1000; i++ {
+		created := sampleNow
+		if i%2 == 1 {
+			created = sampleNow.AddDate(-10, 0, 0)
+		}
+		items = append(items, &testItem{
+			id:      fmt.Sprintf("item%d", i),
+			md5:     fmt.Sprintf("md5-%d", i),
+			created: created,
+		})
+	}
+	newCount := func(selection string) int {
+		sampled, err := Sample(items, SampleOptions{MaxPhotos: 100, Selection: selection, Seed: "s"}, sampleNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, item := range sampled {
+			if item.Created().Equal(sampleNow) {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := newCount(SelectRandom); n < 35 || n > 65 {
+		t.Errorf("random: %d of 100 are new, want about half", n)
+	}
+	// New items are weighted 1, old ones 1/11.
+	if n := newCount(SelectWeighted); n < 85 {
+		t.Errorf("weighted: %d of 100 are new, want most", n)
+	}
+}
+
+func overlap(a, b []SourceItem) int {
+	in := make(map[SourceItem]bool)
+	for _, item := range a {
+		in[item] = true
+	}
+	n := 0
+	for _, item := range b {
+		if in[item] {
+			n++
+		}
+	}
+	return n
+}
+
+func sameItems(a, b []SourceItem) bool {
+	return len(a) == len(b) && overlap(a, b) == len(a)
+}
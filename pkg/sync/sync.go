@@ -0,0 +1,422 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Content is an open stream of an item's bytes, ready to be uploaded.
+type Content struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        uint64
+}
+
+// SourceItem is a single image provided by a Source.
+type SourceItem interface {
+	// ID is whatever the source uses to uniquely identify this item.
+	ID() string
+	Filename() string
+	Md5() string
+	Sha256() string
+
+	// Description is what the owner wrote about the item, if anything.
+	Description() string
+
+	// Created is when the item was taken, as best the source knows (zero if
+	// it doesn't).
+	Created() time.Time
+
+	// Open starts reading the content of the item.  The caller must close
+	// the returned Body.
+	Open() (*Content, error)
+}
+
+// SourceProgressFunc is called once for every item a Source enumerates.
+type SourceProgressFunc func(SourceItem)
+
+// Source is somewhere images are synced from (like a Google Photos album).
+type Source interface {
+	// Name describes the source in progress and error messages.
+	Name() string
+
+	// Items enumerates every item in the source, hashing any that are not
+	// already known.
+	Items(progress SourceProgressFunc) ([]SourceItem, error)
+}
+
+// Preparer is a Source whose items need more work (like transforming)
+// before they can be compared to the destination.  Prepare is only called
+// with the items that will be synced (see Options.Sample), and returns the
+// items to use instead.
+type Preparer interface {
+	Prepare(items []SourceItem) ([]SourceItem, error)
+}
+
+// DestinationItem is a single image already present in a Destination.
+type DestinationItem interface {
+	ID() string
+	Filename() string
+	Md5() string
+}
+
+// DestinationProgressFunc is called once for every item a Destination
+// enumerates.
+type DestinationProgressFunc func(DestinationItem)
+
+// Destination is somewhere images are synced to (like a Nixplay album).
+type Destination interface {
+	// Name describes the destination in progress and error messages.
+	Name() string
+
+	// Items enumerates every item currently in the destination.
+	Items(progress DestinationProgressFunc) ([]DestinationItem, error)
+
+	// Upload may be called concurrently (see Options.MaxConcurrentUploads).
+	Upload(item SourceItem) error
+	Delete(item DestinationItem) error
+
+	// Publish is called once all uploads and deletes are done.  changed is
+	// true if anything was uploaded or deleted (or the user asked to force
+	// publishing).
+	Publish(changed bool) error
+
+	// PlanPublish reports what Publish would do, without doing it.
+	PlanPublish(changed bool) ([]*PlannedPublish, error)
+}
+
+// Options controls a single call to Sync.
+type Options struct {
+	// If true, only report the work, don't do it.
+	DryRun bool
+
+	// If true, publish even if nothing changed.
+	ForcePublish bool
+
+	// If true, never delete from the destination, only add.  Items in the
+	// destination that are in no source are reported as Orphaned instead.
+	Additive bool
+
+	// MaxConcurrentUploads is how many uploads run at once (default 1).
+	MaxConcurrentUploads int
+
+	// Sample limits how many source items are synced.
+	Sample SampleOptions
+
+	// Captions, if set, makes the caption of each item in the destination
+	// (which must be a Captioner).
+	Captions *Captions
+
+	// Playlists, if set, are what the destination (which must be a
+	// Playlister) publishes, instead of its default.
+	Playlists []PlaylistOptions
+}
+
+// Work is what must be done to make a Destination match its Sources.
+type Work struct {
+	ToUpload []SourceItem
+	ToDelete []DestinationItem
+
+	// Orphaned are items in the destination that are in no source, but will
+	// be kept because the sync is additive.
+	Orphaned []DestinationItem
+
+	// ToCaption are items already in the destination whose captions must
+	// change (see Options.Captions).
+	ToCaption []CaptionUpdate
+
+	// captions is the caption for each source item by MD5, if captioning.
+	captions map[string]string
+
+	// playlists are what is published, if not the destination's default.
+	playlists []*Playlist
+}
+
+type Syncer interface {
+	Sync(sources []Source, dest Destination, opts Options) error
+	Plan(sources []Source, dest Destination, opts Options) (*Plan, error)
+	Apply(plan *Plan, sources []Source, dest Destination, opts Options) error
+}
+
+type syncerImpl struct {
+	prom promImpl
+}
+
+// New returns a Syncer that reports metrics to reg.
+func New(reg prometheus.Registerer) Syncer {
+	s := syncerImpl{}
+	s.promRegister(reg)
+	return &s
+}
+
+// Sync makes dest contain the items in sources, matched by MD5.
+func (s *syncerImpl) Sync(sources []Source, dest Destination, opts Options) error {
+	if len(sources) == 0 {
+		fmt.Printf("No source album. Cowardly refusing to delete all destination photos.\n")
+		return nil
+	}
+
+	work, _, err := s.calcWork(sources, dest, opts)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return s.doWork(work, dest, opts)
+}
+
+// calcWork enumerates sources and dest and works out what must be done.  It
+// also returns which of sources (by index) each item came from; names
+// aren't enough, since the same source may be configured twice.
+func (s *syncerImpl) calcWork(sources []Source, dest Destination, opts Options) (*Work, map[SourceItem]int, error) {
+	var sourceItems []SourceItem
+	sourceOf := make(map[SourceItem]int)
+	for i, source := range sources {
+		var sourceUpdateCount int
+		sourceUpdateCb := func(item SourceItem) {
+			sourceUpdateCount++
+			fmt.Fprintf(os.Stdout, "\033[2K\rRefreshing source image %d...", sourceUpdateCount)
+		}
+		items, err := source.Items(sourceUpdateCb)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", source.Name(), err)
+		}
+		for _, item := range items {
+			sourceOf[item] = i
+		}
+		sourceItems = append(sourceItems, items...)
+		fmt.Fprintf(os.Stdout, "\033[2K\rRefreshed %d source images for album %d/%d\n",
+			sourceUpdateCount, i+1, len(sources))
+	}
+
+	sampled, err := Sample(sourceItems, opts.Sample, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sampled) != len(sourceItems) {
+		selection := opts.Sample.Selection
+		if selection == "" {
+			selection = SelectRandom
+		}
+		fmt.Printf("Selected %d of %d source images (%s)\n",
+			len(sampled), len(sourceItems), selection)
+		sourceItems = sampled
+	}
+
+	sourceItems, err = prepare(sources, sourceItems, sourceOf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var destUpdateCount int
+	destUpdateCb := func(item DestinationItem) {
+		destUpdateCount++
+		fmt.Fprintf(os.Stdout, "\033[2K\rRefreshing destination image %d...", destUpdateCount)
+	}
+	destItems, err := dest.Items(destUpdateCb)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(os.Stdout, "\033[2K\rRefreshed %d destination images for %s\n",
+		len(destItems), dest.Name())
+
+	work, err := CalcWork(sourceItems, destItems)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Additive {
+		work.Orphaned = work.ToDelete
+		work.ToDelete = nil
+	}
+	s.prom.orphanedRetained.WithLabelValues(dest.Name()).Set(float64(len(work.Orphaned)))
+
+	if opts.Captions != nil {
+		if _, ok := dest.(Captioner); !ok {
+			return nil, nil, fmt.Errorf("%s can't caption photos", dest.Name())
+		}
+		work.captions, err = captionsByMd5(opts.Captions, sources, sourceItems, sourceOf)
+		if err != nil {
+			return nil, nil, err
+		}
+		work.ToCaption = CalcCaptions(work.captions, destItems)
+	}
+
+	if len(opts.Playlists) > 0 {
+		playlister, ok := dest.(Playlister)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s can't publish playlists", dest.Name())
+		}
+		work.playlists, err = newPlaylists(opts.Playlists, sources, sourceItems, sourceOf)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Set them now, since planning the publish needs them too.
+		playlister.SetPlaylists(work.playlists)
+	}
+
+	fmt.Printf("Sync work:\n")
+	fmt.Printf("  To upload: %d\n", len(work.ToUpload))
+	fmt.Printf("  To delete: %d\n", len(work.ToDelete))
+	if opts.Additive {
+		fmt.Printf("  To keep (delete disabled): %d\n", len(work.Orphaned))
+		for _, orphan := range work.Orphaned {
+			fmt.Printf("    %s (not in any source)\n", orphan.Filename())
+		}
+	}
+	if opts.Captions != nil {
+		fmt.Printf("  To caption: %d\n", len(work.ToCaption))
+	}
+	return work, sourceOf, nil
+}
+
+// prepare calls Prepare for the sources that are Preparers, with their
+// items, and returns all of the items with the prepared ones replaced.
+// sourceOf is updated for the replacements.
+func prepare(sources []Source, items []SourceItem, sourceOf map[SourceItem]int) ([]SourceItem, error) {
+	for s, source := range sources {
+		preparer, ok := source.(Preparer)
+		if !ok {
+			continue
+		}
+		var indexes []int
+		var toPrepare []SourceItem
+		for i, item := range items {
+			if sourceOf[item] == s {
+				indexes = append(indexes, i)
+				toPrepare = append(toPrepare, item)
+			}
+		}
+		if len(toPrepare) == 0 {
+			continue
+		}
+		prepared, err := preparer.Prepare(toPrepare)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source.Name(), err)
+		}
+		for n, i := range indexes {
+			delete(sourceOf, items[i])
+			items[i] = prepared[n]
+			sourceOf[items[i]] = s
+		}
+	}
+	return items, nil
+}
+
+// doWork uploads, deletes and publishes.
+func (s *syncerImpl) doWork(work *Work, dest Destination, opts Options) error {
+	s.upload(work.ToUpload, dest, opts)
+	if len(work.ToUpload) > 0 {
+		fmt.Printf("DONE.  Uploading complete.\n")
+	}
+
+	for i, del := range work.ToDelete {
+		fmt.Fprintf(os.Stdout, "\033[2K\rDeleting image %d/%d...", i+1, len(work.ToDelete))
+		err := dest.Delete(del)
+		if err != nil {
+			fmt.Printf("\nError deleting photo %s (skipping): %v\n", del.Filename(), err)
+		}
+	}
+	if len(work.ToDelete) > 0 {
+		fmt.Printf("DONE.  Deleting complete.\n")
+	}
+
+	err := dest.Publish(work.changed(opts))
+	if err != nil {
+		return err
+	}
+	return s.caption(work, dest)
+}
+
+// upload uploads items to dest using a pool of MaxConcurrentUploads workers.
+// Progress is reported in the order of items, no matter which upload
+// finishes first.
+func (s *syncerImpl) upload(items []SourceItem, dest Destination, opts Options) {
+	workers := opts.MaxConcurrentUploads
+	if workers < 1 {
+		workers = 1
+	}
+	pending := s.prom.uploadsPending.WithLabelValues(dest.Name())
+	pending.Set(float64(len(items)))
+
+	errs := make([]error, len(items))
+	done := make([]chan struct{}, len(items))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	next := make(chan int)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range next {
+				errs[i] = dest.Upload(items[i])
+				pending.Dec()
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range items {
+			next <- i
+		}
+		close(next)
+	}()
+
+	for i, up := range items {
+		<-done[i]
+		fmt.Fprintf(os.Stdout, "\033[2K\rUploading image %d/%d...", i+1, len(items))
+		if errs[i] != nil {
+			fmt.Printf("\nError uploading photo %s (skipping): %v\n", up.Filename(), errs[i])
+		}
+	}
+}
+
+func (w *Work) changed(opts Options) bool {
+	return len(w.ToUpload) > 0 || len(w.ToDelete) > 0 || opts.ForcePublish
+}
+
+// CalcWork compares source and destination items by MD5.
+func CalcWork(sourceItems []SourceItem, destItems []DestinationItem) (*Work, error) {
+	work := Work{}
+
+	// Create a lookup-by-md5 for all the items already in the destination
+	targetMd5s := make(map[string]DestinationItem)
+	for _, item := range destItems {
+		alreadyThere, ok := targetMd5s[item.Md5()]
+		if ok {
+			fmt.Printf(
+				"Warning: duplicate images with MD5 %s (%s, %s)\n",
+				item.Md5(), alreadyThere.Filename(), item.Filename(),
+			)
+			continue
+		}
+		targetMd5s[item.Md5()] = item
+	}
+
+	// For each source item, find if it is already in the destination.
+	for _, item := range sourceItems {
+		_, ok := targetMd5s[item.Md5()]
+		if !ok {
+			work.ToUpload = append(work.ToUpload, item)
+			continue
+		}
+
+		// If it is present, delete it from targetMd5s so it won't count toward
+		// toDelete.  This only works if there are no duplicates in source.
+		delete(targetMd5s, item.Md5())
+	}
+
+	// Everything left isn't referenced by any source item.  Walk destItems
+	// rather than the map so the order is stable.
+	for _, item := range destItems {
+		if targetMd5s[item.Md5()] == item {
+			work.ToDelete = append(work.ToDelete, item)
+		}
+	}
+
+	return &work, nil
+}
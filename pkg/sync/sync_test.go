@@ -0,0 +1,179 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos"
+	"github.com/andrewjjenkins/picsync/pkg/googlephotos/googlephotostest"
+	"github.com/andrewjjenkins/picsync/pkg/nixplay"
+	"github.com/andrewjjenkins/picsync/pkg/nixplay/nixplaytest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestSync syncs a Google Photos album to a Nixplay album a few times,
+// against the fakes, changing things on both sides in between.
+func TestSync(t *testing.T) {
+	defer func(d time.Duration) { digestDelay = d }(digestDelay)
+	digestDelay = 0
+
+	gp := googlephotostest.NewServer()
+	defer gp.Close()
+	np := nixplaytest.NewServer("user@example.com", "password")
+	defer np.Close()
+
+	reg := prometheus.NewRegistry()
+	c, err := cache.New(reg, filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gpClient := googlephotos.NewClient("id", "secret", context.Background(), gp.Token(), c, reg,
+		googlephotos.Options{BaseURL: gp.URL, TokenURL: gp.TokenURL()})
+	npClient, err := nixplay.NewClient(np.Username, np.Password, reg, nixplay.Options{BaseURL: np.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer := New(reg)
+
+	album := gp.AddAlbum("Family", false)
+	want := make(map[string]string)
+	add := func(n int) *googlephotos.MediaItem {
+		content := []byte(fmt.Sprintf("photo %d", n))
+		item := gp.AddMediaItem(album.Id, googlephotos.MediaItem{
+			Filename: fmt.Sprintf("photo%d.jpg", n),
+			MimeType: "image/jpeg",
+		}, content)
+		want[item.Id] = md5Of(content)
+		return item
+	}
+	first := add(1)
+	add(2)
+	add(3)
+	frame := np.AddFrame("Kitchen", "SN1")
+
+	opts := Options{
+		Playlists: []PlaylistOptions{{Name: "ss_Family", Frames: []string{"Kitchen"}}},
+	}
+	sync := func() {
+		t.Helper()
+		source := NewGooglephotosAlbumSource(gpClient, googlephotos.AlbumRef{ID: album.Id}, googlephotos.VideoPolicy{})
+		dest := NewNixplayDestination(npClient, c, "Family", NixplayOptions{MaxAge: time.Hour})
+		if err := syncer.Sync([]Source{source}, dest, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// check that the Nixplay album, its cached listing and the playlist all
+	// have the photos in want, and returns the playlist.
+	check := func(step string) nixplay.Playlist {
+		t.Helper()
+		albums := np.Albums()
+		if len(albums) != 1 || albums[0].Title != "Family" {
+			t.Fatalf("%s: albums %v, want just Family", step, albums)
+		}
+		var wantMd5s, gotMd5s []string
+		for _, md5 := range want {
+			wantMd5s = append(wantMd5s, md5)
+		}
+		var ids []int
+		for _, p := range np.Photos(albums[0].ID) {
+			gotMd5s = append(gotMd5s, p.Md5)
+			ids = append(ids, p.ID)
+		}
+		sort.Strings(wantMd5s)
+		sort.Strings(gotMd5s)
+		if fmt.Sprint(gotMd5s) != fmt.Sprint(wantMd5s) {
+			t.Errorf("%s: album has %v, want %v", step, gotMd5s, wantMd5s)
+		}
+
+		_, cached, err := c.GetNixplayAlbum(albums[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cachedMd5s []string
+		for _, n := range cached {
+			cachedMd5s = append(cachedMd5s, n.Md5)
+		}
+		sort.Strings(cachedMd5s)
+		if fmt.Sprint(cachedMd5s) != fmt.Sprint(gotMd5s) {
+			t.Errorf("%s: cached listing has %v, album has %v", step, cachedMd5s, gotMd5s)
+		}
+
+		playlists := np.Playlists()
+		if len(playlists) != 1 {
+			t.Fatalf("%s: %d playlists, want 1", step, len(playlists))
+		}
+		items := np.PlaylistItems(playlists[0].Id)
+		sort.Ints(ids)
+		sort.Ints(items)
+		if fmt.Sprint(items) != fmt.Sprint(ids) {
+			t.Errorf("%s: playlist has %v, album has %v", step, items, ids)
+		}
+		return playlists[0]
+	}
+
+	// The album and playlist are made, and the playlist is assigned to the
+	// frame.
+	sync()
+	playlist := check("first sync")
+	if got := np.Frames()[0].Playlists; len(got) != 1 || got[0] != playlist.Id {
+		t.Errorf("frame %s shows %v, want [%d]", frame.Name, got, playlist.Id)
+	}
+	if got := np.PlaylistItemsAdded(); got != 3 {
+		t.Errorf("%d playlist items added, want 3", got)
+	}
+	if got := np.Logins(); got != 1 {
+		t.Errorf("%d logins, want 1", got)
+	}
+
+	// Nothing changed: the cached listing is used, and nothing is
+	// downloaded, uploaded or published.
+	downloads := gp.Downloads()
+	listings := counter(t, reg, "nixplay_get_photos_success")
+	uploads := counter(t, reg, "nixplay_upload_photos_success")
+	sync()
+	check("unchanged sync")
+	if got := gp.Downloads() - downloads; got != 0 {
+		t.Errorf("unchanged sync downloaded %d items", got)
+	}
+	if got := counter(t, reg, "nixplay_get_photos_success") - listings; got != 0 {
+		t.Errorf("unchanged sync listed the album %v times, want the cached listing", got)
+	}
+	if got := counter(t, reg, "nixplay_upload_photos_success") - uploads; got != 0 {
+		t.Errorf("unchanged sync uploaded %v photos", got)
+	}
+	if got := np.PlaylistItemsAdded(); got != 3 {
+		t.Errorf("unchanged sync added %d playlist items", got-3)
+	}
+
+	// One photo is removed and one added in Google Photos, the playlist is
+	// renamed in the Nixplay app, and the session expires.  The photos are
+	// deleted and uploaded after logging in again, the album is listed
+	// once to find the upload, and the renamed playlist gets just the new
+	// photo.
+	gp.RemoveMediaItem(album.Id, first.Id)
+	delete(want, first.Id)
+	add(4)
+	np.RenamePlaylist(playlist.Id, "Kitchen slideshow")
+	np.ExpireSession()
+	listings = counter(t, reg, "nixplay_get_photos_success")
+	sync()
+	renamed := check("changed sync")
+	if renamed.Id != playlist.Id || renamed.Name != "Kitchen slideshow" {
+		t.Errorf("published to playlist %d %s, want %d Kitchen slideshow", renamed.Id, renamed.Name, playlist.Id)
+	}
+	if got := np.Logins(); got != 2 {
+		t.Errorf("%d logins, want 2", got)
+	}
+	if got := counter(t, reg, "nixplay_get_photos_success") - listings; got != 1 {
+		t.Errorf("changed sync listed the album %v times, want 1", got)
+	}
+	if got := np.PlaylistItemsAdded(); got != 4 {
+		t.Errorf("changed sync added %d playlist items, want 1", got-3)
+	}
+}
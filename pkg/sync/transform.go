@@ -0,0 +1,137 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"os"
+	gosync "sync"
+
+	"github.com/andrewjjenkins/picsync/pkg/transform"
+)
+
+type transformSource struct {
+	source   Source
+	client   transform.Client
+	settings transform.Settings
+}
+
+// NewTransformSource returns a Source with the items of source, transformed
+// (e.g. resized) with settings before they are uploaded.  Transformed items
+// have the MD5 of the transformed image, which is cached by the original's
+// SHA256 and settings, so an image is only transformed again if it changes
+// or the settings do.
+func NewTransformSource(source Source, client transform.Client, settings transform.Settings) Source {
+	return &transformSource{
+		source:   source,
+		client:   client,
+		settings: settings,
+	}
+}
+
+func (s *transformSource) Name() string {
+	return s.source.Name()
+}
+
+func (s *transformSource) Items(progress SourceProgressFunc) ([]SourceItem, error) {
+	return s.source.Items(progress)
+}
+
+// Prepare transforms the items (that aren't already in the cache).
+func (s *transformSource) Prepare(items []SourceItem) ([]SourceItem, error) {
+	prepared := make([]SourceItem, len(items))
+	errs := make([]error, len(items))
+	var toTransform []int
+	for i, item := range items {
+		if _, done := item.(*transformedItem); done || !s.settings.Applies(item.Filename()) {
+			prepared[i] = item
+			continue
+		}
+		cached, err := s.client.Cached(item.Sha256(), s.settings)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			prepared[i] = s.transformed(item, cached.Md5, cached.Sha256)
+			continue
+		}
+		toTransform = append(toTransform, i)
+	}
+
+	// These run concurrently, bounded by the transform client.
+	var wg gosync.WaitGroup
+	for _, i := range toTransform {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := s.client.Transform(items[i].Sha256(), s.settings, openFunc(items[i]))
+			if err != nil {
+				errs[i] = fmt.Errorf("transforming %s: %v", items[i].Filename(), err)
+				return
+			}
+			prepared[i] = s.transformed(items[i], result.Md5, result.Sha256)
+		}(i)
+	}
+	wg.Wait()
+
+	for n, i := range toTransform {
+		fmt.Fprintf(os.Stdout, "\033[2K\rTransformed image %d/%d...", n+1, len(toTransform))
+		if errs[i] != nil {
+			fmt.Println()
+			return nil, errs[i]
+		}
+	}
+	if len(toTransform) > 0 {
+		fmt.Printf("DONE.  Transformed %d images.\n", len(toTransform))
+	}
+	return prepared, nil
+}
+
+func (s *transformSource) transformed(item SourceItem, md5 string, sha256 string) SourceItem {
+	return &transformedItem{
+		SourceItem: item,
+		source:     s,
+		md5:        md5,
+		sha256:     sha256,
+	}
+}
+
+func openFunc(item SourceItem) transform.OpenFunc {
+	return func() (io.ReadCloser, string, error) {
+		content, err := item.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		return content.Body, content.ContentType, nil
+	}
+}
+
+// transformedItem is a SourceItem that is transformed when opened (unless
+// the transformed image was stored when it was prepared).  Its SHA256 (and
+// ID) are still the original's.
+type transformedItem struct {
+	SourceItem
+	source *transformSource
+	md5    string
+
+	// sha256 is the transformed image's.
+	sha256 string
+}
+
+func (i *transformedItem) Md5() string { return i.md5 }
+
+func (i *transformedItem) Open() (*Content, error) {
+	b, err := i.source.client.Open(i.SourceItem.Sha256(), i.source.settings, openFunc(i.SourceItem))
+	if err != nil {
+		return nil, err
+	}
+	if b.Sha256 != i.sha256 {
+		// Shouldn't happen, but if it does the cache now has the new MD5
+		// and the next sync will match it.
+		fmt.Printf("\nWarning: transforming %s again made a different image\n", i.Filename())
+	}
+	return &Content{
+		Body:        b,
+		ContentType: b.ContentType,
+		Size:        uint64(b.Size),
+	}, nil
+}
@@ -0,0 +1,170 @@
+package transform
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/andrewjjenkins/picsync/pkg/blob"
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/andrewjjenkins/picsync/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OpenFunc starts reading an image, returning its body and content type.
+type OpenFunc func() (io.ReadCloser, string, error)
+
+// Result is a transformed image.
+type Result struct {
+	Data        []byte
+	ContentType string
+	Sha256      string
+	Md5         string
+}
+
+type Client interface {
+	// Cached returns what transforming the image with SHA256 sourceSha256
+	// made last time, or nil if it hasn't been transformed with s.
+	Cached(sourceSha256 string, s Settings) (*cache.TransformData, error)
+
+	// Transform opens the image with SHA256 sourceSha256 and transforms it,
+	// recording the result's hashes in the cache.
+	Transform(sourceSha256 string, s Settings, open OpenFunc) (*Result, error)
+
+	// Open returns the image with SHA256 sourceSha256 transformed with s.
+	// The stored result of the last Transform is used if there is one;
+	// otherwise it is transformed again.
+	Open(sourceSha256 string, s Settings, open OpenFunc) (*blob.Blob, error)
+}
+
+// Options are optional settings for a Client.  The zero value is the
+// default for each.
+type Options struct {
+	// MaxConcurrent bounds how many images are transformed at once (default
+	// 1).  Each needs the whole image in memory.
+	MaxConcurrent int
+
+	// Blobs, if set, keeps transformed images, so that Open doesn't have to
+	// transform them again.
+	Blobs blob.Store
+}
+
+type clientImpl struct {
+	cache cache.Cache
+
+	// cacheMu serializes writes; sqlite doesn't like concurrent writers.
+	cacheMu sync.Mutex
+	running *util.Limiter
+	blobs   blob.Store
+
+	prom promImpl
+}
+
+// NewClient returns a Client that transforms images, recording the results
+// in c.
+func NewClient(c cache.Cache, reg prometheus.Registerer, opts Options) Client {
+	transformClient := clientImpl{
+		cache: c,
+		blobs: opts.Blobs,
+	}
+
+	transformClient.promRegister(reg)
+	transformClient.running = util.NewLimiter(opts.MaxConcurrent, transformClient.prom.imagesInFlight)
+
+	return &transformClient
+}
+
+func (c *clientImpl) Cached(sourceSha256 string, s Settings) (*cache.TransformData, error) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	return c.cache.GetTransform(sourceSha256, s.Key())
+}
+
+func (c *clientImpl) Transform(sourceSha256 string, s Settings, open OpenFunc) (*Result, error) {
+	c.running.Acquire()
+	defer c.running.Release()
+
+	body, contentType, err := open()
+	if err != nil {
+		c.prom.imagesFailure.Inc()
+		return nil, err
+	}
+	in, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		c.prom.imagesFailure.Inc()
+		return nil, err
+	}
+
+	out, err := apply(in, s)
+	if err != nil {
+		c.prom.imagesFailure.Inc()
+		return nil, err
+	}
+	c.prom.imagesSuccess.Inc()
+	c.prom.inputBytes.Add(float64(len(in)))
+	c.prom.outputBytes.Add(float64(len(out)))
+	if !bytes.Equal(in, out) {
+		c.prom.imagesChanged.Inc()
+	}
+
+	sha256Sum := sha256.Sum256(out)
+	md5Sum := md5.Sum(out)
+	result := &Result{
+		Data:        out,
+		ContentType: contentType,
+		Sha256:      hex.EncodeToString(sha256Sum[:]),
+		Md5:         hex.EncodeToString(md5Sum[:]),
+	}
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	err = c.cache.UpsertTransform(&cache.TransformData{
+		SourceSha256: sourceSha256,
+		Settings:     s.Key(),
+		Sha256:       result.Sha256,
+		Md5:          result.Md5,
+		Size:         int64(len(out)),
+		LastUpdated:  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.blobs != nil {
+		stored := c.blobs.Tee(ioutil.NopCloser(bytes.NewReader(out)), int64(len(out)), contentType, result.Sha256)
+		io.Copy(ioutil.Discard, stored)
+		stored.Close()
+	}
+	return result, nil
+}
+
+func (c *clientImpl) Open(sourceSha256 string, s Settings, open OpenFunc) (*blob.Blob, error) {
+	if c.blobs != nil {
+		cached, err := c.Cached(sourceSha256, s)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			b, err := c.blobs.Open(cached.Sha256)
+			if err != nil || b != nil {
+				return b, err
+			}
+		}
+	}
+
+	result, err := c.Transform(sourceSha256, s, open)
+	if err != nil {
+		return nil, err
+	}
+	return &blob.Blob{
+		ReadCloser:  ioutil.NopCloser(bytes.NewReader(result.Data)),
+		Sha256:      result.Sha256,
+		ContentType: result.ContentType,
+		Size:        int64(len(result.Data)),
+	}, nil
+}
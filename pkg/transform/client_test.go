@@ -0,0 +1,78 @@
+package transform
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewjjenkins/picsync/pkg/blob"
+	"github.com/andrewjjenkins/picsync/pkg/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestClient(t *testing.T, withBlobs bool) Client {
+	reg := prometheus.NewRegistry()
+	dir := t.TempDir()
+	c, err := cache.New(reg, filepath.Join(dir, "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opts Options
+	if withBlobs {
+		opts.Blobs, err = blob.NewStore(c, reg, blob.Options{Dir: filepath.Join(dir, "blobs")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewClient(c, reg, opts)
+}
+
+func TestOpenReusesTransform(t *testing.T) {
+	in := testJPEG(t, blocks("ABCD", "EFGH"), nil, 0)
+	sum := sha256.Sum256(in)
+	sourceSha256 := hex.EncodeToString(sum[:])
+	settings := Settings{MaxWidth: 32}
+
+	for _, withBlobs := range []bool{false, true} {
+		client := newTestClient(t, withBlobs)
+		opens := 0
+		open := func() (io.ReadCloser, string, error) {
+			opens++
+			return ioutil.NopCloser(bytes.NewReader(in)), "image/jpeg", nil
+		}
+
+		result, err := client.Transform(sourceSha256, settings, open)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := client.Open(sourceSha256, settings, open)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(b)
+		b.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, result.Data) || b.Sha256 != result.Sha256 || b.Size != int64(len(data)) {
+			t.Errorf("blobs %t: opened %d bytes (%s), transformed %d (%s)",
+				withBlobs, len(data), b.Sha256, len(result.Data), result.Sha256)
+		}
+		if b.ContentType != "image/jpeg" {
+			t.Errorf("blobs %t: content type %q", withBlobs, b.ContentType)
+		}
+		// With a blob store, the original isn't read again.
+		wantOpens := 2
+		if withBlobs {
+			wantOpens = 1
+		}
+		if opens != wantOpens {
+			t.Errorf("blobs %t: original opened %d times, want %d", withBlobs, opens, wantOpens)
+		}
+	}
+}
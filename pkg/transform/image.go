@@ -0,0 +1,341 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// apply transforms the image in (a JPEG or PNG).  If nothing needs to
+// change, it returns in.
+//
+// Re-encoding drops all metadata, so the EXIF orientation is applied to the
+// pixels first (otherwise the frame would show the photo sideways).  If only
+// StripGPS is set, the metadata is removed without re-encoding, unless the
+// orientation needs to be applied.
+func apply(in []byte, s Settings) ([]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	if format != "jpeg" && format != "png" {
+		return in, nil
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(in)
+	}
+	width, height := config.Width, config.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	newWidth, newHeight := fit(width, height, s.MaxWidth, s.MaxHeight)
+	resize := newWidth != width || newHeight != height
+
+	reencode := resize || (format == "jpeg" && s.Quality > 0)
+	if !reencode {
+		if s.StripGPS && format == "jpeg" {
+			if orientation == 1 {
+				return stripMetadata(in)
+			}
+			// Stripping the metadata would lose the orientation.
+			reencode = true
+		} else {
+			return in, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	rgba := orient(img, orientation)
+	if resize {
+		rgba = scale(rgba, newWidth, newHeight)
+	}
+
+	var out bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&out, rgba)
+	} else {
+		quality := s.Quality
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+		err = jpeg.Encode(&out, rgba, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// fit returns the size of a width x height image shrunk to fit in maxWidth x
+// maxHeight (0 is no limit).  It never grows an image.
+func fit(width, height, maxWidth, maxHeight int) (int, int) {
+	ratio := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		ratio = math.Min(ratio, float64(maxWidth)/float64(width))
+	}
+	if maxHeight > 0 && height > maxHeight {
+		ratio = math.Min(ratio, float64(maxHeight)/float64(height))
+	}
+	if ratio == 1.0 {
+		return width, height
+	}
+	newWidth := int(math.Round(float64(width) * ratio))
+	newHeight := int(math.Round(float64(height) * ratio))
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+	return newWidth, newHeight
+}
+
+// orient returns img turned the right way up for an EXIF orientation (1-8).
+func orient(img image.Image, orientation int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := b.Dx(), b.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = w-1-x, y
+			case 3: // Upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // Mirrored, on its side
+				sx, sy = y, x
+			case 6: // Needs turning clockwise
+				sx, sy = y, h-1-x
+			case 7: // Mirrored, on its other side
+				sx, sy = w-1-y, h-1-x
+			case 8: // Needs turning counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4],
+				src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// contribution is the source pixels (from start, with weights summing to 1)
+// that make up one destination pixel.
+type contribution struct {
+	start   int
+	weights []float32
+}
+
+// contributions works out, for shrinking srcLen pixels to dstLen, how much
+// each source pixel contributes to each destination pixel (the area of it
+// that the destination pixel covers).
+func contributions(srcLen, dstLen int) []contribution {
+	ratio := float64(srcLen) / float64(dstLen)
+	out := make([]contribution, dstLen)
+	for d := range out {
+		lo := float64(d) * ratio
+		hi := lo + ratio
+		start := int(lo)
+		end := int(math.Ceil(hi))
+		if end > srcLen {
+			end = srcLen
+		}
+		weights := make([]float32, end-start)
+		var sum float64
+		for s := start; s < end; s++ {
+			w := math.Min(hi, float64(s+1)) - math.Max(lo, float64(s))
+			weights[s-start] = float32(w)
+			sum += w
+		}
+		for i := range weights {
+			weights[i] /= float32(sum)
+		}
+		out[d] = contribution{start: start, weights: weights}
+	}
+	return out
+}
+
+// scale shrinks src to width x height by averaging the pixels each new pixel
+// covers.  That's the best simple filter for shrinking photos a lot.
+func scale(src *image.RGBA, width, height int) *image.RGBA {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// Horizontally first, into floats.
+	cols := contributions(srcW, width)
+	tmp := make([]float32, width*srcH*4)
+	for y := 0; y < srcH; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, c := range cols {
+			var r, g, b, a float32
+			for i, w := range c.weights {
+				p := row[(c.start+i)*4:]
+				r += w * float32(p[0])
+				g += w * float32(p[1])
+				b += w * float32(p[2])
+				a += w * float32(p[3])
+			}
+			t := tmp[(y*width+x)*4:]
+			t[0], t[1], t[2], t[3] = r, g, b, a
+		}
+	}
+
+	// Then vertically.
+	rows := contributions(srcH, height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, c := range rows {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float32
+			for i, w := range c.weights {
+				t := tmp[((c.start+i)*width+x)*4:]
+				r += w * t[0]
+				g += w * t[1]
+				b += w * t[2]
+				a += w * t[3]
+			}
+			p := dst.Pix[y*dst.Stride+x*4:]
+			p[0], p[1], p[2], p[3] = clamp(r), clamp(g), clamp(b), clamp(a)
+		}
+	}
+	return dst
+}
+
+func clamp(v float32) uint8 {
+	v += 0.5
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// jpegSegments calls f with the marker and contents of each JPEG segment
+// before the image data (which starts at the returned offset).
+func jpegSegments(in []byte, f func(marker byte, segment []byte)) (int, error) {
+	if len(in) < 2 || in[0] != 0xFF || in[1] != 0xD8 {
+		return 0, fmt.Errorf("not a JPEG")
+	}
+	i := 2
+	for i+4 <= len(in) {
+		if in[i] != 0xFF {
+			return 0, fmt.Errorf("bad JPEG marker at %d", i)
+		}
+		marker := in[i+1]
+		if marker == 0xFF {
+			// Fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan (or end of image): no more metadata.
+			return i, nil
+		}
+		size := int(binary.BigEndian.Uint16(in[i+2:]))
+		if size < 2 || i+2+size > len(in) {
+			return 0, fmt.Errorf("bad JPEG segment at %d", i)
+		}
+		f(marker, in[i+4:i+2+size])
+		i += 2 + size
+	}
+	return 0, fmt.Errorf("JPEG has no image data")
+}
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// exifOrientation returns the EXIF orientation of a JPEG (1, the right way
+// up, if it doesn't say).
+func exifOrientation(in []byte) int {
+	orientation := 1
+	jpegSegments(in, func(marker byte, segment []byte) {
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			if o := tiffOrientation(segment[len(exifHeader):]); o != 0 {
+				orientation = o
+			}
+		}
+	})
+	return orientation
+}
+
+// tiffOrientation finds the orientation tag in the first IFD of EXIF data
+// (which is TIFF), or returns 0.
+func tiffOrientation(t []byte) int {
+	if len(t) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(t[4:]))
+	if ifd < 8 || ifd+2 > len(t) {
+		return 0
+	}
+	entries := int(order.Uint16(t[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(t) {
+			return 0
+		}
+		if order.Uint16(t[entry:]) == 0x0112 {
+			o := int(order.Uint16(t[entry+8:]))
+			if o < 1 || o > 8 {
+				return 0
+			}
+			return o
+		}
+	}
+	return 0
+}
+
+// stripMetadata removes EXIF and XMP (where GPS locations are kept) from a
+// JPEG without re-encoding it.
+func stripMetadata(in []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(in[:2])
+	scan, err := jpegSegments(in, func(marker byte, segment []byte) {
+		if marker == 0xE1 && (bytes.HasPrefix(segment, exifHeader) || bytes.HasPrefix(segment, xmpHeader)) {
+			return
+		}
+		var header [4]byte
+		header[0], header[1] = 0xFF, marker
+		binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+		out.Write(header[:])
+		out.Write(segment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Write(in[scan:])
+	return out.Bytes(), nil
+}
@@ -0,0 +1,313 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// letters makes an image from rows of letters, each a different gray.
+func letters(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x := 0; x < len(row); x++ {
+			img.Set(x, y, color.RGBA{row[x], row[x], row[x], 255})
+		}
+	}
+	return img
+}
+
+func unletters(img *image.RGBA) []string {
+	var rows []string
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		var row []byte
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			row = append(row, img.RGBAAt(x, y).R)
+		}
+		rows = append(rows, string(row))
+	}
+	return rows
+}
+
+// stored are how a camera stores this upright image for each EXIF
+// orientation:
+//
+//	ABC
+//	DEF
+var stored = map[int][]string{
+	1: {"ABC", "DEF"},
+	2: {"CBA", "FED"},
+	3: {"FED", "CBA"},
+	4: {"DEF", "ABC"},
+	5: {"AD", "BE", "CF"},
+	6: {"CF", "BE", "AD"},
+	7: {"FC", "EB", "DA"},
+	8: {"DA", "EB", "FC"},
+}
+
+func TestOrient(t *testing.T) {
+	for orientation := 1; orientation <= 8; orientation++ {
+		got := unletters(orient(letters(stored[orientation]...), orientation))
+		if len(got) != 2 || got[0] != "ABC" || got[1] != "DEF" {
+			t.Errorf("orientation %d: %v turned into %v, want [ABC DEF]", orientation, stored[orientation], got)
+		}
+	}
+	// Unknown orientations are left alone.
+	if got := unletters(orient(letters("AB"), 9)); got[0] != "AB" {
+		t.Errorf("orientation 9: turned into %v", got)
+	}
+}
+
+// exifSegment is an APP1 segment with just an orientation, in TIFF of the
+// given byte order.
+func exifSegment(order binary.ByteOrder, orientation int) []byte {
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8))
+	// One IFD entry: orientation, SHORT, count 1.
+	binary.Write(&tiff, order, uint16(1))
+	binary.Write(&tiff, order, uint16(0x0112))
+	binary.Write(&tiff, order, uint16(3))
+	binary.Write(&tiff, order, uint32(1))
+	binary.Write(&tiff, order, uint16(orientation))
+	binary.Write(&tiff, order, uint16(0))
+	binary.Write(&tiff, order, uint32(0))
+
+	segment := append([]byte{}, exifHeader...)
+	segment = append(segment, tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	return out.Bytes()
+}
+
+// testJPEG encodes img, with EXIF orientation if it isn't 0.
+func testJPEG(t *testing.T, img image.Image, order binary.ByteOrder, orientation int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	in := buf.Bytes()
+	if orientation == 0 {
+		return in
+	}
+	var out bytes.Buffer
+	out.Write(in[:2])
+	out.Write(exifSegment(order, orientation))
+	out.Write(in[2:])
+	return out.Bytes()
+}
+
+func TestExifOrientation(t *testing.T) {
+	img := letters("AB", "CD")
+	if got := exifOrientation(testJPEG(t, img, nil, 0)); got != 1 {
+		t.Errorf("no EXIF: orientation %d, want 1", got)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for orientation := 1; orientation <= 8; orientation++ {
+			if got := exifOrientation(testJPEG(t, img, order, orientation)); got != orientation {
+				t.Errorf("%v: orientation %d, want %d", order, got, orientation)
+			}
+		}
+		if got := exifOrientation(testJPEG(t, img, order, 9)); got != 1 {
+			t.Errorf("%v: bad orientation 9 read as %d, want 1", order, got)
+		}
+	}
+}
+
+// blocks scales up an image of letters, so each is a 16x16 block of color
+// that survives JPEG compression.
+func blocks(rows ...string) *image.RGBA {
+	small := letters(rows...)
+	b := small.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx()*16, b.Dy()*16))
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			img.Set(x, y, small.At(x/16, y/16))
+		}
+	}
+	return img
+}
+
+// near is true if c is within a little of gray level want (JPEG isn't
+// exact).
+func near(c color.Color, want uint8) bool {
+	r, _, _, _ := c.RGBA()
+	d := int(r>>8) - int(want)
+	return d > -12 && d < 12
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// Letters far apart in gray, so JPEG can't mix them up.
+	upright := []string{"(Px", "0X\xa0"}
+	turn := map[int][]string{
+		2: {"xP(", "\xa0X0"},
+		3: {"\xa0X0", "xP("},
+		4: {"0X\xa0", "(Px"},
+		5: {"(0", "PX", "x\xa0"},
+		6: {"x\xa0", "PX", "(0"},
+		7: {"\xa0x", "XP", "0("},
+		8: {"0(", "XP", "\xa0x"},
+	}
+	for orientation := 2; orientation <= 8; orientation++ {
+		in := testJPEG(t, blocks(turn[orientation]...), binary.BigEndian, orientation)
+		// Only stripping metadata would lose the orientation, so it must be
+		// applied.
+		out, err := apply(in, Settings{StripGPS: true})
+		if err != nil {
+			t.Fatalf("orientation %d: %v", orientation, err)
+		}
+		if got := exifOrientation(out); got != 1 {
+			t.Errorf("orientation %d: output has orientation %d", orientation, got)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 48 || b.Dy() != 32 {
+			t.Errorf("orientation %d: output is %dx%d, want 48x32", orientation, b.Dx(), b.Dy())
+			continue
+		}
+		for y, row := range upright {
+			for x := 0; x < len(row); x++ {
+				if !near(img.At(x*16+8, y*16+8), row[x]) {
+					t.Errorf("orientation %d: block %d,%d is %v, want gray %d",
+						orientation, x, y, img.At(x*16+8, y*16+8), row[x])
+				}
+			}
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height, maxWidth, maxHeight int
+		wantWidth, wantHeight              int
+	}{
+		{4000, 3000, 0, 0, 4000, 3000},
+		{4000, 3000, 1280, 0, 1280, 960},
+		{4000, 3000, 0, 800, 1067, 800},
+		{4000, 3000, 1280, 800, 1067, 800},
+		{3000, 4000, 1280, 800, 600, 800},
+		{1000, 500, 1280, 800, 1000, 500},
+		{1000, 500, 1000, 500, 1000, 500},
+		{10000, 1, 100, 100, 100, 1},
+	}
+	for _, tt := range tests {
+		w, h := fit(tt.width, tt.height, tt.maxWidth, tt.maxHeight)
+		if w != tt.wantWidth || h != tt.wantHeight {
+			t.Errorf("fit(%d, %d, %d, %d) = %d, %d, want %d, %d",
+				tt.width, tt.height, tt.maxWidth, tt.maxHeight, w, h, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	// A checkerboard averages to gray.
+	checker := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				checker.Set(x, y, color.White)
+			} else {
+				checker.Set(x, y, color.Black)
+			}
+		}
+	}
+	small := scale(checker, 4, 4)
+	if b := small.Bounds(); b.Dx() != 4 || b.Dy() != 4 {
+		t.Fatalf("scaled to %dx%d, want 4x4", b.Dx(), b.Dy())
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if c := small.RGBAAt(x, y); c.R != 128 || c.A != 255 {
+				t.Errorf("pixel %d,%d is %v, want gray 128", x, y, c)
+			}
+		}
+	}
+
+	// New pixels that only cover one block keep its color, and one that
+	// covers both is in between.
+	img := blocks("AZ")
+	small = scale(img, 3, 1)
+	if got := small.RGBAAt(0, 0).R; got != 'A' {
+		t.Errorf("left pixel %d, want %d", got, 'A')
+	}
+	if got := small.RGBAAt(2, 0).R; got != 'Z' {
+		t.Errorf("right pixel %d, want %d", got, 'Z')
+	}
+	if got := small.RGBAAt(1, 0).R; got <= 'A' || got >= 'Z' {
+		t.Errorf("middle pixel %d, want between %d and %d", got, 'A', 'Z')
+	}
+}
+
+func TestApplyResize(t *testing.T) {
+	img := blocks("ABCD", "EFGH")
+	jpg := testJPEG(t, img, nil, 0)
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		in         []byte
+		settings   Settings
+		wantFormat string
+		wantWidth  int
+		wantHeight int
+		unchanged  bool
+	}{
+		{"jpeg fits", jpg, Settings{MaxWidth: 64}, "jpeg", 64, 32, true},
+		{"jpeg shrunk", jpg, Settings{MaxWidth: 32}, "jpeg", 32, 16, false},
+		{"jpeg shrunk by height", jpg, Settings{MaxWidth: 100, MaxHeight: 8}, "jpeg", 16, 8, false},
+		{"jpeg re-encoded", jpg, Settings{Quality: 50}, "jpeg", 64, 32, false},
+		{"png shrunk", pngBuf.Bytes(), Settings{MaxHeight: 16}, "png", 32, 16, false},
+		{"png quality is ignored", pngBuf.Bytes(), Settings{Quality: 50}, "png", 64, 32, true},
+	}
+	for _, tt := range tests {
+		out, err := apply(tt.in, tt.settings)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if unchanged := bytes.Equal(out, tt.in); unchanged != tt.unchanged {
+			t.Errorf("%s: unchanged is %t, want %t", tt.name, unchanged, tt.unchanged)
+		}
+		config, format, err := image.DecodeConfig(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if format != tt.wantFormat || config.Width != tt.wantWidth || config.Height != tt.wantHeight {
+			t.Errorf("%s: %s %dx%d, want %s %dx%d", tt.name,
+				format, config.Width, config.Height, tt.wantFormat, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	in := testJPEG(t, blocks("AB"), binary.LittleEndian, 1)
+	out, err := apply(in, Settings{StripGPS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, exifHeader) {
+		t.Errorf("EXIF wasn't removed")
+	}
+	// Without re-encoding, only the EXIF segment is removed.
+	if want := len(in) - len(exifSegment(binary.LittleEndian, 1)); len(out) != want {
+		t.Errorf("stripped to %d bytes, want %d", len(out), want)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped JPEG doesn't decode: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package transform
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type promImpl struct {
+	promFactory promauto.Factory
+
+	imagesSuccess  prometheus.Counter
+	imagesFailure  prometheus.Counter
+	imagesChanged  prometheus.Counter
+	inputBytes     prometheus.Counter
+	outputBytes    prometheus.Counter
+	imagesInFlight prometheus.Gauge
+}
+
+func (c *clientImpl) promRegister(reg prometheus.Registerer) error {
+	c.prom.promFactory = promauto.With(reg)
+
+	c.prom.imagesSuccess = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "transform_images_success",
+			Help: "Number of images that were transformed",
+		})
+	c.prom.imagesFailure = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "transform_images_failure",
+			Help: "Number of images that encountered an error while transforming",
+		})
+	c.prom.imagesChanged = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "transform_images_changed",
+			Help: "Number of transformed images that were changed (not already small enough)",
+		})
+	c.prom.inputBytes = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "transform_input_bytes",
+			Help: "Total bytes of all images before transforming",
+		})
+	c.prom.outputBytes = c.prom.promFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "transform_output_bytes",
+			Help: "Total bytes of all images after transforming",
+		})
+	c.prom.imagesInFlight = c.prom.promFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "transform_images_in_flight",
+			Help: "Number of images currently being transformed",
+		})
+	return nil
+}
@@ -0,0 +1,61 @@
+package transform
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Settings are how to transform an image.  The zero value does nothing.
+type Settings struct {
+	// MaxWidth and MaxHeight are the largest the image can be (0 is no
+	// limit).  Bigger images are shrunk to fit, keeping their shape.
+	MaxWidth  int
+	MaxHeight int
+
+	// Quality re-encodes JPEGs at this quality (1-100), even if they
+	// aren't resized.  Resized JPEGs use DefaultQuality if it is 0.
+	Quality int
+
+	// StripGPS removes metadata that can say where a photo was taken.
+	StripGPS bool
+}
+
+// DefaultQuality is the JPEG quality of resized images if not set.
+const DefaultQuality = 90
+
+// version changes if the same Settings would make different images, so
+// that cached results aren't reused.
+const version = 1
+
+// Enabled is true if s changes anything.
+func (s Settings) Enabled() bool {
+	return s != Settings{}
+}
+
+// Check returns an error if s doesn't make sense.
+func (s Settings) Check() error {
+	if s.MaxWidth < 0 || s.MaxHeight < 0 {
+		return fmt.Errorf("maxWidth and maxHeight can't be negative")
+	}
+	if s.Quality < 0 || s.Quality > 100 {
+		return fmt.Errorf("quality must be 1-100")
+	}
+	return nil
+}
+
+// Key identifies s (and this version of the transforms) in the cache.
+func (s Settings) Key() string {
+	return fmt.Sprintf("v%d w=%d h=%d q=%d stripgps=%t",
+		version, s.MaxWidth, s.MaxHeight, s.Quality, s.StripGPS)
+}
+
+// Applies is true if s can transform a file called filename.  Others (like
+// videos and GIFs) are synced as they are.
+func (s Settings) Applies(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg", ".png":
+		return s.Enabled()
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"fmt"
 	"os"
 
 	"gopkg.in/yaml.v2"
@@ -8,22 +9,124 @@ import (
 
 // Config has all the config (aside from credentials) for what to do.
 type Config struct {
-	Albums     []*ConfigAlbum   `yaml:"albums"`
-	Every      string           `yaml:"every,omitempty"`
-	Prometheus ConfigPrometheus `yaml:"prometheus,omitempty"`
-	Pprof      ConfigPprof      `yaml:"pprof,omitempty"`
+	Albums      []*ConfigAlbum    `yaml:"albums"`
+	Every       string            `yaml:"every,omitempty"`
+	Prometheus  ConfigPrometheus  `yaml:"prometheus,omitempty"`
+	Pprof       ConfigPprof       `yaml:"pprof,omitempty"`
+	Concurrency ConfigConcurrency `yaml:"concurrency,omitempty"`
+	Cache       ConfigCache       `yaml:"cache,omitempty"`
 }
 
 type ConfigAlbum struct {
-	Name         string             `yaml:"name"`
-	DryRun       *bool              `yaml:"dryRun,omitempty"`
-	Delete       *bool              `yaml:"delete,omitempty"`
-	ForcePublish *bool              `yaml:"forcePublish,omitempty"`
-	Sources      ConfigAlbumSources `yaml:"sources"`
+	Name         string             `yaml:"name" json:"name"`
+	DryRun       *bool              `yaml:"dryRun,omitempty" json:"dryRun,omitempty"`
+	Delete       *bool              `yaml:"delete,omitempty" json:"delete,omitempty"`
+	ForcePublish *bool              `yaml:"forcePublish,omitempty" json:"forcePublish,omitempty"`
+	Sources      ConfigAlbumSources `yaml:"sources" json:"sources"`
+
+	// MaxPhotos limits how many photos are synced, chosen by Selection
+	// (see sync.SampleOptions).  ReshuffleEvery is a duration like "168h".
+	MaxPhotos      int    `yaml:"maxPhotos,omitempty" json:"maxPhotos,omitempty"`
+	Selection      string `yaml:"selection,omitempty" json:"selection,omitempty"`
+	Seed           string `yaml:"seed,omitempty" json:"seed,omitempty"`
+	ReshuffleEvery string `yaml:"reshuffleEvery,omitempty" json:"reshuffleEvery,omitempty"`
+
+	Transform *ConfigTransform `yaml:"transform,omitempty" json:"transform,omitempty"`
+	Videos    *ConfigVideos    `yaml:"videos,omitempty" json:"videos,omitempty"`
+
+	// Caption is a template for each photo's caption (see sync.Captions).
+	Caption string `yaml:"caption,omitempty" json:"caption,omitempty"`
+
+	// Order is the slideshow's order (see sync.OrderOptions).  Random order
+	// uses Seed.
+	Order string `yaml:"order,omitempty" json:"order,omitempty"`
+
+	// Frames are the Nixplay frames (by name or serial number) the album's
+	// playlist is assigned to, as well as whatever else they show.
+	Frames []string `yaml:"frames,omitempty" json:"frames,omitempty"`
+
+	// Playlist configures the album's playlist, or Playlists configures
+	// several.  Order and Frames are the default for each.
+	Playlist  *ConfigPlaylist  `yaml:"playlist,omitempty" json:"playlist,omitempty"`
+	Playlists []ConfigPlaylist `yaml:"playlists,omitempty" json:"playlists,omitempty"`
+}
+
+// ConfigPlaylist is a playlist an album is published to (see
+// sync.PlaylistOptions).  Name is a template, like "ss_{{.Album}}".
+type ConfigPlaylist struct {
+	Name      string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Order     string   `yaml:"order,omitempty" json:"order,omitempty"`
+	Sources   []string `yaml:"sources,omitempty" json:"sources,omitempty"`
+	MaxPhotos int      `yaml:"maxPhotos,omitempty" json:"maxPhotos,omitempty"`
+	Frames    []string `yaml:"frames,omitempty" json:"frames,omitempty"`
+}
+
+// ConfigVideos says what to do with Google Photos videos (see
+// googlephotos.VideoPolicy).  MaxDuration is a duration like "90s".
+type ConfigVideos struct {
+	Policy      string `yaml:"policy,omitempty" json:"policy,omitempty"`
+	MaxSizeMB   int64  `yaml:"maxSizeMB,omitempty" json:"maxSizeMB,omitempty"`
+	MaxDuration string `yaml:"maxDuration,omitempty" json:"maxDuration,omitempty"`
+	Oversized   string `yaml:"oversized,omitempty" json:"oversized,omitempty"`
+}
+
+// ConfigTransform changes images before they are uploaded (see
+// transform.Settings).
+type ConfigTransform struct {
+	MaxWidth  int  `yaml:"maxWidth,omitempty" json:"maxWidth,omitempty"`
+	MaxHeight int  `yaml:"maxHeight,omitempty" json:"maxHeight,omitempty"`
+	Quality   int  `yaml:"quality,omitempty" json:"quality,omitempty"`
+	StripGPS  bool `yaml:"stripGps,omitempty" json:"stripGps,omitempty"`
 }
 
 type ConfigAlbumSources struct {
-	Googlephotos []string `json:"googlephotos,omitempty"`
+	// Googlephotos are album IDs, or titles (see googlephotos.AlbumRef).
+	Googlephotos       []string                    `yaml:"googlephotos,omitempty" json:"googlephotos,omitempty"`
+	GooglephotosSearch []*ConfigGooglephotosSearch `yaml:"googlephotosSearch,omitempty" json:"googlephotosSearch,omitempty"`
+	Local              []*ConfigLocalSource        `yaml:"local,omitempty" json:"local,omitempty"`
+}
+
+// ConfigGooglephotosSearch is a search of the whole Google Photos library,
+// or of an album if Album is set (see googlephotos.Search).
+type ConfigGooglephotosSearch struct {
+	Album             string   `yaml:"album,omitempty" json:"album,omitempty"`
+	From              string   `yaml:"from,omitempty" json:"from,omitempty"`
+	To                string   `yaml:"to,omitempty" json:"to,omitempty"`
+	Last              string   `yaml:"last,omitempty" json:"last,omitempty"`
+	OnThisDay         bool     `yaml:"onThisDay,omitempty" json:"onThisDay,omitempty"`
+	DaysAround        int      `yaml:"daysAround,omitempty" json:"daysAround,omitempty"`
+	Categories        []string `yaml:"categories,omitempty" json:"categories,omitempty"`
+	ExcludeCategories []string `yaml:"excludeCategories,omitempty" json:"excludeCategories,omitempty"`
+	MediaType         string   `yaml:"mediaType,omitempty" json:"mediaType,omitempty"`
+	Favorites         bool     `yaml:"favorites,omitempty" json:"favorites,omitempty"`
+}
+
+// ConfigLocalSource is a directory of images on the local filesystem.  It can
+// be written as just the path, or as a map with options.
+type ConfigLocalSource struct {
+	Path      string `yaml:"path" json:"path"`
+	Glob      string `yaml:"glob,omitempty" json:"glob,omitempty"`
+	Recursive bool   `yaml:"recursive,omitempty" json:"recursive,omitempty"`
+}
+
+func (s *ConfigLocalSource) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		s.Path = path
+		return nil
+	}
+
+	// Not a plain string; use the default unmarshalling for the map form.
+	type plain ConfigLocalSource
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	if p.Path == "" {
+		return fmt.Errorf("local source must have a path")
+	}
+	*s = ConfigLocalSource(p)
+	return nil
 }
 
 type ConfigPrometheus struct {
@@ -34,6 +137,53 @@ type ConfigPprof struct {
 	Listen string `yaml:"listen"`
 }
 
+// ConfigConcurrency limits how much is done at once with each service.  Zero
+// means the default of 1.
+type ConfigConcurrency struct {
+	// Downloads from Google Photos (for hashing or uploading)
+	Googlephotos int `yaml:"googlephotos,omitempty" json:"googlephotos,omitempty"`
+	// Uploads to Nixplay
+	Nixplay int `yaml:"nixplay,omitempty" json:"nixplay,omitempty"`
+	// Local files read for hashing
+	Local int `yaml:"local,omitempty" json:"local,omitempty"`
+	// Images transformed (resized) at once
+	Transform int `yaml:"transform,omitempty" json:"transform,omitempty"`
+}
+
+// ConfigCache configures the metadata cache.
+type ConfigCache struct {
+	// GC, if set, removes cache entries at the end of each sync when
+	// syncing every so often.
+	GC *ConfigCacheGC `yaml:"gc,omitempty"`
+
+	Nixplay ConfigCacheNixplay `yaml:"nixplay,omitempty"`
+
+	// Blobs, if set, keeps downloaded originals on disk.
+	Blobs *ConfigCacheBlobs `yaml:"blobs,omitempty"`
+}
+
+// ConfigCacheBlobs configures the blob store (see blob.Options).  Dir
+// defaults to the cache file's name with ".blobs" added, and MaxSizeMB of
+// 0 means no limit.
+type ConfigCacheBlobs struct {
+	Dir       string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	MaxSizeMB int64  `yaml:"maxSizeMB,omitempty" json:"maxSizeMB,omitempty"`
+}
+
+// ConfigCacheNixplay says how sync uses the cache of Nixplay albums (see
+// sync.NixplayOptions).  MaxAge is a duration like "6h".
+type ConfigCacheNixplay struct {
+	MaxAge string `yaml:"maxAge,omitempty"`
+}
+
+// ConfigCacheGC says which cache entries to remove (see cache.GCOptions).
+// OlderThan is a duration like "2160h".
+type ConfigCacheGC struct {
+	OlderThan             string `yaml:"olderThan,omitempty"`
+	MaxEntries            int    `yaml:"maxEntries,omitempty"`
+	NotReferencedByConfig bool   `yaml:"notReferencedByConfig,omitempty"`
+}
+
 func LoadConfig(filename string) (*Config, error) {
 	f, err := os.Open(filename)
 	if err != nil {
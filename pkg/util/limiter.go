@@ -0,0 +1,65 @@
+package util
+
+import (
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Limiter bounds how many of something can happen at once, and reports how
+// many are in flight to a gauge.
+type Limiter struct {
+	slots    chan struct{}
+	inFlight prometheus.Gauge
+}
+
+// NewLimiter returns a Limiter allowing max at once (at least 1).  inFlight
+// may be nil.
+func NewLimiter(max int, inFlight prometheus.Gauge) *Limiter {
+	if max < 1 {
+		max = 1
+	}
+	return &Limiter{
+		slots:    make(chan struct{}, max),
+		inFlight: inFlight,
+	}
+}
+
+// Acquire blocks until there is a free slot.
+func (l *Limiter) Acquire() {
+	l.slots <- struct{}{}
+	if l.inFlight != nil {
+		l.inFlight.Inc()
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (l *Limiter) Release() {
+	if l.inFlight != nil {
+		l.inFlight.Dec()
+	}
+	<-l.slots
+}
+
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	limiter *Limiter
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.limiter.Release)
+	return err
+}
+
+// ReleaseOnClose wraps rc so that closing it releases a slot taken by
+// Acquire.  This is useful when a slot covers a download that the caller
+// finishes reading later.
+func (l *Limiter) ReleaseOnClose(rc io.ReadCloser) io.ReadCloser {
+	return &releaseOnClose{
+		ReadCloser: rc,
+		limiter:    l,
+	}
+}
@@ -0,0 +1,250 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryPolicy says how hard to try a call before giving up.
+type RetryPolicy struct {
+	// MaxAttempts includes the first try.  1 means never retry.
+	MaxAttempts int
+
+	// The delay before the Nth retry is a random duration up to
+	// BaseDelay * 2^(N-1), capped at MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Idempotent marks a call as safe to repeat even though its method
+	// (usually POST) isn't.  GET, HEAD, OPTIONS, PUT and DELETE are always
+	// considered idempotent.
+	Idempotent bool
+}
+
+// DefaultRetryPolicy is used for calls that don't match any RetryEndpoint.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    60 * time.Second,
+}
+
+// RetryEndpoint sets the policy for calls matching a method and path.
+type RetryEndpoint struct {
+	// Name is the "endpoint" label on the retry metrics.
+	Name string
+
+	// Method matches the request method; empty matches any.
+	Method string
+
+	// PathPrefix matches the start of the request URL path.  A "*" path
+	// segment matches any one segment (like an ID).
+	PathPrefix string
+
+	Policy RetryPolicy
+}
+
+func (e *RetryEndpoint) matches(req *http.Request) bool {
+	if e.Method != "" && e.Method != req.Method {
+		return false
+	}
+	want := strings.Split(e.PathPrefix, "/")
+	have := strings.Split(req.URL.Path, "/")
+	if len(have) < len(want) {
+		return false
+	}
+	for i, w := range want {
+		if w == "*" && have[i] != "" {
+			continue
+		}
+		if i == len(want)-1 {
+			return strings.HasPrefix(have[i], w)
+		}
+		if w != have[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// RetryTransport is an http.RoundTripper that retries network errors, 429s
+// and 5xxs with exponential backoff and jitter.  A Retry-After header on
+// the response is honored if it is no longer than the policy's MaxDelay;
+// if the server wants us to wait longer, the response is returned as-is.
+//
+// Only idempotent calls are retried, and only if the request body can be
+// replayed (see http.Request.GetBody).
+type RetryTransport struct {
+	Base      http.RoundTripper
+	Endpoints []RetryEndpoint
+
+	// Retries counts retries, and Failures counts calls that still failed
+	// when we gave up retrying.  Both are labelled by endpoint, and either
+	// may be nil.
+	Retries  *prometheus.CounterVec
+	Failures *prometheus.CounterVec
+}
+
+// NewRetryTransport wraps base (http.DefaultTransport if nil).  The first
+// of endpoints that matches a request sets its policy; requests matching
+// none use DefaultRetryPolicy and the endpoint label "other".
+func NewRetryTransport(
+	base http.RoundTripper,
+	endpoints []RetryEndpoint,
+	retries *prometheus.CounterVec,
+	failures *prometheus.CounterVec,
+) *RetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RetryTransport{
+		Base:      base,
+		Endpoints: endpoints,
+		Retries:   retries,
+		Failures:  failures,
+	}
+}
+
+func (t *RetryTransport) endpointFor(req *http.Request) (string, RetryPolicy) {
+	for i := range t.Endpoints {
+		if t.Endpoints[i].matches(req) {
+			return t.Endpoints[i].Name, t.Endpoints[i].Policy
+		}
+	}
+	return "other", DefaultRetryPolicy
+}
+
+func isIdempotent(req *http.Request, policy RetryPolicy) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return policy.Idempotent
+}
+
+// shouldRetry is true for failures that might go away if we try again.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoff is the delay before retry number attempt (starting at 1).
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	max := policy.BaseDelay << (attempt - 1)
+	if max <= 0 || max > policy.MaxDelay {
+		max = policy.MaxDelay
+	}
+	if max <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitter.Int63n(int64(max))) + 1
+}
+
+// retryAfter parses a Retry-After header (seconds or an HTTP date).  ok is
+// false if there isn't a valid one.
+func retryAfter(resp *http.Response) (d time.Duration, ok bool) {
+	if resp == nil {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if when, err := http.ParseTime(v); err == nil {
+		d = time.Until(when)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint, policy := t.endpointFor(req)
+	canRetry := isIdempotent(req, policy) &&
+		(req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.Base.RoundTrip(req)
+		if !shouldRetry(resp, err) {
+			return resp, err
+		}
+		if !canRetry || attempt >= policy.MaxAttempts {
+			t.fail(endpoint)
+			return resp, err
+		}
+
+		delay := backoff(policy, attempt)
+		if after, ok := retryAfter(resp); ok {
+			if after > policy.MaxDelay {
+				t.fail(endpoint)
+				return resp, err
+			}
+			if after > delay {
+				delay = after
+			}
+		}
+
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				t.fail(endpoint)
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		if resp != nil {
+			// Drain so the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		if t.Retries != nil {
+			t.Retries.WithLabelValues(endpoint).Inc()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			t.fail(endpoint)
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *RetryTransport) fail(endpoint string) {
+	if t.Failures != nil {
+		t.Failures.WithLabelValues(endpoint).Inc()
+	}
+}
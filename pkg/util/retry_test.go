@@ -0,0 +1,295 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 10,
+		BaseDelay:   time.Second,
+		MaxDelay:    10 * time.Second,
+	}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{6, 10 * time.Second},
+		// Big enough that BaseDelay << (attempt - 1) overflows.
+		{70, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			d := backoff(policy, tt.attempt)
+			if d <= 0 || d > tt.max {
+				t.Fatalf("attempt %d: backoff %v, want in (0, %v]", tt.attempt, d, tt.max)
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+		// Jitter should use most of the range.
+		if longest < tt.max/2 {
+			t.Errorf("attempt %d: longest backoff %v of 1000, want near %v", tt.attempt, longest, tt.max)
+		}
+	}
+
+	if d := backoff(RetryPolicy{}, 1); d != 0 {
+		t.Errorf("zero policy: backoff %v, want 0", d)
+	}
+}
+
+// flakyServer fails the first failures requests with status (and
+// Retry-After, if set), then succeeds.  It records each request's body.
+type flakyServer struct {
+	failures   int
+	status     int
+	retryAfter string
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.bodies = append(f.bodies, string(body))
+	n := len(f.bodies)
+	f.mu.Unlock()
+	if n <= f.failures {
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		w.WriteHeader(f.status)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func (f *flakyServer) requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.bodies...)
+}
+
+func TestRetryTransport(t *testing.T) {
+	fast := RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Second,
+	}
+	idempotent := fast
+	idempotent.Idempotent = true
+
+	tests := []struct {
+		name       string
+		method     string
+		body       func() io.Reader
+		policy     RetryPolicy
+		failures   int
+		status     int
+		retryAfter string
+		wantStatus int
+		wantTries  int
+		minElapsed time.Duration
+	}{
+		{
+			name:       "GET succeeds first time",
+			method:     "GET",
+			policy:     fast,
+			wantStatus: http.StatusOK,
+			wantTries:  1,
+		},
+		{
+			name:       "GET retried until it succeeds",
+			method:     "GET",
+			policy:     fast,
+			failures:   2,
+			status:     http.StatusServiceUnavailable,
+			wantStatus: http.StatusOK,
+			wantTries:  3,
+		},
+		{
+			name:       "GET gives up after MaxAttempts",
+			method:     "GET",
+			policy:     fast,
+			failures:   5,
+			status:     http.StatusBadGateway,
+			wantStatus: http.StatusBadGateway,
+			wantTries:  3,
+		},
+		{
+			name:       "4xx is not retried",
+			method:     "GET",
+			policy:     fast,
+			failures:   1,
+			status:     http.StatusNotFound,
+			wantStatus: http.StatusNotFound,
+			wantTries:  1,
+		},
+		{
+			name:       "429 is retried",
+			method:     "GET",
+			policy:     fast,
+			failures:   1,
+			status:     http.StatusTooManyRequests,
+			wantStatus: http.StatusOK,
+			wantTries:  2,
+		},
+		{
+			name:       "Retry-After within MaxDelay is honored",
+			method:     "GET",
+			policy:     fast,
+			failures:   1,
+			status:     http.StatusTooManyRequests,
+			retryAfter: "0",
+			wantStatus: http.StatusOK,
+			wantTries:  2,
+		},
+		{
+			name:       "Retry-After longer than the backoff is waited for",
+			method:     "GET",
+			policy:     RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Second},
+			failures:   1,
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "1",
+			wantStatus: http.StatusOK,
+			wantTries:  2,
+			minElapsed: time.Second,
+		},
+		{
+			name:       "Retry-After beyond MaxDelay gives up",
+			method:     "GET",
+			policy:     fast,
+			failures:   1,
+			status:     http.StatusTooManyRequests,
+			retryAfter: "120",
+			wantStatus: http.StatusTooManyRequests,
+			wantTries:  1,
+		},
+		{
+			name:       "non-idempotent POST is not retried",
+			method:     "POST",
+			body:       func() io.Reader { return strings.NewReader("payload") },
+			policy:     fast,
+			failures:   1,
+			status:     http.StatusServiceUnavailable,
+			wantStatus: http.StatusServiceUnavailable,
+			wantTries:  1,
+		},
+		{
+			name:       "idempotent POST replays its body",
+			method:     "POST",
+			body:       func() io.Reader { return bytes.NewReader([]byte("payload")) },
+			policy:     idempotent,
+			failures:   2,
+			status:     http.StatusInternalServerError,
+			wantStatus: http.StatusOK,
+			wantTries:  3,
+		},
+		{
+			name: "idempotent POST without GetBody is not retried",
+			// http.NewRequest only sets GetBody for the readers it knows.
+			method:     "POST",
+			body:       func() io.Reader { return io.MultiReader(strings.NewReader("payload")) },
+			policy:     idempotent,
+			failures:   1,
+			status:     http.StatusInternalServerError,
+			wantStatus: http.StatusInternalServerError,
+			wantTries:  1,
+		},
+		{
+			name:       "PUT is always idempotent",
+			method:     "PUT",
+			body:       func() io.Reader { return strings.NewReader("payload") },
+			policy:     fast,
+			failures:   1,
+			status:     http.StatusGatewayTimeout,
+			wantStatus: http.StatusOK,
+			wantTries:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &flakyServer{
+				failures:   tt.failures,
+				status:     tt.status,
+				retryAfter: tt.retryAfter,
+			}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			client := &http.Client{Transport: NewRetryTransport(nil, []RetryEndpoint{
+				{Name: "test", PathPrefix: "/", Policy: tt.policy},
+			}, nil, nil)}
+			var body io.Reader
+			wantBody := ""
+			if tt.body != nil {
+				body = tt.body()
+				wantBody = "payload"
+			}
+			req, err := http.NewRequest(tt.method, ts.URL+"/x", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if elapsed := time.Since(start); elapsed < tt.minElapsed {
+				t.Errorf("took %v, want at least %v", elapsed, tt.minElapsed)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			requests := server.requests()
+			if len(requests) != tt.wantTries {
+				t.Errorf("%d tries, want %d", len(requests), tt.wantTries)
+			}
+			for i, got := range requests {
+				if got != wantBody {
+					t.Errorf("try %d: body %q, want %q", i+1, got, wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryEndpointMatches(t *testing.T) {
+	endpoints := []RetryEndpoint{
+		{Name: "pictures", Method: "GET", PathPrefix: "/album/*/pictures/json/"},
+		{Name: "albums", Method: "GET", PathPrefix: "/albums/"},
+	}
+	transport := NewRetryTransport(nil, endpoints, nil, nil)
+	tests := []struct {
+		method, path, want string
+	}{
+		{"GET", "/album/123/pictures/json/", "pictures"},
+		{"GET", "/album//pictures/json/", "other"},
+		{"POST", "/album/123/pictures/json/", "other"},
+		{"GET", "/albums/web/json/", "albums"},
+		{"GET", "/photos/", "other"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if got, _ := transport.endpointFor(req); got != tt.want {
+			t.Errorf("%s %s: endpoint %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package util
+
+import "sync"
+
+// RunWorkers calls work with each of jobs, on a fixed pool of at most
+// workers goroutines (at least 1), and returns once they're all done.
+func RunWorkers(workers int, jobs []int, work func(job int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				work(job)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+}